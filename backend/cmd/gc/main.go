@@ -0,0 +1,65 @@
+// Command gc sweeps the blob store once and reports the blobs no project,
+// asset, export or upload points at. It only reports unless -delete is set.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Pieli/server/config"
+	"github.com/Pieli/server/internal/client"
+	"github.com/Pieli/server/internal/gc"
+	"github.com/Pieli/server/internal/storage"
+)
+
+func main() {
+	deleteOrphans := flag.Bool("delete", false, "delete the orphaned blobs instead of only reporting them")
+	grace := flag.Duration("grace", gc.DefaultGrace, "minimum age of an orphaned blob")
+	flag.Parse()
+
+	if err := run(*deleteOrphans, *grace); err != nil {
+		fmt.Printf("error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(deleteOrphans bool, grace time.Duration) error {
+	env, err := config.LoadConfig()
+	if err != nil {
+		return err
+	}
+
+	db, err := storage.BootstrapMongo(env.MONGODB_URI, env.MONGODB_NAME, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	defer storage.CloseMongo(db)
+
+	blobStore, err := client.NewBlobStore(env)
+	if err != nil {
+		return err
+	}
+
+	sweeper := gc.NewSweeper(blobStore, gc.MongoReferences(db, gc.ReferenceCollections...), grace, !deleteOrphans)
+	report, err := sweeper.Sweep(context.Background(), time.Now())
+	if err != nil {
+		return err
+	}
+
+	var orphanedBytes int64
+	for _, orphan := range report.Orphans {
+		orphanedBytes += orphan.Size
+		fmt.Printf("%s\t%d\t%s\n", orphan.Key, orphan.Size, orphan.ModTime.Format(time.RFC3339))
+	}
+
+	fmt.Printf("scanned %d blobs, %d orphaned (%d bytes)\n", report.Scanned, len(report.Orphans), orphanedBytes)
+	if report.DryRun {
+		fmt.Println("dry run, nothing was deleted, run with -delete to remove them")
+	} else {
+		fmt.Printf("deleted %d blobs, freed %d bytes\n", report.Deleted, report.FreedBytes)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+// Command worker renders export jobs apart from the api server. It claims
+// them over the internal render api, keeps their lease with heartbeats,
+// reports the progress and uploads the videos. Any number of workers can
+// run, a job whose worker dies is queued again once its lease expired.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/Pieli/server/config"
+	"github.com/Pieli/server/internal/client"
+	"github.com/Pieli/server/internal/exports"
+	"github.com/Pieli/server/internal/render"
+	"github.com/Pieli/server/internal/renderapi"
+)
+
+func main() {
+	name := flag.String("name", "", "name of the worker in the leases, host name and process id by default")
+	flag.Parse()
+
+	if err := run(*name); err != nil {
+		fmt.Printf("error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(name string) error {
+	env, err := config.LoadWorkerConfig()
+	if err != nil {
+		return err
+	}
+
+	renderer, err := client.NewRenderer(env)
+	if err != nil {
+		return err
+	}
+
+	// leases are held by name, two workers must never share one
+	if name == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return err
+		}
+		name = fmt.Sprintf("%s-%d", hostname, os.Getpid())
+	}
+	workers := env.EXPORT_WORKERS
+	if workers <= 0 {
+		workers = 1
+	}
+
+	// renders running on a signal are cancelled, their jobs go back into
+	// the queue once the lease expired
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	log.Printf("rendering with %d workers named %s for %s\n", workers, name, env.RENDER_API_URL)
+	queue := renderapi.NewClient(env.RENDER_API_URL, env.RENDER_WORKER_TOKEN)
+	exports.NewPool(queue, render.ForExports(renderer, os.TempDir()), name, workers).Run(ctx)
+	return nil
+}
@@ -9,52 +9,47 @@ import (
 )
 
 type EnvVars struct {
-	MONGODB_URI        string `mapstructure:"MONGODB_URI"`
-	MONGODB_NAME       string `mapstructure:"MONGODB_NAME"`
-	PORT               string `mapstructure:"PORT"`
-	FIREBASE_CONFIG    string `mapstructure:"FIREBASE_CONFIG"`
-	INTERNAL_MAIL_PASS string `mapstructure:"INTERNAL_MAIL_PASS"`
-	HELLO_MAIL_PASS    string `mapstructure:"INTERNAL_MAIL_PASS"`
-	ANALYTICS_DEV      string `mapstructure:"ANALYTICS_DEV"`
-	ANALYTICS_PROD     string `mapstructure:"ANALYTICS_PROD"`
+	MONGODB_URI          string `mapstructure:"MONGODB_URI"`
+	MONGODB_NAME         string `mapstructure:"MONGODB_NAME"`
+	PORT                 string `mapstructure:"PORT"`
+	FIREBASE_CONFIG      string `mapstructure:"FIREBASE_CONFIG"`
+	INTERNAL_MAIL_PASS   string `mapstructure:"INTERNAL_MAIL_PASS"`
+	HELLO_MAIL_PASS      string `mapstructure:"INTERNAL_MAIL_PASS"`
+	ANALYTICS_DEV        string `mapstructure:"ANALYTICS_DEV"`
+	ANALYTICS_PROD       string `mapstructure:"ANALYTICS_PROD"`
+	TIERS_FILE           string `mapstructure:"TIERS_FILE"`
+	CATALOG_FILE         string `mapstructure:"CATALOG_FILE"`
+	BLOB_BACKEND         string `mapstructure:"BLOB_BACKEND"`
+	BLOB_DIR             string `mapstructure:"BLOB_DIR"`
+	S3_ENDPOINT          string `mapstructure:"S3_ENDPOINT"`
+	S3_REGION            string `mapstructure:"S3_REGION"`
+	S3_BUCKET            string `mapstructure:"S3_BUCKET"`
+	S3_ACCESS_KEY        string `mapstructure:"S3_ACCESS_KEY"`
+	S3_SECRET_KEY        string `mapstructure:"S3_SECRET_KEY"`
+	LLM_PROVIDER         string `mapstructure:"LLM_PROVIDER"`
+	LLM_BASE_URL         string `mapstructure:"LLM_BASE_URL"`
+	OPENAI_API_KEY       string `mapstructure:"OPENAI_API_KEY"`
+	OPENAI_MODEL         string `mapstructure:"OPENAI_MODEL"`
+	DOWNLOAD_SECRET      string `mapstructure:"DOWNLOAD_SECRET"`
+	DOWNLOAD_URL_TTL     string `mapstructure:"DOWNLOAD_URL_TTL"`
+	GC_INTERVAL          string `mapstructure:"GC_INTERVAL"`
+	GC_GRACE             string `mapstructure:"GC_GRACE"`
+	GC_DRY_RUN           bool   `mapstructure:"GC_DRY_RUN"`
+	RENDERER             string `mapstructure:"RENDERER"`
+	REMOTION_DIR         string `mapstructure:"REMOTION_DIR"`
+	REMOTION_ENTRY       string `mapstructure:"REMOTION_ENTRY"`
+	REMOTION_COMPOSITION string `mapstructure:"REMOTION_COMPOSITION"`
+	EXPORT_WORKERS       int    `mapstructure:"EXPORT_WORKERS"`
+	RENDER_WORKER_TOKEN  string `mapstructure:"RENDER_WORKER_TOKEN"`
+	RENDER_API_URL       string `mapstructure:"RENDER_API_URL"`
 }
 
 func LoadConfig() (config EnvVars, err error) {
-	env := os.Getenv("GO_ENV")
-
-	if env == "" {
-		env = "development"
-	}
-
-	log.Println("ENV:", env)
-
-	if env == "production" {
-		viper.AutomaticEnv()
-		_ = viper.BindEnv("MONGODB_URI")
-		_ = viper.BindEnv("MONGODB_NAME")
-		_ = viper.BindEnv("PORT")
-		_ = viper.BindEnv("FIREBASE_CONFIG")
-		_ = viper.BindEnv("INTERNAL_MAIL_PASS")
-		_ = viper.BindEnv("ANALYTICS_DEV")
-		_ = viper.BindEnv("ANALYTICS_PROD")
-	} else {
-		viper.AddConfigPath(".")
-		viper.SetConfigName("app")
-		viper.SetConfigType("env")
-
-		viper.AutomaticEnv()
-
-		err = viper.ReadInConfig()
-		if err != nil {
-			return
-		}
-
-	}
-
-	err = viper.Unmarshal(&config)
+	config, err = load()
 	if err != nil {
 		return
 	}
+
 	// validate config here
 	if config.MONGODB_URI == "" {
 		err = errors.New("MONGODB_URI is required")
@@ -76,6 +71,12 @@ func LoadConfig() (config EnvVars, err error) {
 		return
 	}
 
+	// replicas and restarts have to accept the links signed by the others
+	if os.Getenv("GO_ENV") == "production" && config.DOWNLOAD_SECRET == "" {
+		err = errors.New("DOWNLOAD_SECRET is required in production")
+		return
+	}
+
 	// if config.INTERNAL_MAIL_PASS == "" {
 	// 	err = errors.New("Email env is not set")
 	// 	return
@@ -95,3 +96,91 @@ func LoadConfig() (config EnvVars, err error) {
 
 	return
 }
+
+// LoadWorkerConfig loads the config of a render worker, it only talks to
+// the api server and needs neither the database nor firebase
+func LoadWorkerConfig() (config EnvVars, err error) {
+	config, err = load()
+	if err != nil {
+		return
+	}
+
+	if config.RENDER_API_URL == "" {
+		err = errors.New("RENDER_API_URL is required")
+		return
+	}
+
+	if config.RENDER_WORKER_TOKEN == "" {
+		err = errors.New("RENDER_WORKER_TOKEN is required")
+		return
+	}
+
+	if config.RENDERER == "" {
+		config.RENDERER = "remotion"
+	}
+
+	return
+}
+
+// load reads the variables from the environment in production and from
+// app.env otherwise
+func load() (config EnvVars, err error) {
+	env := os.Getenv("GO_ENV")
+
+	if env == "" {
+		env = "development"
+	}
+
+	log.Println("ENV:", env)
+
+	if env == "production" {
+		viper.AutomaticEnv()
+		_ = viper.BindEnv("MONGODB_URI")
+		_ = viper.BindEnv("MONGODB_NAME")
+		_ = viper.BindEnv("PORT")
+		_ = viper.BindEnv("FIREBASE_CONFIG")
+		_ = viper.BindEnv("INTERNAL_MAIL_PASS")
+		_ = viper.BindEnv("ANALYTICS_DEV")
+		_ = viper.BindEnv("ANALYTICS_PROD")
+		_ = viper.BindEnv("TIERS_FILE")
+		_ = viper.BindEnv("CATALOG_FILE")
+		_ = viper.BindEnv("BLOB_BACKEND")
+		_ = viper.BindEnv("BLOB_DIR")
+		_ = viper.BindEnv("S3_ENDPOINT")
+		_ = viper.BindEnv("S3_REGION")
+		_ = viper.BindEnv("S3_BUCKET")
+		_ = viper.BindEnv("S3_ACCESS_KEY")
+		_ = viper.BindEnv("S3_SECRET_KEY")
+		_ = viper.BindEnv("LLM_PROVIDER")
+		_ = viper.BindEnv("LLM_BASE_URL")
+		_ = viper.BindEnv("OPENAI_API_KEY")
+		_ = viper.BindEnv("OPENAI_MODEL")
+		_ = viper.BindEnv("DOWNLOAD_SECRET")
+		_ = viper.BindEnv("DOWNLOAD_URL_TTL")
+		_ = viper.BindEnv("GC_INTERVAL")
+		_ = viper.BindEnv("GC_GRACE")
+		_ = viper.BindEnv("GC_DRY_RUN")
+		_ = viper.BindEnv("RENDERER")
+		_ = viper.BindEnv("REMOTION_DIR")
+		_ = viper.BindEnv("REMOTION_ENTRY")
+		_ = viper.BindEnv("REMOTION_COMPOSITION")
+		_ = viper.BindEnv("EXPORT_WORKERS")
+		_ = viper.BindEnv("RENDER_WORKER_TOKEN")
+		_ = viper.BindEnv("RENDER_API_URL")
+	} else {
+		viper.AddConfigPath(".")
+		viper.SetConfigName("app")
+		viper.SetConfigType("env")
+
+		viper.AutomaticEnv()
+
+		err = viper.ReadInConfig()
+		if err != nil {
+			return
+		}
+
+	}
+
+	err = viper.Unmarshal(&config)
+	return
+}
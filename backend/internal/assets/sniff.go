@@ -0,0 +1,96 @@
+package assets
+
+import (
+	"bytes"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// Category is the ProjectAssets array an asset is listed in
+type Category string
+
+const (
+	Images Category = "images"
+	Videos Category = "videos"
+	Audio  Category = "audio"
+	Fonts  Category = "fonts"
+	Other  Category = "other"
+)
+
+// SniffLen is the number of leading bytes Sniff looks at
+const SniffLen = 512
+
+// Limits caps the size of a single upload per category
+var Limits = map[Category]int64{
+	Images: 20 << 20,
+	Videos: 500 << 20,
+	Audio:  50 << 20,
+	Fonts:  10 << 20,
+	Other:  20 << 20,
+}
+
+// MaxSize is the largest limit of any category, the most a file can be
+// before its content is known
+func MaxSize() int64 {
+	var max int64
+	for _, limit := range Limits {
+		if limit > max {
+			max = limit
+		}
+	}
+	return max
+}
+
+// Sniff detects the MIME type from the leading bytes of a file. It
+// extends http.DetectContentType with the formats it does not know.
+func Sniff(head []byte) string {
+	if len(head) > SniffLen {
+		head = head[:SniffLen]
+	}
+
+	switch {
+	case len(head) >= 12 && bytes.Equal(head[4:8], []byte("ftyp")):
+		switch string(head[8:12]) {
+		case "qt  ":
+			return "video/quicktime"
+		case "M4A ", "M4B ":
+			return "audio/mp4"
+		}
+	case bytes.HasPrefix(head, []byte("fLaC")):
+		return "audio/flac"
+	case bytes.HasPrefix(head, []byte("OggS")):
+		return "audio/ogg"
+	case len(head) >= 2 && head[0] == 0xFF && head[1]&0xE0 == 0xE0:
+		// MPEG audio frame without an ID3 tag
+		return "audio/mpeg"
+	}
+
+	detected, _, err := mime.ParseMediaType(http.DetectContentType(head))
+	if err != nil {
+		return "application/octet-stream"
+	}
+
+	// SVGs are sniffed as XML or plain text
+	if (detected == "text/xml" || detected == "text/plain") && bytes.Contains(head, []byte("<svg")) {
+		return "image/svg+xml"
+	}
+
+	return detected
+}
+
+// CategoryOf sorts a MIME type into its ProjectAssets category
+func CategoryOf(mimeType string) Category {
+	switch {
+	case strings.HasPrefix(mimeType, "image/"):
+		return Images
+	case strings.HasPrefix(mimeType, "video/"):
+		return Videos
+	case strings.HasPrefix(mimeType, "audio/"):
+		return Audio
+	case strings.HasPrefix(mimeType, "font/"), mimeType == "application/vnd.ms-fontobject":
+		return Fonts
+	default:
+		return Other
+	}
+}
@@ -0,0 +1,54 @@
+package assets
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSniff(t *testing.T) {
+	cases := map[string]struct {
+		head     []byte
+		mime     string
+		category Category
+	}{
+		"png":  {[]byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"), "image/png", Images},
+		"svg":  {[]byte(`<?xml version="1.0"?><svg xmlns="http://www.w3.org/2000/svg"></svg>`), "image/svg+xml", Images},
+		"mp4":  {[]byte("\x00\x00\x00\x18ftypmp42\x00\x00\x00\x00mp42isom"), "video/mp4", Videos},
+		"mov":  {[]byte("\x00\x00\x00\x14ftypqt  \x00\x00\x00\x00"), "video/quicktime", Videos},
+		"mp3":  {[]byte("ID3\x03\x00\x00\x00\x00\x00\x00"), "audio/mpeg", Audio},
+		"wav":  {[]byte("RIFF\x24\x00\x00\x00WAVEfmt "), "audio/wave", Audio},
+		"ttf":  {[]byte("\x00\x01\x00\x00\x00\x0c\x00\x80\x00\x03"), "font/ttf", Fonts},
+		"woff": {[]byte("wOFF\x00\x01\x00\x00"), "font/woff", Fonts},
+		"text": {[]byte("just some notes"), "text/plain", Other},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			mime := Sniff(c.head)
+			assert.Equal(t, c.mime, mime)
+			assert.Equal(t, c.category, CategoryOf(mime))
+		})
+	}
+}
+
+func TestReceive(t *testing.T) {
+	upload, err := Receive(strings.NewReader("plain text content"))
+	require.NoError(t, err)
+	defer upload.Close()
+
+	assert.Equal(t, int64(18), upload.Size)
+	assert.Equal(t, Other, upload.Category)
+	// sha256 of "plain text content"
+	assert.Equal(t, "9fd6d0b2904aaabe0455818ffcbbe215f602b48829584e9a415aee75b2ae3202", upload.Hash)
+
+	_, err = Receive(strings.NewReader(""))
+	assert.ErrorIs(t, err, ErrEmpty)
+
+	tooLarge := bytes.Repeat([]byte("a"), int(Limits[Other])+1)
+	_, err = Receive(bytes.NewReader(tooLarge))
+	assert.ErrorIs(t, err, ErrTooLarge)
+}
@@ -0,0 +1,80 @@
+package assets
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+)
+
+var (
+	ErrEmpty    = errors.New("the file is empty")
+	ErrTooLarge = errors.New("the file exceeds the size limit of its category")
+)
+
+// Upload is a received file spooled to a temporary file, so it can be
+// checked before it is stored
+type Upload struct {
+	File     *os.File
+	Size     int64
+	Type     string
+	Category Category
+	// Hash is the hex encoded SHA-256 of the content
+	Hash string
+}
+
+// Receive sniffs r and copies it to a temporary file, at most up to the
+// limit of the sniffed category. The caller has to Close the upload.
+func Receive(r io.Reader) (*Upload, error) {
+	head := make([]byte, SniffLen)
+	n, err := io.ReadFull(r, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, ErrEmpty
+	}
+	head = head[:n]
+
+	mimeType := Sniff(head)
+	category := CategoryOf(mimeType)
+	limit := Limits[category]
+
+	file, err := os.CreateTemp("", "asset-*")
+	if err != nil {
+		return nil, err
+	}
+	upload := &Upload{File: file, Type: mimeType, Category: category}
+
+	// one byte more than allowed tells a file at the limit from a larger one
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(file, hash), io.LimitReader(io.MultiReader(bytes.NewReader(head), r), limit+1))
+	if err != nil {
+		upload.Close()
+		return nil, err
+	}
+	if size > limit {
+		upload.Close()
+		return nil, ErrTooLarge
+	}
+	upload.Size = size
+	upload.Hash = hex.EncodeToString(hash.Sum(nil))
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		upload.Close()
+		return nil, err
+	}
+
+	return upload, nil
+}
+
+// Close removes the temporary file
+func (u *Upload) Close() error {
+	err := u.File.Close()
+	if removeErr := os.Remove(u.File.Name()); err == nil {
+		err = removeErr
+	}
+	return err
+}
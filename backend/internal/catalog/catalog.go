@@ -0,0 +1,187 @@
+package catalog
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+)
+
+// Category separates the foreground animations from the backgrounds
+type Category string
+
+const (
+	Animation  Category = "animation"
+	Background Category = "background"
+)
+
+//go:embed catalog.json
+var defaultCatalog []byte
+
+// Entry is an animation or background the editor can render,
+// keep in sync with frontend/src/remotion-lib/animation-bindings.ts
+type Entry struct {
+	Name     string   `json:"name"`
+	Category Category `json:"category"`
+	Version  int      `json:"version"`
+	Usecase  string   `json:"usecase"`
+	// LLMExclude lists the props the model is not told about
+	LLMExclude []string `json:"llmExclude"`
+	// Schema is the JSON Schema of the props
+	Schema *openapi3.Schema `json:"schema"`
+}
+
+// FieldError points at a single invalid value of a request
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+// Catalog holds the entries in the order they are offered
+type Catalog struct {
+	entries []Entry
+	byName  map[string]Entry
+}
+
+// Load reads the catalog from the JSON file at path,
+// an empty path yields the built in catalog
+func Load(path string) (*Catalog, error) {
+	data := defaultCatalog
+	if path != "" {
+		var err error
+		data, err = os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var list []Entry
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("invalid animation catalog: %w", err)
+	}
+
+	return newCatalog(list)
+}
+
+func newCatalog(list []Entry) (*Catalog, error) {
+	catalog := &Catalog{
+		entries: list,
+		byName:  make(map[string]Entry, len(list)),
+	}
+
+	for _, entry := range list {
+		if entry.Name == "" {
+			return nil, errors.New("invalid animation catalog: entry without name")
+		}
+		if entry.Category != Animation && entry.Category != Background {
+			return nil, fmt.Errorf("invalid animation catalog: %q has unknown category %q", entry.Name, entry.Category)
+		}
+		if entry.Schema == nil {
+			return nil, fmt.Errorf("invalid animation catalog: %q has no schema", entry.Name)
+		}
+		if _, ok := catalog.byName[entry.Name]; ok {
+			return nil, fmt.Errorf("invalid animation catalog: duplicate entry %q", entry.Name)
+		}
+		catalog.byName[entry.Name] = entry
+	}
+
+	return catalog, nil
+}
+
+func (c *Catalog) All() []Entry {
+	return c.entries
+}
+
+// Get returns the entry with the given name of the category
+func (c *Catalog) Get(category Category, name string) (Entry, bool) {
+	entry, ok := c.byName[name]
+	if !ok || entry.Category != category {
+		return Entry{}, false
+	}
+	return entry, true
+}
+
+// Names lists the entries of a category in catalog order
+func (c *Catalog) Names(category Category) []string {
+	names := []string{}
+	for _, entry := range c.entries {
+		if entry.Category == category {
+			names = append(names, entry.Name)
+		}
+	}
+	return names
+}
+
+// Validate checks the props against the schema of the named entry. The
+// fields of the errors are relative to the props.
+func (c *Catalog) Validate(category Category, name string, props map[string]interface{}) []FieldError {
+	entry, ok := c.Get(category, name)
+	if !ok {
+		return []FieldError{{Field: "name", Message: fmt.Sprintf("unknown %s %q", category, name)}}
+	}
+
+	if props == nil {
+		return nil
+	}
+
+	err := entry.Schema.VisitJSON(props, openapi3.MultiErrors())
+	if err == nil {
+		return nil
+	}
+
+	return fieldErrors(err, "props")
+}
+
+// fieldErrors flattens the schema errors of kin-openapi
+func fieldErrors(err error, prefix string) []FieldError {
+	var multi openapi3.MultiError
+	if errors.As(err, &multi) {
+		fields := []FieldError{}
+		for _, e := range multi {
+			fields = append(fields, fieldErrors(e, prefix)...)
+		}
+		return fields
+	}
+
+	var schemaErr *openapi3.SchemaError
+	if !errors.As(err, &schemaErr) {
+		return []FieldError{{Field: prefix, Message: err.Error()}}
+	}
+
+	field := strings.Join(append([]string{prefix}, schemaErr.JSONPointer()...), ".")
+	message := schemaErr.Reason
+	switch {
+	case schemaErr.SchemaField == "pattern" && schemaErr.Schema != nil && schemaErr.Schema.Format == "color":
+		message = "must be a CSS color"
+	case message == "":
+		message = fmt.Sprintf("does not match %q of the schema", schemaErr.SchemaField)
+	}
+
+	return []FieldError{{Field: field, Message: message}}
+}
+
+// Defaults returns the default value of every prop that has one
+func (e Entry) Defaults() map[string]interface{} {
+	defaults := map[string]interface{}{}
+	for name, prop := range e.Schema.Properties {
+		if prop.Value != nil && prop.Value.Default != nil {
+			defaults[name] = prop.Value.Default
+		}
+	}
+	return defaults
+}
+
+// PropNames lists the props of the entry sorted by name
+func (e Entry) PropNames() []string {
+	names := make([]string, 0, len(e.Schema.Properties))
+	for name := range e.Schema.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
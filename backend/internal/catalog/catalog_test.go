@@ -0,0 +1,47 @@
+package catalog
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadDefaultCatalog(t *testing.T) {
+	catalog, err := Load("")
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"slideInTransition", "fadeInTransition", "scaleUpDownTransition", "simpleTextTyping"}, catalog.Names(Animation))
+	assert.Contains(t, catalog.Names(Background), "stairsTextureV3")
+
+	entry, ok := catalog.Get(Background, "stairsTexture")
+	require.True(t, ok)
+	assert.Equal(t, "#5C4B9F", entry.Defaults()["blob_one_color"])
+}
+
+func TestValidate(t *testing.T) {
+	catalog, err := Load("")
+	require.NoError(t, err)
+
+	assert.Empty(t, catalog.Validate(Animation, "slideInTransition", map[string]interface{}{
+		"typo_text":      "Hi",
+		"typo_textColor": "#ffffff",
+		"slideDuration":  float64(20),
+		"unknownProp":    true,
+	}))
+
+	fields := catalog.Validate(Animation, "slideInTransition", map[string]interface{}{
+		"typo_textAlign": "justify",
+		"slideDuration":  float64(0),
+		"typo_textColor": "not a color!",
+	})
+	names := []string{}
+	for _, field := range fields {
+		names = append(names, field.Field)
+	}
+	assert.ElementsMatch(t, []string{"props.typo_textAlign", "props.slideDuration", "props.typo_textColor"}, names)
+
+	fields = catalog.Validate(Animation, "plainBackground", nil)
+	require.Len(t, fields, 1)
+	assert.Equal(t, "name", fields[0].Field)
+}
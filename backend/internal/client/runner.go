@@ -2,11 +2,21 @@ package client
 
 import (
 	"context"
+	"crypto/rand"
 
 	firebase "firebase.google.com/go"
 
 	"github.com/Pieli/server/config"
+	"github.com/Pieli/server/internal/catalog"
+	"github.com/Pieli/server/internal/credits"
+	"github.com/Pieli/server/internal/dedup"
+	"github.com/Pieli/server/internal/download"
+	"github.com/Pieli/server/internal/exports"
+	"github.com/Pieli/server/internal/gc"
 	"github.com/Pieli/server/internal/generated"
+	"github.com/Pieli/server/internal/llm"
+	"github.com/Pieli/server/internal/render"
+	"github.com/Pieli/server/internal/renderapi"
 	"net/http"
 	"strings"
 
@@ -21,6 +31,8 @@ import (
 	"github.com/labstack/echo/v4/middleware"
 
 	"github.com/Pieli/server/internal/storage"
+	"github.com/Pieli/server/internal/tiers"
+	"github.com/Pieli/server/internal/uploads"
 )
 
 var fab *firebase.App
@@ -70,7 +82,10 @@ func buildServer(env config.EnvVars) (*echo.Echo, func(), error) {
 	app := echo.New()
 
 	// add middleware
-	app.Use(middleware.CORS())
+	// browsers only hand the listed headers to scripts, tus clients need them
+	app.Use(middleware.CORSWithConfig(middleware.CORSConfig{
+		ExposeHeaders: []string{"Location", "Tus-Resumable", "Upload-Offset", "Upload-Length", "Upload-Asset-Id"},
+	}))
 	app.Use(middleware.LoggerWithConfig(middleware.LoggerConfig{
 		Format: "${time_rfc3339} | [${remote_ip}] | ${status} - ${method} | ${latency_human} | ${uri} | ${error} |  \n",
 		Output: os.Stdout,
@@ -93,12 +108,124 @@ func buildServer(env config.EnvVars) (*echo.Echo, func(), error) {
 		app.Static("/swagger", "./api")
 	}
 
+	// load the payment tiers, the built in catalog is used without a file
+	tierCatalog, err := tiers.Load(env.TIERS_FILE)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// load the animations and backgrounds the editor can render
+	animationCatalog, err := catalog.Load(env.CATALOG_FILE)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// uploaded files are kept on disk or in an S3 compatible bucket
+	blobStore, err := NewBlobStore(env)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// download links are signed, without a secret they die with the process.
+	// Only development gets this far without one.
+	downloadSecret := []byte(env.DOWNLOAD_SECRET)
+	if len(downloadSecret) == 0 {
+		log.Println("DOWNLOAD_SECRET is not set, download links are only valid until restart")
+		downloadSecret = make([]byte, 32)
+		if _, err := rand.Read(downloadSecret); err != nil {
+			return nil, nil, err
+		}
+	}
+	downloadTTL := download.DefaultTTL
+	if env.DOWNLOAD_URL_TTL != "" {
+		downloadTTL, err = time.ParseDuration(env.DOWNLOAD_URL_TTL)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid DOWNLOAD_URL_TTL: %w", err)
+		}
+	}
+	downloadSigner, err := download.NewSigner(downloadSecret, downloadTTL)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	store := api.NewStorage(db)
-	serv := api.NewServer(store)
+	creditService := credits.NewService(credits.NewStore(db),
+		tierCatalog.Allowances(), tierCatalog.Get(tiers.DefaultTier).MonthlyCredits)
+
+	// generation is only available with an api key or the fake provider
+	var generator *llm.Generator
+	switch env.LLM_PROVIDER {
+	case "fake":
+		generator = llm.NewGenerator(llm.FakeProvider{}, animationCatalog)
+	case "", "openai":
+		if env.OPENAI_API_KEY != "" {
+			generator = llm.NewGenerator(llm.NewOpenAIProvider(env.LLM_BASE_URL, env.OPENAI_API_KEY, env.OPENAI_MODEL), animationCatalog)
+		}
+	default:
+		return nil, nil, fmt.Errorf("unknown LLM_PROVIDER %q", env.LLM_PROVIDER)
+	}
+
+	// exports are queued as jobs and rendered in the background, without a
+	// renderer they wait for a render worker (cmd/worker)
+	exportService := exports.NewService(db, creditService, blobStore)
+	renderer, err := NewRenderer(env)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serv := api.NewServer(store, creditService, tierCatalog, animationCatalog, blobStore, dedup.NewStore(db), uploads.NewStore(db), downloadSigner, generator, exportService)
 
 	api.RegisterHandlers(app, api.NewStrictHandler(serv, nil))
 
+	// render workers on other machines claim the jobs through the internal
+	// api, it is only served with a token to check them against
+	if env.RENDER_WORKER_TOKEN != "" {
+		renderapi.Register(app, exportService, env.RENDER_WORKER_TOKEN, os.TempDir())
+	}
+
+	// start the background jobs
+	background, stopBackground := context.WithCancel(context.Background())
+	go credits.NewScheduler(creditService, time.Hour).Run(background)
+	go exportService.Run(background, time.Minute)
+	if renderer != nil {
+		workers := env.EXPORT_WORKERS
+		if workers <= 0 {
+			workers = 1
+		}
+		// workers are named after the host, so a lease tells who holds it
+		hostname, err := os.Hostname()
+		if err != nil {
+			hostname = "exports"
+		}
+		pool := exports.NewPool(exportService, render.ForExports(renderer, os.TempDir()), hostname, workers)
+		go pool.Run(background)
+	}
+
+	// sweep the blobs no record points at anymore, a zero interval disables it
+	gcInterval := 6 * time.Hour
+	if env.GC_INTERVAL != "" {
+		gcInterval, err = time.ParseDuration(env.GC_INTERVAL)
+		if err != nil {
+			stopBackground()
+			return nil, nil, fmt.Errorf("invalid GC_INTERVAL: %w", err)
+		}
+	}
+	gcGrace := gc.DefaultGrace
+	if env.GC_GRACE != "" {
+		gcGrace, err = time.ParseDuration(env.GC_GRACE)
+		if err != nil {
+			stopBackground()
+			return nil, nil, fmt.Errorf("invalid GC_GRACE: %w", err)
+		}
+	}
+	if gcInterval > 0 {
+		sweeper := gc.NewSweeper(blobStore, gc.MongoReferences(db, gc.ReferenceCollections...), gcGrace, env.GC_DRY_RUN)
+		go sweeper.Run(background, gcInterval)
+	}
+
 	return app, func() {
+		stopBackground()
+
 		err := storage.CloseMongo(db)
 		if err != nil {
 			log.Printf("error closing database: %v\n", err)
@@ -106,13 +233,64 @@ func buildServer(env config.EnvVars) (*echo.Echo, func(), error) {
 	}, nil
 }
 
+// NewRenderer creates the renderer selected by RENDERER, there is none
+// without a value
+func NewRenderer(env config.EnvVars) (render.Renderer, error) {
+	switch env.RENDERER {
+	case "":
+		return nil, nil
+	case "fake":
+		return render.FakeRenderer{Step: time.Second}, nil
+	case "remotion":
+		return render.NewRemotionRenderer(env.REMOTION_DIR, env.REMOTION_ENTRY, env.REMOTION_COMPOSITION), nil
+	default:
+		return nil, fmt.Errorf("unknown RENDERER %q", env.RENDERER)
+	}
+}
+
+// NewBlobStore opens the blob store selected by BLOB_BACKEND
+func NewBlobStore(env config.EnvVars) (storage.BlobStore, error) {
+	switch env.BLOB_BACKEND {
+	case "", "local":
+		blobDir := env.BLOB_DIR
+		if blobDir == "" {
+			blobDir = "./data/blobs"
+		}
+		return storage.NewLocalBlobStore(blobDir)
+	case "s3":
+		s3Store, err := storage.NewS3BlobStore(storage.S3Config{
+			Endpoint:  env.S3_ENDPOINT,
+			Region:    env.S3_REGION,
+			Bucket:    env.S3_BUCKET,
+			AccessKey: env.S3_ACCESS_KEY,
+			SecretKey: env.S3_SECRET_KEY,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := s3Store.EnsureBucket(ctx); err != nil {
+			return nil, err
+		}
+		return s3Store, nil
+	default:
+		return nil, fmt.Errorf("unknown BLOB_BACKEND %q", env.BLOB_BACKEND)
+	}
+}
+
 func authMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
 
 		// exception skipping url / subideal
 		if strings.HasPrefix(c.Path(), "/docs") ||
 			strings.HasPrefix(c.Path(), "/swagger") ||
-			c.Path() == "/health" {
+			c.Path() == "/health" ||
+			c.Path() == "/api/tiers" ||
+			c.Path() == "/api/catalog" ||
+			strings.HasPrefix(c.Path(), "/api/downloads/") ||
+			strings.HasPrefix(c.Path(), renderapi.Prefix+"/") {
 			return next(c)
 		}
 
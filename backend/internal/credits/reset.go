@@ -0,0 +1,219 @@
+package credits
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// pendingReset is written together with the new balance and removed once
+// the matching ledger entry exists. The pre-generated transaction id makes
+// writing the ledger entry idempotent.
+type pendingReset struct {
+	TransactionId primitive.ObjectID `bson:"transactionId"`
+	Period        time.Time          `bson:"period"`
+	Amount        int                `bson:"amount"`
+	Monthly       int                `bson:"monthly"`
+}
+
+type resetUser struct {
+	Id      primitive.ObjectID `bson:"_id"`
+	Credits struct {
+		PendingReset *pendingReset `bson:"pendingReset"`
+	} `bson:"credits"`
+}
+
+// ResetDue resets the balance of every user whose last reset lies before
+// the current month to the monthly allowance of their payment tier.
+// Credits reserved until then went with the old balance. Each
+// user is claimed with a single conditional update, so several replicas can
+// run it at once and a restart picks up where the last run stopped.
+func (s *Service) ResetDue(ctx context.Context, now time.Time) (int, error) {
+	period := MonthStart(now)
+
+	// finish resets interrupted between the balance update and the ledger
+	if err := s.finishPendingResets(ctx); err != nil {
+		return 0, err
+	}
+
+	filter := bson.M{
+		"credits.pendingReset": bson.M{"$exists": false},
+		"$or": bson.A{
+			bson.M{"credits.lastReset": bson.M{"$lt": period}},
+			bson.M{"credits.lastReset": bson.M{"$exists": false}},
+		},
+	}
+
+	reset := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return reset, err
+		}
+
+		monthly := s.allowanceExpression()
+		update := bson.A{
+			bson.M{"$set": bson.M{
+				"credits.pendingReset": bson.M{
+					"transactionId": primitive.NewObjectID(),
+					"period":        period,
+					"amount": bson.M{"$subtract": bson.A{
+						monthly,
+						bson.M{"$ifNull": bson.A{"$credits.current", 0}},
+					}},
+					"monthly": monthly,
+				},
+				"credits.current":   monthly,
+				"credits.monthly":   monthly,
+				"credits.lastReset": period,
+				// reservations from before are not refunded on top
+				"credits.resetAt": now,
+			}},
+		}
+
+		opts := options.FindOneAndUpdate().
+			SetReturnDocument(options.After).
+			SetProjection(bson.M{"credits.pendingReset": 1})
+
+		var user resetUser
+		err := s.Users().FindOneAndUpdate(ctx, filter, update, opts).Decode(&user)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return reset, nil
+		}
+		if err != nil {
+			return reset, err
+		}
+
+		if err := s.completeReset(ctx, user); err != nil {
+			return reset, err
+		}
+		reset++
+	}
+}
+
+// allowanceExpression looks up the monthly credits of the user's tier
+// inside the update pipeline
+func (s *Service) allowanceExpression() bson.M {
+	branches := bson.A{}
+	for tier, credits := range s.allowances {
+		branches = append(branches, bson.M{
+			"case": bson.M{"$eq": bson.A{"$paymentTier", tier}},
+			"then": credits,
+		})
+	}
+
+	if len(branches) == 0 {
+		return bson.M{"$literal": s.defaultAllowance}
+	}
+
+	return bson.M{"$switch": bson.M{
+		"branches": branches,
+		"default":  s.defaultAllowance,
+	}}
+}
+
+func (s *Service) finishPendingResets(ctx context.Context) error {
+	cursor, err := s.Users().Find(ctx,
+		bson.M{"credits.pendingReset": bson.M{"$exists": true}},
+		options.Find().SetProjection(bson.M{"credits.pendingReset": 1}))
+	if err != nil {
+		return err
+	}
+
+	var users []resetUser
+	if err = cursor.All(ctx, &users); err != nil {
+		return err
+	}
+
+	for _, user := range users {
+		if err := s.completeReset(ctx, user); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// completeReset writes the ledger entry of a claimed reset and clears the marker
+func (s *Service) completeReset(ctx context.Context, user resetUser) error {
+	pending := user.Credits.PendingReset
+	if pending == nil {
+		return nil
+	}
+
+	transaction := Transaction{
+		Id:          pending.TransactionId,
+		UserId:      user.Id.Hex(),
+		Amount:      pending.Amount,
+		Operation:   MonthlyReset,
+		Description: fmt.Sprintf("Monthly reset to %d credits", pending.Monthly),
+		Metadata: map[string]interface{}{
+			"period": pending.Period.Format("2006-01"),
+		},
+		CreatedAt: time.Now(),
+	}
+
+	_, err := s.Transactions().InsertOne(ctx, transaction)
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		return err
+	}
+
+	_, err = s.Users().UpdateOne(ctx,
+		bson.M{"_id": user.Id, "credits.pendingReset.transactionId": pending.TransactionId},
+		bson.M{"$unset": bson.M{"credits.pendingReset": ""}})
+	return err
+}
+
+// Scheduler periodically runs the monthly reset and cleans up expired
+// reservations
+type Scheduler struct {
+	service  *Service
+	interval time.Duration
+}
+
+func NewScheduler(service *Service, interval time.Duration) *Scheduler {
+	return &Scheduler{
+		service:  service,
+		interval: interval,
+	}
+}
+
+// Run blocks until ctx is cancelled, a run happens right away and then
+// once per interval
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		s.tick(ctx, time.Now())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Scheduler) tick(ctx context.Context, now time.Time) {
+	reset, err := s.service.ResetDue(ctx, now)
+	if err != nil && ctx.Err() == nil {
+		log.Printf("error resetting monthly credits: %v\n", err)
+	}
+	if reset > 0 {
+		log.Printf("reset monthly credits of %d users\n", reset)
+	}
+
+	released, err := s.service.ReleaseExpired(ctx, now)
+	if err != nil && ctx.Err() == nil {
+		log.Printf("error releasing expired reservations: %v\n", err)
+	}
+	if released > 0 {
+		log.Printf("released %d expired credit reservations\n", released)
+	}
+}
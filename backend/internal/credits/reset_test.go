@@ -0,0 +1,53 @@
+package credits
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestResetDue(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("marks when the balance was replaced", func(mt *mtest.T) {
+		now := time.Date(2026, 10, 1, 0, 5, 0, 0, time.UTC)
+		pending := bson.D{
+			{Key: "transactionId", Value: primitive.NewObjectID()},
+			{Key: "period", Value: MonthStart(now)},
+			{Key: "amount", Value: 40},
+			{Key: "monthly", Value: 50},
+		}
+		mt.AddMockResponses(
+			// nothing left over from an earlier run
+			found("test.users"),
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: bson.D{
+				{Key: "_id", Value: primitive.NewObjectID()},
+				{Key: "credits", Value: bson.D{{Key: "pendingReset", Value: pending}}},
+			}}),
+			written(1), written(1),
+			// no one else is due
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: nil}),
+		)
+
+		reset, err := newTestService(mt).ResetDue(context.Background(), now)
+		require.NoError(mt, err)
+		assert.Equal(mt, 1, reset)
+		assert.Equal(mt, []string{
+			"find users",
+			"findAndModify users",
+			"insert credit_transactions",
+			"update users",
+			"findAndModify users",
+		}, sent(mt))
+
+		// a later release of an older reservation compares against it
+		set := mt.GetAllStartedEvents()[1].Command.Lookup("update").Array().Index(0).Value().Document().Lookup("$set")
+		assert.Equal(mt, now, set.Document().Lookup("credits.resetAt").Time().UTC())
+	})
+}
@@ -0,0 +1,312 @@
+package credits
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	ErrInsufficientCredits = errors.New("insufficient credits")
+	ErrUnknownOperation    = errors.New("operation has no price")
+	ErrReservationReleased = errors.New("the reservation was released")
+)
+
+// Costs is the price list of the billable operations
+var Costs = map[Operation]int{
+	GenerateAnimation: 5,
+	AIChatMessage:     1,
+	ExportHDVideo:     10,
+	Export4KVideo:     25,
+	Export8KVideo:     50,
+	AssetUpload:       1,
+	CompositionSave:   0,
+}
+
+// reservations not finalised after this duration are given back by ReleaseExpired
+const defaultReservationTTL = 15 * time.Minute
+
+// committed marks a reservation claimed by Commit, it can no longer be
+// released and is only waiting for its ledger entry
+const committed = "committed"
+
+// Reservation holds back credits until the billed operation finished.
+// Its id is reused for the ledger entry, which makes a commit idempotent.
+type Reservation struct {
+	Id          primitive.ObjectID     `bson:"_id"`
+	UserId      string                 `bson:"userId"`
+	Amount      int                    `bson:"amount"`
+	Operation   Operation              `bson:"operation"`
+	Description string                 `bson:"description"`
+	Metadata    map[string]interface{} `bson:"metadata,omitempty"`
+	CreatedAt   time.Time              `bson:"createdAt"`
+	ExpiresAt   time.Time              `bson:"expiresAt"`
+	// State is empty while the credits are held
+	State string `bson:"state,omitempty"`
+}
+
+// Service charges billable operations in two steps:
+// Reserve before the work starts, then Commit or Release the reservation.
+type Service struct {
+	*Store
+	ttl time.Duration
+
+	// monthly credits per payment tier, used by the monthly reset
+	allowances       map[string]int
+	defaultAllowance int
+}
+
+func NewService(store *Store, allowances map[string]int, defaultAllowance int) *Service {
+	return &Service{
+		Store:            store,
+		ttl:              defaultReservationTTL,
+		allowances:       allowances,
+		defaultAllowance: defaultAllowance,
+	}
+}
+
+func (s *Service) Reservations() *mongo.Collection {
+	return s.db.Collection("credit_reservations")
+}
+
+// Reserve holds back the price of op, see ReserveAmount
+func (s *Service) Reserve(ctx context.Context, userID string, op Operation, description string, metadata map[string]interface{}) (Reservation, error) {
+	amount, ok := Costs[op]
+	if !ok {
+		return Reservation{}, ErrUnknownOperation
+	}
+	return s.ReserveAmount(ctx, userID, amount, op, description, metadata)
+}
+
+// ReserveFor holds back the price of op for ttl instead of the default
+// lifetime, for operations that wait in a queue before they run
+func (s *Service) ReserveFor(ctx context.Context, userID string, op Operation, ttl time.Duration, description string, metadata map[string]interface{}) (Reservation, error) {
+	amount, ok := Costs[op]
+	if !ok {
+		return Reservation{}, ErrUnknownOperation
+	}
+	return s.reserve(ctx, userID, amount, op, ttl, description, metadata)
+}
+
+// ReserveAmount moves amount credits from the available to the reserved
+// balance. The update only matches if the balance covers the amount, so
+// concurrent reservations can never overdraw it.
+func (s *Service) ReserveAmount(ctx context.Context, userID string, amount int, op Operation, description string, metadata map[string]interface{}) (Reservation, error) {
+	return s.reserve(ctx, userID, amount, op, s.ttl, description, metadata)
+}
+
+func (s *Service) reserve(ctx context.Context, userID string, amount int, op Operation, ttl time.Duration, description string, metadata map[string]interface{}) (Reservation, error) {
+	objectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return Reservation{}, err
+	}
+
+	now := time.Now()
+	reservation := Reservation{
+		Id:          primitive.NewObjectID(),
+		UserId:      userID,
+		Amount:      amount,
+		Operation:   op,
+		Description: description,
+		Metadata:    metadata,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(ttl),
+	}
+
+	// free operations still get a reservation to keep the flow uniform
+	if amount > 0 {
+		result, err := s.Users().UpdateOne(ctx,
+			bson.M{"_id": objectID, "credits.current": bson.M{"$gte": amount}},
+			bson.M{"$inc": bson.M{
+				"credits.current":  -amount,
+				"credits.reserved": amount,
+			}})
+		if err != nil {
+			return Reservation{}, err
+		}
+		if result.MatchedCount == 0 {
+			return Reservation{}, ErrInsufficientCredits
+		}
+	}
+
+	_, err = s.Reservations().InsertOne(ctx, reservation)
+	if err != nil {
+		// give the credits back, the reservation does not exist
+		_ = s.refund(ctx, reservation)
+		return Reservation{}, err
+	}
+
+	return reservation, nil
+}
+
+// Commit turns the reservation into a ledger entry. The reservation is
+// claimed first, so a concurrent Release either refunds it before or
+// leaves it alone. Committing a released reservation fails with
+// ErrReservationReleased.
+func (s *Service) Commit(ctx context.Context, r Reservation) (Transaction, error) {
+	claimed, err := s.Reservations().UpdateOne(ctx,
+		bson.M{"_id": r.Id},
+		bson.M{"$set": bson.M{"state": committed}})
+	if err != nil {
+		return Transaction{}, err
+	}
+	if claimed.MatchedCount == 0 {
+		// gone, either finalised by an earlier commit or released
+		var transaction Transaction
+		err := s.Transactions().FindOne(ctx, bson.M{"_id": r.Id}).Decode(&transaction)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return Transaction{}, ErrReservationReleased
+		}
+		return transaction, err
+	}
+
+	transaction := Transaction{
+		Id:          r.Id,
+		UserId:      r.UserId,
+		Amount:      -r.Amount,
+		Operation:   r.Operation,
+		Description: r.Description,
+		Metadata:    r.Metadata,
+		CreatedAt:   time.Now(),
+	}
+
+	// a duplicate means an earlier commit got this far already
+	_, err = s.Transactions().InsertOne(ctx, transaction)
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		return Transaction{}, err
+	}
+
+	return transaction, s.finalise(ctx, r)
+}
+
+// Release gives the reserved credits back to the user. Deleting the
+// reservation claims it, a reservation claimed by Commit is left alone.
+func (s *Service) Release(ctx context.Context, r Reservation) error {
+	_, err := s.release(ctx, r)
+	return err
+}
+
+// release tells whether the credits were given back
+func (s *Service) release(ctx context.Context, r Reservation) (bool, error) {
+	result, err := s.Reservations().DeleteOne(ctx, bson.M{"_id": r.Id, "state": bson.M{"$ne": committed}})
+	if err != nil {
+		return false, err
+	}
+
+	// already committed or released
+	if result.DeletedCount == 0 {
+		return false, nil
+	}
+
+	return true, s.refund(ctx, r)
+}
+
+// Charge reserves and commits in one go, for operations that cannot fail
+// after the payment
+func (s *Service) Charge(ctx context.Context, userID string, op Operation, description string, metadata map[string]interface{}) (Transaction, error) {
+	reservation, err := s.Reserve(ctx, userID, op, description, metadata)
+	if err != nil {
+		return Transaction{}, err
+	}
+	return s.Commit(ctx, reservation)
+}
+
+// ReleaseExpired cleans up reservations whose owner never finished them,
+// e.g. after a crash. Reservations claimed by a commit or found in the
+// ledger are finalised, all others are refunded.
+func (s *Service) ReleaseExpired(ctx context.Context, now time.Time) (int, error) {
+	cursor, err := s.Reservations().Find(ctx, bson.M{"expiresAt": bson.M{"$lt": now}})
+	if err != nil {
+		return 0, err
+	}
+
+	var expired []Reservation
+	if err = cursor.All(ctx, &expired); err != nil {
+		return 0, err
+	}
+
+	released := 0
+	for _, r := range expired {
+		if r.State != committed {
+			// reservations from before the state was kept may have reached
+			// the ledger nonetheless
+			err := s.Transactions().FindOne(ctx, bson.M{"_id": r.Id}).Err()
+			if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+				return released, err
+			}
+			if err == nil {
+				r.State = committed
+			}
+		}
+
+		if r.State == committed {
+			// the commit stopped halfway, it is carried on
+			if _, err := s.Commit(ctx, r); err != nil && !errors.Is(err, ErrReservationReleased) {
+				return released, err
+			}
+			released++
+			continue
+		}
+
+		ok, err := s.release(ctx, r)
+		if err != nil {
+			return released, err
+		}
+		if ok {
+			released++
+		}
+	}
+
+	return released, nil
+}
+
+// finalise drops a committed reservation and its reserved amount
+func (s *Service) finalise(ctx context.Context, r Reservation) error {
+	result, err := s.Reservations().DeleteOne(ctx, bson.M{"_id": r.Id, "state": committed})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 || r.Amount == 0 {
+		return nil
+	}
+
+	objectID, err := primitive.ObjectIDFromHex(r.UserId)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.Users().UpdateOne(ctx,
+		bson.M{"_id": objectID},
+		bson.M{"$inc": bson.M{"credits.reserved": -r.Amount}})
+	return err
+}
+
+func (s *Service) refund(ctx context.Context, r Reservation) error {
+	if r.Amount == 0 {
+		return nil
+	}
+
+	objectID, err := primitive.ObjectIDFromHex(r.UserId)
+	if err != nil {
+		return err
+	}
+
+	// a monthly reset since the reservation replaced the balance it was
+	// taken from, giving it back would go beyond the allowance
+	refunded := bson.M{"$cond": bson.A{
+		bson.M{"$lte": bson.A{bson.M{"$ifNull": bson.A{"$credits.resetAt", r.CreatedAt}}, r.CreatedAt}},
+		r.Amount,
+		0,
+	}}
+	_, err = s.Users().UpdateOne(ctx,
+		bson.M{"_id": objectID},
+		bson.A{bson.M{"$set": bson.M{
+			"credits.current":  bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$credits.current", 0}}, refunded}},
+			"credits.reserved": bson.M{"$subtract": bson.A{bson.M{"$ifNull": bson.A{"$credits.reserved", 0}}, r.Amount}},
+		}}})
+	return err
+}
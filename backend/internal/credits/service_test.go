@@ -0,0 +1,211 @@
+package credits
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// the mock deployment answers the commands in order with the queued
+// responses, the tests check what was sent
+
+func written(n int) bson.D {
+	return mtest.CreateSuccessResponse(bson.E{Key: "n", Value: n}, bson.E{Key: "nModified", Value: n})
+}
+
+func found(ns string, docs ...bson.D) bson.D {
+	return mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, docs...)
+}
+
+// sent lists the commands as "name collection"
+func sent(mt *mtest.T) []string {
+	commands := []string{}
+	for _, e := range mt.GetAllStartedEvents() {
+		commands = append(commands, e.CommandName+" "+e.Command.Lookup(e.CommandName).StringValue())
+	}
+	return commands
+}
+
+// statement returns the first update or delete statement of a command,
+// the filter of a find
+func statement(e *event.CommandStartedEvent) bson.Raw {
+	switch e.CommandName {
+	case "update":
+		return e.Command.Lookup("updates").Array().Index(0).Value().Document()
+	case "delete":
+		return e.Command.Lookup("deletes").Array().Index(0).Value().Document()
+	case "find":
+		return e.Command.Lookup("filter").Document()
+	}
+	return e.Command
+}
+
+// refunded returns the refund of a release and the reservation time it
+// is compared with, it only applies if no reset ran since
+func refunded(update bson.Raw) (int32, time.Time) {
+	set := update.Lookup("u").Array().Index(0).Value().Document().Lookup("$set")
+	cond, _ := set.Document().Lookup("credits.current", "$add").Array().Values()
+	args, _ := cond[1].Document().Lookup("$cond").Array().Values()
+	reservedAt := args[0].Document().Lookup("$lte").Array().Index(1).Value().Time()
+	return args[1].Int32(), reservedAt
+}
+
+func newTestService(mt *mtest.T) *Service {
+	return &Service{Store: &Store{db: mt.DB}, ttl: defaultReservationTTL}
+}
+
+func reservation(amount int) Reservation {
+	return Reservation{
+		Id:        primitive.NewObjectID(),
+		UserId:    primitive.NewObjectID().Hex(),
+		Amount:    amount,
+		Operation: ExportHDVideo,
+		ExpiresAt: time.Now().Add(-time.Minute),
+	}
+}
+
+func TestReserve(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	ctx := context.Background()
+	userID := primitive.NewObjectID().Hex()
+
+	mt.Run("holds the price back", func(mt *mtest.T) {
+		mt.AddMockResponses(written(1), written(1))
+		r, err := newTestService(mt).Reserve(ctx, userID, ExportHDVideo, "export", nil)
+		require.NoError(mt, err)
+		assert.Equal(mt, 10, r.Amount)
+		assert.Equal(mt, []string{"update users", "insert credit_reservations"}, sent(mt))
+
+		// only a balance covering the price matches
+		update := statement(mt.GetAllStartedEvents()[0])
+		assert.Equal(mt, int32(10), update.Lookup("q", "credits.current", "$gte").Int32())
+		assert.Equal(mt, int32(-10), update.Lookup("u", "$inc", "credits.current").Int32())
+	})
+
+	mt.Run("insufficient credits", func(mt *mtest.T) {
+		mt.AddMockResponses(written(0))
+		_, err := newTestService(mt).Reserve(ctx, userID, Export8KVideo, "export", nil)
+		assert.ErrorIs(mt, err, ErrInsufficientCredits)
+		assert.Equal(mt, []string{"update users"}, sent(mt))
+	})
+
+	mt.Run("unknown operation", func(mt *mtest.T) {
+		_, err := newTestService(mt).Reserve(ctx, userID, MonthlyReset, "reset", nil)
+		assert.ErrorIs(mt, err, ErrUnknownOperation)
+		assert.Empty(mt, sent(mt))
+	})
+}
+
+func TestCommit(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	ctx := context.Background()
+
+	mt.Run("claims before the ledger", func(mt *mtest.T) {
+		r := reservation(25)
+		mt.AddMockResponses(written(1), written(1), written(1), written(1))
+		transaction, err := newTestService(mt).Commit(ctx, r)
+		require.NoError(mt, err)
+		assert.Equal(mt, r.Id, transaction.Id)
+		assert.Equal(mt, -25, transaction.Amount)
+		assert.Equal(mt, []string{
+			"update credit_reservations",
+			"insert credit_transactions",
+			"delete credit_reservations",
+			"update users",
+		}, sent(mt))
+
+		events := mt.GetAllStartedEvents()
+		assert.Equal(mt, committed, statement(events[0]).Lookup("u", "$set", "state").StringValue())
+		// only the claimed reservation is finalised
+		assert.Equal(mt, committed, statement(events[2]).Lookup("q", "state").StringValue())
+		assert.Equal(mt, int32(-25), statement(events[3]).Lookup("u", "$inc", "credits.reserved").Int32())
+	})
+
+	mt.Run("repeated after finalising", func(mt *mtest.T) {
+		r := reservation(25)
+		mt.AddMockResponses(written(0), found("test.credit_transactions",
+			bson.D{{Key: "_id", Value: r.Id}, {Key: "userId", Value: r.UserId}, {Key: "amount", Value: -25}}))
+		transaction, err := newTestService(mt).Commit(ctx, r)
+		require.NoError(mt, err)
+		assert.Equal(mt, -25, transaction.Amount)
+		assert.Equal(mt, []string{"update credit_reservations", "find credit_transactions"}, sent(mt))
+	})
+
+	mt.Run("after a release", func(mt *mtest.T) {
+		mt.AddMockResponses(written(0), found("test.credit_transactions"))
+		_, err := newTestService(mt).Commit(ctx, reservation(25))
+		assert.ErrorIs(mt, err, ErrReservationReleased)
+		// no ledger entry for credits that were given back
+		assert.Equal(mt, []string{"update credit_reservations", "find credit_transactions"}, sent(mt))
+	})
+}
+
+func TestRelease(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	ctx := context.Background()
+
+	mt.Run("refunds", func(mt *mtest.T) {
+		r := reservation(10)
+		r.CreatedAt = time.Now().Add(-time.Hour)
+		mt.AddMockResponses(written(1), written(1))
+		require.NoError(mt, newTestService(mt).Release(ctx, r))
+		assert.Equal(mt, []string{"delete credit_reservations", "update users"}, sent(mt))
+
+		events := mt.GetAllStartedEvents()
+		// a reservation claimed by a commit does not match
+		assert.Equal(mt, committed, statement(events[0]).Lookup("q", "state", "$ne").StringValue())
+		amount, reservedAt := refunded(statement(events[1]))
+		assert.Equal(mt, int32(10), amount)
+		assert.WithinDuration(mt, r.CreatedAt, reservedAt, time.Millisecond)
+		reserved, _ := statement(events[1]).Lookup("u").Array().Index(0).Value().Document().
+			Lookup("$set", "credits.reserved", "$subtract").Array().Values()
+		assert.Equal(mt, int32(10), reserved[1].Int32())
+	})
+
+	mt.Run("committed or released already", func(mt *mtest.T) {
+		mt.AddMockResponses(written(0))
+		require.NoError(mt, newTestService(mt).Release(ctx, reservation(10)))
+		assert.Equal(mt, []string{"delete credit_reservations"}, sent(mt))
+	})
+}
+
+func TestReleaseExpired(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("finishes commits and refunds the rest", func(mt *mtest.T) {
+		claimed, held := reservation(10), reservation(5)
+		mt.AddMockResponses(
+			found("test.credit_reservations",
+				bson.D{{Key: "_id", Value: claimed.Id}, {Key: "userId", Value: claimed.UserId}, {Key: "amount", Value: 10}, {Key: "state", Value: committed}},
+				bson.D{{Key: "_id", Value: held.Id}, {Key: "userId", Value: held.UserId}, {Key: "amount", Value: 5}}),
+			// the claimed one is committed to the end
+			written(1), written(1), written(1), written(1),
+			// the held one is not in the ledger and refunded
+			found("test.credit_transactions"), written(1), written(1),
+		)
+
+		released, err := newTestService(mt).ReleaseExpired(context.Background(), time.Now())
+		require.NoError(mt, err)
+		assert.Equal(mt, 2, released)
+		assert.Equal(mt, []string{
+			"find credit_reservations",
+			"update credit_reservations",
+			"insert credit_transactions",
+			"delete credit_reservations",
+			"update users",
+			"find credit_transactions",
+			"delete credit_reservations",
+			"update users",
+		}, sent(mt))
+
+		amount, _ := refunded(statement(mt.GetAllStartedEvents()[7]))
+		assert.Equal(mt, int32(5), amount)
+	})
+}
@@ -0,0 +1,221 @@
+package credits
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Operation mirrors the CreditTransaction.operation enum of the api spec
+type Operation string
+
+const (
+	GenerateAnimation Operation = "generate_animation"
+	ExportHDVideo     Operation = "export_hd_video"
+	Export4KVideo     Operation = "export_4k_video"
+	Export8KVideo     Operation = "export_8k_video"
+	AIChatMessage     Operation = "ai_chat_message"
+	AssetUpload       Operation = "asset_upload"
+	CompositionSave   Operation = "composition_save"
+	MonthlyReset      Operation = "monthly_reset"
+	Purchase          Operation = "purchase"
+)
+
+// Balance is the credit state embedded in the user document
+type Balance struct {
+	Current   int       `bson:"current"`
+	Reserved  int       `bson:"reserved"`
+	Monthly   int       `bson:"monthly"`
+	LastReset time.Time `bson:"lastReset"`
+	// ResetAt is when the last monthly reset ran
+	ResetAt time.Time `bson:"resetAt,omitempty"`
+}
+
+// Transaction is a single append-only ledger entry
+type Transaction struct {
+	Id          primitive.ObjectID     `bson:"_id,omitempty"`
+	UserId      string                 `bson:"userId"`
+	Amount      int                    `bson:"amount"`
+	Operation   Operation              `bson:"operation"`
+	Description string                 `bson:"description"`
+	Metadata    map[string]interface{} `bson:"metadata,omitempty"`
+	CreatedAt   time.Time              `bson:"createdAt"`
+}
+
+type Store struct {
+	db *mongo.Database
+}
+
+func initIndexes(db *mongo.Database) {
+	coll := db.Collection("credit_transactions")
+
+	indexModel := mongo.IndexModel{
+		Keys: bson.D{{Key: "userId", Value: 1}, {Key: "createdAt", Value: -1}},
+	}
+
+	// create the index for the per user history and the usage aggregation
+	_, err := coll.Indexes().CreateOne(context.TODO(), indexModel)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	// create the index to find expired reservations
+	_, err = db.Collection("credit_reservations").Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys: bson.D{{Key: "expiresAt", Value: 1}},
+	})
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+}
+
+func NewStore(db *mongo.Database) *Store {
+	initIndexes(db)
+	return &Store{
+		db: db,
+	}
+}
+
+func (s *Store) Users() *mongo.Collection {
+	return s.db.Collection("users")
+}
+
+func (s *Store) Transactions() *mongo.Collection {
+	return s.db.Collection("credit_transactions")
+}
+
+// Balance returns the credit state of the user, a user without any credit
+// information yields the zero balance
+func (s *Store) Balance(ctx context.Context, userID string) (Balance, error) {
+	objectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return Balance{}, err
+	}
+
+	var user struct {
+		Credits Balance `bson:"credits"`
+	}
+
+	opts := options.FindOne().SetProjection(bson.M{"credits": 1})
+	err = s.Users().FindOne(ctx, bson.M{"_id": objectID}, opts).Decode(&user)
+	if err != nil {
+		return Balance{}, err
+	}
+
+	return user.Credits, nil
+}
+
+// Apply appends a ledger entry and moves the balance by the same amount.
+// The ledger is written first, so a failed balance update can always be
+// repaired with Rebuild.
+func (s *Store) Apply(ctx context.Context, userID string, amount int, op Operation, description string, metadata map[string]interface{}) (Transaction, error) {
+	objectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return Transaction{}, err
+	}
+
+	transaction := Transaction{
+		Id:          primitive.NewObjectID(),
+		UserId:      userID,
+		Amount:      amount,
+		Operation:   op,
+		Description: description,
+		Metadata:    metadata,
+		CreatedAt:   time.Now(),
+	}
+
+	_, err = s.Transactions().InsertOne(ctx, transaction)
+	if err != nil {
+		return Transaction{}, err
+	}
+
+	_, err = s.Users().UpdateOne(ctx,
+		bson.M{"_id": objectID},
+		bson.M{"$inc": bson.M{"credits.current": amount}})
+	if err != nil {
+		return Transaction{}, err
+	}
+
+	return transaction, nil
+}
+
+// Recent returns the latest ledger entries of the user, newest first
+func (s *Store) Recent(ctx context.Context, userID string, limit int64) ([]Transaction, error) {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: -1}}).
+		SetLimit(limit)
+
+	cursor, err := s.Transactions().Find(ctx, bson.M{"userId": userID}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	transactions := make([]Transaction, 0)
+	if err = cursor.All(ctx, &transactions); err != nil {
+		return nil, err
+	}
+
+	return transactions, nil
+}
+
+// Rebuild recomputes the balance of the user from the ledger and the open
+// reservations and stores it
+func (s *Store) Rebuild(ctx context.Context, userID string) (int, error) {
+	objectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return 0, err
+	}
+
+	total, err := sumAmounts(ctx, s.Transactions(), userID)
+	if err != nil {
+		return 0, err
+	}
+
+	reserved, err := sumAmounts(ctx, s.db.Collection("credit_reservations"), userID)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = s.Users().UpdateOne(ctx,
+		bson.M{"_id": objectID},
+		bson.M{"$set": bson.M{
+			"credits.current":  total - reserved,
+			"credits.reserved": reserved,
+		}})
+	if err != nil {
+		return 0, err
+	}
+
+	return total - reserved, nil
+}
+
+func sumAmounts(ctx context.Context, coll *mongo.Collection, userID string) (int, error) {
+	pipeline := bson.A{
+		bson.D{{Key: "$match", Value: bson.D{{Key: "userId", Value: userID}}}},
+		bson.D{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: nil},
+			{Key: "total", Value: bson.D{{Key: "$sum", Value: "$amount"}}},
+		}}},
+	}
+
+	cursor, err := coll.Aggregate(ctx, pipeline)
+	if err != nil {
+		return 0, err
+	}
+
+	var result []struct {
+		Total int `bson:"total"`
+	}
+	if err = cursor.All(ctx, &result); err != nil {
+		return 0, err
+	}
+
+	if len(result) == 0 {
+		return 0, nil
+	}
+	return result[0].Total, nil
+}
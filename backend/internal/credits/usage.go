@@ -0,0 +1,132 @@
+package credits
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/Pieli/server/internal/util"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// OperationUsage sums the credits spent on a single operation
+type OperationUsage struct {
+	Operation   Operation `bson:"operation"`
+	Count       int       `bson:"count"`
+	CreditsUsed int       `bson:"creditsUsed"`
+}
+
+// Usage summarises the spending of a user for the credit page
+type Usage struct {
+	CurrentMonthUsed int
+	LastMonthUsed    int
+	TopOperations    []OperationUsage
+}
+
+// MonthStart returns the first instant of the month t is in (UTC)
+func MonthStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// topOperationsLimit caps the operations listed in the usage
+const topOperationsLimit = 5
+
+type usageFacets struct {
+	Months []struct {
+		Month string `bson:"_id"`
+		Used  int    `bson:"used"`
+	} `bson:"months"`
+	Operations []OperationUsage `bson:"operations"`
+}
+
+// billable lists the operations that are paid for, the monthly reset
+// lowers the balance as well but is not spending
+func billable() bson.A {
+	operations := make([]string, 0, len(Costs))
+	for op := range Costs {
+		operations = append(operations, string(op))
+	}
+	sort.Strings(operations)
+
+	billable := bson.A{}
+	for _, op := range operations {
+		billable = append(billable, op)
+	}
+	return billable
+}
+
+// Usage aggregates the spending of the current and the last month.
+// The match stage is covered by the userId+createdAt index, so only the
+// deductions of the last two months are read.
+func (s *Store) Usage(ctx context.Context, userID string, now time.Time) (Usage, error) {
+	currentStart := MonthStart(now)
+	lastStart := currentStart.AddDate(0, -1, 0)
+
+	// deductions are negative, usage is reported positive
+	spent := bson.D{{Key: "$multiply", Value: bson.A{"$amount", -1}}}
+
+	pipeline := bson.A{
+		bson.D{{Key: "$match", Value: bson.D{
+			{Key: "userId", Value: userID},
+			{Key: "createdAt", Value: bson.D{{Key: "$gte", Value: lastStart}}},
+			{Key: "amount", Value: bson.D{{Key: "$lt", Value: 0}}},
+			{Key: "operation", Value: bson.D{{Key: "$in", Value: billable()}}},
+		}}},
+		bson.D{{Key: "$facet", Value: bson.D{
+			{Key: "months", Value: bson.A{
+				bson.D{{Key: "$group", Value: bson.D{
+					{Key: "_id", Value: bson.D{{Key: "$cond", Value: bson.A{
+						bson.D{{Key: "$gte", Value: bson.A{"$createdAt", currentStart}}},
+						"current",
+						"last",
+					}}}},
+					{Key: "used", Value: bson.D{{Key: "$sum", Value: spent}}},
+				}}},
+			}},
+			{Key: "operations", Value: bson.A{
+				bson.D{{Key: "$match", Value: bson.D{
+					{Key: "createdAt", Value: bson.D{{Key: "$gte", Value: currentStart}}},
+				}}},
+				bson.D{{Key: "$group", Value: bson.D{
+					{Key: "_id", Value: "$operation"},
+					{Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}},
+					{Key: "creditsUsed", Value: bson.D{{Key: "$sum", Value: spent}}},
+				}}},
+				bson.D{{Key: "$sort", Value: bson.D{
+					{Key: "count", Value: -1},
+					{Key: "creditsUsed", Value: -1},
+				}}},
+				bson.D{{Key: "$limit", Value: topOperationsLimit}},
+				bson.D{{Key: "$project", Value: bson.D{
+					{Key: "_id", Value: 0},
+					{Key: "operation", Value: "$_id"},
+					{Key: "count", Value: 1},
+					{Key: "creditsUsed", Value: 1},
+				}}},
+			}},
+		}}},
+	}
+
+	facets, err := util.GetAllGeneric[usageFacets](s.Transactions(), pipeline, ctx)
+	if err != nil {
+		return Usage{}, err
+	}
+
+	usage := Usage{TopOperations: []OperationUsage{}}
+	if len(facets) == 0 {
+		return usage, nil
+	}
+
+	for _, month := range facets[0].Months {
+		switch month.Month {
+		case "current":
+			usage.CurrentMonthUsed = month.Used
+		case "last":
+			usage.LastMonthUsed = month.Used
+		}
+	}
+	usage.TopOperations = append(usage.TopOperations, facets[0].Operations...)
+
+	return usage, nil
+}
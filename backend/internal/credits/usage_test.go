@@ -0,0 +1,63 @@
+package credits
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestMonthStart(t *testing.T) {
+	now := time.Date(2025, 3, 31, 23, 30, 0, 0, time.FixedZone("CET", -2*60*60))
+	assert.Equal(t, time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC), MonthStart(now))
+}
+
+func TestUsage(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("counts only billable operations", func(mt *mtest.T) {
+		mt.AddMockResponses(found("test.credit_transactions", bson.D{
+			{Key: "months", Value: bson.A{
+				bson.D{{Key: "_id", Value: "current"}, {Key: "used", Value: 35}},
+				bson.D{{Key: "_id", Value: "last"}, {Key: "used", Value: 5}},
+			}},
+			{Key: "operations", Value: bson.A{
+				bson.D{{Key: "operation", Value: "export_4k_video"}, {Key: "count", Value: 1}, {Key: "creditsUsed", Value: 25}},
+				bson.D{{Key: "operation", Value: "export_hd_video"}, {Key: "count", Value: 1}, {Key: "creditsUsed", Value: 10}},
+			}},
+		}))
+
+		usage, err := (&Store{db: mt.DB}).Usage(context.Background(), "user", time.Now())
+		require.NoError(mt, err)
+		assert.Equal(mt, 35, usage.CurrentMonthUsed)
+		assert.Equal(mt, 5, usage.LastMonthUsed)
+		assert.Equal(mt, []OperationUsage{
+			{Operation: Export4KVideo, Count: 1, CreditsUsed: 25},
+			{Operation: ExportHDVideo, Count: 1, CreditsUsed: 10},
+		}, usage.TopOperations)
+
+		// a reset down from a larger balance is a deduction in the ledger
+		// too, the match must leave it out
+		ledger := []Transaction{
+			{Operation: Export4KVideo, Amount: -25},
+			{Operation: ExportHDVideo, Amount: -10},
+			{Operation: MonthlyReset, Amount: -150},
+		}
+
+		match := mt.GetStartedEvent().Command.Lookup("pipeline").Array().Index(0).Value().Document().Lookup("$match")
+		values, err := match.Document().Lookup("operation", "$in").Array().Values()
+		require.NoError(mt, err)
+		matched := map[Operation]bool{}
+		for _, v := range values {
+			matched[Operation(v.StringValue())] = true
+		}
+		for _, entry := range ledger {
+			assert.Equal(mt, entry.Operation != MonthlyReset, matched[entry.Operation], entry.Operation)
+		}
+		assert.False(mt, matched[Purchase])
+	})
+}
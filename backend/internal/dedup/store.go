@@ -0,0 +1,216 @@
+package dedup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrQuotaExceeded = errors.New("the upload exceeds the storage quota")
+
+// Blob is a stored content, shared by every asset with the same hash
+type Blob struct {
+	Hash     string `bson:"_id"`
+	Key      string `bson:"key"`
+	Size     int64  `bson:"size"`
+	RefCount int    `bson:"refCount"`
+	// Stored is set once the content was written to the key
+	Stored bool `bson:"stored"`
+	// Info is what processing learned about the content, it is set
+	// together with Stored and decoded by the caller
+	Info      bson.Raw  `bson:"info,omitempty"`
+	CreatedAt time.Time `bson:"createdAt"`
+}
+
+// owner counts the references of a single user to a blob, the size
+// of every owned blob counts once towards the quota of the user
+type owner struct {
+	Id       string `bson:"_id"`
+	UserId   string `bson:"userId"`
+	Hash     string `bson:"hash"`
+	Size     int64  `bson:"size"`
+	RefCount int    `bson:"refCount"`
+}
+
+func ownerID(userID, hash string) string {
+	return userID + "/" + hash
+}
+
+// Key names the blob of a content. Each time a content is stored anew
+// it gets a fresh key, so a blob being deleted after its last release is
+// never the one a new reference points at.
+func Key(hash string) string {
+	return fmt.Sprintf("blobs/sha256/%s/%s", hash, primitive.NewObjectID().Hex())
+}
+
+type Store struct {
+	db *mongo.Database
+}
+
+func initIndexes(db *mongo.Database) {
+	// create the index for the usage of a user
+	_, err := db.Collection("blob_owners").Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys: bson.D{{Key: "userId", Value: 1}},
+	})
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+}
+
+func NewStore(db *mongo.Database) *Store {
+	initIndexes(db)
+	return &Store{
+		db: db,
+	}
+}
+
+func (s *Store) Blobs() *mongo.Collection {
+	return s.db.Collection("blobs")
+}
+
+func (s *Store) owners() *mongo.Collection {
+	return s.db.Collection("blob_owners")
+}
+
+// Acquire adds a reference of the user to the content with the given hash.
+// Content the user does not reference yet has to fit into the quota, a
+// quota of zero is unlimited. When the returned blob is not Stored the
+// caller writes the content to its key and calls MarkStored.
+//
+// The reference of the user is taken before the quota is checked, so
+// concurrent uploads see each other in the usage. The one that does not
+// fit gives its reference back.
+func (s *Store) Acquire(ctx context.Context, userID, hash string, size, quota int64) (Blob, error) {
+	var owned owner
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	err := s.owners().FindOneAndUpdate(ctx,
+		bson.M{"_id": ownerID(userID, hash)},
+		bson.M{
+			"$inc":         bson.M{"refCount": 1},
+			"$setOnInsert": bson.M{"userId": userID, "hash": hash, "size": size},
+		}, opts).Decode(&owned)
+	if err != nil {
+		return Blob{}, err
+	}
+
+	if quota > 0 && owned.RefCount == 1 {
+		used, err := s.Usage(ctx, userID)
+		if err == nil && used > quota {
+			err = ErrQuotaExceeded
+		}
+		if err != nil {
+			_ = s.disown(context.WithoutCancel(ctx), userID, hash)
+			return Blob{}, err
+		}
+	}
+
+	var blob Blob
+	err = s.Blobs().FindOneAndUpdate(ctx,
+		bson.M{"_id": hash},
+		bson.M{
+			"$inc":         bson.M{"refCount": 1},
+			"$setOnInsert": bson.M{"key": Key(hash), "size": size, "stored": false, "createdAt": time.Now()},
+		}, opts).Decode(&blob)
+	if err != nil {
+		_ = s.disown(context.WithoutCancel(ctx), userID, hash)
+		return Blob{}, err
+	}
+
+	return blob, nil
+}
+
+// MarkStored records that the content of the blob was written, along
+// with what processing learned about it
+func (s *Store) MarkStored(ctx context.Context, blob Blob, info interface{}) error {
+	_, err := s.Blobs().UpdateOne(ctx,
+		bson.M{"_id": blob.Hash, "key": blob.Key},
+		bson.M{"$set": bson.M{"stored": true, "info": info}})
+	return err
+}
+
+// Release drops a reference of the user to the content. After the last
+// reference is gone the blob is returned, its files are for deletion.
+func (s *Store) Release(ctx context.Context, userID, hash string) (*Blob, error) {
+	if err := s.disown(ctx, userID, hash); err != nil {
+		return nil, err
+	}
+
+	var blob Blob
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := s.Blobs().FindOneAndUpdate(ctx,
+		bson.M{"_id": hash},
+		bson.M{"$inc": bson.M{"refCount": -1}}, opts).Decode(&blob)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if blob.RefCount > 0 {
+		return nil, nil
+	}
+
+	// a reference taken in the meantime keeps the blob alive
+	result, err := s.Blobs().DeleteOne(ctx, bson.M{"_id": hash, "key": blob.Key, "refCount": bson.M{"$lte": 0}})
+	if err != nil {
+		return nil, err
+	}
+	if result.DeletedCount == 0 {
+		return nil, nil
+	}
+	return &blob, nil
+}
+
+// disown drops a reference of the user, without the last one the content
+// no longer counts towards the quota
+func (s *Store) disown(ctx context.Context, userID, hash string) error {
+	var owned owner
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := s.owners().FindOneAndUpdate(ctx,
+		bson.M{"_id": ownerID(userID, hash)},
+		bson.M{"$inc": bson.M{"refCount": -1}}, opts).Decode(&owned)
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if owned.RefCount <= 0 {
+		_, err = s.owners().DeleteOne(ctx, bson.M{"_id": owned.Id, "refCount": bson.M{"$lte": 0}})
+	}
+	return err
+}
+
+// Usage is the number of unique bytes the user references
+func (s *Store) Usage(ctx context.Context, userID string) (int64, error) {
+	pipeline := bson.A{
+		bson.D{{Key: "$match", Value: bson.D{{Key: "userId", Value: userID}}}},
+		bson.D{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: nil},
+			{Key: "total", Value: bson.D{{Key: "$sum", Value: "$size"}}},
+		}}},
+	}
+
+	cursor, err := s.owners().Aggregate(ctx, pipeline)
+	if err != nil {
+		return 0, err
+	}
+
+	var result []struct {
+		Total int64 `bson:"total"`
+	}
+	if err = cursor.All(ctx, &result); err != nil {
+		return 0, err
+	}
+	if len(result) == 0 {
+		return 0, nil
+	}
+	return result[0].Total, nil
+}
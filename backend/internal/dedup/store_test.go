@@ -0,0 +1,135 @@
+package dedup
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestKeyIsContentAddressed(t *testing.T) {
+	hash := "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+
+	first, second := Key(hash), Key(hash)
+	assert.True(t, strings.HasPrefix(first, "blobs/sha256/"+hash+"/"))
+	assert.NotEqual(t, first, second)
+}
+
+func TestOwnerID(t *testing.T) {
+	assert.Equal(t, "u1/abc", ownerID("u1", "abc"))
+	assert.NotEqual(t, ownerID("u1", "abc"), ownerID("u2", "abc"))
+}
+
+// the mock deployment answers the commands in order with the queued
+// responses, the tests check what was sent
+
+func modified(doc bson.D) bson.D {
+	return mtest.CreateSuccessResponse(bson.E{Key: "value", Value: doc})
+}
+
+func usage(total int64) bson.D {
+	return mtest.CreateCursorResponse(0, "test.blob_owners", mtest.FirstBatch, bson.D{{Key: "total", Value: total}})
+}
+
+func ownerDoc(refCount int) bson.D {
+	return bson.D{{Key: "_id", Value: "u1/abc"}, {Key: "userId", Value: "u1"}, {Key: "hash", Value: "abc"}, {Key: "size", Value: 10}, {Key: "refCount", Value: refCount}}
+}
+
+func blobDoc(refCount int) bson.D {
+	return bson.D{{Key: "_id", Value: "abc"}, {Key: "key", Value: "blobs/sha256/abc/1"}, {Key: "size", Value: 10}, {Key: "refCount", Value: refCount}}
+}
+
+// sent lists the commands as "name collection"
+func sent(mt *mtest.T) []string {
+	commands := []string{}
+	for _, e := range mt.GetAllStartedEvents() {
+		commands = append(commands, e.CommandName+" "+e.Command.Lookup(e.CommandName).StringValue())
+	}
+	return commands
+}
+
+func TestAcquire(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	ctx := context.Background()
+
+	mt.Run("new content within the quota", func(mt *mtest.T) {
+		mt.AddMockResponses(modified(ownerDoc(1)), usage(100), modified(blobDoc(1)))
+		blob, err := (&Store{db: mt.DB}).Acquire(ctx, "u1", "abc", 10, 100)
+		require.NoError(mt, err)
+		assert.Equal(mt, "blobs/sha256/abc/1", blob.Key)
+		assert.False(mt, blob.Stored)
+		// the reference is taken before the usage is summed up
+		assert.Equal(mt, []string{"findAndModify blob_owners", "aggregate blob_owners", "findAndModify blobs"}, sent(mt))
+	})
+
+	mt.Run("content owned already", func(mt *mtest.T) {
+		mt.AddMockResponses(modified(ownerDoc(2)), modified(blobDoc(3)))
+		blob, err := (&Store{db: mt.DB}).Acquire(ctx, "u1", "abc", 10, 5)
+		require.NoError(mt, err)
+		assert.Equal(mt, 3, blob.RefCount)
+		assert.Equal(mt, []string{"findAndModify blob_owners", "findAndModify blobs"}, sent(mt))
+	})
+
+	mt.Run("unlimited quota", func(mt *mtest.T) {
+		mt.AddMockResponses(modified(ownerDoc(1)), modified(blobDoc(1)))
+		_, err := (&Store{db: mt.DB}).Acquire(ctx, "u1", "abc", 10, 0)
+		require.NoError(mt, err)
+		assert.Equal(mt, []string{"findAndModify blob_owners", "findAndModify blobs"}, sent(mt))
+	})
+
+	mt.Run("over the quota gives the reference back", func(mt *mtest.T) {
+		// a concurrent upload took the rest of the quota
+		mt.AddMockResponses(modified(ownerDoc(1)), usage(101), modified(ownerDoc(0)), mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}))
+		_, err := (&Store{db: mt.DB}).Acquire(ctx, "u1", "abc", 10, 100)
+		assert.ErrorIs(mt, err, ErrQuotaExceeded)
+		assert.Equal(mt, []string{
+			"findAndModify blob_owners",
+			"aggregate blob_owners",
+			"findAndModify blob_owners",
+			"delete blob_owners",
+		}, sent(mt))
+
+		rollback := mt.GetAllStartedEvents()[2].Command
+		assert.Equal(mt, int32(-1), rollback.Lookup("update", "$inc", "refCount").Int32())
+	})
+}
+
+func TestRelease(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	ctx := context.Background()
+
+	mt.Run("last reference", func(mt *mtest.T) {
+		mt.AddMockResponses(modified(ownerDoc(0)), mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+			modified(blobDoc(0)), mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}))
+		blob, err := (&Store{db: mt.DB}).Release(ctx, "u1", "abc")
+		require.NoError(mt, err)
+		require.NotNil(mt, blob)
+		assert.Equal(mt, "blobs/sha256/abc/1", blob.Key)
+		assert.Equal(mt, []string{
+			"findAndModify blob_owners",
+			"delete blob_owners",
+			"findAndModify blobs",
+			"delete blobs",
+		}, sent(mt))
+	})
+
+	mt.Run("shared with others", func(mt *mtest.T) {
+		mt.AddMockResponses(modified(ownerDoc(0)), mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}), modified(blobDoc(2)))
+		blob, err := (&Store{db: mt.DB}).Release(ctx, "u1", "abc")
+		require.NoError(mt, err)
+		assert.Nil(mt, blob)
+		assert.Equal(mt, []string{"findAndModify blob_owners", "delete blob_owners", "findAndModify blobs"}, sent(mt))
+	})
+
+	mt.Run("referenced again in the meantime", func(mt *mtest.T) {
+		mt.AddMockResponses(modified(ownerDoc(1)), modified(blobDoc(0)), mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 0}))
+		blob, err := (&Store{db: mt.DB}).Release(ctx, "u1", "abc")
+		require.NoError(mt, err)
+		assert.Nil(mt, blob)
+		assert.Equal(mt, []string{"findAndModify blob_owners", "findAndModify blobs", "delete blobs"}, sent(mt))
+	})
+}
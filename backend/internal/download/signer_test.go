@@ -0,0 +1,60 @@
+package download
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// parse splits a signed URL into the values Verify takes
+func parse(t *testing.T, signed string) (string, int64, string) {
+	path, rawQuery, ok := strings.Cut(signed, "?")
+	require.True(t, ok)
+	query, err := url.ParseQuery(rawQuery)
+	require.NoError(t, err)
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	require.NoError(t, err)
+	return path, expires, query.Get("signature")
+}
+
+func TestSignerRoundTrip(t *testing.T) {
+	signer, err := NewSigner([]byte("secret"), time.Minute)
+	require.NoError(t, err)
+
+	path, expires, signature := parse(t, signer.Sign("/api/downloads/projects/p/assets/a"))
+	assert.Equal(t, "/api/downloads/projects/p/assets/a", path)
+	assert.NoError(t, signer.Verify(path, expires, signature))
+}
+
+func TestSignerRejectsTampering(t *testing.T) {
+	signer, err := NewSigner([]byte("secret"), time.Minute)
+	require.NoError(t, err)
+
+	path, expires, signature := parse(t, signer.Sign("/api/downloads/projects/p/assets/a"))
+
+	assert.ErrorIs(t, signer.Verify("/api/downloads/projects/p/assets/b", expires, signature), ErrInvalidSignature)
+	assert.ErrorIs(t, signer.Verify(path, expires+3600, signature), ErrInvalidSignature)
+	assert.ErrorIs(t, signer.Verify(path, expires, ""), ErrInvalidSignature)
+
+	other, err := NewSigner([]byte("other"), time.Minute)
+	require.NoError(t, err)
+	assert.ErrorIs(t, other.Verify(path, expires, signature), ErrInvalidSignature)
+}
+
+func TestSignerRejectsExpired(t *testing.T) {
+	signer, err := NewSigner([]byte("secret"), time.Minute)
+	require.NoError(t, err)
+
+	path, expires, signature := parse(t, signer.SignUntil("/file", time.Now().Add(-time.Second)))
+	assert.ErrorIs(t, signer.Verify(path, expires, signature), ErrExpired)
+}
+
+func TestNewSignerRequiresSecret(t *testing.T) {
+	_, err := NewSigner(nil, time.Minute)
+	assert.Error(t, err)
+}
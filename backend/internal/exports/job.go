@@ -0,0 +1,128 @@
+package exports
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Pieli/server/internal/credits"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MaxAttempts is how often a job is rendered before it fails for good,
+// a worker that dies during the render uses up an attempt as well
+const MaxAttempts = 3
+
+// DefaultDeadline is how long a job may wait and render in total
+const DefaultDeadline = 24 * time.Hour
+
+var (
+	ErrNoJob     = errors.New("no export job is waiting")
+	ErrLeaseLost = errors.New("the lease of the export job is held by another worker")
+	ErrNotFound  = errors.New("export job not found")
+)
+
+// Status is the state of a job, it mirrors ExportJob.status of the api spec
+type Status string
+
+const (
+	Queued    Status = "queued"
+	Rendering Status = "rendering"
+	Succeeded Status = "succeeded"
+	Failed    Status = "failed"
+)
+
+// qualities lists the export qualities from lowest to highest with the
+// operation they are charged as
+var qualities = []struct {
+	name      string
+	operation credits.Operation
+}{
+	{"HD", credits.ExportHDVideo},
+	{"4K", credits.Export4KVideo},
+	{"8K", credits.Export8KVideo},
+}
+
+// Formats maps the containers a video can be exported as to their MIME type
+var Formats = map[string]string{
+	"mp4":  "video/mp4",
+	"webm": "video/webm",
+}
+
+// Operation returns the credit operation an export of the quality is
+// charged as
+func Operation(quality string) (credits.Operation, bool) {
+	for _, q := range qualities {
+		if q.name == quality {
+			return q.operation, true
+		}
+	}
+	return "", false
+}
+
+// QualityAllowed tells whether a tier exporting up to max may export in
+// the quality
+func QualityAllowed(quality, max string) bool {
+	for _, q := range qualities {
+		if q.name == quality {
+			return true
+		}
+		if q.name == max {
+			return false
+		}
+	}
+	return false
+}
+
+// Job is an export of a project, kept in the export_jobs collection. Its
+// id is reused for the ExportedVideo it results in.
+type Job struct {
+	Id        primitive.ObjectID `bson:"_id"`
+	UserId    string             `bson:"userId"`
+	ProjectId string             `bson:"projectId"`
+	Quality   string             `bson:"quality"`
+	Format    string             `bson:"format"`
+	// Compositions are the JSON of the project compositions when the
+	// export was requested, later edits do not change the video
+	Compositions json.RawMessage `bson:"compositions"`
+	Status       Status          `bson:"status"`
+	// Attempts counts the claims, including the current one
+	Attempts int `bson:"attempts"`
+	// Worker holds the lease until LeaseExpiresAt, an expired lease puts
+	// the job back into the queue
+	Worker         string    `bson:"worker,omitempty"`
+	LeaseExpiresAt time.Time `bson:"leaseExpiresAt,omitempty"`
+	// Stage and Progress are the last report of the worker, Progress
+	// runs from 0 to 100 and starts over with every attempt
+	Stage    string `bson:"stage,omitempty"`
+	Progress int    `bson:"progress"`
+	// Reservation holds back the credits until the video is stored
+	Reservation credits.Reservation `bson:"reservation"`
+	Result      *Result             `bson:"result,omitempty"`
+	// Error is why the last attempt failed
+	Error     string    `bson:"error,omitempty"`
+	CreatedAt time.Time `bson:"createdAt"`
+	UpdatedAt time.Time `bson:"updatedAt"`
+	// Deadline is when a job that has not succeeded yet fails
+	Deadline time.Time `bson:"deadline"`
+}
+
+// Progress is how far the render of a job got, the stages are named by
+// the renderer
+type Progress struct {
+	Stage   string
+	Percent int
+}
+
+// Result is the stored video of a succeeded job
+type Result struct {
+	Key      string  `bson:"key"`
+	Size     int64   `bson:"size"`
+	Duration float64 `bson:"duration"`
+}
+
+// Key names the blob of the video of a job
+func Key(job Job) string {
+	return fmt.Sprintf("exports/%s/%s.%s", job.ProjectId, job.Id.Hex(), job.Format)
+}
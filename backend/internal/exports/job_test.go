@@ -0,0 +1,32 @@
+package exports
+
+import (
+	"testing"
+
+	"github.com/Pieli/server/internal/credits"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestQualityAllowed(t *testing.T) {
+	assert.True(t, QualityAllowed("HD", "HD"))
+	assert.False(t, QualityAllowed("4K", "HD"))
+	assert.True(t, QualityAllowed("4K", "8K"))
+	assert.True(t, QualityAllowed("8K", "8K"))
+	assert.False(t, QualityAllowed("16K", "8K"))
+}
+
+func TestOperation(t *testing.T) {
+	operation, ok := Operation("4K")
+	assert.True(t, ok)
+	assert.Equal(t, credits.Export4KVideo, operation)
+
+	_, ok = Operation("SD")
+	assert.False(t, ok)
+}
+
+func TestKey(t *testing.T) {
+	id, err := primitive.ObjectIDFromHex("65a4f1c2e4b0a1b2c3d4e5f7")
+	assert.NoError(t, err)
+	assert.Equal(t, "exports/p1/65a4f1c2e4b0a1b2c3d4e5f7.webm", Key(Job{Id: id, ProjectId: "p1", Format: "webm"}))
+}
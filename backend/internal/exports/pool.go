@@ -0,0 +1,194 @@
+package exports
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultLease is how long a job stays with a worker without a
+	// heartbeat, workers renew it three times per lease
+	DefaultLease = time.Minute
+	// DefaultPoll is how long an idle worker waits before asking again
+	DefaultPoll = 5 * time.Second
+	// reportInterval spaces the progress reports within a stage, a new
+	// stage is reported right away
+	reportInterval = time.Second
+)
+
+// Output is the video a render produced
+type Output struct {
+	// Path is the file the video was written to, the pool removes it
+	// once the job is completed
+	Path     string
+	Duration float64
+}
+
+// Render produces the video of a claimed job, it calls progress whenever
+// the render advanced
+type Render func(ctx context.Context, job Job, progress func(Progress)) (Output, error)
+
+// Queue hands out the jobs to the workers of a pool, the Service is the
+// one backed by mongo
+type Queue interface {
+	// Claim leases the next job to the worker or fails with ErrNoJob
+	Claim(ctx context.Context, worker string, lease time.Duration) (Job, error)
+	// Renew extends the lease or fails with ErrLeaseLost
+	Renew(ctx context.Context, job Job, worker string, lease time.Duration) error
+	// Report stores the progress or fails with ErrLeaseLost
+	Report(ctx context.Context, job Job, worker string, progress Progress) error
+	Complete(ctx context.Context, job Job, worker string, output Output) error
+	Fail(ctx context.Context, job Job, worker string, reason error) error
+}
+
+// Pool runs a number of workers that claim jobs from the queue and render
+// them. A job whose lease is lost is abandoned, it is in other hands.
+type Pool struct {
+	queue   Queue
+	render  Render
+	name    string
+	workers int
+	lease   time.Duration
+	poll    time.Duration
+}
+
+// NewPool creates a pool of workers named after name and their number
+func NewPool(queue Queue, render Render, name string, workers int) *Pool {
+	return &Pool{
+		queue:   queue,
+		render:  render,
+		name:    name,
+		workers: workers,
+		lease:   DefaultLease,
+		poll:    DefaultPoll,
+	}
+}
+
+// Run blocks until ctx is cancelled and every worker stopped. Renders
+// running at that point are cancelled, their jobs go back into the queue
+// once the lease expired.
+func (p *Pool) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < p.workers; i++ {
+		wg.Add(1)
+		go func(worker string) {
+			defer wg.Done()
+			p.work(ctx, worker)
+		}(fmt.Sprintf("%s-%d", p.name, i))
+	}
+	wg.Wait()
+}
+
+func (p *Pool) work(ctx context.Context, worker string) {
+	for {
+		job, err := p.queue.Claim(ctx, worker, p.lease)
+		if err == nil {
+			p.process(ctx, worker, job)
+			continue
+		}
+		if !errors.Is(err, ErrNoJob) && ctx.Err() == nil {
+			log.Printf("error claiming an export job: %v\n", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(p.poll):
+		}
+	}
+}
+
+// process renders and completes the job while a heartbeat keeps the
+// lease, an upload may take longer than the lease
+func (p *Pool) process(ctx context.Context, worker string, job Job) {
+	leaseCtx, cancel := context.WithCancel(ctx)
+	var heartbeat sync.WaitGroup
+	heartbeat.Add(1)
+	go func() {
+		defer heartbeat.Done()
+		p.heartbeat(leaseCtx, cancel, worker, job)
+	}()
+	stop := func() {
+		cancel()
+		heartbeat.Wait()
+	}
+	defer stop()
+
+	output, err := p.render(leaseCtx, job, p.reporter(leaseCtx, cancel, worker, job))
+	if output.Path != "" {
+		defer os.Remove(output.Path)
+	}
+	if err == nil {
+		err = p.queue.Complete(leaseCtx, job, worker, output)
+		if err != nil && leaseCtx.Err() == nil {
+			log.Printf("error completing export job %s: %v\n", job.Id.Hex(), err)
+		}
+	}
+
+	// shutting down or the lease is lost, the job is not ours to report
+	if err == nil || leaseCtx.Err() != nil || errors.Is(err, ErrLeaseLost) {
+		return
+	}
+
+	// the lease is given up with the failure
+	stop()
+	if err := p.queue.Fail(ctx, job, worker, err); err != nil && !errors.Is(err, ErrLeaseLost) {
+		log.Printf("error failing export job %s: %v\n", job.Id.Hex(), err)
+	}
+}
+
+// reporter returns the progress callback of a render, reports come in
+// faster than they are worth storing so they are thinned out
+func (p *Pool) reporter(ctx context.Context, cancel context.CancelFunc, worker string, job Job) func(Progress) {
+	var last Progress
+	var reported time.Time
+	return func(progress Progress) {
+		if ctx.Err() != nil || progress == last {
+			return
+		}
+		if progress.Stage == last.Stage && time.Since(reported) < reportInterval {
+			return
+		}
+		last, reported = progress, time.Now()
+
+		err := p.queue.Report(ctx, job, worker, progress)
+		if errors.Is(err, ErrLeaseLost) {
+			log.Printf("lost the lease of export job %s\n", job.Id.Hex())
+			cancel()
+			return
+		}
+		if err != nil && ctx.Err() == nil {
+			log.Printf("error reporting the progress of export job %s: %v\n", job.Id.Hex(), err)
+		}
+	}
+}
+
+// heartbeat renews the lease until ctx is done, a lost lease cancels the
+// render
+func (p *Pool) heartbeat(ctx context.Context, cancel context.CancelFunc, worker string, job Job) {
+	ticker := time.NewTicker(p.lease / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := p.queue.Renew(ctx, job, worker, p.lease)
+		if errors.Is(err, ErrLeaseLost) {
+			log.Printf("lost the lease of export job %s\n", job.Id.Hex())
+			cancel()
+			return
+		}
+		if err != nil && ctx.Err() == nil {
+			log.Printf("error renewing the lease of export job %s: %v\n", job.Id.Hex(), err)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package exports
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// memoryQueue hands out its jobs once and records what happened to them
+type memoryQueue struct {
+	mu       sync.Mutex
+	jobs     []Job
+	renewals int
+	renewErr error
+	// completing delays Complete, like a slow upload
+	completing time.Duration
+	reports    []Progress
+	completed  map[primitive.ObjectID]Output
+	failed     map[primitive.ObjectID]error
+	done       chan struct{}
+}
+
+func newMemoryQueue(jobs ...Job) *memoryQueue {
+	return &memoryQueue{
+		jobs:      jobs,
+		completed: map[primitive.ObjectID]Output{},
+		failed:    map[primitive.ObjectID]error{},
+		done:      make(chan struct{}, len(jobs)),
+	}
+}
+
+func (q *memoryQueue) Claim(ctx context.Context, worker string, lease time.Duration) (Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.jobs) == 0 {
+		return Job{}, ErrNoJob
+	}
+	job := q.jobs[0]
+	q.jobs = q.jobs[1:]
+	job.Status = Rendering
+	job.Worker = worker
+	job.Attempts++
+	return job, nil
+}
+
+func (q *memoryQueue) Renew(ctx context.Context, job Job, worker string, lease time.Duration) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.renewals++
+	return q.renewErr
+}
+
+func (q *memoryQueue) Report(ctx context.Context, job Job, worker string, progress Progress) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.reports = append(q.reports, progress)
+	return q.renewErr
+}
+
+func (q *memoryQueue) Complete(ctx context.Context, job Job, worker string, output Output) error {
+	time.Sleep(q.completing)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	// the file still exists while the job is completed
+	if _, err := os.Stat(output.Path); err != nil {
+		return err
+	}
+	q.completed[job.Id] = output
+	q.done <- struct{}{}
+	return nil
+}
+
+func (q *memoryQueue) Fail(ctx context.Context, job Job, worker string, reason error) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.failed[job.Id] = reason
+	q.done <- struct{}{}
+	return nil
+}
+
+func (q *memoryQueue) wait(t *testing.T, n int) {
+	for i := 0; i < n; i++ {
+		select {
+		case <-q.done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("the jobs were not finished in time")
+		}
+	}
+}
+
+func TestPool(t *testing.T) {
+	succeeding, failing := Job{Id: primitive.NewObjectID()}, Job{Id: primitive.NewObjectID(), Format: "broken"}
+	queue := newMemoryQueue(succeeding, failing)
+
+	dir := t.TempDir()
+	render := func(ctx context.Context, job Job, progress func(Progress)) (Output, error) {
+		if job.Format == "broken" {
+			return Output{}, errors.New("render failed")
+		}
+		path := filepath.Join(dir, job.Id.Hex()+".mp4")
+		return Output{Path: path, Duration: 2.5}, os.WriteFile(path, []byte("video"), 0o600)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	pool := NewPool(queue, render, "test", 2)
+	pool.poll = 10 * time.Millisecond
+	stopped := make(chan struct{})
+	go func() {
+		pool.Run(ctx)
+		close(stopped)
+	}()
+
+	queue.wait(t, 2)
+	cancel()
+	<-stopped
+
+	require.Contains(t, queue.completed, succeeding.Id)
+	assert.Equal(t, 2.5, queue.completed[succeeding.Id].Duration)
+	assert.EqualError(t, queue.failed[failing.Id], "render failed")
+
+	// the rendered file is removed after the job was completed
+	_, err := os.Stat(queue.completed[succeeding.Id].Path)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestPoolAbandonsJobsWithLostLease(t *testing.T) {
+	queue := newMemoryQueue(Job{Id: primitive.NewObjectID()})
+	queue.renewErr = ErrLeaseLost
+
+	cancelled := make(chan struct{})
+	render := func(ctx context.Context, job Job, progress func(Progress)) (Output, error) {
+		<-ctx.Done()
+		close(cancelled)
+		return Output{}, ctx.Err()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pool := NewPool(queue, render, "test", 1)
+	pool.lease = 30 * time.Millisecond
+	pool.poll = 10 * time.Millisecond
+	go pool.Run(ctx)
+
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the render was not cancelled")
+	}
+	cancel()
+
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	assert.Equal(t, 1, queue.renewals)
+	assert.Empty(t, queue.completed)
+	assert.Empty(t, queue.failed)
+}
+
+func TestPoolThinsOutProgress(t *testing.T) {
+	queue := newMemoryQueue(Job{Id: primitive.NewObjectID()})
+
+	dir := t.TempDir()
+	render := func(ctx context.Context, job Job, progress func(Progress)) (Output, error) {
+		for _, p := range []Progress{{"bundling", 0}, {"bundling", 5}, {"bundling", 10}, {"rendering", 10}, {"rendering", 10}, {"rendering", 20}} {
+			progress(p)
+		}
+		path := filepath.Join(dir, job.Id.Hex()+".mp4")
+		return Output{Path: path}, os.WriteFile(path, []byte("video"), 0o600)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go NewPool(queue, render, "test", 1).Run(ctx)
+	queue.wait(t, 1)
+
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	assert.Equal(t, []Progress{{"bundling", 0}, {"rendering", 10}}, queue.reports)
+}
+
+func TestPoolKeepsLeaseWhileCompleting(t *testing.T) {
+	queue := newMemoryQueue(Job{Id: primitive.NewObjectID()})
+	queue.completing = 100 * time.Millisecond
+
+	dir := t.TempDir()
+	render := func(ctx context.Context, job Job, progress func(Progress)) (Output, error) {
+		path := filepath.Join(dir, job.Id.Hex()+".mp4")
+		return Output{Path: path}, os.WriteFile(path, []byte("video"), 0o600)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pool := NewPool(queue, render, "test", 1)
+	pool.lease = 30 * time.Millisecond
+	go pool.Run(ctx)
+	queue.wait(t, 1)
+
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	// the render is instant, the renewals fell into the upload
+	assert.GreaterOrEqual(t, queue.renewals, 2)
+	assert.Len(t, queue.completed, 1)
+}
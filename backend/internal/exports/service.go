@@ -0,0 +1,449 @@
+package exports
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/Pieli/server/internal/credits"
+	"github.com/Pieli/server/internal/storage"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// reservationMargin keeps the credits of a job reserved a little longer
+// than its deadline, so they are never released while it still runs
+const reservationMargin = time.Hour
+
+// exportedVideo is the ExportedVideo entry a succeeded job adds to its
+// project, with the key of its blob
+type exportedVideo struct {
+	Id       string  `bson:"id"`
+	Quality  string  `bson:"quality"`
+	Format   string  `bson:"format"`
+	Url      string  `bson:"url"`
+	Size     int64   `bson:"size"`
+	Duration float64 `bson:"duration"`
+	// CreditsUsed is what the export was charged
+	CreditsUsed int       `bson:"creditsUsed"`
+	ExportedAt  time.Time `bson:"exportedAt"`
+	Key         string    `bson:"key"`
+}
+
+// Service keeps the export jobs in mongo and hands them out to workers. A
+// job that succeeds is stored, listed in its project and charged, one that
+// fails for good gets its credits back.
+type Service struct {
+	db       *mongo.Database
+	credits  *credits.Service
+	blobs    storage.BlobStore
+	deadline time.Duration
+}
+
+func initIndexes(coll *mongo.Collection) {
+	// create the index workers claim the oldest waiting job with
+	_, err := coll.Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys: bson.D{{Key: "status", Value: 1}, {Key: "createdAt", Value: 1}},
+	})
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+}
+
+func NewService(db *mongo.Database, creditService *credits.Service, blobStore storage.BlobStore) *Service {
+	initIndexes(db.Collection("export_jobs"))
+	return &Service{
+		db:       db,
+		credits:  creditService,
+		blobs:    blobStore,
+		deadline: DefaultDeadline,
+	}
+}
+
+func (s *Service) Jobs() *mongo.Collection {
+	return s.db.Collection("export_jobs")
+}
+
+func (s *Service) projects() *mongo.Collection {
+	return s.db.Collection("projects")
+}
+
+// Create queues an export of the compositions of a project. The price of
+// the quality is reserved right away and charged once the video is stored.
+func (s *Service) Create(ctx context.Context, userID, projectID, projectName, quality, format string, compositions json.RawMessage) (Job, error) {
+	operation, ok := Operation(quality)
+	if !ok {
+		return Job{}, fmt.Errorf("unknown export quality %q", quality)
+	}
+
+	now := time.Now()
+	job := Job{
+		Id:           primitive.NewObjectID(),
+		UserId:       userID,
+		ProjectId:    projectID,
+		Quality:      quality,
+		Format:       format,
+		Compositions: compositions,
+		Status:       Queued,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+		Deadline:     now.Add(s.deadline),
+	}
+
+	reservation, err := s.credits.ReserveFor(ctx, userID, operation, s.deadline+reservationMargin,
+		fmt.Sprintf("Exported %s video of project %q", quality, projectName),
+		map[string]interface{}{"projectId": projectID, "exportId": job.Id.Hex(), "format": format})
+	if err != nil {
+		return Job{}, err
+	}
+	job.Reservation = reservation
+
+	_, err = s.Jobs().InsertOne(ctx, job)
+	if err != nil {
+		_ = s.credits.Release(ctx, reservation)
+		return Job{}, err
+	}
+	return job, nil
+}
+
+// Get returns the job with the given id
+func (s *Service) Get(ctx context.Context, id string) (Job, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return Job{}, ErrNotFound
+	}
+
+	var job Job
+	err = s.Jobs().FindOne(ctx, bson.M{"_id": objectID}).Decode(&job)
+	if err == mongo.ErrNoDocuments {
+		return Job{}, ErrNotFound
+	}
+	return job, err
+}
+
+// Claim leases the oldest waiting job to the worker. Jobs whose lease
+// expired are waiting again, until they ran out of attempts.
+func (s *Service) Claim(ctx context.Context, worker string, lease time.Duration) (Job, error) {
+	now := time.Now()
+	filter := bson.M{
+		"$or": bson.A{
+			bson.M{"status": Queued},
+			bson.M{"status": Rendering, "leaseExpiresAt": bson.M{"$lt": now}},
+		},
+		"attempts": bson.M{"$lt": MaxAttempts},
+		"deadline": bson.M{"$gt": now},
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"status":         Rendering,
+			"worker":         worker,
+			"leaseExpiresAt": now.Add(lease),
+			"progress":       0,
+			"updatedAt":      now,
+		},
+		"$inc":   bson.M{"attempts": 1},
+		"$unset": bson.M{"stage": ""},
+	}
+
+	var job Job
+	opts := options.FindOneAndUpdate().SetSort(bson.D{{Key: "createdAt", Value: 1}}).SetReturnDocument(options.After)
+	err := s.Jobs().FindOneAndUpdate(ctx, filter, update, opts).Decode(&job)
+	if err == mongo.ErrNoDocuments {
+		return Job{}, ErrNoJob
+	}
+	return job, err
+}
+
+// Renew extends the lease of the worker on the job, a lease that ran out
+// is not extended
+func (s *Service) Renew(ctx context.Context, job Job, worker string, lease time.Duration) error {
+	now := time.Now()
+	result, err := s.Jobs().UpdateOne(ctx,
+		bson.M{"_id": job.Id, "status": Rendering, "worker": worker, "leaseExpiresAt": bson.M{"$gt": now}},
+		bson.M{"$set": bson.M{"leaseExpiresAt": now.Add(lease)}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// Report stores the progress of the render, clients following the job
+// pick it up from there
+func (s *Service) Report(ctx context.Context, job Job, worker string, progress Progress) error {
+	result, err := s.Jobs().UpdateOne(ctx,
+		bson.M{"_id": job.Id, "status": Rendering, "worker": worker, "leaseExpiresAt": bson.M{"$gt": time.Now()}},
+		bson.M{"$set": bson.M{"stage": progress.Stage, "progress": progress.Percent}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// Complete stores the rendered video, lists it in the project and charges
+// the reserved credits. Every step can be repeated, so a job whose worker
+// died halfway is completed by the next one without double charges. A
+// job that cannot be charged fails for good without the video.
+func (s *Service) Complete(ctx context.Context, job Job, worker string, output Output) error {
+	file, err := os.Open(output.Path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	result := Result{Key: Key(job), Size: stat.Size(), Duration: output.Duration}
+	err = s.blobs.Put(ctx, result.Key, file, result.Size, Formats[job.Format])
+	if err != nil {
+		return err
+	}
+
+	// the upload may have outlasted the lease, another worker could own
+	// the job by now
+	if err := s.hold(ctx, job, worker); err != nil {
+		return err
+	}
+
+	listed, err := s.list(ctx, job, result)
+	if err != nil {
+		return err
+	}
+	if !listed {
+		// the project was deleted in the meantime, nobody gets the video
+		if err := s.blobs.Delete(ctx, result.Key); err != nil {
+			log.Printf("error deleting blob %s: %v\n", result.Key, err)
+		}
+		return s.finish(ctx, job, worker, bson.M{"status": Failed, "error": "The project was deleted during the export."})
+	}
+
+	_, err = s.credits.Commit(ctx, job.Reservation)
+	if err != nil {
+		// the reservation ran out during a long render, or the ledger is
+		// not reachable. Nobody paid for the video, so it is taken back
+		// and the job ends here rather than being rendered again.
+		log.Printf("error charging export %s: %v\n", job.Id.Hex(), err)
+		if err := s.unlist(ctx, job); err != nil {
+			log.Printf("error unlisting export %s: %v\n", job.Id.Hex(), err)
+		}
+		if err := s.blobs.Delete(ctx, result.Key); err != nil {
+			log.Printf("error deleting blob %s: %v\n", result.Key, err)
+		}
+		return s.finish(ctx, job, worker, bson.M{"status": Failed, "error": "The credits of the export could not be charged."})
+	}
+
+	return s.finish(ctx, job, worker, bson.M{"status": Succeeded, "result": result, "error": "", "progress": 100})
+}
+
+// hold checks that the worker still has an unexpired lease and extends it
+// for the steps that finish the job
+func (s *Service) hold(ctx context.Context, job Job, worker string) error {
+	now := time.Now()
+	result, err := s.Jobs().UpdateOne(ctx,
+		bson.M{"_id": job.Id, "status": Rendering, "worker": worker, "leaseExpiresAt": bson.M{"$gt": now}},
+		bson.M{"$set": bson.M{"leaseExpiresAt": now.Add(DefaultLease)}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// list adds the video to the exported videos of the project, unless it
+// is listed already. It tells whether the project still exists.
+func (s *Service) list(ctx context.Context, job Job, result Result) (bool, error) {
+	projectID, err := primitive.ObjectIDFromHex(job.ProjectId)
+	if err != nil {
+		return false, err
+	}
+
+	video := exportedVideo{
+		Id:       job.Id.Hex(),
+		Quality:  job.Quality,
+		Format:   job.Format,
+		Url:      fmt.Sprintf("/api/downloads/projects/%s/exports/%s", job.ProjectId, job.Id.Hex()),
+		Size:     result.Size,
+		Duration: result.Duration,
+		// the ledger entry is written with the same amount
+		CreditsUsed: job.Reservation.Amount,
+		ExportedAt:  time.Now(),
+		Key:         result.Key,
+	}
+
+	updated, err := s.projects().UpdateOne(ctx,
+		bson.M{"_id": projectID, "exportedVideos.id": bson.M{"$ne": video.Id}},
+		bson.M{
+			"$push": bson.M{"exportedVideos": video},
+			"$set":  bson.M{"metadata.updatedAt": time.Now()},
+		})
+	if err != nil {
+		return false, err
+	}
+	if updated.MatchedCount > 0 {
+		return true, nil
+	}
+
+	count, err := s.projects().CountDocuments(ctx, bson.M{"_id": projectID})
+	return count > 0, err
+}
+
+// unlist removes the video of the job from the project again
+func (s *Service) unlist(ctx context.Context, job Job) error {
+	projectID, err := primitive.ObjectIDFromHex(job.ProjectId)
+	if err != nil {
+		return err
+	}
+	_, err = s.projects().UpdateOne(ctx,
+		bson.M{"_id": projectID},
+		bson.M{"$pull": bson.M{"exportedVideos": bson.M{"id": job.Id.Hex()}}})
+	return err
+}
+
+// Fail puts the job back into the queue after a failed attempt, with the
+// last attempt it fails for good and the credits are given back
+func (s *Service) Fail(ctx context.Context, job Job, worker string, reason error) error {
+	if job.Attempts < MaxAttempts {
+		return s.finish(ctx, job, worker, bson.M{"status": Queued, "error": reason.Error(), "progress": 0})
+	}
+	return s.finish(ctx, job, worker, bson.M{"status": Failed, "error": reason.Error()})
+}
+
+// finish moves a job the worker holds the lease on out of rendering, the
+// stage goes with it. A failed job gets its credits back.
+func (s *Service) finish(ctx context.Context, job Job, worker string, set bson.M) error {
+	set["updatedAt"] = time.Now()
+	result, err := s.Jobs().UpdateOne(ctx,
+		bson.M{"_id": job.Id, "status": Rendering, "worker": worker},
+		bson.M{"$set": set, "$unset": bson.M{"worker": "", "leaseExpiresAt": "", "stage": ""}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrLeaseLost
+	}
+
+	if set["status"] == Failed {
+		return s.credits.Release(ctx, job.Reservation)
+	}
+	return nil
+}
+
+// Requeue puts the jobs whose worker stopped renewing the lease back into
+// the queue, as long as they have attempts left
+func (s *Service) Requeue(ctx context.Context, now time.Time) (int, error) {
+	result, err := s.Jobs().UpdateMany(ctx,
+		bson.M{
+			"status":         Rendering,
+			"leaseExpiresAt": bson.M{"$lt": now},
+			"attempts":       bson.M{"$lt": MaxAttempts},
+			"deadline":       bson.M{"$gt": now},
+		},
+		bson.M{
+			"$set":   bson.M{"status": Queued, "error": "The worker stopped responding.", "progress": 0, "updatedAt": now},
+			"$unset": bson.M{"worker": "", "leaseExpiresAt": "", "stage": ""},
+		})
+	if err != nil {
+		return 0, err
+	}
+	return int(result.ModifiedCount), nil
+}
+
+// Expire fails the jobs that passed their deadline and those whose last
+// attempt lost its lease, their credits are given back
+func (s *Service) Expire(ctx context.Context, now time.Time) (int, error) {
+	filter := bson.M{"$or": bson.A{
+		bson.M{"status": bson.M{"$in": bson.A{Queued, Rendering}}, "deadline": bson.M{"$lte": now}},
+		bson.M{"status": Rendering, "leaseExpiresAt": bson.M{"$lt": now}, "attempts": bson.M{"$gte": MaxAttempts}},
+	}}
+
+	cursor, err := s.Jobs().Find(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+
+	var jobs []Job
+	if err = cursor.All(ctx, &jobs); err != nil {
+		return 0, err
+	}
+
+	expired := 0
+	for _, job := range jobs {
+		message := "The export did not finish in time."
+		if job.Deadline.After(now) {
+			message = "The export failed repeatedly."
+			if job.Error != "" {
+				message = job.Error
+			}
+		}
+
+		// a worker finishing or renewing its lease in the meantime wins
+		unchanged := bson.M{"_id": job.Id, "status": job.Status, "updatedAt": job.UpdatedAt}
+		if job.Status == Rendering {
+			unchanged["leaseExpiresAt"] = job.LeaseExpiresAt
+		}
+		result, err := s.Jobs().UpdateOne(ctx, unchanged,
+			bson.M{
+				"$set":   bson.M{"status": Failed, "error": message, "updatedAt": now},
+				"$unset": bson.M{"worker": "", "leaseExpiresAt": "", "stage": ""},
+			})
+		if err != nil {
+			return expired, err
+		}
+		if result.MatchedCount == 0 {
+			continue
+		}
+
+		if err := s.credits.Release(ctx, job.Reservation); err != nil {
+			return expired, err
+		}
+		expired++
+	}
+
+	return expired, nil
+}
+
+// Run blocks until ctx is cancelled, jobs with an expired lease are put
+// back into the queue or failed right away and then once per interval
+func (s *Service) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		requeued, err := s.Requeue(ctx, time.Now())
+		if err != nil && ctx.Err() == nil {
+			log.Printf("error requeueing export jobs: %v\n", err)
+		}
+		if requeued > 0 {
+			log.Printf("requeued %d export jobs with an expired lease\n", requeued)
+		}
+
+		expired, err := s.Expire(ctx, time.Now())
+		if err != nil && ctx.Err() == nil {
+			log.Printf("error expiring export jobs: %v\n", err)
+		}
+		if expired > 0 {
+			log.Printf("failed %d expired export jobs\n", expired)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package exports
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Pieli/server/internal/credits"
+	"github.com/Pieli/server/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// the mock deployment answers the commands in order with the queued
+// responses, the tests check what was sent
+
+func written(n int) bson.D {
+	return mtest.CreateSuccessResponse(bson.E{Key: "n", Value: n}, bson.E{Key: "nModified", Value: n})
+}
+
+// sent lists the commands as "name collection"
+func sent(mt *mtest.T) []string {
+	commands := []string{}
+	for _, e := range mt.GetAllStartedEvents() {
+		commands = append(commands, e.CommandName+" "+e.Command.Lookup(e.CommandName).StringValue())
+	}
+	return commands
+}
+
+func TestCompleteWithReleasedReservation(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("takes the video back", func(mt *mtest.T) {
+		ctx := context.Background()
+		// the credit store creates its indexes first
+		mt.AddMockResponses(mtest.CreateSuccessResponse(), mtest.CreateSuccessResponse())
+		creditService := credits.NewService(credits.NewStore(mt.DB), nil, 0)
+		mt.ClearEvents()
+
+		blobs, err := storage.NewLocalBlobStore(t.TempDir())
+		require.NoError(mt, err)
+		service := &Service{db: mt.DB, credits: creditService, blobs: blobs, deadline: DefaultDeadline}
+
+		output := filepath.Join(t.TempDir(), "out.mp4")
+		require.NoError(mt, os.WriteFile(output, []byte("video"), 0o600))
+		job := Job{
+			Id:          primitive.NewObjectID(),
+			UserId:      primitive.NewObjectID().Hex(),
+			ProjectId:   primitive.NewObjectID().Hex(),
+			Quality:     "HD",
+			Format:      "mp4",
+			Status:      Rendering,
+			Reservation: credits.Reservation{Id: primitive.NewObjectID(), Amount: 10, ExpiresAt: time.Now().Add(-time.Minute)},
+		}
+
+		mt.AddMockResponses(
+			// hold and list
+			written(1), written(1),
+			// the sweep released the reservation, it is not in the ledger
+			written(0), mtest.CreateCursorResponse(0, "test.credit_transactions", mtest.FirstBatch),
+			// unlist, finish and the release finding nothing to give back
+			written(1), written(1), written(0),
+		)
+
+		require.NoError(mt, service.Complete(ctx, job, "w1", Output{Path: output, Duration: 2}))
+		assert.Equal(mt, []string{
+			"update export_jobs",
+			"update projects",
+			"update credit_reservations",
+			"find credit_transactions",
+			"update projects",
+			"update export_jobs",
+			"delete credit_reservations",
+		}, sent(mt))
+
+		events := mt.GetAllStartedEvents()
+		pulled := events[4].Command.Lookup("updates").Array().Index(0).Value().Document().Lookup("u", "$pull", "exportedVideos", "id")
+		assert.Equal(mt, job.Id.Hex(), pulled.StringValue())
+		finished := events[5].Command.Lookup("updates").Array().Index(0).Value().Document().Lookup("u", "$set", "status")
+		assert.Equal(mt, string(Failed), finished.StringValue())
+
+		_, err = blobs.Get(ctx, Key(job))
+		assert.ErrorIs(mt, err, storage.ErrBlobNotFound)
+	})
+}
@@ -0,0 +1,109 @@
+package fonts
+
+import (
+	_ "embed"
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// Source tells where the files of a family come from
+type Source string
+
+const (
+	// Google families are loaded by the editor through @remotion/google-fonts
+	Google Source = "google"
+	// Upload families are font assets of the user
+	Upload Source = "upload"
+)
+
+// popularJSON is the curated list of families,
+// keep in sync with frontend/src/remotion-lib/popular-fonts.ts
+//
+//go:embed popular.json
+var popularJSON []byte
+
+var popular []string
+
+func init() {
+	if err := json.Unmarshal(popularJSON, &popular); err != nil {
+		panic("invalid popular fonts: " + err.Error())
+	}
+}
+
+// Popular returns the curated families
+func Popular() []string {
+	return popular
+}
+
+// Variant is an uploaded font file of a family
+type Variant struct {
+	Weight    int
+	Style     string
+	Format    string
+	ProjectId string
+	AssetId   string
+}
+
+// Uploaded is a font asset with the family it belongs to
+type Uploaded struct {
+	Family string
+	Variant
+}
+
+// Family is an entry of the font list of the editor
+type Family struct {
+	Family string
+	Source Source
+	// Variants lists the files of uploaded families by weight and style
+	Variants []Variant
+}
+
+// styleOrder lists the upright variant of a weight first
+var styleOrder = map[string]int{"normal": 0, "italic": 1, "oblique": 2}
+
+// Merge combines the curated families with the uploaded fonts into one
+// list sorted by name. An uploaded family replaces a curated one of the
+// same name, a weight and style uploaded twice is listed once.
+func Merge(curated []string, uploaded []Uploaded) []Family {
+	byName := map[string]*Family{}
+	for _, name := range curated {
+		byName[strings.ToLower(name)] = &Family{Family: name, Source: Google, Variants: []Variant{}}
+	}
+
+	for _, font := range uploaded {
+		key := strings.ToLower(font.Family)
+		family, ok := byName[key]
+		if !ok || family.Source != Upload {
+			family = &Family{Family: font.Family, Source: Upload, Variants: []Variant{}}
+			byName[key] = family
+		}
+
+		duplicate := false
+		for _, variant := range family.Variants {
+			if variant.Weight == font.Weight && variant.Style == font.Style {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			family.Variants = append(family.Variants, font.Variant)
+		}
+	}
+
+	families := make([]Family, 0, len(byName))
+	for _, family := range byName {
+		sort.SliceStable(family.Variants, func(i, j int) bool {
+			if family.Variants[i].Weight != family.Variants[j].Weight {
+				return family.Variants[i].Weight < family.Variants[j].Weight
+			}
+			return styleOrder[family.Variants[i].Style] < styleOrder[family.Variants[j].Style]
+		})
+		families = append(families, *family)
+	}
+	sort.Slice(families, func(i, j int) bool {
+		return strings.ToLower(families[i].Family) < strings.ToLower(families[j].Family)
+	})
+
+	return families
+}
@@ -0,0 +1,42 @@
+package fonts
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPopular(t *testing.T) {
+	families := Popular()
+	require.Len(t, families, 250)
+	assert.Contains(t, families, "Roboto")
+}
+
+func TestMerge(t *testing.T) {
+	families := Merge([]string{"Roboto", "Lato", "Inter"}, []Uploaded{
+		{Family: "Brand Sans", Variant: Variant{Weight: 700, Style: "normal", AssetId: "a1"}},
+		{Family: "Brand Sans", Variant: Variant{Weight: 400, Style: "italic", AssetId: "a2"}},
+		{Family: "Brand Sans", Variant: Variant{Weight: 400, Style: "normal", AssetId: "a3"}},
+		// the same file in another project
+		{Family: "brand sans", Variant: Variant{Weight: 700, Style: "normal", AssetId: "a4"}},
+		// an upload of a curated family replaces it
+		{Family: "Lato", Variant: Variant{Weight: 400, Style: "normal", AssetId: "a5"}},
+	})
+
+	require.Len(t, families, 4)
+	names := []string{}
+	for _, family := range families {
+		names = append(names, family.Family)
+	}
+	assert.Equal(t, []string{"Brand Sans", "Inter", "Lato", "Roboto"}, names)
+
+	assert.Equal(t, Upload, families[0].Source)
+	require.Len(t, families[0].Variants, 3)
+	assert.Equal(t, []string{"a3", "a2", "a1"}, []string{families[0].Variants[0].AssetId, families[0].Variants[1].AssetId, families[0].Variants[2].AssetId})
+
+	assert.Equal(t, Google, families[1].Source)
+	assert.Empty(t, families[1].Variants)
+	assert.Equal(t, Upload, families[2].Source)
+	assert.Equal(t, "a5", families[2].Variants[0].AssetId)
+}
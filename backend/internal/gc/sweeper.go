@@ -0,0 +1,180 @@
+package gc
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/Pieli/server/internal/storage"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DefaultGrace is how old an unreferenced blob has to be before it is
+// deleted, it covers the time between storing a blob and its record
+const DefaultGrace = 24 * time.Hour
+
+// ReferenceCollections hold the records that point at blobs
+var ReferenceCollections = []string{"projects", "blobs", "upload_sessions", "export_jobs"}
+
+// References returns the keys of every blob that is still in use
+type References func(ctx context.Context) (map[string]bool, error)
+
+// Orphan is a blob no record points at
+type Orphan struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// Report is the outcome of a sweep
+type Report struct {
+	DryRun  bool
+	Scanned int
+	// Orphans lists the unreferenced blobs older than the grace period
+	Orphans    []Orphan
+	Deleted    int
+	FreedBytes int64
+}
+
+// Sweeper deletes the blobs that are no longer referenced. In dry run
+// mode it only reports what it would delete.
+type Sweeper struct {
+	blobs      storage.BlobStore
+	references References
+	grace      time.Duration
+	dryRun     bool
+}
+
+func NewSweeper(blobs storage.BlobStore, references References, grace time.Duration, dryRun bool) *Sweeper {
+	return &Sweeper{
+		blobs:      blobs,
+		references: references,
+		grace:      grace,
+		dryRun:     dryRun,
+	}
+}
+
+// Sweep compares the stored blobs with the references once. The references
+// are read first, a blob stored afterwards is younger than the grace period.
+func (s *Sweeper) Sweep(ctx context.Context, now time.Time) (Report, error) {
+	report := Report{DryRun: s.dryRun}
+
+	referenced, err := s.references(ctx)
+	if err != nil {
+		return report, err
+	}
+
+	err = s.blobs.List(ctx, "", func(info storage.BlobInfo) error {
+		report.Scanned++
+		if referenced[info.Key] || now.Sub(info.ModTime) < s.grace {
+			return nil
+		}
+		report.Orphans = append(report.Orphans, Orphan{Key: info.Key, Size: info.Size, ModTime: info.ModTime})
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	if s.dryRun {
+		return report, nil
+	}
+
+	for _, orphan := range report.Orphans {
+		if err := s.blobs.Delete(ctx, orphan.Key); err != nil {
+			return report, err
+		}
+		report.Deleted++
+		report.FreedBytes += orphan.Size
+	}
+
+	return report, nil
+}
+
+// Run blocks until ctx is cancelled, a sweep happens right away and then
+// once per interval
+func (s *Sweeper) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		report, err := s.Sweep(ctx, time.Now())
+		if err != nil && ctx.Err() == nil {
+			log.Printf("error sweeping blobs: %v\n", err)
+		}
+		switch {
+		case report.DryRun && len(report.Orphans) > 0:
+			log.Printf("blob sweep (dry run): %d of %d blobs are orphaned\n", len(report.Orphans), report.Scanned)
+		case report.Deleted > 0:
+			log.Printf("blob sweep: deleted %d orphaned blobs, freed %d bytes\n", report.Deleted, report.FreedBytes)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// MongoReferences collects the value of every "key" field, at any depth,
+// of the documents in the collections. Matching by field name keeps new
+// kinds of blobs, like derivatives of assets, covered without changes here.
+func MongoReferences(db *mongo.Database, collections ...string) References {
+	return func(ctx context.Context) (map[string]bool, error) {
+		referenced := map[string]bool{}
+		for _, name := range collections {
+			cursor, err := db.Collection(name).Find(ctx, bson.M{}, options.Find().SetBatchSize(100))
+			if err != nil {
+				return nil, err
+			}
+
+			for cursor.Next(ctx) {
+				var doc bson.D
+				if err := cursor.Decode(&doc); err != nil {
+					cursor.Close(ctx)
+					return nil, err
+				}
+				collectKeys(doc, referenced)
+			}
+			err = cursor.Err()
+			cursor.Close(ctx)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return referenced, nil
+	}
+}
+
+// collectKeys adds the strings stored under "key" anywhere in value
+func collectKeys(value interface{}, keys map[string]bool) {
+	switch v := value.(type) {
+	case bson.D:
+		for _, elem := range v {
+			if key, ok := elem.Value.(string); ok && elem.Key == "key" {
+				keys[key] = true
+				continue
+			}
+			collectKeys(elem.Value, keys)
+		}
+	case bson.M:
+		for name, elem := range v {
+			if key, ok := elem.(string); ok && name == "key" {
+				keys[key] = true
+				continue
+			}
+			collectKeys(elem, keys)
+		}
+	case bson.A:
+		for _, elem := range v {
+			collectKeys(elem, keys)
+		}
+	case []interface{}:
+		for _, elem := range v {
+			collectKeys(elem, keys)
+		}
+	}
+}
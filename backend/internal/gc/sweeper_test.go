@@ -0,0 +1,86 @@
+package gc
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Pieli/server/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestCollectKeys(t *testing.T) {
+	keys := map[string]bool{}
+	collectKeys(bson.D{
+		{Key: "name", Value: "project"},
+		{Key: "assets", Value: bson.D{
+			{Key: "images", Value: bson.A{
+				bson.D{{Key: "id", Value: "a1"}, {Key: "key", Value: "blobs/sha256/x/1"}},
+				bson.M{"key": "assets/u/p/a2", "thumbnail": bson.M{"key": "derived/a2/thumb"}},
+			}},
+		}},
+		{Key: "exportedVideos", Value: []interface{}{bson.D{{Key: "key", Value: "exports/e1"}}}},
+		// only strings are keys
+		{Key: "key", Value: 42},
+	}, keys)
+
+	assert.Equal(t, map[string]bool{
+		"blobs/sha256/x/1": true,
+		"assets/u/p/a2":    true,
+		"derived/a2/thumb": true,
+		"exports/e1":       true,
+	}, keys)
+}
+
+func TestSweep(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	store, err := storage.NewLocalBlobStore(root)
+	require.NoError(t, err)
+
+	now := time.Now()
+	old := now.Add(-48 * time.Hour)
+	for key, modTime := range map[string]time.Time{
+		"assets/referenced": old,
+		"assets/orphan":     old,
+		"uploads/in-flight": now,
+	} {
+		require.NoError(t, store.Put(ctx, key, strings.NewReader("data"), 4, ""))
+		require.NoError(t, os.Chtimes(filepath.Join(root, filepath.FromSlash(key)), modTime, modTime))
+	}
+
+	references := func(ctx context.Context) (map[string]bool, error) {
+		return map[string]bool{"assets/referenced": true}, nil
+	}
+
+	report, err := NewSweeper(store, references, DefaultGrace, true).Sweep(ctx, now)
+	require.NoError(t, err)
+	assert.True(t, report.DryRun)
+	assert.Equal(t, 3, report.Scanned)
+	require.Len(t, report.Orphans, 1)
+	assert.Equal(t, "assets/orphan", report.Orphans[0].Key)
+	assert.Equal(t, 0, report.Deleted)
+
+	// the dry run left the orphan in place
+	body, err := store.Get(ctx, "assets/orphan")
+	require.NoError(t, err)
+	body.Close()
+
+	report, err = NewSweeper(store, references, DefaultGrace, false).Sweep(ctx, now)
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Deleted)
+	assert.Equal(t, int64(4), report.FreedBytes)
+
+	_, err = store.Get(ctx, "assets/orphan")
+	assert.ErrorIs(t, err, storage.ErrBlobNotFound)
+	for _, key := range []string{"assets/referenced", "uploads/in-flight"} {
+		body, err := store.Get(ctx, key)
+		require.NoError(t, err, key)
+		body.Close()
+	}
+}
@@ -0,0 +1,689 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Pieli/server/internal/assets"
+	"github.com/Pieli/server/internal/credits"
+	"github.com/Pieli/server/internal/dedup"
+	"github.com/Pieli/server/internal/media"
+	"github.com/Pieli/server/internal/storage"
+	"github.com/Pieli/server/internal/util"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// storedAsset is an Asset as kept in the project document, with the key
+// and content hash of its blob
+type storedAsset struct {
+	Asset `bson:",inline"`
+	Key   string `bson:"key"`
+	Hash  string `bson:"hash"`
+}
+
+// storedExport is an ExportedVideo as kept in the project document,
+// with the key of its blob
+type storedExport struct {
+	ExportedVideo `bson:",inline"`
+	Key           string `bson:"key"`
+}
+
+// assetProject is the part of a project document the asset handlers need
+type assetProject struct {
+	UserId         string                   `bson:"userId"`
+	Assets         map[string][]storedAsset `bson:"assets"`
+	ExportedVideos []storedExport           `bson:"exportedVideos"`
+}
+
+// find returns the asset with the given id and the category it is listed in
+func (p assetProject) find(assetID string) (storedAsset, assets.Category, bool) {
+	for category, list := range p.Assets {
+		for _, asset := range list {
+			if asset.Id == assetID {
+				return asset, assets.Category(category), true
+			}
+		}
+	}
+	return storedAsset{}, "", false
+}
+
+// findExport returns the exported video with the given id
+func (p assetProject) findExport(exportID string) (storedExport, bool) {
+	for _, export := range p.ExportedVideos {
+		if export.Id == exportID {
+			return export, true
+		}
+	}
+	return storedExport{}, false
+}
+
+// List project assets
+// (GET /api/users/me/projects/{projectId}/assets)
+func (s Server) GetApiUsersMeProjectsProjectIdAssets(ctx context.Context, request GetApiUsersMeProjectsProjectIdAssetsRequestObject) (GetApiUsersMeProjectsProjectIdAssetsResponseObject, error) {
+	projectsColl := s.userStorage.db.Collection("projects")
+	userColl := s.userStorage.Collection()
+	uid := ctx.Value("uid").(string)
+
+	// Get user ID from UID
+	user, err := util.GetGenericUID[UserResponse](uid, userColl, ctx)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return GetApiUsersMeProjectsProjectIdAssets404JSONResponse{NotFoundJSONResponse{
+				Error:   "User not found",
+				Message: "The user with the specified ID does not exist.",
+			}}, nil
+		}
+		return GetApiUsersMeProjectsProjectIdAssets500JSONResponse{InternalServerErrorJSONResponse{
+			Error:   err.Error(),
+			Message: "Failed to get user information.",
+		}}, nil
+	}
+
+	// Validate project ID format
+	_, err = primitive.ObjectIDFromHex(request.ProjectId)
+	if err != nil {
+		return GetApiUsersMeProjectsProjectIdAssets400JSONResponse{BadRequestJSONResponse{
+			Error:   "Invalid project ID",
+			Message: "The provided project ID is not valid.",
+		}}, nil
+	}
+
+	// Get the project to verify it exists and belongs to user
+	project, err := util.GetGeneric[Project](request.ProjectId, projectsColl, ctx)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return GetApiUsersMeProjectsProjectIdAssets404JSONResponse{NotFoundJSONResponse{
+				Error:   "Project not found",
+				Message: "The project with the specified ID does not exist.",
+			}}, nil
+		}
+		return GetApiUsersMeProjectsProjectIdAssets500JSONResponse{InternalServerErrorJSONResponse{
+			Error:   err.Error(),
+			Message: "Failed to retrieve project.",
+		}}, nil
+	}
+
+	// Verify the project belongs to the current user
+	if project.UserId != user.Id {
+		return GetApiUsersMeProjectsProjectIdAssets404JSONResponse{NotFoundJSONResponse{
+			Error:   "Project not found",
+			Message: "The project with the specified ID does not exist or does not belong to you.",
+		}}, nil
+	}
+
+	return GetApiUsersMeProjectsProjectIdAssets200JSONResponse(s.signAssetURLs(request.ProjectId, withEmptyAssetLists(project.Assets))), nil
+}
+
+// withEmptyAssetLists replaces the missing lists of projects without uploads
+func withEmptyAssetLists(projectAssets ProjectAssets) ProjectAssets {
+	for _, list := range []*[]Asset{&projectAssets.Images, &projectAssets.Videos, &projectAssets.Audio, &projectAssets.Fonts, &projectAssets.Other} {
+		if *list == nil {
+			*list = []Asset{}
+		}
+	}
+	return projectAssets
+}
+
+// Upload a project asset
+// (POST /api/users/me/projects/{projectId}/assets)
+func (s Server) PostApiUsersMeProjectsProjectIdAssets(ctx context.Context, request PostApiUsersMeProjectsProjectIdAssetsRequestObject) (PostApiUsersMeProjectsProjectIdAssetsResponseObject, error) {
+	projectsColl := s.userStorage.db.Collection("projects")
+	userColl := s.userStorage.Collection()
+	uid := ctx.Value("uid").(string)
+
+	// Get user ID from UID
+	user, err := util.GetGenericUID[UserResponse](uid, userColl, ctx)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return PostApiUsersMeProjectsProjectIdAssets404JSONResponse{NotFoundJSONResponse{
+				Error:   "User not found",
+				Message: "The user with the specified ID does not exist.",
+			}}, nil
+		}
+		return PostApiUsersMeProjectsProjectIdAssets500JSONResponse{InternalServerErrorJSONResponse{
+			Error:   err.Error(),
+			Message: "Failed to get user information.",
+		}}, nil
+	}
+
+	// Validate project ID format
+	projectObjectID, err := primitive.ObjectIDFromHex(request.ProjectId)
+	if err != nil {
+		return PostApiUsersMeProjectsProjectIdAssets400JSONResponse{BadRequestJSONResponse{
+			Error:   "Invalid project ID",
+			Message: "The provided project ID is not valid.",
+		}}, nil
+	}
+
+	// Get the project to verify it exists and belongs to user
+	project, err := util.GetGeneric[Project](request.ProjectId, projectsColl, ctx)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return PostApiUsersMeProjectsProjectIdAssets404JSONResponse{NotFoundJSONResponse{
+				Error:   "Project not found",
+				Message: "The project with the specified ID does not exist.",
+			}}, nil
+		}
+		return PostApiUsersMeProjectsProjectIdAssets500JSONResponse{InternalServerErrorJSONResponse{
+			Error:   err.Error(),
+			Message: "Failed to retrieve project.",
+		}}, nil
+	}
+
+	// Verify the project belongs to the current user
+	if project.UserId != user.Id {
+		return PostApiUsersMeProjectsProjectIdAssets404JSONResponse{NotFoundJSONResponse{
+			Error:   "Project not found",
+			Message: "The project with the specified ID does not exist or does not belong to you.",
+		}}, nil
+	}
+
+	// Spool the file, the content decides about type and limit
+	name, upload, err := receiveFilePart(request.Body)
+	if err != nil {
+		switch {
+		case errors.Is(err, assets.ErrTooLarge):
+			return PostApiUsersMeProjectsProjectIdAssets413JSONResponse{PayloadTooLargeJSONResponse{
+				Error:   "File too large",
+				Message: "The file exceeds the size limit of its category.",
+			}}, nil
+		case errors.Is(err, assets.ErrEmpty), errors.Is(err, http.ErrMissingFile):
+			return PostApiUsersMeProjectsProjectIdAssets400JSONResponse{BadRequestJSONResponse{
+				Error:   err.Error(),
+				Message: "The request must contain a non-empty file.",
+			}}, nil
+		}
+		return PostApiUsersMeProjectsProjectIdAssets400JSONResponse{BadRequestJSONResponse{
+			Error:   err.Error(),
+			Message: "Failed to read the uploaded file.",
+		}}, nil
+	}
+	defer upload.Close()
+
+	// Hold back the credits until the asset is stored
+	reservation, err := s.credits.Reserve(ctx, user.Id, credits.AssetUpload,
+		fmt.Sprintf("Uploaded %q to project %q", name, project.Name),
+		map[string]interface{}{"projectId": request.ProjectId, "fileName": name, "size": upload.Size})
+	if err != nil {
+		if errors.Is(err, credits.ErrInsufficientCredits) {
+			return PostApiUsersMeProjectsProjectIdAssets402JSONResponse{PaymentRequiredJSONResponse{
+				Error:   "Insufficient credits",
+				Message: "You do not have enough credits to upload an asset.",
+			}}, nil
+		}
+		return PostApiUsersMeProjectsProjectIdAssets500JSONResponse{InternalServerErrorJSONResponse{
+			Error:   err.Error(),
+			Message: "Failed to reserve credits.",
+		}}, nil
+	}
+
+	stored, err := s.storeContent(ctx, user, upload.Hash, upload.Size, upload.Type, upload.File)
+	if err != nil {
+		_ = s.credits.Release(ctx, reservation)
+		if errors.Is(err, dedup.ErrQuotaExceeded) {
+			return PostApiUsersMeProjectsProjectIdAssets403JSONResponse{QuotaExceededJSONResponse{
+				Error:   err.Error(),
+				Message: "The file does not fit into the storage of your tier.",
+			}}, nil
+		}
+		if errors.Is(err, media.ErrInvalid) {
+			return PostApiUsersMeProjectsProjectIdAssets400JSONResponse{BadRequestJSONResponse{
+				Error:   err.Error(),
+				Message: "The file is damaged or not supported.",
+			}}, nil
+		}
+		return PostApiUsersMeProjectsProjectIdAssets500JSONResponse{InternalServerErrorJSONResponse{
+			Error:   err.Error(),
+			Message: "Failed to store the file.",
+		}}, nil
+	}
+
+	assetID := primitive.NewObjectID().Hex()
+	asset := storedAsset{
+		Asset: withSanitization(withMedia(Asset{
+			Id:         assetID,
+			Name:       name,
+			Type:       upload.Type,
+			Size:       int(stored.Size),
+			Url:        assetDownloadPath(request.ProjectId, assetID),
+			UploadedAt: time.Now(),
+		}, request.ProjectId, stored.Info), stored.Sanitized),
+		Key:  stored.Key,
+		Hash: stored.Hash,
+	}
+
+	// List the asset in its category
+	updateData := bson.M{
+		"$push": bson.M{"assets." + string(upload.Category): asset},
+		"$set":  bson.M{"metadata.updatedAt": time.Now()},
+	}
+
+	_, err = projectsColl.UpdateOne(ctx, bson.M{"_id": projectObjectID}, updateData)
+	if err != nil {
+		s.releaseContent(ctx, user.Id, asset)
+		_ = s.credits.Release(ctx, reservation)
+		return PostApiUsersMeProjectsProjectIdAssets500JSONResponse{InternalServerErrorJSONResponse{
+			Error:   err.Error(),
+			Message: "Failed to add the asset to the project.",
+		}}, nil
+	}
+
+	_, err = s.credits.Commit(ctx, reservation)
+	if err != nil {
+		log.Printf("error committing credits of asset %s: %v\n", assetID, err)
+	}
+
+	// only the response carries the signatures, the stored urls stay valid
+	return PostApiUsersMeProjectsProjectIdAssets201JSONResponse(s.signAssetURL(request.ProjectId, asset.Asset)), nil
+}
+
+// storedContent is where storeContent put a content and what it
+// learned about it
+type storedContent struct {
+	Key  string
+	Hash string
+	Size int64
+	Info media.Info
+	// Sanitized lists what was removed from an SVG before it was stored
+	Sanitized []media.SVGChange
+}
+
+// storeContent adds a reference of the user to the content and returns
+// the key of its blob with what processing learned about it. The content
+// is only written and processed when no asset holds the same bytes yet,
+// new content has to fit into the quota of the tier. SVGs are stored
+// sanitized, hash and size are those of the stored bytes.
+func (s Server) storeContent(ctx context.Context, user UserResponse, hash string, size int64, contentType string, content io.Reader) (storedContent, error) {
+	stored := storedContent{Hash: hash, Size: size}
+	if media.IsSVG(contentType) {
+		sanitized, changes, err := media.SanitizeSVG(content)
+		if err != nil {
+			return storedContent{}, err
+		}
+		// the markup is always written anew, the stored bytes differ
+		// from the upload even without changes
+		sum := sha256.Sum256(sanitized)
+		stored = storedContent{Hash: hex.EncodeToString(sum[:]), Size: int64(len(sanitized)), Sanitized: changes}
+		content = bytes.NewReader(sanitized)
+	}
+
+	blob, err := s.dedup.Acquire(ctx, user.Id, stored.Hash, stored.Size, s.userTier(user).Limits.MaxStorageBytes)
+	if err != nil {
+		return storedContent{}, err
+	}
+	stored.Key = blob.Key
+	if blob.Stored {
+		stored.Info = blobInfo(blob)
+		return stored, nil
+	}
+
+	err = s.blobs.Put(ctx, blob.Key, content, stored.Size, contentType)
+	if err == nil {
+		stored.Info, err = s.processContent(ctx, blob, contentType)
+	}
+	if err == nil {
+		err = s.dedup.MarkStored(ctx, blob, stored.Info)
+		if err != nil {
+			s.deleteBlobs(ctx, derivativeKeys(stored.Info)...)
+		}
+	}
+	if err != nil {
+		s.releaseContent(ctx, user.Id, storedAsset{Hash: stored.Hash})
+		return storedContent{}, err
+	}
+
+	return stored, nil
+}
+
+// releaseContent drops the reference of an asset to its blob, the blob and
+// its derivatives are deleted with the last reference
+func (s Server) releaseContent(ctx context.Context, userID string, asset storedAsset) {
+	// assets stored before deduplication own their blob
+	if asset.Hash == "" {
+		if asset.Key != "" {
+			s.deleteBlobs(ctx, asset.Key)
+		}
+		return
+	}
+
+	blob, err := s.dedup.Release(ctx, userID, asset.Hash)
+	if err != nil {
+		log.Printf("error releasing blob %s: %v\n", asset.Hash, err)
+		return
+	}
+	if blob == nil {
+		return
+	}
+
+	s.deleteBlobs(ctx, append([]string{blob.Key}, derivativeKeys(blobInfo(*blob))...)...)
+}
+
+// receiveFilePart spools the "file" part of the form, other parts are skipped
+func receiveFilePart(form *multipart.Reader) (string, *assets.Upload, error) {
+	for {
+		part, err := form.NextPart()
+		if err == io.EOF {
+			return "", nil, http.ErrMissingFile
+		}
+		if err != nil {
+			return "", nil, err
+		}
+
+		if part.FormName() != "file" {
+			part.Close()
+			continue
+		}
+		defer part.Close()
+
+		upload, err := assets.Receive(part)
+		if err != nil {
+			return "", nil, err
+		}
+		return assetFileName(part.FileName()), upload, nil
+	}
+}
+
+// assetFileName strips the directories a client sent along with the name
+func assetFileName(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	if name == "." || name == "/" {
+		name = "upload"
+	}
+	return name
+}
+
+// Delete a project asset
+// (DELETE /api/users/me/projects/{projectId}/assets/{assetId})
+func (s Server) DeleteApiUsersMeProjectsProjectIdAssetsAssetId(ctx context.Context, request DeleteApiUsersMeProjectsProjectIdAssetsAssetIdRequestObject) (DeleteApiUsersMeProjectsProjectIdAssetsAssetIdResponseObject, error) {
+	projectsColl := s.userStorage.db.Collection("projects")
+	userColl := s.userStorage.Collection()
+	uid := ctx.Value("uid").(string)
+
+	// Get user ID from UID
+	user, err := util.GetGenericUID[UserResponse](uid, userColl, ctx)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return DeleteApiUsersMeProjectsProjectIdAssetsAssetId404JSONResponse{NotFoundJSONResponse{
+				Error:   "User not found",
+				Message: "The user with the specified ID does not exist.",
+			}}, nil
+		}
+		return DeleteApiUsersMeProjectsProjectIdAssetsAssetId500JSONResponse{InternalServerErrorJSONResponse{
+			Error:   err.Error(),
+			Message: "Failed to get user information.",
+		}}, nil
+	}
+
+	// Validate project ID format
+	projectObjectID, err := primitive.ObjectIDFromHex(request.ProjectId)
+	if err != nil {
+		return DeleteApiUsersMeProjectsProjectIdAssetsAssetId400JSONResponse{BadRequestJSONResponse{
+			Error:   "Invalid project ID",
+			Message: "The provided project ID is not valid.",
+		}}, nil
+	}
+
+	// Get the project with the blob keys of its assets
+	project, err := util.GetGeneric[assetProject](request.ProjectId, projectsColl, ctx)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return DeleteApiUsersMeProjectsProjectIdAssetsAssetId404JSONResponse{NotFoundJSONResponse{
+				Error:   "Project not found",
+				Message: "The project with the specified ID does not exist.",
+			}}, nil
+		}
+		return DeleteApiUsersMeProjectsProjectIdAssetsAssetId500JSONResponse{InternalServerErrorJSONResponse{
+			Error:   err.Error(),
+			Message: "Failed to retrieve project.",
+		}}, nil
+	}
+
+	// Verify the project belongs to the current user
+	if project.UserId != user.Id {
+		return DeleteApiUsersMeProjectsProjectIdAssetsAssetId404JSONResponse{NotFoundJSONResponse{
+			Error:   "Project not found",
+			Message: "The project with the specified ID does not exist or does not belong to you.",
+		}}, nil
+	}
+
+	asset, category, ok := project.find(request.AssetId)
+	if !ok {
+		return DeleteApiUsersMeProjectsProjectIdAssetsAssetId404JSONResponse{NotFoundJSONResponse{
+			Error:   "Asset not found",
+			Message: "The asset with the specified ID does not exist in this project.",
+		}}, nil
+	}
+
+	updateData := bson.M{
+		"$pull": bson.M{"assets." + string(category): bson.M{"id": asset.Id}},
+		"$set":  bson.M{"metadata.updatedAt": time.Now()},
+	}
+
+	_, err = projectsColl.UpdateOne(ctx, bson.M{"_id": projectObjectID}, updateData)
+	if err != nil {
+		return DeleteApiUsersMeProjectsProjectIdAssetsAssetId500JSONResponse{InternalServerErrorJSONResponse{
+			Error:   err.Error(),
+			Message: "Failed to remove the asset from the project.",
+		}}, nil
+	}
+
+	// the asset is gone for the user, a left over blob is only wasted space
+	s.releaseContent(ctx, user.Id, asset)
+
+	return DeleteApiUsersMeProjectsProjectIdAssetsAssetId204Response{}, nil
+}
+
+// Download the content of a project asset
+// (GET /api/users/me/projects/{projectId}/assets/{assetId}/content)
+func (s Server) GetApiUsersMeProjectsProjectIdAssetsAssetIdContent(ctx context.Context, request GetApiUsersMeProjectsProjectIdAssetsAssetIdContentRequestObject) (GetApiUsersMeProjectsProjectIdAssetsAssetIdContentResponseObject, error) {
+	projectsColl := s.userStorage.db.Collection("projects")
+	userColl := s.userStorage.Collection()
+	uid := ctx.Value("uid").(string)
+
+	// Get user ID from UID
+	user, err := util.GetGenericUID[UserResponse](uid, userColl, ctx)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return GetApiUsersMeProjectsProjectIdAssetsAssetIdContent404JSONResponse{NotFoundJSONResponse{
+				Error:   "User not found",
+				Message: "The user with the specified ID does not exist.",
+			}}, nil
+		}
+		return GetApiUsersMeProjectsProjectIdAssetsAssetIdContent500JSONResponse{InternalServerErrorJSONResponse{
+			Error:   err.Error(),
+			Message: "Failed to get user information.",
+		}}, nil
+	}
+
+	// Validate project ID format
+	_, err = primitive.ObjectIDFromHex(request.ProjectId)
+	if err != nil {
+		return GetApiUsersMeProjectsProjectIdAssetsAssetIdContent400JSONResponse{BadRequestJSONResponse{
+			Error:   "Invalid project ID",
+			Message: "The provided project ID is not valid.",
+		}}, nil
+	}
+
+	// Get the project with the blob keys of its assets
+	project, err := util.GetGeneric[assetProject](request.ProjectId, projectsColl, ctx)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return GetApiUsersMeProjectsProjectIdAssetsAssetIdContent404JSONResponse{NotFoundJSONResponse{
+				Error:   "Project not found",
+				Message: "The project with the specified ID does not exist.",
+			}}, nil
+		}
+		return GetApiUsersMeProjectsProjectIdAssetsAssetIdContent500JSONResponse{InternalServerErrorJSONResponse{
+			Error:   err.Error(),
+			Message: "Failed to retrieve project.",
+		}}, nil
+	}
+
+	// Verify the project belongs to the current user
+	if project.UserId != user.Id {
+		return GetApiUsersMeProjectsProjectIdAssetsAssetIdContent404JSONResponse{NotFoundJSONResponse{
+			Error:   "Project not found",
+			Message: "The project with the specified ID does not exist or does not belong to you.",
+		}}, nil
+	}
+
+	asset, _, ok := project.find(request.AssetId)
+	if !ok {
+		return GetApiUsersMeProjectsProjectIdAssetsAssetIdContent404JSONResponse{NotFoundJSONResponse{
+			Error:   "Asset not found",
+			Message: "The asset with the specified ID does not exist in this project.",
+		}}, nil
+	}
+
+	body, err := s.blobs.Get(ctx, asset.Key)
+	if err != nil {
+		if errors.Is(err, storage.ErrBlobNotFound) {
+			return GetApiUsersMeProjectsProjectIdAssetsAssetIdContent404JSONResponse{NotFoundJSONResponse{
+				Error:   "Asset content not found",
+				Message: "The file of the asset is missing.",
+			}}, nil
+		}
+		return GetApiUsersMeProjectsProjectIdAssetsAssetIdContent500JSONResponse{InternalServerErrorJSONResponse{
+			Error:   err.Error(),
+			Message: "Failed to read the asset.",
+		}}, nil
+	}
+
+	return blobContent{
+		body:        body,
+		name:        asset.Name,
+		contentType: asset.Type,
+		size:        int64(asset.Size),
+	}, nil
+}
+
+// blobContent streams a stored file with its own content type
+type blobContent struct {
+	body        io.ReadCloser
+	name        string
+	contentType string
+	size        int64
+	// attachment asks browsers to save the file instead of showing it
+	attachment bool
+}
+
+// showsInline tells whether browsers may show a file of the type in
+// place. Media that cannot run anything qualifies, SVG, XML and HTML are
+// always saved, they would run on the API origin.
+func showsInline(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || media.IsSVG(mediaType) {
+		return false
+	}
+	kind, _, _ := strings.Cut(mediaType, "/")
+	switch kind {
+	case "image", "audio", "video", "font":
+		return true
+	}
+	return false
+}
+
+func (b blobContent) write(w http.ResponseWriter) error {
+	defer b.body.Close()
+
+	w.Header().Set("Content-Type", b.contentType)
+	w.Header().Set("Content-Length", fmt.Sprint(b.size))
+	disposition := "inline"
+	if b.attachment || !showsInline(b.contentType) {
+		disposition = "attachment"
+		// in case a browser shows it anyway
+		w.Header().Set("Content-Security-Policy", "sandbox")
+	}
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": b.name}))
+	// the type was sniffed, browsers must not guess another one
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+
+	_, err := io.Copy(w, b.body)
+	return err
+}
+
+func (b blobContent) VisitGetApiUsersMeProjectsProjectIdAssetsAssetIdContentResponse(w http.ResponseWriter) error {
+	return b.write(w)
+}
+
+func (b blobContent) VisitGetApiDownloadsProjectsProjectIdAssetsAssetIdResponse(w http.ResponseWriter) error {
+	return b.write(w)
+}
+
+func (b blobContent) VisitGetApiDownloadsProjectsProjectIdAssetsAssetIdDerivativesDerivativeResponse(w http.ResponseWriter) error {
+	return b.write(w)
+}
+
+func (b blobContent) VisitGetApiDownloadsProjectsProjectIdExportsExportIdResponse(w http.ResponseWriter) error {
+	return b.write(w)
+}
+
+// releaseProjectContent drops the blobs of the assets and exports of a
+// deleted project
+func (s Server) releaseProjectContent(ctx context.Context, userID string, project assetProject) {
+	for _, list := range project.Assets {
+		for _, asset := range list {
+			s.releaseContent(ctx, userID, asset)
+		}
+	}
+
+	for _, export := range project.ExportedVideos {
+		if export.Key == "" {
+			continue
+		}
+		err := s.blobs.Delete(ctx, export.Key)
+		if err != nil {
+			log.Printf("error deleting blob %s: %v\n", export.Key, err)
+		}
+	}
+}
+
+// Get the storage used by the current user
+// (GET /api/users/me/storage)
+func (s Server) GetApiUsersMeStorage(ctx context.Context, request GetApiUsersMeStorageRequestObject) (GetApiUsersMeStorageResponseObject, error) {
+	userColl := s.userStorage.Collection()
+	uid := ctx.Value("uid").(string)
+
+	// Get user ID from UID
+	user, err := util.GetGenericUID[UserResponse](uid, userColl, ctx)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return GetApiUsersMeStorage404JSONResponse{NotFoundJSONResponse{
+				Error:   "User not found",
+				Message: "The user with the specified ID does not exist.",
+			}}, nil
+		}
+		return GetApiUsersMeStorage500JSONResponse{InternalServerErrorJSONResponse{
+			Error:   err.Error(),
+			Message: "Failed to get user information.",
+		}}, nil
+	}
+
+	used, err := s.dedup.Usage(ctx, user.Id)
+	if err != nil {
+		return GetApiUsersMeStorage500JSONResponse{InternalServerErrorJSONResponse{
+			Error:   err.Error(),
+			Message: "Failed to compute the storage usage.",
+		}}, nil
+	}
+
+	return GetApiUsersMeStorage200JSONResponse{
+		UsedBytes: used,
+		MaxBytes:  s.userTier(user).Limits.MaxStorageBytes,
+	}, nil
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/Pieli/server/internal/catalog"
+)
+
+// List animations and backgrounds
+// (GET /api/catalog)
+func (s Server) GetApiCatalog(ctx context.Context, request GetApiCatalogRequestObject) (GetApiCatalogResponseObject, error) {
+	all := s.catalog.All()
+
+	response := make([]CatalogEntry, 0, len(all))
+	for _, entry := range all {
+		catalogEntry, err := toCatalogEntry(entry)
+		if err != nil {
+			return GetApiCatalog500JSONResponse{InternalServerErrorJSONResponse{
+				Error:   err.Error(),
+				Message: fmt.Sprintf("Failed to encode the schema of %q.", entry.Name),
+			}}, nil
+		}
+		response = append(response, catalogEntry)
+	}
+
+	return GetApiCatalog200JSONResponse(response), nil
+}
+
+// validateCompositions checks the compositions and their backgrounds
+// against the animation catalog
+func (s Server) validateCompositions(compositions []Composition) []FieldError {
+	fields := []FieldError{}
+
+	for i, comp := range compositions {
+		prefix := fmt.Sprintf("compositions.%d", i)
+		fields = append(fields, toFieldErrors(prefix,
+			s.catalog.Validate(catalog.Animation, comp.Name, comp.Props))...)
+
+		if comp.Background != nil {
+			fields = append(fields, toFieldErrors(prefix+".background",
+				s.catalog.Validate(catalog.Background, comp.Background.Name, comp.Background.Props))...)
+		}
+	}
+
+	return fields
+}
+
+func toFieldErrors(prefix string, errs []catalog.FieldError) []FieldError {
+	fields := make([]FieldError, 0, len(errs))
+	for _, e := range errs {
+		fields = append(fields, FieldError{
+			Field:   prefix + "." + e.Field,
+			Message: e.Message,
+		})
+	}
+	return fields
+}
+
+func toCatalogEntry(entry catalog.Entry) (CatalogEntry, error) {
+	// the schema is passed on as plain JSON
+	raw, err := json.Marshal(entry.Schema)
+	if err != nil {
+		return CatalogEntry{}, err
+	}
+
+	var schema map[string]interface{}
+	if err := json.Unmarshal(raw, &schema); err != nil {
+		return CatalogEntry{}, err
+	}
+
+	return CatalogEntry{
+		Name:     entry.Name,
+		Category: CatalogEntryCategory(entry.Category),
+		Version:  entry.Version,
+		Usecase:  entry.Usecase,
+		Schema:   schema,
+		Defaults: entry.Defaults(),
+	}, nil
+}
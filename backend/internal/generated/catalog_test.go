@@ -0,0 +1,42 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/Pieli/server/internal/catalog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestToCatalogEntry(t *testing.T) {
+	animationCatalog, err := catalog.Load("")
+	require.NoError(t, err)
+
+	entry, ok := animationCatalog.Get(catalog.Animation, "slideInTransition")
+	require.True(t, ok)
+
+	catalogEntry, err := toCatalogEntry(entry)
+	require.NoError(t, err)
+
+	assert.Equal(t, CatalogEntryCategory("animation"), catalogEntry.Category)
+	assert.Equal(t, "object", catalogEntry.Schema["type"])
+	properties, ok := catalogEntry.Schema["properties"].(map[string]interface{})
+	require.True(t, ok)
+	assert.Contains(t, properties, "slideDuration")
+	assert.Equal(t, float64(1000), catalogEntry.Defaults["slideDistance"])
+}
+
+func TestValidateCompositions(t *testing.T) {
+	animationCatalog, err := catalog.Load("")
+	require.NoError(t, err)
+	s := Server{catalog: animationCatalog}
+
+	fields := s.validateCompositions([]Composition{
+		{Id: "a", Name: "fadeInTransition", Props: map[string]interface{}{"fadeDuration": 2.0}},
+		{Id: "b", Name: "unknown", Background: &Composition{Name: "gradientMesh", Props: map[string]interface{}{"size": 500.0}}},
+	})
+
+	require.Len(t, fields, 2)
+	assert.Equal(t, "compositions.1.name", fields[0].Field)
+	assert.Equal(t, "compositions.1.background.props.size", fields[1].Field)
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"context"
+
+	"github.com/Pieli/server/internal/credits"
+	"github.com/Pieli/server/internal/tiers"
+)
+
+// number of transactions returned with the credit information
+const recentTransactionsLimit = 20
+
+// List payment tiers
+// (GET /api/tiers)
+func (s Server) GetApiTiers(ctx context.Context, request GetApiTiersRequestObject) (GetApiTiersResponseObject, error) {
+	all := s.tiers.All()
+
+	response := make([]PaymentTier, 0, len(all))
+	for _, tier := range all {
+		response = append(response, toPaymentTier(tier))
+	}
+
+	return GetApiTiers200JSONResponse(response), nil
+}
+
+// userTier resolves the payment tier of the user, users created before
+// tiers existed are in the default tier
+func (s Server) userTier(user UserResponse) tiers.Tier {
+	if user.PaymentTier == nil {
+		return s.tiers.Get(tiers.DefaultTier)
+	}
+	return s.tiers.Get(string(*user.PaymentTier))
+}
+
+func toPaymentTier(tier tiers.Tier) PaymentTier {
+	return PaymentTier{
+		Name:           TierName(tier.Name),
+		MonthlyCredits: tier.MonthlyCredits,
+		Price:          tier.Price,
+		Features:       tier.Features,
+		Limits: TierLimits{
+			MaxProjects:      tier.Limits.MaxProjects,
+			MaxStorageBytes:  tier.Limits.MaxStorageBytes,
+			MaxExportQuality: TierLimitsMaxExportQuality(tier.Limits.MaxExportQuality),
+		},
+	}
+}
+
+func toCreditTransaction(t credits.Transaction) CreditTransaction {
+	transaction := CreditTransaction{
+		UnderscoreId: t.Id.Hex(),
+		UserId:       t.UserId,
+		Amount:       t.Amount,
+		Operation:    CreditTransactionOperation(t.Operation),
+		Description:  t.Description,
+		CreatedAt:    t.CreatedAt,
+	}
+
+	if t.Metadata != nil {
+		metadata := t.Metadata
+		transaction.Metadata = &metadata
+	}
+
+	return transaction
+}
+
+func toUsageStats(usage credits.Usage, balance credits.Balance) UsageStats {
+	stats := UsageStats{
+		TopOperations: make([]OperationUsage, 0, len(usage.TopOperations)),
+	}
+
+	stats.CurrentMonth.Used = usage.CurrentMonthUsed
+	stats.CurrentMonth.Remaining = balance.Current
+	stats.LastMonth.Used = usage.LastMonthUsed
+	stats.LastMonth.Total = balance.Monthly
+
+	for _, op := range usage.TopOperations {
+		stats.TopOperations = append(stats.TopOperations, OperationUsage{
+			Operation:   string(op.Operation),
+			Count:       op.Count,
+			CreditsUsed: op.CreditsUsed,
+		})
+	}
+
+	return stats
+}
@@ -0,0 +1,192 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+
+	"github.com/Pieli/server/internal/download"
+	"github.com/Pieli/server/internal/exports"
+	"github.com/Pieli/server/internal/storage"
+	"github.com/Pieli/server/internal/util"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// assetDownloadPath is the unsigned path of the download URL of an asset
+func assetDownloadPath(projectID, assetID string) string {
+	return fmt.Sprintf("/api/downloads/projects/%s/assets/%s", projectID, assetID)
+}
+
+// exportDownloadPath is the unsigned path of the download URL of an export
+func exportDownloadPath(projectID, exportID string) string {
+	return fmt.Sprintf("/api/downloads/projects/%s/exports/%s", projectID, exportID)
+}
+
+// signAssetURL replaces the urls of an asset and its derivatives with
+// freshly signed ones
+func (s Server) signAssetURL(projectID string, asset Asset) Asset {
+	asset.Url = s.downloads.Sign(assetDownloadPath(projectID, asset.Id))
+	if asset.Derivatives != nil {
+		derivatives := make([]AssetDerivative, len(*asset.Derivatives))
+		for i, derivative := range *asset.Derivatives {
+			derivative.Url = s.downloads.Sign(assetDerivativePath(projectID, asset.Id, derivative.Name))
+			derivatives[i] = derivative
+		}
+		asset.Derivatives = &derivatives
+	}
+	return asset
+}
+
+// signAssetURLs replaces the urls of the assets with freshly signed ones
+func (s Server) signAssetURLs(projectID string, projectAssets ProjectAssets) ProjectAssets {
+	for _, list := range [][]Asset{projectAssets.Images, projectAssets.Videos, projectAssets.Audio, projectAssets.Fonts, projectAssets.Other} {
+		for i := range list {
+			list[i] = s.signAssetURL(projectID, list[i])
+		}
+	}
+	return projectAssets
+}
+
+// withSignedURLs signs the urls of the assets and exports of a project,
+// the stored urls are never handed out
+func (s Server) withSignedURLs(project Project) Project {
+	project.Assets = s.signAssetURLs(project.Id, project.Assets)
+	for i := range project.ExportedVideos {
+		project.ExportedVideos[i].Url = s.downloads.Sign(exportDownloadPath(project.Id, project.ExportedVideos[i].Id))
+	}
+	return project
+}
+
+// downloadError maps a failed signature check to its message
+func downloadError(err error) string {
+	if errors.Is(err, download.ErrExpired) {
+		return "The download link has expired, request a new one."
+	}
+	return "The download link is not valid."
+}
+
+// Download a project asset through a signed URL
+// (GET /api/downloads/projects/{projectId}/assets/{assetId})
+func (s Server) GetApiDownloadsProjectsProjectIdAssetsAssetId(ctx context.Context, request GetApiDownloadsProjectsProjectIdAssetsAssetIdRequestObject) (GetApiDownloadsProjectsProjectIdAssetsAssetIdResponseObject, error) {
+	projectsColl := s.userStorage.db.Collection("projects")
+
+	// The signature stands in for the token, the ids are covered by it
+	err := s.downloads.Verify(assetDownloadPath(request.ProjectId, request.AssetId), request.Params.Expires, request.Params.Signature)
+	if err != nil {
+		return GetApiDownloadsProjectsProjectIdAssetsAssetId403JSONResponse{ForbiddenJSONResponse{
+			Error:   err.Error(),
+			Message: downloadError(err),
+		}}, nil
+	}
+
+	project, err := util.GetGeneric[assetProject](request.ProjectId, projectsColl, ctx)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return GetApiDownloadsProjectsProjectIdAssetsAssetId404JSONResponse{NotFoundJSONResponse{
+				Error:   "Project not found",
+				Message: "The project with the specified ID does not exist.",
+			}}, nil
+		}
+		return GetApiDownloadsProjectsProjectIdAssetsAssetId500JSONResponse{InternalServerErrorJSONResponse{
+			Error:   err.Error(),
+			Message: "Failed to retrieve project.",
+		}}, nil
+	}
+
+	asset, _, ok := project.find(request.AssetId)
+	if !ok {
+		return GetApiDownloadsProjectsProjectIdAssetsAssetId404JSONResponse{NotFoundJSONResponse{
+			Error:   "Asset not found",
+			Message: "The asset with the specified ID does not exist in this project.",
+		}}, nil
+	}
+
+	body, err := s.blobs.Get(ctx, asset.Key)
+	if err != nil {
+		if errors.Is(err, storage.ErrBlobNotFound) {
+			return GetApiDownloadsProjectsProjectIdAssetsAssetId404JSONResponse{NotFoundJSONResponse{
+				Error:   "Asset content not found",
+				Message: "The file of the asset is missing.",
+			}}, nil
+		}
+		return GetApiDownloadsProjectsProjectIdAssetsAssetId500JSONResponse{InternalServerErrorJSONResponse{
+			Error:   err.Error(),
+			Message: "Failed to read the asset.",
+		}}, nil
+	}
+
+	return blobContent{
+		body:        body,
+		name:        asset.Name,
+		contentType: asset.Type,
+		size:        int64(asset.Size),
+	}, nil
+}
+
+// Download an exported video through a signed URL
+// (GET /api/downloads/projects/{projectId}/exports/{exportId})
+func (s Server) GetApiDownloadsProjectsProjectIdExportsExportId(ctx context.Context, request GetApiDownloadsProjectsProjectIdExportsExportIdRequestObject) (GetApiDownloadsProjectsProjectIdExportsExportIdResponseObject, error) {
+	projectsColl := s.userStorage.db.Collection("projects")
+
+	// The signature stands in for the token, the ids are covered by it
+	err := s.downloads.Verify(exportDownloadPath(request.ProjectId, request.ExportId), request.Params.Expires, request.Params.Signature)
+	if err != nil {
+		return GetApiDownloadsProjectsProjectIdExportsExportId403JSONResponse{ForbiddenJSONResponse{
+			Error:   err.Error(),
+			Message: downloadError(err),
+		}}, nil
+	}
+
+	project, err := util.GetGeneric[assetProject](request.ProjectId, projectsColl, ctx)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return GetApiDownloadsProjectsProjectIdExportsExportId404JSONResponse{NotFoundJSONResponse{
+				Error:   "Project not found",
+				Message: "The project with the specified ID does not exist.",
+			}}, nil
+		}
+		return GetApiDownloadsProjectsProjectIdExportsExportId500JSONResponse{InternalServerErrorJSONResponse{
+			Error:   err.Error(),
+			Message: "Failed to retrieve project.",
+		}}, nil
+	}
+
+	export, ok := project.findExport(request.ExportId)
+	if !ok || export.Key == "" {
+		return GetApiDownloadsProjectsProjectIdExportsExportId404JSONResponse{NotFoundJSONResponse{
+			Error:   "Export not found",
+			Message: "The export with the specified ID does not exist in this project.",
+		}}, nil
+	}
+
+	body, err := s.blobs.Get(ctx, export.Key)
+	if err != nil {
+		if errors.Is(err, storage.ErrBlobNotFound) {
+			return GetApiDownloadsProjectsProjectIdExportsExportId404JSONResponse{NotFoundJSONResponse{
+				Error:   "Export content not found",
+				Message: "The file of the export is missing.",
+			}}, nil
+		}
+		return GetApiDownloadsProjectsProjectIdExportsExportId500JSONResponse{InternalServerErrorJSONResponse{
+			Error:   err.Error(),
+			Message: "Failed to read the export.",
+		}}, nil
+	}
+
+	contentType, ok := exports.Formats[export.Format]
+	if !ok {
+		contentType = mime.TypeByExtension("." + export.Format)
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	return blobContent{
+		body:        body,
+		name:        fmt.Sprintf("%s.%s", export.Id, export.Format),
+		contentType: contentType,
+		size:        int64(export.Size),
+		attachment:  true,
+	}, nil
+}
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"io"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Pieli/server/internal/download"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestWithSignedURLs(t *testing.T) {
+	signer, err := download.NewSigner([]byte("secret"), time.Minute)
+	require.NoError(t, err)
+	s := Server{downloads: signer}
+
+	project := s.withSignedURLs(Project{
+		Id: "p1",
+		Assets: ProjectAssets{Images: []Asset{{
+			Id:          "a1",
+			Url:         "/stale",
+			Derivatives: &[]AssetDerivative{{Name: "thumbnail", Url: "/stale"}},
+		}}},
+		ExportedVideos: []ExportedVideo{{Id: "e1", Url: "/stale"}},
+	})
+
+	for path, signed := range map[string]string{
+		"/api/downloads/projects/p1/assets/a1":                       project.Assets.Images[0].Url,
+		"/api/downloads/projects/p1/assets/a1/derivatives/thumbnail": (*project.Assets.Images[0].Derivatives)[0].Url,
+		"/api/downloads/projects/p1/exports/e1":                      project.ExportedVideos[0].Url,
+	} {
+		signedPath, rawQuery, ok := strings.Cut(signed, "?")
+		require.True(t, ok)
+		assert.Equal(t, path, signedPath)
+
+		query, err := url.ParseQuery(rawQuery)
+		require.NoError(t, err)
+		expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+		require.NoError(t, err)
+		assert.NoError(t, signer.Verify(path, expires, query.Get("signature")))
+	}
+}
+
+func TestBlobContentDisposition(t *testing.T) {
+	for contentType, inline := range map[string]bool{
+		"image/png":                true,
+		"audio/mpeg":               true,
+		"video/mp4":                true,
+		"font/woff2":               true,
+		"image/svg+xml":            false,
+		"text/xml; charset=utf-8":  false,
+		"text/html; charset=utf-8": false,
+		"application/octet-stream": false,
+		"":                         false,
+	} {
+		recorder := httptest.NewRecorder()
+		content := blobContent{body: io.NopCloser(strings.NewReader("data")), name: "file", contentType: contentType, size: 4}
+		require.NoError(t, content.write(recorder))
+
+		disposition := recorder.Header().Get("Content-Disposition")
+		if inline {
+			assert.True(t, strings.HasPrefix(disposition, "inline"), contentType)
+			assert.Empty(t, recorder.Header().Get("Content-Security-Policy"), contentType)
+			continue
+		}
+		assert.True(t, strings.HasPrefix(disposition, "attachment"), contentType)
+		assert.Equal(t, "sandbox", recorder.Header().Get("Content-Security-Policy"), contentType)
+	}
+}
+
+func TestAssetProjectDecodes(t *testing.T) {
+	raw, err := bson.Marshal(bson.M{
+		"userId": "u1",
+		"assets": bson.M{"images": bson.A{
+			bson.M{"id": "a1", "key": "blobs/a1", "hash": "abc"},
+		}},
+		"exportedVideos": bson.A{
+			bson.M{"id": "e1", "key": "exports/e1"},
+		},
+	})
+	require.NoError(t, err)
+
+	var project assetProject
+	require.NoError(t, bson.Unmarshal(raw, &project))
+	assert.Equal(t, "u1", project.UserId)
+
+	asset, category, ok := project.find("a1")
+	require.True(t, ok)
+	assert.EqualValues(t, "images", category)
+	assert.Equal(t, "blobs/a1", asset.Key)
+	assert.Equal(t, "abc", asset.Hash)
+
+	require.Len(t, project.ExportedVideos, 1)
+	assert.Equal(t, "e1", project.ExportedVideos[0].Id)
+	assert.Equal(t, "exports/e1", project.ExportedVideos[0].Key)
+}
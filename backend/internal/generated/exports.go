@@ -0,0 +1,338 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Pieli/server/internal/credits"
+	"github.com/Pieli/server/internal/exports"
+	"github.com/Pieli/server/internal/util"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// toExportJob maps a job to its api representation, the video of a
+// succeeded job comes with a signed url
+func (s Server) toExportJob(job exports.Job) ExportJob {
+	exportJob := ExportJob{
+		Id:        job.Id.Hex(),
+		ProjectId: job.ProjectId,
+		Quality:   ExportJobQuality(job.Quality),
+		Format:    ExportJobFormat(job.Format),
+		Status:    ExportJobStatus(job.Status),
+		Attempts:  job.Attempts,
+		Progress:  job.Progress,
+		CreatedAt: job.CreatedAt,
+		UpdatedAt: job.UpdatedAt,
+	}
+	if job.Stage != "" {
+		stage := ExportJobStage(job.Stage)
+		exportJob.Stage = &stage
+	}
+	if job.Error != "" {
+		exportJob.Error = &job.Error
+	}
+
+	if job.Status == exports.Succeeded && job.Result != nil {
+		exportJob.ExportedVideo = &ExportedVideo{
+			Id:          job.Id.Hex(),
+			Quality:     ExportedVideoQuality(job.Quality),
+			Format:      job.Format,
+			Url:         s.downloads.Sign(exportDownloadPath(job.ProjectId, job.Id.Hex())),
+			Size:        int(job.Result.Size),
+			Duration:    float32(job.Result.Duration),
+			CreditsUsed: job.Reservation.Amount,
+			ExportedAt:  job.UpdatedAt,
+		}
+	}
+
+	return exportJob
+}
+
+// Export the project as a video
+// (POST /api/users/me/projects/{projectId}/exports)
+func (s Server) PostApiUsersMeProjectsProjectIdExports(ctx context.Context, request PostApiUsersMeProjectsProjectIdExportsRequestObject) (PostApiUsersMeProjectsProjectIdExportsResponseObject, error) {
+	projectsColl := s.userStorage.db.Collection("projects")
+	userColl := s.userStorage.Collection()
+	uid := ctx.Value("uid").(string)
+
+	// Get user ID from UID
+	user, err := util.GetGenericUID[UserResponse](uid, userColl, ctx)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return PostApiUsersMeProjectsProjectIdExports404JSONResponse{NotFoundJSONResponse{
+				Error:   "User not found",
+				Message: "The user with the specified ID does not exist.",
+			}}, nil
+		}
+		return PostApiUsersMeProjectsProjectIdExports500JSONResponse{InternalServerErrorJSONResponse{
+			Error:   err.Error(),
+			Message: "Failed to get user information.",
+		}}, nil
+	}
+
+	// Validate project ID format
+	_, err = primitive.ObjectIDFromHex(request.ProjectId)
+	if err != nil {
+		return PostApiUsersMeProjectsProjectIdExports400JSONResponse{BadRequestJSONResponse{
+			Error:   "Invalid project ID",
+			Message: "The provided project ID is not valid.",
+		}}, nil
+	}
+
+	quality := string(request.Body.Quality)
+	if _, ok := exports.Operation(quality); !ok {
+		return PostApiUsersMeProjectsProjectIdExports400JSONResponse{BadRequestJSONResponse{
+			Error:   "Invalid quality",
+			Message: "The quality must be one of HD, 4K or 8K.",
+		}}, nil
+	}
+	format := "mp4"
+	if request.Body.Format != nil {
+		format = string(*request.Body.Format)
+	}
+	if _, ok := exports.Formats[format]; !ok {
+		return PostApiUsersMeProjectsProjectIdExports400JSONResponse{BadRequestJSONResponse{
+			Error:   "Invalid format",
+			Message: "The format must be mp4 or webm.",
+		}}, nil
+	}
+
+	// The tier limits the quality
+	maxQuality := s.userTier(user).Limits.MaxExportQuality
+	if !exports.QualityAllowed(quality, maxQuality) {
+		return PostApiUsersMeProjectsProjectIdExports403JSONResponse{ForbiddenJSONResponse{
+			Error:   "Quality not available",
+			Message: fmt.Sprintf("Your tier exports videos up to %s.", maxQuality),
+		}}, nil
+	}
+
+	// Get the project to verify it exists and belongs to user
+	project, err := util.GetGeneric[Project](request.ProjectId, projectsColl, ctx)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return PostApiUsersMeProjectsProjectIdExports404JSONResponse{NotFoundJSONResponse{
+				Error:   "Project not found",
+				Message: "The project with the specified ID does not exist.",
+			}}, nil
+		}
+		return PostApiUsersMeProjectsProjectIdExports500JSONResponse{InternalServerErrorJSONResponse{
+			Error:   err.Error(),
+			Message: "Failed to retrieve project.",
+		}}, nil
+	}
+
+	// Verify the project belongs to the current user
+	if project.UserId != user.Id {
+		return PostApiUsersMeProjectsProjectIdExports404JSONResponse{NotFoundJSONResponse{
+			Error:   "Project not found",
+			Message: "The project with the specified ID does not exist or does not belong to you.",
+		}}, nil
+	}
+
+	if len(project.Compositions) == 0 {
+		return PostApiUsersMeProjectsProjectIdExports400JSONResponse{BadRequestJSONResponse{
+			Error:   "Nothing to export",
+			Message: "The project has no compositions.",
+		}}, nil
+	}
+
+	// The job renders the compositions as they are now
+	compositions, err := json.Marshal(project.Compositions)
+	if err != nil {
+		return PostApiUsersMeProjectsProjectIdExports500JSONResponse{InternalServerErrorJSONResponse{
+			Error:   err.Error(),
+			Message: "Failed to read the compositions.",
+		}}, nil
+	}
+
+	job, err := s.exports.Create(ctx, user.Id, request.ProjectId, project.Name, quality, format, compositions)
+	if err != nil {
+		if errors.Is(err, credits.ErrInsufficientCredits) {
+			return PostApiUsersMeProjectsProjectIdExports402JSONResponse{PaymentRequiredJSONResponse{
+				Error:   "Insufficient credits",
+				Message: fmt.Sprintf("You do not have enough credits to export a %s video.", quality),
+			}}, nil
+		}
+		return PostApiUsersMeProjectsProjectIdExports500JSONResponse{InternalServerErrorJSONResponse{
+			Error:   err.Error(),
+			Message: "Failed to queue the export.",
+		}}, nil
+	}
+
+	return PostApiUsersMeProjectsProjectIdExports202JSONResponse(s.toExportJob(job)), nil
+}
+
+// Get an export job
+// (GET /api/users/me/projects/{projectId}/exports/{exportId})
+func (s Server) GetApiUsersMeProjectsProjectIdExportsExportId(ctx context.Context, request GetApiUsersMeProjectsProjectIdExportsExportIdRequestObject) (GetApiUsersMeProjectsProjectIdExportsExportIdResponseObject, error) {
+	userColl := s.userStorage.Collection()
+	uid := ctx.Value("uid").(string)
+
+	// Get user ID from UID
+	user, err := util.GetGenericUID[UserResponse](uid, userColl, ctx)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return GetApiUsersMeProjectsProjectIdExportsExportId404JSONResponse{NotFoundJSONResponse{
+				Error:   "User not found",
+				Message: "The user with the specified ID does not exist.",
+			}}, nil
+		}
+		return GetApiUsersMeProjectsProjectIdExportsExportId500JSONResponse{InternalServerErrorJSONResponse{
+			Error:   err.Error(),
+			Message: "Failed to get user information.",
+		}}, nil
+	}
+
+	job, err := s.exports.Get(ctx, request.ExportId)
+	if err != nil && !errors.Is(err, exports.ErrNotFound) {
+		return GetApiUsersMeProjectsProjectIdExportsExportId500JSONResponse{InternalServerErrorJSONResponse{
+			Error:   err.Error(),
+			Message: "Failed to retrieve the export.",
+		}}, nil
+	}
+
+	// Jobs of other users do not exist as far as this one is concerned
+	if err != nil || job.UserId != user.Id || job.ProjectId != request.ProjectId {
+		return GetApiUsersMeProjectsProjectIdExportsExportId404JSONResponse{NotFoundJSONResponse{
+			Error:   "Export not found",
+			Message: "The export with the specified ID does not exist in this project.",
+		}}, nil
+	}
+
+	return GetApiUsersMeProjectsProjectIdExportsExportId200JSONResponse(s.toExportJob(job)), nil
+}
+
+// exportEventsPoll is how often a followed job is read again, workers
+// store their progress in the job so every server instance sees it
+var exportEventsPoll = time.Second
+
+// exportEventsKeepAlive is the longest a stream stays quiet
+const exportEventsKeepAlive = 15 * time.Second
+
+// Follow an export job as Server-Sent Events
+// (GET /api/users/me/projects/{projectId}/exports/{exportId}/events)
+func (s Server) GetApiUsersMeProjectsProjectIdExportsExportIdEvents(ctx context.Context, request GetApiUsersMeProjectsProjectIdExportsExportIdEventsRequestObject) (GetApiUsersMeProjectsProjectIdExportsExportIdEventsResponseObject, error) {
+	userColl := s.userStorage.Collection()
+	uid := ctx.Value("uid").(string)
+
+	// Get user ID from UID
+	user, err := util.GetGenericUID[UserResponse](uid, userColl, ctx)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return GetApiUsersMeProjectsProjectIdExportsExportIdEvents404JSONResponse{NotFoundJSONResponse{
+				Error:   "User not found",
+				Message: "The user with the specified ID does not exist.",
+			}}, nil
+		}
+		return GetApiUsersMeProjectsProjectIdExportsExportIdEvents500JSONResponse{InternalServerErrorJSONResponse{
+			Error:   err.Error(),
+			Message: "Failed to get user information.",
+		}}, nil
+	}
+
+	job, err := s.exports.Get(ctx, request.ExportId)
+	if err != nil && !errors.Is(err, exports.ErrNotFound) {
+		return GetApiUsersMeProjectsProjectIdExportsExportIdEvents500JSONResponse{InternalServerErrorJSONResponse{
+			Error:   err.Error(),
+			Message: "Failed to retrieve the export.",
+		}}, nil
+	}
+
+	// Jobs of other users do not exist as far as this one is concerned
+	if err != nil || job.UserId != user.Id || job.ProjectId != request.ProjectId {
+		return GetApiUsersMeProjectsProjectIdExportsExportIdEvents404JSONResponse{NotFoundJSONResponse{
+			Error:   "Export not found",
+			Message: "The export with the specified ID does not exist in this project.",
+		}}, nil
+	}
+
+	return exportEvents{
+		ctx:    ctx,
+		server: s,
+		job:    job,
+		get: func(ctx context.Context) (exports.Job, error) {
+			return s.exports.Get(ctx, request.ExportId)
+		},
+	}, nil
+}
+
+// exportEvents streams a job as the 200 response until it finished or the
+// client left
+type exportEvents struct {
+	ctx    context.Context
+	server Server
+	job    exports.Job
+	// get reads the current state of the job
+	get func(ctx context.Context) (exports.Job, error)
+}
+
+func (e exportEvents) VisitGetApiUsersMeProjectsProjectIdExportsExportIdEventsResponse(w http.ResponseWriter) error {
+	stream := newEventStream(w)
+
+	ticker := time.NewTicker(exportEventsPoll)
+	defer ticker.Stop()
+
+	job := e.job
+	// the first event carries the stored state, a reconnecting client
+	// resumes from there
+	sent, first := job, true
+	written := time.Now()
+	for {
+		switch job.Status {
+		case exports.Succeeded:
+			return stream.send("done", e.server.toExportJob(job))
+		case exports.Failed:
+			reason := job.Error
+			if reason == "" {
+				reason = "Export failed"
+			}
+			return stream.send("error", Error{
+				Error:   reason,
+				Message: "The export failed, the reserved credits were given back.",
+			})
+		}
+
+		if first || progressed(sent, job) {
+			if err := stream.send("progress", e.server.toExportJob(job)); err != nil {
+				return err
+			}
+			sent, first, written = job, false, time.Now()
+		} else if time.Since(written) >= exportEventsKeepAlive {
+			if err := stream.keepAlive(); err != nil {
+				return err
+			}
+			written = time.Now()
+		}
+
+		select {
+		case <-e.ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		var err error
+		job, err = e.get(e.ctx)
+		if err != nil {
+			if e.ctx.Err() != nil {
+				return nil
+			}
+			return stream.send("error", Error{
+				Error:   err.Error(),
+				Message: "Failed to retrieve the export.",
+			})
+		}
+	}
+}
+
+// progressed tells whether the job changed in a way its followers see
+func progressed(before, after exports.Job) bool {
+	return before.Status != after.Status ||
+		before.Stage != after.Stage ||
+		before.Progress != after.Progress ||
+		before.Attempts != after.Attempts
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Pieli/server/internal/credits"
+	"github.com/Pieli/server/internal/download"
+	"github.com/Pieli/server/internal/exports"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestToExportJob(t *testing.T) {
+	signer, err := download.NewSigner([]byte("secret"), time.Minute)
+	require.NoError(t, err)
+	s := Server{downloads: signer}
+
+	job := exports.Job{
+		Id:          primitive.NewObjectID(),
+		ProjectId:   "p1",
+		Quality:     "4K",
+		Format:      "webm",
+		Status:      exports.Queued,
+		Reservation: credits.Reservation{Amount: 25},
+	}
+	exportJob := s.toExportJob(job)
+	assert.Equal(t, ExportJobStatus("queued"), exportJob.Status)
+	assert.Nil(t, exportJob.Error)
+	assert.Nil(t, exportJob.ExportedVideo)
+
+	job.Status = exports.Succeeded
+	job.Attempts = 2
+	job.Error = "the first attempt failed"
+	job.Result = &exports.Result{Key: exports.Key(job), Size: 1024, Duration: 12.5}
+	exportJob = s.toExportJob(job)
+	assert.Equal(t, 2, exportJob.Attempts)
+	require.NotNil(t, exportJob.Error)
+	require.NotNil(t, exportJob.ExportedVideo)
+
+	video := *exportJob.ExportedVideo
+	assert.Equal(t, job.Id.Hex(), video.Id)
+	assert.Equal(t, ExportedVideoQuality("4K"), video.Quality)
+	assert.Equal(t, 1024, video.Size)
+	assert.Equal(t, float32(12.5), video.Duration)
+	assert.Equal(t, 25, video.CreditsUsed)
+	assert.True(t, strings.HasPrefix(video.Url, exportDownloadPath("p1", job.Id.Hex())+"?"))
+}
+
+func TestExportEvents(t *testing.T) {
+	signer, err := download.NewSigner([]byte("secret"), time.Minute)
+	require.NoError(t, err)
+	exportEventsPoll = time.Millisecond
+	defer func() { exportEventsPoll = time.Second }()
+
+	job := exports.Job{Id: primitive.NewObjectID(), ProjectId: "p1", Quality: "HD", Format: "mp4", Status: exports.Queued}
+	states := []exports.Job{job}
+	for _, change := range []func(j *exports.Job){
+		func(j *exports.Job) { j.Status, j.Attempts, j.Stage = exports.Rendering, 1, "bundling" },
+		func(j *exports.Job) {},
+		func(j *exports.Job) { j.Stage, j.Progress = "rendering", 40 },
+		func(j *exports.Job) {
+			j.Status, j.Stage, j.Progress, j.Result = exports.Succeeded, "", 100, &exports.Result{Size: 10}
+		},
+	} {
+		next := states[len(states)-1]
+		change(&next)
+		states = append(states, next)
+	}
+
+	reads := 1
+	events := exportEvents{
+		ctx:    context.Background(),
+		server: Server{downloads: signer},
+		job:    states[0],
+		get: func(ctx context.Context) (exports.Job, error) {
+			reads++
+			return states[reads-1], nil
+		},
+	}
+
+	recorder := httptest.NewRecorder()
+	require.NoError(t, events.VisitGetApiUsersMeProjectsProjectIdExportsExportIdEventsResponse(recorder))
+	assert.Equal(t, "text/event-stream", recorder.Header().Get("Content-Type"))
+
+	body := recorder.Body.String()
+	assert.Equal(t, 3, strings.Count(body, "event: progress\n"))
+	assert.Contains(t, body, `"stage":"rendering"`)
+	assert.Contains(t, body, "event: done\n")
+	assert.Contains(t, body, exportDownloadPath("p1", job.Id.Hex())+"?")
+}
+
+func TestExportEventsOfFailedJob(t *testing.T) {
+	events := exportEvents{
+		ctx: context.Background(),
+		job: exports.Job{Id: primitive.NewObjectID(), Status: exports.Failed, Error: "The export did not finish in time."},
+	}
+
+	recorder := httptest.NewRecorder()
+	require.NoError(t, events.VisitGetApiUsersMeProjectsProjectIdExportsExportIdEventsResponse(recorder))
+	assert.True(t, strings.HasPrefix(recorder.Body.String(), "event: error\n"))
+	assert.Contains(t, recorder.Body.String(), "did not finish in time")
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"context"
+
+	"github.com/Pieli/server/internal/fonts"
+	"github.com/Pieli/server/internal/util"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// fontProject is the part of a project document the font list needs
+type fontProject struct {
+	Id     string `bson:"_id"`
+	Assets struct {
+		Fonts []Asset `bson:"fonts"`
+	} `bson:"assets"`
+}
+
+// uploadedFonts lists the parsed font assets of the projects, fonts
+// uploaded before parsing have no family and are left out
+func uploadedFonts(projects []fontProject) []fonts.Uploaded {
+	uploaded := []fonts.Uploaded{}
+	for _, project := range projects {
+		for _, asset := range project.Assets.Fonts {
+			if asset.Font == nil {
+				continue
+			}
+			uploaded = append(uploaded, fonts.Uploaded{
+				Family: asset.Font.Family,
+				Variant: fonts.Variant{
+					Weight:    asset.Font.Weight,
+					Style:     string(asset.Font.Style),
+					Format:    string(asset.Font.Format),
+					ProjectId: project.Id,
+					AssetId:   asset.Id,
+				},
+			})
+		}
+	}
+	return uploaded
+}
+
+// List the fonts available to the typography editors
+// (GET /api/users/me/fonts)
+func (s Server) GetApiUsersMeFonts(ctx context.Context, request GetApiUsersMeFontsRequestObject) (GetApiUsersMeFontsResponseObject, error) {
+	projectsColl := s.userStorage.db.Collection("projects")
+	userColl := s.userStorage.Collection()
+	uid := ctx.Value("uid").(string)
+
+	// Get user ID from UID
+	user, err := util.GetGenericUID[UserResponse](uid, userColl, ctx)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return GetApiUsersMeFonts404JSONResponse{NotFoundJSONResponse{
+				Error:   "User not found",
+				Message: "The user with the specified ID does not exist.",
+			}}, nil
+		}
+		return GetApiUsersMeFonts500JSONResponse{InternalServerErrorJSONResponse{
+			Error:   err.Error(),
+			Message: "Failed to get user information.",
+		}}, nil
+	}
+
+	// Only the font assets of the projects are needed
+	cursor, err := projectsColl.Find(ctx, bson.M{"userId": user.Id},
+		options.Find().SetProjection(bson.M{"assets.fonts": 1}))
+	if err != nil {
+		return GetApiUsersMeFonts500JSONResponse{InternalServerErrorJSONResponse{
+			Error:   err.Error(),
+			Message: "Failed to retrieve projects.",
+		}}, nil
+	}
+
+	var projects []fontProject
+	if err = cursor.All(ctx, &projects); err != nil {
+		return GetApiUsersMeFonts500JSONResponse{InternalServerErrorJSONResponse{
+			Error:   err.Error(),
+			Message: "Failed to decode projects.",
+		}}, nil
+	}
+
+	families := fonts.Merge(fonts.Popular(), uploadedFonts(projects))
+
+	response := make(GetApiUsersMeFonts200JSONResponse, 0, len(families))
+	for _, family := range families {
+		variants := make([]FontVariant, 0, len(family.Variants))
+		for _, variant := range family.Variants {
+			variants = append(variants, FontVariant{
+				Weight:    variant.Weight,
+				Style:     FontStyle(variant.Style),
+				Format:    variant.Format,
+				ProjectId: variant.ProjectId,
+				AssetId:   variant.AssetId,
+				Url:       s.downloads.Sign(assetDownloadPath(variant.ProjectId, variant.AssetId)),
+			})
+		}
+		response = append(response, FontFamily{
+			Family:   family.Family,
+			Source:   FontFamilySource(family.Source),
+			Variants: variants,
+		})
+	}
+
+	return response, nil
+}
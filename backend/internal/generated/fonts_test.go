@@ -0,0 +1,42 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/Pieli/server/internal/fonts"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestUploadedFonts(t *testing.T) {
+	project := fontProject{Id: "p1"}
+	project.Assets.Fonts = []Asset{
+		{Id: "a1", Font: &FontInfo{Family: "Inter", Weight: 700, Style: "italic", Format: "woff2"}},
+		// uploaded before fonts were parsed
+		{Id: "a2"},
+	}
+
+	assert.Equal(t, []fonts.Uploaded{{
+		Family:  "Inter",
+		Variant: fonts.Variant{Weight: 700, Style: "italic", Format: "woff2", ProjectId: "p1", AssetId: "a1"},
+	}}, uploadedFonts([]fontProject{project}))
+}
+
+func TestFontProjectDecodes(t *testing.T) {
+	id := primitive.NewObjectID()
+	raw, err := bson.Marshal(bson.M{
+		"_id": id,
+		"assets": bson.M{"fonts": bson.A{
+			bson.M{"id": "a1", "font": bson.M{"family": "Inter", "weight": 400, "style": "normal", "format": "ttf"}},
+		}},
+	})
+	require.NoError(t, err)
+
+	var project fontProject
+	require.NoError(t, bson.Unmarshal(raw, &project))
+	assert.Equal(t, id.Hex(), project.Id)
+	require.Len(t, project.Assets.Fonts, 1)
+	assert.Equal(t, "Inter", project.Assets.Fonts[0].Font.Family)
+}
@@ -0,0 +1,363 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Pieli/server/internal/credits"
+	"github.com/Pieli/server/internal/llm"
+	"github.com/Pieli/server/internal/util"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// generation is a checked generate request with its credits reserved
+type generation struct {
+	projectObjectID primitive.ObjectID
+	prompt          string
+	request         llm.GenerateRequest
+	reservation     credits.Reservation
+}
+
+// generationError is a generate request that cannot go ahead, the
+// handlers answer it with their response of the status
+type generationError struct {
+	status   int
+	response Error
+}
+
+func (e *generationError) Error() string {
+	return e.response.Message
+}
+
+// prepareGeneration checks the request of both generate endpoints, looks
+// up the project context and reserves the credits. Errors other than a
+// *generationError are internal.
+func (s Server) prepareGeneration(ctx context.Context, projectID string, body GenerateRequest) (generation, error) {
+	uid := ctx.Value("uid").(string)
+
+	// Get user ID from UID
+	user, err := util.GetGenericUID[UserResponse](uid, s.userStorage.Collection(), ctx)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return generation{}, &generationError{http.StatusNotFound, Error{
+				Error:   "User not found",
+				Message: "The user with the specified ID does not exist.",
+			}}
+		}
+		return generation{}, &generationError{http.StatusInternalServerError, Error{
+			Error:   err.Error(),
+			Message: "Failed to get user information.",
+		}}
+	}
+
+	if strings.TrimSpace(body.Prompt) == "" {
+		return generation{}, &generationError{http.StatusBadRequest, Error{
+			Error:   "Empty prompt",
+			Message: "The prompt must not be empty.",
+		}}
+	}
+
+	// Validate project ID format
+	projectObjectID, err := primitive.ObjectIDFromHex(projectID)
+	if err != nil {
+		return generation{}, &generationError{http.StatusBadRequest, Error{
+			Error:   "Invalid project ID",
+			Message: "The provided project ID is not valid.",
+		}}
+	}
+
+	// Get the project to verify it exists and belongs to user
+	project, err := util.GetGeneric[Project](projectID, s.userStorage.db.Collection("projects"), ctx)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return generation{}, &generationError{http.StatusNotFound, Error{
+				Error:   "Project not found",
+				Message: "The project with the specified ID does not exist.",
+			}}
+		}
+		return generation{}, &generationError{http.StatusInternalServerError, Error{
+			Error:   err.Error(),
+			Message: "Failed to retrieve project.",
+		}}
+	}
+
+	// Verify the project belongs to the current user
+	if project.UserId != user.Id {
+		return generation{}, &generationError{http.StatusNotFound, Error{
+			Error:   "Project not found",
+			Message: "The project with the specified ID does not exist or does not belong to you.",
+		}}
+	}
+
+	if s.generator == nil {
+		return generation{}, &generationError{http.StatusInternalServerError, Error{
+			Error:   "Generation unavailable",
+			Message: "No language model is configured on the server.",
+		}}
+	}
+
+	// Hold back the credits until the model answered
+	reservation, err := s.credits.Reserve(ctx, user.Id, credits.GenerateAnimation,
+		fmt.Sprintf("Generated animation for project %q", project.Name),
+		map[string]interface{}{"projectId": projectID, "projectName": project.Name})
+	if err != nil {
+		if errors.Is(err, credits.ErrInsufficientCredits) {
+			return generation{}, &generationError{http.StatusPaymentRequired, Error{
+				Error:   "Insufficient credits",
+				Message: "You do not have enough credits to generate an animation.",
+			}}
+		}
+		return generation{}, &generationError{http.StatusInternalServerError, Error{
+			Error:   err.Error(),
+			Message: "Failed to reserve credits.",
+		}}
+	}
+
+	return generation{
+		projectObjectID: projectObjectID,
+		prompt:          body.Prompt,
+		request:         toGenerateRequest(project, body),
+		reservation:     reservation,
+	}, nil
+}
+
+// Generate compositions from a prompt
+// (POST /api/users/me/projects/{projectId}/generate)
+func (s Server) PostApiUsersMeProjectsProjectIdGenerate(ctx context.Context, request PostApiUsersMeProjectsProjectIdGenerateRequestObject) (PostApiUsersMeProjectsProjectIdGenerateResponseObject, error) {
+	g, err := s.prepareGeneration(ctx, request.ProjectId, *request.Body)
+	if err != nil {
+		e := err.(*generationError)
+		switch e.status {
+		case http.StatusBadRequest:
+			return PostApiUsersMeProjectsProjectIdGenerate400JSONResponse{BadRequestJSONResponse(e.response)}, nil
+		case http.StatusPaymentRequired:
+			return PostApiUsersMeProjectsProjectIdGenerate402JSONResponse{PaymentRequiredJSONResponse(e.response)}, nil
+		case http.StatusNotFound:
+			return PostApiUsersMeProjectsProjectIdGenerate404JSONResponse{NotFoundJSONResponse(e.response)}, nil
+		}
+		return PostApiUsersMeProjectsProjectIdGenerate500JSONResponse{InternalServerErrorJSONResponse(e.response)}, nil
+	}
+
+	generated, err := s.generator.Generate(ctx, g.request)
+	if err != nil {
+		// the client may be gone already, give the credits back regardless
+		_ = s.credits.Release(context.WithoutCancel(ctx), g.reservation)
+		return PostApiUsersMeProjectsProjectIdGenerate502JSONResponse{BadGatewayJSONResponse{
+			Error:   err.Error(),
+			Message: "The language model did not return a usable answer.",
+		}}, nil
+	}
+
+	result, err := s.saveGeneration(ctx, g, generated)
+	if err != nil {
+		return PostApiUsersMeProjectsProjectIdGenerate500JSONResponse{InternalServerErrorJSONResponse{
+			Error:   err.Error(),
+			Message: "Failed to add messages to chat history.",
+		}}, nil
+	}
+
+	return PostApiUsersMeProjectsProjectIdGenerate200JSONResponse(result), nil
+}
+
+// Generate compositions from a prompt as Server-Sent Events
+// (POST /api/users/me/projects/{projectId}/generate/stream)
+func (s Server) PostApiUsersMeProjectsProjectIdGenerateStream(ctx context.Context, request PostApiUsersMeProjectsProjectIdGenerateStreamRequestObject) (PostApiUsersMeProjectsProjectIdGenerateStreamResponseObject, error) {
+	g, err := s.prepareGeneration(ctx, request.ProjectId, *request.Body)
+	if err != nil {
+		e := err.(*generationError)
+		switch e.status {
+		case http.StatusBadRequest:
+			return PostApiUsersMeProjectsProjectIdGenerateStream400JSONResponse{BadRequestJSONResponse(e.response)}, nil
+		case http.StatusPaymentRequired:
+			return PostApiUsersMeProjectsProjectIdGenerateStream402JSONResponse{PaymentRequiredJSONResponse(e.response)}, nil
+		case http.StatusNotFound:
+			return PostApiUsersMeProjectsProjectIdGenerateStream404JSONResponse{NotFoundJSONResponse(e.response)}, nil
+		}
+		return PostApiUsersMeProjectsProjectIdGenerateStream500JSONResponse{InternalServerErrorJSONResponse(e.response)}, nil
+	}
+
+	// the generation runs while the response is written
+	return generateStream{ctx: ctx, server: s, generation: g}, nil
+}
+
+// generateStream streams a generation as the 200 response. The context is
+// the one of the request, so a client disconnect cancels the model call.
+type generateStream struct {
+	ctx    context.Context
+	server Server
+	generation
+}
+
+func (g generateStream) VisitPostApiUsersMeProjectsProjectIdGenerateStreamResponse(w http.ResponseWriter) error {
+	stream := newEventStream(w)
+
+	generated, err := g.server.generator.Stream(g.ctx, g.request,
+		func(token string) error {
+			return stream.send("token", map[string]string{"content": token})
+		},
+		func(comp llm.GeneratedComposition) error {
+			return stream.send("composition", toComposition(comp))
+		})
+	if err != nil {
+		// the client may be gone already, give the credits back regardless
+		_ = g.server.credits.Release(context.WithoutCancel(g.ctx), g.reservation)
+		if g.ctx.Err() != nil {
+			return nil
+		}
+		return stream.send("error", Error{
+			Error:   err.Error(),
+			Message: "The language model did not return a usable answer.",
+		})
+	}
+
+	// the answer is complete, store it even if the client left meanwhile
+	result, err := g.server.saveGeneration(context.WithoutCancel(g.ctx), g.generation, generated)
+	if err != nil {
+		return stream.send("error", Error{
+			Error:   err.Error(),
+			Message: "Failed to add messages to chat history.",
+		})
+	}
+
+	return stream.send("done", GenerateStreamDone{
+		MessageId: result.Message.Id,
+		Result:    result,
+	})
+}
+
+// saveGeneration appends the prompt and the answer to the chat history
+// and settles the reserved credits, they are released on failure
+func (s Server) saveGeneration(ctx context.Context, g generation, generated llm.Response) (GenerateResponse, error) {
+	userMessage, assistantMessage, err := generationMessages(g.prompt, generated)
+	if err != nil {
+		_ = s.credits.Release(ctx, g.reservation)
+		return GenerateResponse{}, err
+	}
+
+	// Append both messages to the chat history
+	updateData := bson.M{
+		"$push": bson.M{"chatHistory": bson.M{"$each": bson.A{userMessage, assistantMessage}}},
+		"$set":  bson.M{"metadata.updatedAt": time.Now()},
+	}
+
+	_, err = s.userStorage.db.Collection("projects").UpdateOne(ctx, bson.M{"_id": g.projectObjectID}, updateData)
+	if err != nil {
+		_ = s.credits.Release(ctx, g.reservation)
+		return GenerateResponse{}, err
+	}
+
+	_, err = s.credits.Commit(ctx, g.reservation)
+	if err != nil {
+		log.Printf("error committing credits of generation %s: %v\n", assistantMessage.Id, err)
+	}
+
+	return GenerateResponse{
+		UserMessage:  userMessage,
+		Message:      assistantMessage,
+		Compositions: toCompositions(generated),
+		Comment:      generated.Comment,
+	}, nil
+}
+
+// toGenerateRequest collects the project context for the model,
+// values sent by the client take precedence over the stored ones
+func toGenerateRequest(project Project, body GenerateRequest) llm.GenerateRequest {
+	compositions := project.Compositions
+	if body.Compositions != nil {
+		compositions = *body.Compositions
+	}
+
+	palette := project.ColorScheme
+	if body.ColorScheme != nil {
+		palette = body.ColorScheme
+	}
+
+	req := llm.GenerateRequest{
+		Prompt:       body.Prompt,
+		History:      make([]llm.Message, 0, len(project.ChatHistory)),
+		Compositions: make([]llm.Composition, 0, len(compositions)),
+	}
+
+	for _, msg := range project.ChatHistory {
+		req.History = append(req.History, llm.Message{Role: string(msg.Role), Content: msg.Content})
+	}
+
+	for _, comp := range compositions {
+		req.Compositions = append(req.Compositions, llm.Composition{
+			Id:       comp.Id,
+			Name:     comp.Name,
+			Duration: comp.Duration,
+			Props:    comp.Props,
+		})
+	}
+
+	if palette != nil {
+		req.Colors = palette.Colors
+	}
+
+	return req
+}
+
+// generationMessages creates the chat messages of a generation. The
+// assistant message keeps the full answer for later context, like the
+// frontend's createAgentMessageFromResponse.
+func generationMessages(prompt string, generated llm.Response) (ChatMessage, ChatMessage, error) {
+	content, err := json.Marshal(map[string]interface{}{
+		"fullResponse":   generated,
+		"displayComment": generated.Comment,
+	})
+	if err != nil {
+		return ChatMessage{}, ChatMessage{}, err
+	}
+
+	userMessage := ChatMessage{
+		Id:        primitive.NewObjectID().Hex(),
+		Role:      ChatMessageRoleUser,
+		Content:   prompt,
+		Timestamp: time.Now(),
+	}
+
+	assistantMessage := ChatMessage{
+		Id:        primitive.NewObjectID().Hex(),
+		Role:      ChatMessageRoleAssistant,
+		Content:   string(content),
+		Timestamp: time.Now(),
+	}
+
+	return userMessage, assistantMessage, nil
+}
+
+// toCompositions converts the model output into stored compositions,
+// mirrors responseToGeneratedComposition of the frontend
+func toCompositions(generated llm.Response) []Composition {
+	compositions := make([]Composition, 0, len(generated.Compositions))
+
+	for _, comp := range generated.Compositions {
+		compositions = append(compositions, toComposition(comp))
+	}
+
+	return compositions
+}
+
+func toComposition(comp llm.GeneratedComposition) Composition {
+	return Composition{
+		Id:       comp.Id,
+		Name:     comp.AnimationName,
+		Props:    comp.AnimationSettings,
+		Duration: comp.Duration,
+		Background: &Composition{
+			Id:       comp.Id + "-background",
+			Name:     comp.Background.Name,
+			Props:    comp.Background.Settings,
+			Duration: 1,
+		},
+	}
+}
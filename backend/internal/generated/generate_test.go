@@ -0,0 +1,51 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/Pieli/server/internal/catalog"
+	"github.com/Pieli/server/internal/llm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerationFlowWithFakeProvider(t *testing.T) {
+	project := Project{
+		ChatHistory:  []ChatMessage{{Role: ChatMessageRoleUser, Content: "earlier"}},
+		Compositions: []Composition{{Id: "old", Name: "fadeInTransition", Duration: 45}},
+		ColorScheme:  &ColorPalette{Colors: []string{"#123456"}},
+	}
+	body := GenerateRequest{Prompt: "Launch day is here"}
+
+	req := toGenerateRequest(project, body)
+	assert.Equal(t, []string{"#123456"}, req.Colors)
+	require.Len(t, req.History, 1)
+	require.Len(t, req.Compositions, 1)
+
+	animationCatalog, err := catalog.Load("")
+	require.NoError(t, err)
+
+	generated, err := llm.NewGenerator(llm.FakeProvider{}, animationCatalog).Generate(context.Background(), req)
+	require.NoError(t, err)
+
+	compositions := toCompositions(generated)
+	require.Len(t, compositions, 1)
+	assert.Equal(t, "slide-1", compositions[0].Id)
+	assert.Equal(t, "Launch day is here", compositions[0].Props["typo_text"])
+	require.NotNil(t, compositions[0].Background)
+	assert.Equal(t, "slide-1-background", compositions[0].Background.Id)
+
+	userMessage, assistantMessage, err := generationMessages(body.Prompt, generated)
+	require.NoError(t, err)
+	assert.Equal(t, "Launch day is here", userMessage.Content)
+
+	var content struct {
+		FullResponse   llm.Response `json:"fullResponse"`
+		DisplayComment string       `json:"displayComment"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(assistantMessage.Content), &content))
+	assert.Equal(t, generated, content.FullResponse)
+	assert.Equal(t, generated.Comment, content.DisplayComment)
+}
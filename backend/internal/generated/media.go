@@ -0,0 +1,333 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/Pieli/server/internal/dedup"
+	"github.com/Pieli/server/internal/media"
+	"github.com/Pieli/server/internal/storage"
+	"github.com/Pieli/server/internal/util"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// assetDerivativePath is the unsigned path of the download URL of a derivative
+func assetDerivativePath(projectID, assetID, name string) string {
+	return fmt.Sprintf("/api/downloads/projects/%s/assets/%s/derivatives/%s", projectID, assetID, name)
+}
+
+// processContent learns about freshly stored content and writes its
+// derivatives next to the blob. Content that cannot be processed is
+// kept without.
+func (s Server) processContent(ctx context.Context, blob dedup.Blob, contentType string) (media.Info, error) {
+	info := media.Info{}
+	if !media.CanDecodeImage(contentType) && !media.CanParseAudio(contentType) &&
+		!media.CanProbeVideo(contentType) && !media.CanParseFont(contentType) {
+		return info, nil
+	}
+
+	body, err := s.blobs.Get(ctx, blob.Key)
+	if err != nil {
+		return info, err
+	}
+	defer body.Close()
+
+	switch {
+	case media.CanParseAudio(contentType):
+		audio, err := media.ProcessAudio(body, contentType, blob.Size)
+		if err != nil {
+			return info, err
+		}
+		info.Audio = &audio
+	case media.CanProbeVideo(contentType):
+		video, err := media.ProcessVideo(body, contentType)
+		if err != nil {
+			return info, err
+		}
+		info.Video = &video
+	case media.CanParseFont(contentType):
+		font, err := media.ProcessFont(body, contentType)
+		if err != nil {
+			return info, err
+		}
+		info.Font = &font
+	default:
+		return s.processImage(ctx, blob, contentType, body)
+	}
+
+	return info, nil
+}
+
+// processImage decodes an image and stores its resized derivatives
+func (s Server) processImage(ctx context.Context, blob dedup.Blob, contentType string, body io.Reader) (media.Info, error) {
+	info := media.Info{}
+	// decoding needs to read the header twice, images are small enough
+	content, err := io.ReadAll(body)
+	if err != nil {
+		return info, err
+	}
+
+	image, renditions, err := media.ProcessImage(bytes.NewReader(content), contentType)
+	if err != nil {
+		return info, err
+	}
+	info.Image = &image
+
+	for _, rendition := range renditions {
+		derivative := media.Derivative{
+			Name:   rendition.Name,
+			Key:    media.DerivativeKey(blob.Key, rendition.Name),
+			Type:   rendition.Type,
+			Width:  rendition.Width,
+			Height: rendition.Height,
+			Size:   int64(len(rendition.Data)),
+		}
+		err := s.blobs.Put(ctx, derivative.Key, bytes.NewReader(rendition.Data), derivative.Size, derivative.Type)
+		if err != nil {
+			s.deleteBlobs(ctx, derivativeKeys(info)...)
+			return media.Info{}, err
+		}
+		info.Derivatives = append(info.Derivatives, derivative)
+	}
+
+	return info, nil
+}
+
+// blobInfo decodes what processing stored with a blob
+func blobInfo(blob dedup.Blob) media.Info {
+	info := media.Info{}
+	if len(blob.Info) == 0 {
+		return info
+	}
+	if err := bson.Unmarshal(blob.Info, &info); err != nil {
+		log.Printf("error decoding the info of blob %s: %v\n", blob.Hash, err)
+	}
+	return info
+}
+
+func derivativeKeys(info media.Info) []string {
+	keys := make([]string, 0, len(info.Derivatives))
+	for _, derivative := range info.Derivatives {
+		keys = append(keys, derivative.Key)
+	}
+	return keys
+}
+
+func (s Server) deleteBlobs(ctx context.Context, keys ...string) {
+	for _, key := range keys {
+		err := s.blobs.Delete(ctx, key)
+		if err != nil {
+			log.Printf("error deleting blob %s: %v\n", key, err)
+		}
+	}
+}
+
+// withMedia adds what processing learned about the content to an asset.
+// The palette is named after the file so it can be applied as it is.
+func withMedia(asset Asset, projectID string, info media.Info) Asset {
+	if info.Image != nil {
+		asset.Image = &ImageInfo{Width: info.Image.Width, Height: info.Image.Height}
+		if len(info.Image.Palette) >= 2 {
+			asset.Image.Palette = &ColorPalette{
+				Id:     "asset-" + asset.Id,
+				Name:   fmt.Sprintf("Colors of %s", asset.Name),
+				Colors: info.Image.Palette,
+			}
+		}
+	}
+
+	if info.Audio != nil {
+		asset.Audio = &AudioInfo{
+			Duration:   info.Audio.Duration,
+			SampleRate: info.Audio.SampleRate,
+			Channels:   info.Audio.Channels,
+			Waveform:   info.Audio.Waveform,
+		}
+		if asset.Audio.Waveform == nil {
+			asset.Audio.Waveform = []float64{}
+		}
+		if info.Audio.BitsPerSample > 0 {
+			asset.Audio.BitsPerSample = &info.Audio.BitsPerSample
+		}
+		if info.Audio.Bitrate > 0 {
+			asset.Audio.Bitrate = &info.Audio.Bitrate
+		}
+	}
+
+	if info.Video != nil {
+		asset.Video = &VideoInfo{
+			Container:  VideoInfoContainer(info.Video.Container),
+			Duration:   info.Video.Duration,
+			Width:      info.Video.Width,
+			Height:     info.Video.Height,
+			VideoCodec: info.Video.VideoCodec,
+			Renderable: media.CanRender(info.Video.VideoCodec),
+		}
+		if info.Video.FrameRate > 0 {
+			asset.Video.FrameRate = &info.Video.FrameRate
+		}
+		if info.Video.AudioCodec != "" {
+			asset.Video.AudioCodec = &info.Video.AudioCodec
+		}
+	}
+
+	if info.Font != nil {
+		asset.Font = &FontInfo{
+			Format: FontInfoFormat(info.Font.Format),
+			Family: info.Font.Family,
+			Weight: info.Font.Weight,
+			Style:  FontStyle(info.Font.Style),
+		}
+		if info.Font.Subfamily != "" {
+			asset.Font.Subfamily = &info.Font.Subfamily
+		}
+		if info.Font.FullName != "" {
+			asset.Font.FullName = &info.Font.FullName
+		}
+	}
+
+	if len(info.Derivatives) > 0 {
+		derivatives := make([]AssetDerivative, 0, len(info.Derivatives))
+		for _, derivative := range info.Derivatives {
+			derivatives = append(derivatives, AssetDerivative{
+				Name:   derivative.Name,
+				Type:   derivative.Type,
+				Width:  optionalSize(derivative.Width),
+				Height: optionalSize(derivative.Height),
+				Size:   int(derivative.Size),
+				Url:    assetDerivativePath(projectID, asset.Id, derivative.Name),
+			})
+		}
+		asset.Derivatives = &derivatives
+	}
+
+	return asset
+}
+
+// withSanitization lists on an asset what was removed from its SVG
+func withSanitization(asset Asset, changes []media.SVGChange) Asset {
+	if len(changes) == 0 {
+		return asset
+	}
+
+	sanitization := make([]SanitizationChange, 0, len(changes))
+	for _, change := range changes {
+		sanitizationChange := SanitizationChange{
+			Reason: SanitizationChangeReason(change.Reason),
+			Count:  change.Count,
+		}
+		if change.Element != "" {
+			sanitizationChange.Element = &change.Element
+		}
+		if change.Attribute != "" {
+			sanitizationChange.Attribute = &change.Attribute
+		}
+		sanitization = append(sanitization, sanitizationChange)
+	}
+	asset.Sanitization = &sanitization
+	return asset
+}
+
+func optionalSize(size int) *int {
+	if size == 0 {
+		return nil
+	}
+	return &size
+}
+
+// findDerivative returns the derivative of the asset with the given name
+func findDerivative(asset Asset, name string) (AssetDerivative, bool) {
+	if asset.Derivatives == nil {
+		return AssetDerivative{}, false
+	}
+	for _, derivative := range *asset.Derivatives {
+		if derivative.Name == name {
+			return derivative, true
+		}
+	}
+	return AssetDerivative{}, false
+}
+
+// derivativeFileName names the file of a derivative after its asset, with
+// the extension of its own type
+func derivativeFileName(assetName string, derivative AssetDerivative) string {
+	extension := strings.TrimPrefix(derivative.Type, "image/")
+	if extension == "jpeg" {
+		extension = "jpg"
+	}
+	return fmt.Sprintf("%s-%s.%s", strings.TrimSuffix(assetName, filepath.Ext(assetName)), derivative.Name, extension)
+}
+
+// Download a derivative of a project asset through a signed URL
+// (GET /api/downloads/projects/{projectId}/assets/{assetId}/derivatives/{derivative})
+func (s Server) GetApiDownloadsProjectsProjectIdAssetsAssetIdDerivativesDerivative(ctx context.Context, request GetApiDownloadsProjectsProjectIdAssetsAssetIdDerivativesDerivativeRequestObject) (GetApiDownloadsProjectsProjectIdAssetsAssetIdDerivativesDerivativeResponseObject, error) {
+	projectsColl := s.userStorage.db.Collection("projects")
+
+	// The signature stands in for the token, the ids are covered by it
+	err := s.downloads.Verify(assetDerivativePath(request.ProjectId, request.AssetId, request.Derivative), request.Params.Expires, request.Params.Signature)
+	if err != nil {
+		return GetApiDownloadsProjectsProjectIdAssetsAssetIdDerivativesDerivative403JSONResponse{ForbiddenJSONResponse{
+			Error:   err.Error(),
+			Message: downloadError(err),
+		}}, nil
+	}
+
+	project, err := util.GetGeneric[assetProject](request.ProjectId, projectsColl, ctx)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return GetApiDownloadsProjectsProjectIdAssetsAssetIdDerivativesDerivative404JSONResponse{NotFoundJSONResponse{
+				Error:   "Project not found",
+				Message: "The project with the specified ID does not exist.",
+			}}, nil
+		}
+		return GetApiDownloadsProjectsProjectIdAssetsAssetIdDerivativesDerivative500JSONResponse{InternalServerErrorJSONResponse{
+			Error:   err.Error(),
+			Message: "Failed to retrieve project.",
+		}}, nil
+	}
+
+	asset, _, ok := project.find(request.AssetId)
+	if !ok {
+		return GetApiDownloadsProjectsProjectIdAssetsAssetIdDerivativesDerivative404JSONResponse{NotFoundJSONResponse{
+			Error:   "Asset not found",
+			Message: "The asset with the specified ID does not exist in this project.",
+		}}, nil
+	}
+
+	derivative, ok := findDerivative(asset.Asset, request.Derivative)
+	if !ok {
+		return GetApiDownloadsProjectsProjectIdAssetsAssetIdDerivativesDerivative404JSONResponse{NotFoundJSONResponse{
+			Error:   "Derivative not found",
+			Message: "The asset has no derivative with this name.",
+		}}, nil
+	}
+
+	// derivatives are stored next to the blob of the asset
+	body, err := s.blobs.Get(ctx, media.DerivativeKey(asset.Key, derivative.Name))
+	if err != nil {
+		if errors.Is(err, storage.ErrBlobNotFound) {
+			return GetApiDownloadsProjectsProjectIdAssetsAssetIdDerivativesDerivative404JSONResponse{NotFoundJSONResponse{
+				Error:   "Derivative content not found",
+				Message: "The file of the derivative is missing.",
+			}}, nil
+		}
+		return GetApiDownloadsProjectsProjectIdAssetsAssetIdDerivativesDerivative500JSONResponse{InternalServerErrorJSONResponse{
+			Error:   err.Error(),
+			Message: "Failed to read the derivative.",
+		}}, nil
+	}
+
+	return blobContent{
+		body:        body,
+		name:        derivativeFileName(asset.Name, derivative),
+		contentType: derivative.Type,
+		size:        int64(derivative.Size),
+	}, nil
+}
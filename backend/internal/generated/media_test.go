@@ -0,0 +1,95 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/Pieli/server/internal/media"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithMedia(t *testing.T) {
+	info := media.Info{
+		Image: &media.ImageInfo{Width: 800, Height: 600, Palette: []string{"#FF0000", "#0000FF"}},
+		Derivatives: []media.Derivative{
+			{Name: "thumbnail", Key: "blobs/sha256/abc/1.thumbnail", Type: "image/jpeg", Width: 256, Height: 192, Size: 1024},
+		},
+	}
+
+	asset := withMedia(Asset{Id: "a1", Name: "sunset.png"}, "p1", info)
+	require.NotNil(t, asset.Image)
+	assert.Equal(t, 800, asset.Image.Width)
+	require.NotNil(t, asset.Image.Palette)
+	assert.Equal(t, ColorPalette{Id: "asset-a1", Name: "Colors of sunset.png", Colors: []string{"#FF0000", "#0000FF"}}, *asset.Image.Palette)
+
+	require.NotNil(t, asset.Derivatives)
+	derivative, ok := findDerivative(asset, "thumbnail")
+	require.True(t, ok)
+	assert.Equal(t, "/api/downloads/projects/p1/assets/a1/derivatives/thumbnail", derivative.Url)
+	assert.Equal(t, "sunset-thumbnail.jpg", derivativeFileName(asset.Name, derivative))
+}
+
+func TestWithMediaSkipsSingleColorPalettes(t *testing.T) {
+	asset := withMedia(Asset{Id: "a1"}, "p1", media.Info{Image: &media.ImageInfo{Width: 1, Height: 1, Palette: []string{"#FFFFFF"}}})
+	require.NotNil(t, asset.Image)
+	assert.Nil(t, asset.Image.Palette)
+	assert.Nil(t, asset.Derivatives)
+
+	asset = withMedia(Asset{Id: "a2"}, "p1", media.Info{})
+	assert.Nil(t, asset.Image)
+}
+
+func TestWithMediaAudio(t *testing.T) {
+	asset := withMedia(Asset{Id: "a1"}, "p1", media.Info{Audio: &media.AudioInfo{Duration: 2.5, SampleRate: 44100, Channels: 2, Bitrate: 128000}})
+	require.NotNil(t, asset.Audio)
+	assert.Equal(t, 2.5, asset.Audio.Duration)
+	assert.Nil(t, asset.Audio.BitsPerSample)
+	require.NotNil(t, asset.Audio.Bitrate)
+	assert.Equal(t, 128000, *asset.Audio.Bitrate)
+	// MP3s have no waveform, the list is empty rather than missing
+	assert.Equal(t, []float64{}, asset.Audio.Waveform)
+}
+
+func TestWithMediaVideo(t *testing.T) {
+	asset := withMedia(Asset{Id: "a1"}, "p1", media.Info{Video: &media.VideoInfo{Container: "mp4", Duration: 3, Width: 640, Height: 360, VideoCodec: "h265"}})
+	require.NotNil(t, asset.Video)
+	assert.Equal(t, VideoInfoContainer("mp4"), asset.Video.Container)
+	assert.False(t, asset.Video.Renderable)
+	assert.Nil(t, asset.Video.FrameRate)
+	assert.Nil(t, asset.Video.AudioCodec)
+}
+
+func TestWithMediaFont(t *testing.T) {
+	info := media.Info{Font: &media.FontInfo{Format: "woff2", Family: "Inter", Weight: 700, Style: "italic"}}
+
+	asset := withMedia(Asset{Id: "a1", Name: "Inter-BoldItalic.woff2"}, "p1", info)
+	require.NotNil(t, asset.Font)
+	assert.Equal(t, FontInfoFormat("woff2"), asset.Font.Format)
+	assert.Equal(t, "Inter", asset.Font.Family)
+	assert.Equal(t, 700, asset.Font.Weight)
+	assert.Equal(t, FontStyle("italic"), asset.Font.Style)
+	assert.Nil(t, asset.Font.Subfamily)
+	assert.Nil(t, asset.Font.FullName)
+}
+
+func TestWithSanitization(t *testing.T) {
+	asset := withSanitization(Asset{Id: "a1"}, nil)
+	assert.Nil(t, asset.Sanitization)
+
+	asset = withSanitization(asset, []media.SVGChange{
+		{Reason: media.SVGEventHandler, Element: "rect", Attribute: "onclick", Count: 2},
+		{Reason: media.SVGDoctype, Count: 1},
+	})
+	require.NotNil(t, asset.Sanitization)
+	require.Len(t, *asset.Sanitization, 2)
+
+	handler := (*asset.Sanitization)[0]
+	assert.Equal(t, SanitizationChangeReason("eventHandler"), handler.Reason)
+	assert.Equal(t, "rect", *handler.Element)
+	assert.Equal(t, "onclick", *handler.Attribute)
+	assert.Equal(t, 2, handler.Count)
+
+	doctype := (*asset.Sanitization)[1]
+	assert.Nil(t, doctype.Element)
+	assert.Nil(t, doctype.Attribute)
+}
@@ -2,11 +2,22 @@ package api
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
 	"net/url"
 	"time"
 
 	"firebase.google.com/go/auth"
+	"github.com/Pieli/server/internal/catalog"
+	"github.com/Pieli/server/internal/credits"
+	"github.com/Pieli/server/internal/dedup"
+	"github.com/Pieli/server/internal/download"
+	"github.com/Pieli/server/internal/exports"
+	"github.com/Pieli/server/internal/llm"
+	"github.com/Pieli/server/internal/storage"
+	"github.com/Pieli/server/internal/tiers"
+	"github.com/Pieli/server/internal/uploads"
 	"github.com/Pieli/server/internal/util"
 	openapi_types "github.com/oapi-codegen/runtime/types"
 	"go.mongodb.org/mongo-driver/bson"
@@ -20,11 +31,29 @@ var _ StrictServerInterface = (*Server)(nil)
 
 type Server struct {
 	userStorage *UserStore
+	credits     *credits.Service
+	tiers       *tiers.Catalog
+	catalog     *catalog.Catalog
+	blobs       storage.BlobStore
+	dedup       *dedup.Store
+	uploads     *uploads.Store
+	downloads   *download.Signer
+	generator   *llm.Generator
+	exports     *exports.Service
 }
 
-func NewServer(userStore *UserStore) Server {
+func NewServer(userStore *UserStore, creditService *credits.Service, tierCatalog *tiers.Catalog, animationCatalog *catalog.Catalog, blobStore storage.BlobStore, contentStore *dedup.Store, uploadStore *uploads.Store, downloadSigner *download.Signer, generator *llm.Generator, exportService *exports.Service) Server {
 	return Server{
 		userStorage: userStore,
+		credits:     creditService,
+		tiers:       tierCatalog,
+		catalog:     animationCatalog,
+		blobs:       blobStore,
+		dedup:       contentStore,
+		uploads:     uploadStore,
+		downloads:   downloadSigner,
+		generator:   generator,
+		exports:     exportService,
 	}
 }
 
@@ -87,6 +116,8 @@ type UserCreationIntermediate struct {
 	CreatedAt     time.Time         `bson:"createdAt"`
 	EmailVerified bool              `bson:"emailVerified"`
 	UID           string            `bson:"uid"`
+	PaymentTier   string            `bson:"paymentTier"`
+	Credits       credits.Balance   `bson:"credits"`
 }
 
 // --- Project endpoints ---
@@ -133,6 +164,10 @@ func (s Server) GetApiUsersMeProjects(ctx context.Context, request GetApiUsersMe
 		}}, nil
 	}
 
+	for i := range projects {
+		projects[i] = s.withSignedURLs(projects[i])
+	}
+
 	return GetApiUsersMeProjects200JSONResponse(projects), nil
 }
 
@@ -174,6 +209,32 @@ func (s Server) PostApiUsersMeProjects(ctx context.Context, request PostApiUsers
 	}
 
 	projectID := inserted.InsertedID.(primitive.ObjectID)
+
+	// Enforce the project limit of the tier. The new project is counted
+	// along with the others so concurrent creates cannot both slip under it.
+	count, err := coll.CountDocuments(ctx, bson.M{"userId": user.Id})
+	if err != nil {
+		_, _ = coll.DeleteOne(context.WithoutCancel(ctx), bson.M{"_id": projectID})
+		return PostApiUsersMeProjects500JSONResponse{InternalServerErrorJSONResponse{
+			Error:   err.Error(),
+			Message: "Failed to count projects.",
+		}}, nil
+	}
+
+	tier := s.userTier(user)
+	if count > int64(tier.Limits.MaxProjects) {
+		if _, err := coll.DeleteOne(context.WithoutCancel(ctx), bson.M{"_id": projectID}); err != nil {
+			return PostApiUsersMeProjects500JSONResponse{InternalServerErrorJSONResponse{
+				Error:   err.Error(),
+				Message: "Failed to remove the project over the limit.",
+			}}, nil
+		}
+		return PostApiUsersMeProjects403JSONResponse{ForbiddenJSONResponse{
+			Error:   "Project limit reached",
+			Message: fmt.Sprintf("The %s tier allows at most %d projects.", tier.Name, tier.Limits.MaxProjects),
+		}}, nil
+	}
+
 	created, err := util.GetGeneric[Project](projectID.Hex(), coll, ctx)
 	if err != nil {
 		return PostApiUsersMeProjects500JSONResponse{InternalServerErrorJSONResponse{
@@ -226,8 +287,8 @@ func (s Server) DeleteApiUsersMeProjectsProjectId(ctx context.Context, request D
 		}}, nil
 	}
 
-	// Check if project exists and belongs to the user
-	var project Project
+	// Check if project exists and belongs to the user, with the blobs it holds
+	var project assetProject
 	err = projectsColl.FindOne(ctx, bson.M{
 		"_id":    projectObjectID,
 		"userId": user.Id,
@@ -254,6 +315,9 @@ func (s Server) DeleteApiUsersMeProjectsProjectId(ctx context.Context, request D
 		}}, nil
 	}
 
+	// Drop the files of the project, whatever is missed is left to the sweeper
+	s.releaseProjectContent(ctx, user.Id, project)
+
 	// Remove project reference from user's projects array
 	userObjectID, _ := primitive.ObjectIDFromHex(user.Id)
 	err = s.userStorage.RemoveProject(ctx, userObjectID, projectObjectID)
@@ -317,7 +381,7 @@ func (s Server) GetApiUsersMeProjectsProjectId(ctx context.Context, request GetA
 		}}, nil
 	}
 
-	return GetApiUsersMeProjectsProjectId200JSONResponse(project), nil
+	return GetApiUsersMeProjectsProjectId200JSONResponse(s.withSignedURLs(project)), nil
 }
 
 // Update project compositions
@@ -351,6 +415,17 @@ func (s Server) PutApiUsersMeProjectsProjectId(ctx context.Context, request PutA
 		}}, nil
 	}
 
+	// Reject compositions the editor cannot render
+	if request.Body.Compositions != nil {
+		if fields := s.validateCompositions(*request.Body.Compositions); len(fields) > 0 {
+			return PutApiUsersMeProjectsProjectId400JSONResponse{BadRequestJSONResponse{
+				Error:   "Invalid compositions",
+				Message: "One or more compositions do not match the animation catalog.",
+				Fields:  &fields,
+			}}, nil
+		}
+	}
+
 	// Get the project using generic function to verify it exists and belongs to user
 	project, err := util.GetGeneric[Project](request.ProjectId, projectsColl, ctx)
 	if err != nil {
@@ -406,7 +481,7 @@ func (s Server) PutApiUsersMeProjectsProjectId(ctx context.Context, request PutA
 		}}, nil
 	}
 
-	return PutApiUsersMeProjectsProjectId200JSONResponse(updatedProject), nil
+	return PutApiUsersMeProjectsProjectId200JSONResponse(s.withSignedURLs(updatedProject)), nil
 }
 
 func (s Server) PutApiUsersMeProjectsProjectIdCompositions(ctx context.Context, request PutApiUsersMeProjectsProjectIdCompositionsRequestObject) (PutApiUsersMeProjectsProjectIdCompositionsResponseObject, error) {
@@ -438,6 +513,17 @@ func (s Server) PutApiUsersMeProjectsProjectIdCompositions(ctx context.Context,
 		}}, nil
 	}
 
+	// Reject compositions the editor cannot render
+	if request.Body.Compositions != nil {
+		if fields := s.validateCompositions(*request.Body.Compositions); len(fields) > 0 {
+			return PutApiUsersMeProjectsProjectIdCompositions400JSONResponse{BadRequestJSONResponse{
+				Error:   "Invalid compositions",
+				Message: "One or more compositions do not match the animation catalog.",
+				Fields:  &fields,
+			}}, nil
+		}
+	}
+
 	// Get the project using generic function to verify it exists and belongs to user
 	project, err := util.GetGeneric[Project](request.ProjectId, projectsColl, ctx)
 	if err != nil {
@@ -493,7 +579,7 @@ func (s Server) PutApiUsersMeProjectsProjectIdCompositions(ctx context.Context,
 		}}, nil
 	}
 
-	return PutApiUsersMeProjectsProjectIdCompositions200JSONResponse(updatedProject), nil
+	return PutApiUsersMeProjectsProjectIdCompositions200JSONResponse(s.withSignedURLs(updatedProject)), nil
 }
 
 // Add message to project chat history
@@ -550,6 +636,26 @@ func (s Server) PostApiUsersMeProjectsProjectIdChat(ctx context.Context, request
 		}}, nil
 	}
 
+	// messages written by the user are billed
+	var reservation credits.Reservation
+	if request.Body.Role == CreateChatMessageRoleUser {
+		reservation, err = s.credits.Reserve(ctx, user.Id, credits.AIChatMessage,
+			fmt.Sprintf("Chat message in project %q", project.Name),
+			map[string]interface{}{"projectId": request.ProjectId})
+		if err != nil {
+			if errors.Is(err, credits.ErrInsufficientCredits) {
+				return PostApiUsersMeProjectsProjectIdChat402JSONResponse{PaymentRequiredJSONResponse{
+					Error:   "Insufficient credits",
+					Message: "You do not have enough credits to send a message.",
+				}}, nil
+			}
+			return PostApiUsersMeProjectsProjectIdChat500JSONResponse{InternalServerErrorJSONResponse{
+				Error:   err.Error(),
+				Message: "Failed to reserve credits.",
+			}}, nil
+		}
+	}
+
 	// Create new chat message
 	chatMessage := ChatMessage{
 		Id:        primitive.NewObjectID().Hex(),
@@ -568,12 +674,20 @@ func (s Server) PostApiUsersMeProjectsProjectIdChat(ctx context.Context, request
 	projectObjectID, _ := primitive.ObjectIDFromHex(request.ProjectId)
 	_, err = projectsColl.UpdateOne(ctx, bson.M{"_id": projectObjectID}, updateData)
 	if err != nil {
+		_ = s.credits.Release(ctx, reservation)
 		return PostApiUsersMeProjectsProjectIdChat500JSONResponse{InternalServerErrorJSONResponse{
 			Error:   err.Error(),
 			Message: "Failed to add message to chat history.",
 		}}, nil
 	}
 
+	if request.Body.Role == CreateChatMessageRoleUser {
+		_, err = s.credits.Commit(ctx, reservation)
+		if err != nil {
+			log.Printf("error committing credits of message %s: %v\n", chatMessage.Id, err)
+		}
+	}
+
 	return PostApiUsersMeProjectsProjectIdChat200JSONResponse(chatMessage), nil
 }
 
@@ -607,11 +721,20 @@ func (s Server) PostApiUsers(ctx context.Context, request PostApiUsersRequestObj
 	}
 	createUser.Email = openapi_types.Email(email)
 
+	// new users start in the default tier
+	tier := s.tiers.Get(tiers.DefaultTier)
+
 	// Create intermediate object with timestamp and photo URL
 	userIntermediate := UserCreationIntermediate{
-		UserReq:   createUser,
-		CreatedAt: time.Now(),
-		UID:       userRecord.UID,
+		UserReq:     createUser,
+		CreatedAt:   time.Now(),
+		UID:         userRecord.UID,
+		PaymentTier: tier.Name,
+		Credits: credits.Balance{
+			Current:   0,
+			Monthly:   tier.MonthlyCredits,
+			LastReset: credits.MonthStart(time.Now()),
+		},
 	}
 
 	// Add photo URL if available from Firebase
@@ -629,8 +752,22 @@ func (s Server) PostApiUsers(ctx context.Context, request PostApiUsersRequestObj
 		}}, nil
 	}
 
+	// grant the first allowance through the ledger
+	userID := result.InsertedID.(primitive.ObjectID).Hex()
+	_, err = s.credits.Apply(ctx, userID, tier.MonthlyCredits,
+		credits.MonthlyReset, "Initial monthly allowance", nil)
+	if err != nil {
+		// without the grant the user would wait for next month's reset,
+		// it is removed so creating it again starts over
+		_, _ = s.userStorage.Collection().DeleteOne(context.WithoutCancel(ctx), bson.M{"_id": result.InsertedID})
+		return PostApiUsers500JSONResponse{InternalServerErrorJSONResponse{
+			Error:   err.Error(),
+			Message: "The initial credits could not be granted. Please try again later.",
+		}}, nil
+	}
+
 	return PostApiUsers200JSONResponse{
-		UserId: result.InsertedID.(primitive.ObjectID).Hex(),
+		UserId: userID,
 	}, nil
 
 }
@@ -662,7 +799,64 @@ func (s Server) GetApiUsersMe(ctx context.Context, request GetApiUsersMeRequestO
 // Get current user credit information
 // (GET /api/users/me/credit)
 func (s Server) GetApiUsersMeCredit(ctx context.Context, request GetApiUsersMeCreditRequestObject) (GetApiUsersMeCreditResponseObject, error) {
-	return nil, nil
+	coll := s.userStorage.Collection()
+	uid := ctx.Value("uid").(string)
+
+	user, err := util.GetGenericUID[UserResponse](uid, coll, ctx)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return GetApiUsersMeCredit404JSONResponse{NotFoundJSONResponse{
+				Error:   "User not found",
+				Message: "The user with the specified ID does not exist.",
+			}}, nil
+		}
+		return GetApiUsersMeCredit500JSONResponse{InternalServerErrorJSONResponse{
+			Error:   err.Error(),
+			Message: "Failed to get user information.",
+		}}, nil
+	}
+
+	balance, err := s.credits.Balance(ctx, user.Id)
+	if err != nil {
+		return GetApiUsersMeCredit500JSONResponse{InternalServerErrorJSONResponse{
+			Error:   err.Error(),
+			Message: "Failed to get credit balance.",
+		}}, nil
+	}
+
+	recent, err := s.credits.Recent(ctx, user.Id, recentTransactionsLimit)
+	if err != nil {
+		return GetApiUsersMeCredit500JSONResponse{InternalServerErrorJSONResponse{
+			Error:   err.Error(),
+			Message: "Failed to get credit transactions.",
+		}}, nil
+	}
+
+	usage, err := s.credits.Usage(ctx, user.Id, time.Now())
+	if err != nil {
+		return GetApiUsersMeCredit500JSONResponse{InternalServerErrorJSONResponse{
+			Error:   err.Error(),
+			Message: "Failed to get credit usage.",
+		}}, nil
+	}
+
+	transactions := make([]CreditTransaction, 0, len(recent))
+	for _, t := range recent {
+		transactions = append(transactions, toCreditTransaction(t))
+	}
+
+	response := UserCredits{
+		Credits: Credits{
+			Current:      balance.Current,
+			Monthly:      balance.Monthly,
+			LastReset:    balance.LastReset,
+			Transactions: transactions,
+		},
+		Tier:  toPaymentTier(s.userTier(user)),
+		Usage: toUsageStats(usage, balance),
+	}
+
+	return GetApiUsersMeCredit200JSONResponse(response), nil
 }
 
 // Update project name
@@ -751,7 +945,7 @@ func (s Server) PatchApiUsersMeProjectsProjectIdName(ctx context.Context, reques
 		}}, nil
 	}
 
-	return PatchApiUsersMeProjectsProjectIdName200JSONResponse(updatedProject), nil
+	return PatchApiUsersMeProjectsProjectIdName200JSONResponse(s.withSignedURLs(updatedProject)), nil
 }
 
 func (s Server) PatchApiUsersMeProjectsProjectIdColorScheme(ctx context.Context, request PatchApiUsersMeProjectsProjectIdColorSchemeRequestObject) (PatchApiUsersMeProjectsProjectIdColorSchemeResponseObject, error) {
@@ -838,5 +1032,5 @@ func (s Server) PatchApiUsersMeProjectsProjectIdColorScheme(ctx context.Context,
 		}}, nil
 	}
 
-	return PatchApiUsersMeProjectsProjectIdColorScheme200JSONResponse(updatedProject), nil
+	return PatchApiUsersMeProjectsProjectIdColorScheme200JSONResponse(s.withSignedURLs(updatedProject)), nil
 }
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// eventStream writes Server-Sent Events, every event is flushed right away
+type eventStream struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+// newEventStream sends the event stream headers and the 200 status
+func newEventStream(w http.ResponseWriter) eventStream {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	// keep reverse proxies from buffering the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+	stream := eventStream{w: w, flusher: flusher}
+	stream.flush()
+	return stream
+}
+
+// send writes data as JSON encoded event
+func (e eventStream) send(event string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	if _, err := fmt.Fprintf(e.w, "event: %s\ndata: %s\n\n", event, payload); err != nil {
+		return err
+	}
+	e.flush()
+	return nil
+}
+
+// keepAlive writes a comment, it keeps proxies from closing a quiet stream
+func (e eventStream) keepAlive() error {
+	if _, err := fmt.Fprint(e.w, ": keep-alive\n\n"); err != nil {
+		return err
+	}
+	e.flush()
+	return nil
+}
+
+func (e eventStream) flush() {
+	if e.flusher != nil {
+		e.flusher.Flush()
+	}
+}
@@ -0,0 +1,531 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/Pieli/server/internal/assets"
+	"github.com/Pieli/server/internal/credits"
+	"github.com/Pieli/server/internal/dedup"
+	"github.com/Pieli/server/internal/media"
+	"github.com/Pieli/server/internal/storage"
+	"github.com/Pieli/server/internal/uploads"
+	"github.com/Pieli/server/internal/util"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// uploadURL is the location of an upload session
+func uploadURL(projectID, uploadID string) string {
+	return fmt.Sprintf("/api/users/me/projects/%s/uploads/%s", projectID, uploadID)
+}
+
+// Start a resumable upload
+// (POST /api/users/me/projects/{projectId}/uploads)
+func (s Server) PostApiUsersMeProjectsProjectIdUploads(ctx context.Context, request PostApiUsersMeProjectsProjectIdUploadsRequestObject) (PostApiUsersMeProjectsProjectIdUploadsResponseObject, error) {
+	projectsColl := s.userStorage.db.Collection("projects")
+	userColl := s.userStorage.Collection()
+	uid := ctx.Value("uid").(string)
+
+	if request.Params.TusResumable != uploads.TusVersion {
+		return PostApiUsersMeProjectsProjectIdUploads412JSONResponse{PreconditionFailedJSONResponse{
+			Error:   "Unsupported tus version",
+			Message: fmt.Sprintf("Only version %s of the tus protocol is supported.", uploads.TusVersion),
+		}}, nil
+	}
+
+	// Get user ID from UID
+	user, err := util.GetGenericUID[UserResponse](uid, userColl, ctx)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return PostApiUsersMeProjectsProjectIdUploads404JSONResponse{NotFoundJSONResponse{
+				Error:   "User not found",
+				Message: "The user with the specified ID does not exist.",
+			}}, nil
+		}
+		return PostApiUsersMeProjectsProjectIdUploads500JSONResponse{InternalServerErrorJSONResponse{
+			Error:   err.Error(),
+			Message: "Failed to get user information.",
+		}}, nil
+	}
+
+	// Validate project ID format
+	_, err = primitive.ObjectIDFromHex(request.ProjectId)
+	if err != nil {
+		return PostApiUsersMeProjectsProjectIdUploads400JSONResponse{BadRequestJSONResponse{
+			Error:   "Invalid project ID",
+			Message: "The provided project ID is not valid.",
+		}}, nil
+	}
+
+	// Get the project to verify it exists and belongs to user
+	project, err := util.GetGeneric[Project](request.ProjectId, projectsColl, ctx)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return PostApiUsersMeProjectsProjectIdUploads404JSONResponse{NotFoundJSONResponse{
+				Error:   "Project not found",
+				Message: "The project with the specified ID does not exist.",
+			}}, nil
+		}
+		return PostApiUsersMeProjectsProjectIdUploads500JSONResponse{InternalServerErrorJSONResponse{
+			Error:   err.Error(),
+			Message: "Failed to retrieve project.",
+		}}, nil
+	}
+
+	// Verify the project belongs to the current user
+	if project.UserId != user.Id {
+		return PostApiUsersMeProjectsProjectIdUploads404JSONResponse{NotFoundJSONResponse{
+			Error:   "Project not found",
+			Message: "The project with the specified ID does not exist or does not belong to you.",
+		}}, nil
+	}
+
+	// The content decides the limit later, until then only the largest applies
+	if request.Params.UploadLength <= 0 {
+		return PostApiUsersMeProjectsProjectIdUploads400JSONResponse{BadRequestJSONResponse{
+			Error:   assets.ErrEmpty.Error(),
+			Message: "The upload length must be positive.",
+		}}, nil
+	}
+	if request.Params.UploadLength > assets.MaxSize() {
+		return PostApiUsersMeProjectsProjectIdUploads413JSONResponse{PayloadTooLargeJSONResponse{
+			Error:   "File too large",
+			Message: fmt.Sprintf("Files can have at most %d bytes.", assets.MaxSize()),
+		}}, nil
+	}
+
+	metadata := map[string]string{}
+	if request.Params.UploadMetadata != nil {
+		metadata, err = uploads.ParseMetadata(*request.Params.UploadMetadata)
+		if err != nil {
+			return PostApiUsersMeProjectsProjectIdUploads400JSONResponse{BadRequestJSONResponse{
+				Error:   err.Error(),
+				Message: "The Upload-Metadata header is not valid.",
+			}}, nil
+		}
+	}
+
+	// the declared length counts towards the quota until the upload is done
+	space := int64(uploads.Unlimited)
+	if quota := s.userTier(user).Limits.MaxStorageBytes; quota > 0 {
+		used, err := s.dedup.Usage(ctx, user.Id)
+		if err != nil {
+			return PostApiUsersMeProjectsProjectIdUploads500JSONResponse{InternalServerErrorJSONResponse{
+				Error:   err.Error(),
+				Message: "Failed to get the storage usage.",
+			}}, nil
+		}
+		space = max(quota-used, 0)
+	}
+
+	session, err := s.uploads.Create(ctx, user.Id, request.ProjectId, assetFileName(metadata["filename"]), request.Params.UploadLength, space)
+	if err != nil {
+		switch {
+		case errors.Is(err, uploads.ErrNoSpace):
+			return PostApiUsersMeProjectsProjectIdUploads403JSONResponse{QuotaExceededJSONResponse{
+				Error:   err.Error(),
+				Message: "The file does not fit into the storage of your tier next to your open uploads.",
+			}}, nil
+		case errors.Is(err, uploads.ErrTooMany):
+			return PostApiUsersMeProjectsProjectIdUploads429JSONResponse{TooManyRequestsJSONResponse{
+				Error:   err.Error(),
+				Message: fmt.Sprintf("You can have at most %d uploads open, finish or cancel one first.", uploads.MaxOpen),
+			}}, nil
+		}
+		return PostApiUsersMeProjectsProjectIdUploads500JSONResponse{InternalServerErrorJSONResponse{
+			Error:   err.Error(),
+			Message: "Failed to create the upload session.",
+		}}, nil
+	}
+
+	return PostApiUsersMeProjectsProjectIdUploads201Response{
+		Headers: PostApiUsersMeProjectsProjectIdUploads201ResponseHeaders{
+			Location:     uploadURL(request.ProjectId, session.Id.Hex()),
+			TusResumable: uploads.TusVersion,
+		},
+	}, nil
+}
+
+// userUpload returns the session if it belongs to the user and project
+func (s Server) userUpload(ctx context.Context, uid, projectID, uploadID string) (UserResponse, uploads.Session, error) {
+	user, err := util.GetGenericUID[UserResponse](uid, s.userStorage.Collection(), ctx)
+	if err != nil {
+		return UserResponse{}, uploads.Session{}, err
+	}
+
+	session, err := s.uploads.Get(ctx, uploadID)
+	if err != nil {
+		return UserResponse{}, uploads.Session{}, err
+	}
+
+	if session.UserId != user.Id || session.ProjectId != projectID {
+		return UserResponse{}, uploads.Session{}, uploads.ErrNotFound
+	}
+	return user, session, nil
+}
+
+// Get the offset of a resumable upload
+// (HEAD /api/users/me/projects/{projectId}/uploads/{uploadId})
+func (s Server) HeadApiUsersMeProjectsProjectIdUploadsUploadId(ctx context.Context, request HeadApiUsersMeProjectsProjectIdUploadsUploadIdRequestObject) (HeadApiUsersMeProjectsProjectIdUploadsUploadIdResponseObject, error) {
+	uid := ctx.Value("uid").(string)
+
+	_, session, err := s.userUpload(ctx, uid, request.ProjectId, request.UploadId)
+	if err != nil {
+		if err == mongo.ErrNoDocuments || errors.Is(err, uploads.ErrNotFound) {
+			return HeadApiUsersMeProjectsProjectIdUploadsUploadId404Response{}, nil
+		}
+		return HeadApiUsersMeProjectsProjectIdUploadsUploadId500Response{}, nil
+	}
+
+	headers := HeadApiUsersMeProjectsProjectIdUploadsUploadId200ResponseHeaders{
+		CacheControl: "no-store",
+		TusResumable: uploads.TusVersion,
+		UploadLength: session.Length,
+		UploadOffset: session.Offset,
+	}
+	if session.Completed {
+		headers.UploadAssetId = session.AssetId
+	}
+
+	return HeadApiUsersMeProjectsProjectIdUploadsUploadId200Response{Headers: headers}, nil
+}
+
+// Append a chunk to a resumable upload
+// (PATCH /api/users/me/projects/{projectId}/uploads/{uploadId})
+func (s Server) PatchApiUsersMeProjectsProjectIdUploadsUploadId(ctx context.Context, request PatchApiUsersMeProjectsProjectIdUploadsUploadIdRequestObject) (PatchApiUsersMeProjectsProjectIdUploadsUploadIdResponseObject, error) {
+	uid := ctx.Value("uid").(string)
+
+	if request.Params.TusResumable != uploads.TusVersion {
+		return PatchApiUsersMeProjectsProjectIdUploadsUploadId412JSONResponse{PreconditionFailedJSONResponse{
+			Error:   "Unsupported tus version",
+			Message: fmt.Sprintf("Only version %s of the tus protocol is supported.", uploads.TusVersion),
+		}}, nil
+	}
+
+	user, session, err := s.userUpload(ctx, uid, request.ProjectId, request.UploadId)
+	if err != nil {
+		if err == mongo.ErrNoDocuments || errors.Is(err, uploads.ErrNotFound) {
+			return PatchApiUsersMeProjectsProjectIdUploadsUploadId404JSONResponse{NotFoundJSONResponse{
+				Error:   "Upload not found",
+				Message: "The upload does not exist or has expired.",
+			}}, nil
+		}
+		return PatchApiUsersMeProjectsProjectIdUploadsUploadId500JSONResponse{InternalServerErrorJSONResponse{
+			Error:   err.Error(),
+			Message: "Failed to retrieve the upload.",
+		}}, nil
+	}
+
+	if request.Params.UploadOffset != session.Offset {
+		return PatchApiUsersMeProjectsProjectIdUploadsUploadId409JSONResponse{ConflictJSONResponse{
+			Error:   uploads.ErrOffsetMismatch.Error(),
+			Message: fmt.Sprintf("The upload continues at offset %d.", session.Offset),
+		}}, nil
+	}
+
+	if session.Completed {
+		return PatchApiUsersMeProjectsProjectIdUploadsUploadId204Response{
+			Headers: PatchApiUsersMeProjectsProjectIdUploadsUploadId204ResponseHeaders{
+				TusResumable:  uploads.TusVersion,
+				UploadOffset:  session.Offset,
+				UploadAssetId: session.AssetId,
+			},
+		}, nil
+	}
+
+	// An empty PATCH at the end retries a failed completion
+	if !session.Received() {
+		session, err = s.appendChunk(ctx, session, request.Body)
+		if err != nil {
+			switch {
+			case errors.Is(err, uploads.ErrTooLarge):
+				return PatchApiUsersMeProjectsProjectIdUploadsUploadId413JSONResponse{PayloadTooLargeJSONResponse{
+					Error:   err.Error(),
+					Message: fmt.Sprintf("The upload has %d bytes left.", session.Length-session.Offset),
+				}}, nil
+			case errors.Is(err, uploads.ErrOffsetMismatch):
+				return PatchApiUsersMeProjectsProjectIdUploadsUploadId409JSONResponse{ConflictJSONResponse{
+					Error:   err.Error(),
+					Message: "Another request appended to the upload, check its offset.",
+				}}, nil
+			case errors.Is(err, io.ErrUnexpectedEOF):
+				return PatchApiUsersMeProjectsProjectIdUploadsUploadId400JSONResponse{BadRequestJSONResponse{
+					Error:   err.Error(),
+					Message: "The chunk was cut off, resume from the offset of the upload.",
+				}}, nil
+			}
+			return PatchApiUsersMeProjectsProjectIdUploadsUploadId500JSONResponse{InternalServerErrorJSONResponse{
+				Error:   err.Error(),
+				Message: "Failed to store the chunk.",
+			}}, nil
+		}
+	}
+
+	if session.Received() {
+		session, err = s.completeUpload(ctx, user, session)
+		if err != nil {
+			switch {
+			case errors.Is(err, assets.ErrTooLarge):
+				return PatchApiUsersMeProjectsProjectIdUploadsUploadId413JSONResponse{PayloadTooLargeJSONResponse{
+					Error:   "File too large",
+					Message: "The file exceeds the size limit of its category.",
+				}}, nil
+			case errors.Is(err, credits.ErrInsufficientCredits):
+				return PatchApiUsersMeProjectsProjectIdUploadsUploadId402JSONResponse{PaymentRequiredJSONResponse{
+					Error:   "Insufficient credits",
+					Message: "You do not have enough credits to upload an asset.",
+				}}, nil
+			case errors.Is(err, dedup.ErrQuotaExceeded):
+				return PatchApiUsersMeProjectsProjectIdUploadsUploadId403JSONResponse{QuotaExceededJSONResponse{
+					Error:   err.Error(),
+					Message: "The file does not fit into the storage of your tier.",
+				}}, nil
+			case errors.Is(err, media.ErrInvalid):
+				return PatchApiUsersMeProjectsProjectIdUploadsUploadId400JSONResponse{BadRequestJSONResponse{
+					Error:   err.Error(),
+					Message: "The file is damaged or not supported, the upload was discarded.",
+				}}, nil
+			case errors.Is(err, uploads.ErrBusy):
+				return PatchApiUsersMeProjectsProjectIdUploadsUploadId409JSONResponse{ConflictJSONResponse{
+					Error:   err.Error(),
+					Message: "The upload is being turned into an asset.",
+				}}, nil
+			case errors.Is(err, mongo.ErrNoDocuments):
+				return PatchApiUsersMeProjectsProjectIdUploadsUploadId404JSONResponse{NotFoundJSONResponse{
+					Error:   "Project not found",
+					Message: "The project of the upload no longer exists.",
+				}}, nil
+			}
+			return PatchApiUsersMeProjectsProjectIdUploadsUploadId500JSONResponse{InternalServerErrorJSONResponse{
+				Error:   err.Error(),
+				Message: "Failed to turn the upload into an asset.",
+			}}, nil
+		}
+	}
+
+	headers := PatchApiUsersMeProjectsProjectIdUploadsUploadId204ResponseHeaders{
+		TusResumable: uploads.TusVersion,
+		UploadOffset: session.Offset,
+	}
+	if session.Completed {
+		headers.UploadAssetId = session.AssetId
+	}
+
+	return PatchApiUsersMeProjectsProjectIdUploadsUploadId204Response{Headers: headers}, nil
+}
+
+// appendChunk stores the body as the next chunk of the upload. The bytes
+// of a dropped connection are stored too, along with the read error.
+func (s Server) appendChunk(ctx context.Context, session uploads.Session, body io.Reader) (uploads.Session, error) {
+	part, readErr := uploads.ReceivePart(body, session.Length-session.Offset)
+	if part == nil {
+		return session, readErr
+	}
+	defer part.Close()
+
+	if part.Size == 0 {
+		return session, readErr
+	}
+
+	// the client may be gone already, what arrived is stored regardless
+	ctx = context.WithoutCancel(ctx)
+
+	chunk := uploads.Chunk{
+		Key:    uploads.ChunkKey(session.Id, session.Offset),
+		Offset: session.Offset,
+		Size:   part.Size,
+	}
+
+	err := s.blobs.Put(ctx, chunk.Key, part.File, part.Size, "application/offset+octet-stream")
+	if err != nil {
+		return session, err
+	}
+
+	updated, err := s.uploads.AddChunk(ctx, session.Id, chunk)
+	if err != nil {
+		_ = s.blobs.Delete(ctx, chunk.Key)
+		return session, err
+	}
+
+	return updated, readErr
+}
+
+// completeUpload validates a fully received upload and turns it into an
+// asset of its project. A file over the limit of its category is dropped
+// together with the session.
+func (s Server) completeUpload(ctx context.Context, user UserResponse, session uploads.Session) (uploads.Session, error) {
+	projectsColl := s.userStorage.db.Collection("projects")
+
+	// every byte arrived, a client leaving now does not stop the asset
+	ctx = context.WithoutCancel(ctx)
+
+	assetID := primitive.NewObjectID().Hex()
+	session, err := s.uploads.Claim(ctx, session.Id, assetID)
+	if err != nil {
+		return session, err
+	}
+
+	project, err := util.GetGeneric[Project](session.ProjectId, projectsColl, ctx)
+	if err != nil {
+		_ = s.uploads.Unclaim(ctx, session.Id, assetID)
+		return session, err
+	}
+
+	// the content decides about type and limit, as for a direct upload,
+	// the same pass hashes it
+	head := make([]byte, assets.SniffLen)
+	hash := sha256.New()
+	content := storage.Concat(ctx, s.blobs, session.ChunkKeys())
+	n, err := io.ReadFull(io.TeeReader(content, hash), head)
+	if err == nil || errors.Is(err, io.ErrUnexpectedEOF) {
+		_, err = io.Copy(hash, content)
+	}
+	content.Close()
+	if err != nil {
+		_ = s.uploads.Unclaim(ctx, session.Id, assetID)
+		return session, err
+	}
+
+	contentHash := hex.EncodeToString(hash.Sum(nil))
+	mimeType := assets.Sniff(head[:n])
+	category := assets.CategoryOf(mimeType)
+	if session.Length > assets.Limits[category] {
+		s.discardUpload(ctx, session)
+		return session, assets.ErrTooLarge
+	}
+
+	// Hold back the credits until the asset is stored
+	reservation, err := s.credits.Reserve(ctx, user.Id, credits.AssetUpload,
+		fmt.Sprintf("Uploaded %q to project %q", session.FileName, project.Name),
+		map[string]interface{}{"projectId": session.ProjectId, "fileName": session.FileName, "size": session.Length})
+	if err != nil {
+		_ = s.uploads.Unclaim(ctx, session.Id, assetID)
+		return session, err
+	}
+
+	content = storage.Concat(ctx, s.blobs, session.ChunkKeys())
+	stored, err := s.storeContent(ctx, user, contentHash, session.Length, mimeType, content)
+	content.Close()
+	if err != nil {
+		_ = s.credits.Release(ctx, reservation)
+		// a damaged file stays damaged, retrying would not help
+		if errors.Is(err, media.ErrInvalid) {
+			s.discardUpload(ctx, session)
+			return session, err
+		}
+		_ = s.uploads.Unclaim(ctx, session.Id, assetID)
+		return session, err
+	}
+
+	asset := storedAsset{
+		Asset: withSanitization(withMedia(Asset{
+			Id:         assetID,
+			Name:       session.FileName,
+			Type:       mimeType,
+			Size:       int(stored.Size),
+			Url:        assetDownloadPath(session.ProjectId, assetID),
+			UploadedAt: time.Now(),
+		}, session.ProjectId, stored.Info), stored.Sanitized),
+		Key:  stored.Key,
+		Hash: stored.Hash,
+	}
+
+	// List the asset in its category
+	projectObjectID, _ := primitive.ObjectIDFromHex(session.ProjectId)
+	updateData := bson.M{
+		"$push": bson.M{"assets." + string(category): asset},
+		"$set":  bson.M{"metadata.updatedAt": time.Now()},
+	}
+
+	_, err = projectsColl.UpdateOne(ctx, bson.M{"_id": projectObjectID}, updateData)
+	if err != nil {
+		s.releaseContent(ctx, user.Id, asset)
+		_ = s.credits.Release(ctx, reservation)
+		_ = s.uploads.Unclaim(ctx, session.Id, assetID)
+		return session, err
+	}
+
+	_, err = s.credits.Commit(ctx, reservation)
+	if err != nil {
+		log.Printf("error committing credits of asset %s: %v\n", assetID, err)
+	}
+
+	// the asset exists, left over chunks are only wasted space
+	err = s.uploads.Finish(ctx, session.Id)
+	if err != nil {
+		log.Printf("error finishing upload %s: %v\n", session.Id.Hex(), err)
+	}
+	s.deleteChunks(ctx, session)
+
+	session.Completed = true
+	return session, nil
+}
+
+// discardUpload removes the session and the chunks received so far
+func (s Server) discardUpload(ctx context.Context, session uploads.Session) {
+	err := s.uploads.Delete(ctx, session.Id)
+	if err != nil {
+		log.Printf("error deleting upload %s: %v\n", session.Id.Hex(), err)
+	}
+	s.deleteChunks(ctx, session)
+}
+
+func (s Server) deleteChunks(ctx context.Context, session uploads.Session) {
+	for _, key := range session.ChunkKeys() {
+		err := s.blobs.Delete(ctx, key)
+		if err != nil {
+			log.Printf("error deleting blob %s: %v\n", key, err)
+		}
+	}
+}
+
+// Cancel a resumable upload
+// (DELETE /api/users/me/projects/{projectId}/uploads/{uploadId})
+func (s Server) DeleteApiUsersMeProjectsProjectIdUploadsUploadId(ctx context.Context, request DeleteApiUsersMeProjectsProjectIdUploadsUploadIdRequestObject) (DeleteApiUsersMeProjectsProjectIdUploadsUploadIdResponseObject, error) {
+	uid := ctx.Value("uid").(string)
+
+	if request.Params.TusResumable != uploads.TusVersion {
+		return DeleteApiUsersMeProjectsProjectIdUploadsUploadId412JSONResponse{PreconditionFailedJSONResponse{
+			Error:   "Unsupported tus version",
+			Message: fmt.Sprintf("Only version %s of the tus protocol is supported.", uploads.TusVersion),
+		}}, nil
+	}
+
+	_, session, err := s.userUpload(ctx, uid, request.ProjectId, request.UploadId)
+	if err != nil {
+		if err == mongo.ErrNoDocuments || errors.Is(err, uploads.ErrNotFound) {
+			return DeleteApiUsersMeProjectsProjectIdUploadsUploadId404JSONResponse{NotFoundJSONResponse{
+				Error:   "Upload not found",
+				Message: "The upload does not exist or has expired.",
+			}}, nil
+		}
+		return DeleteApiUsersMeProjectsProjectIdUploadsUploadId500JSONResponse{InternalServerErrorJSONResponse{
+			Error:   err.Error(),
+			Message: "Failed to retrieve the upload.",
+		}}, nil
+	}
+
+	// the chunks of a claimed upload are being copied into the asset
+	if session.Busy(time.Now()) {
+		return DeleteApiUsersMeProjectsProjectIdUploadsUploadId409JSONResponse{ConflictJSONResponse{
+			Error:   uploads.ErrBusy.Error(),
+			Message: "The upload is being turned into an asset.",
+		}}, nil
+	}
+
+	s.discardUpload(ctx, session)
+
+	return DeleteApiUsersMeProjectsProjectIdUploadsUploadId204Response{
+		Headers: DeleteApiUsersMeProjectsProjectIdUploadsUploadId204ResponseHeaders{
+			TusResumable: uploads.TusVersion,
+		},
+	}, nil
+}
@@ -0,0 +1,132 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	// fakeWordsPerSlide mirrors the slide constraint of the system prompt
+	fakeWordsPerSlide = 4
+	// fakeTokenSize is the length of the chunks sent by Stream
+	fakeTokenSize = 16
+)
+
+// FakeProvider is an offline provider for development and tests. It
+// answers with Content when set, otherwise it turns the last user
+// message into one slide per few words, cycling through the animations
+// and backgrounds allowed by the schema. The output only depends on the
+// input, so the same prompt always yields the same compositions.
+type FakeProvider struct {
+	Content string
+}
+
+func (f FakeProvider) Complete(ctx context.Context, messages []Message, schema map[string]interface{}) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	if f.Content != "" {
+		return f.Content, nil
+	}
+
+	prompt := ""
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == RoleUser {
+			prompt = messages[i].Content
+			break
+		}
+	}
+
+	animations := schemaEnum(schema, "compositions", "animationName")
+	backgrounds := schemaEnum(schema, "compositions", "background", "name")
+
+	content, err := json.Marshal(FakeResponse(prompt, animations, backgrounds))
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
+
+// Stream sends the answer of Complete in chunks of fakeTokenSize characters
+func (f FakeProvider) Stream(ctx context.Context, messages []Message, schema map[string]interface{}, onToken func(string) error) (string, error) {
+	content, err := f.Complete(ctx, messages, schema)
+	if err != nil {
+		return "", err
+	}
+
+	// chunk by runes, a token never splits a character
+	runes := []rune(content)
+	for start := 0; start < len(runes); start += fakeTokenSize {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		if err := onToken(string(runes[start:min(start+fakeTokenSize, len(runes))])); err != nil {
+			return "", err
+		}
+	}
+
+	return content, nil
+}
+
+// FakeResponse is the canned answer of FakeProvider for a prompt
+func FakeResponse(prompt string, animations, backgrounds []string) Response {
+	words := strings.Fields(prompt)
+	if len(words) == 0 {
+		words = []string{"Hello", "World"}
+	}
+	// without a schema fall back to entries of the built in catalog
+	if len(animations) == 0 {
+		animations = []string{"fadeInTransition"}
+	}
+	if len(backgrounds) == 0 {
+		backgrounds = []string{"plainBackground"}
+	}
+
+	response := Response{
+		Compositions: []GeneratedComposition{},
+		Comment:      fmt.Sprintf("Created %d slides for: %s", (len(words)+fakeWordsPerSlide-1)/fakeWordsPerSlide, strings.Join(words, " ")),
+	}
+
+	for i := 0; i*fakeWordsPerSlide < len(words); i++ {
+		text := strings.Join(words[i*fakeWordsPerSlide:min((i+1)*fakeWordsPerSlide, len(words))], " ")
+
+		comp := GeneratedComposition{
+			Id:            fmt.Sprintf("slide-%d", i+1),
+			Duration:      45,
+			AnimationName: animations[i%len(animations)],
+			AnimationSettings: map[string]interface{}{
+				"typo_text":      text,
+				"typo_textColor": "#ffffff",
+			},
+			Text: text,
+		}
+		comp.Background.Name = backgrounds[i%len(backgrounds)]
+		comp.Background.Settings = map[string]interface{}{
+			"type":            comp.Background.Name,
+			"backgroundColor": "#1e1e1e",
+		}
+
+		response.Compositions = append(response.Compositions, comp)
+	}
+
+	return response
+}
+
+// schemaEnum follows the properties (and array items) of a response
+// schema to the allowed values of a string
+func schemaEnum(schema map[string]interface{}, path ...string) []string {
+	current := schema
+	for _, name := range path {
+		if items, ok := current["items"].(map[string]interface{}); ok {
+			current = items
+		}
+		properties, _ := current["properties"].(map[string]interface{})
+		current, _ = properties[name].(map[string]interface{})
+	}
+
+	values, _ := current["enum"].([]string)
+	return values
+}
@@ -0,0 +1,173 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/Pieli/server/internal/catalog"
+)
+
+const (
+	RoleSystem    = "system"
+	RoleDeveloper = "developer"
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+)
+
+var ErrInvalidResponse = errors.New("invalid model response")
+
+type Message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// Composition is the summary of a stored composition given to the model
+type Composition struct {
+	Id       string                 `json:"id"`
+	Name     string                 `json:"component"`
+	Duration float32                `json:"duration"`
+	Props    map[string]interface{} `json:"props"`
+}
+
+// GeneratedComposition is a single slide as returned by the model
+type GeneratedComposition struct {
+	Id                string                 `json:"id"`
+	Duration          float32                `json:"duration"`
+	AnimationName     string                 `json:"animationName"`
+	AnimationSettings map[string]interface{} `json:"animationSettings"`
+	Text              string                 `json:"text"`
+	Background        struct {
+		Name     string                 `json:"name"`
+		Settings map[string]interface{} `json:"settings"`
+	} `json:"background"`
+}
+
+// Response is the structured output of the model,
+// matches Response in frontend/src/api/llm-types.ts
+type Response struct {
+	Compositions []GeneratedComposition `json:"compositions"`
+	Comment      string                 `json:"comment"`
+}
+
+type GenerateRequest struct {
+	Prompt       string
+	History      []Message
+	Compositions []Composition
+	Colors       []string
+}
+
+// Generator turns a prompt and the project context into compositions
+type Generator struct {
+	provider LLMProvider
+	catalog  *catalog.Catalog
+}
+
+func NewGenerator(provider LLMProvider, c *catalog.Catalog) *Generator {
+	return &Generator{
+		provider: provider,
+		catalog:  c,
+	}
+}
+
+// Messages builds the conversation sent to the model
+func (g *Generator) Messages(req GenerateRequest) []Message {
+	messages := []Message{{Role: RoleSystem, Content: SystemPrompt(g.catalog)}}
+
+	if msg := compositionContext(req.Compositions); msg != nil {
+		messages = append(messages, *msg)
+	}
+	if msg := colorPaletteContext(req.Colors); msg != nil {
+		messages = append(messages, *msg)
+	}
+
+	messages = append(messages, req.History...)
+	messages = append(messages, Message{Role: RoleUser, Content: req.Prompt})
+
+	return messages
+}
+
+func (g *Generator) Generate(ctx context.Context, req GenerateRequest) (Response, error) {
+	content, err := g.provider.Complete(ctx, g.Messages(req), g.responseSchema())
+	if err != nil {
+		return Response{}, err
+	}
+
+	return g.ParseResponse(content)
+}
+
+// ParseResponse decodes and validates the raw model output
+func (g *Generator) ParseResponse(content string) (Response, error) {
+	var response Response
+	if err := json.Unmarshal([]byte(content), &response); err != nil {
+		return Response{}, fmt.Errorf("%w: %v", ErrInvalidResponse, err)
+	}
+
+	if err := response.Validate(g.catalog); err != nil {
+		return Response{}, err
+	}
+	return response, nil
+}
+
+// Validate checks that every composition has a usable duration and
+// references an animation and a background of the catalog with fitting
+// settings
+func (r Response) Validate(c *catalog.Catalog) error {
+	for i, comp := range r.Compositions {
+		if comp.Id == "" {
+			return fmt.Errorf("%w: composition %d has no id", ErrInvalidResponse, i)
+		}
+		if comp.Duration <= 0 {
+			return fmt.Errorf("%w: composition %q has no duration", ErrInvalidResponse, comp.Id)
+		}
+
+		if fields := c.Validate(catalog.Animation, comp.AnimationName, comp.AnimationSettings); len(fields) > 0 {
+			return fmt.Errorf("%w: composition %q: animation %s: %s", ErrInvalidResponse, comp.Id, fields[0].Field, fields[0].Message)
+		}
+		if fields := c.Validate(catalog.Background, comp.Background.Name, comp.Background.Settings); len(fields) > 0 {
+			return fmt.Errorf("%w: composition %q: background %s: %s", ErrInvalidResponse, comp.Id, fields[0].Field, fields[0].Message)
+		}
+	}
+
+	return nil
+}
+
+// responseSchema is the JSON schema of Response for structured outputs
+func (g *Generator) responseSchema() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"compositions": map[string]interface{}{
+				"type": "array",
+				"items": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"id":       map[string]interface{}{"type": "string"},
+						"duration": map[string]interface{}{"type": "number"},
+						"animationName": map[string]interface{}{
+							"type": "string",
+							"enum": g.catalog.Names(catalog.Animation),
+						},
+						"animationSettings": map[string]interface{}{"type": "object"},
+						"text":              map[string]interface{}{"type": "string"},
+						"background": map[string]interface{}{
+							"type": "object",
+							"properties": map[string]interface{}{
+								"name": map[string]interface{}{
+									"type": "string",
+									"enum": g.catalog.Names(catalog.Background),
+								},
+								"settings": map[string]interface{}{"type": "object"},
+							},
+							"required": []string{"name", "settings"},
+						},
+					},
+					"required": []string{"id", "duration", "animationName", "animationSettings", "text", "background"},
+				},
+			},
+			"comment": map[string]interface{}{"type": "string"},
+		},
+		"required": []string{"compositions", "comment"},
+	}
+}
@@ -0,0 +1,70 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Pieli/server/internal/catalog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testGenerator(t *testing.T, provider LLMProvider) *Generator {
+	c, err := catalog.Load("")
+	require.NoError(t, err)
+	return NewGenerator(provider, c)
+}
+
+func TestGenerateWithFakeProvider(t *testing.T) {
+	generator := testGenerator(t, FakeProvider{})
+
+	response, err := generator.Generate(context.Background(), GenerateRequest{
+		Prompt: "Tired of slow builds? Try our cache today",
+		Colors: []string{"#ff0000"},
+	})
+	require.NoError(t, err)
+
+	require.Len(t, response.Compositions, 2)
+	assert.Equal(t, "Tired of slow builds?", response.Compositions[0].Text)
+	assert.Equal(t, "Try our cache today", response.Compositions[1].AnimationSettings["typo_text"])
+	assert.NotEqual(t, response.Compositions[0].AnimationName, response.Compositions[1].AnimationName)
+
+	again, err := generator.Generate(context.Background(), GenerateRequest{Prompt: "Tired of slow builds? Try our cache today"})
+	require.NoError(t, err)
+	assert.Equal(t, response, again)
+}
+
+func TestGenerateRejectsUnknownAnimation(t *testing.T) {
+	generator := testGenerator(t, FakeProvider{
+		Content: `{"compositions":[{"id":"a","duration":45,"animationName":"explode","animationSettings":{},"text":"","background":{"name":"plainBackground","settings":{}}}],"comment":""}`,
+	})
+
+	_, err := generator.Generate(context.Background(), GenerateRequest{Prompt: "hi"})
+	assert.True(t, errors.Is(err, ErrInvalidResponse))
+}
+
+func TestMessagesIncludeContext(t *testing.T) {
+	messages := testGenerator(t, FakeProvider{}).Messages(GenerateRequest{
+		Prompt:       "make it shorter",
+		History:      []Message{{Role: RoleUser, Content: "first"}},
+		Compositions: []Composition{{Id: "a", Name: "fadeInTransition", Duration: 45}},
+		Colors:       []string{"#000000"},
+	})
+
+	require.Len(t, messages, 5)
+	assert.Equal(t, RoleSystem, messages[0].Role)
+	assert.Equal(t, RoleDeveloper, messages[1].Role)
+	assert.Equal(t, RoleDeveloper, messages[2].Role)
+	assert.Equal(t, Message{Role: RoleUser, Content: "make it shorter"}, messages[4])
+}
+
+func TestGenerateRejectsInvalidSettings(t *testing.T) {
+	generator := testGenerator(t, FakeProvider{
+		Content: `{"compositions":[{"id":"a","duration":45,"animationName":"slideInTransition","animationSettings":{"slideDuration":0},"text":"","background":{"name":"plainBackground","settings":{}}}],"comment":""}`,
+	})
+
+	_, err := generator.Generate(context.Background(), GenerateRequest{Prompt: "hi"})
+	assert.ErrorIs(t, err, ErrInvalidResponse)
+	assert.ErrorContains(t, err, "props.slideDuration")
+}
@@ -0,0 +1,210 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultOpenAIBaseURL = "https://api.openai.com/v1"
+	DefaultOpenAIModel   = "gpt-4.1-nano-2025-04-14"
+)
+
+// OpenAIProvider talks to the chat completions API of OpenAI or any
+// compatible server (vLLM, Ollama, LiteLLM, ...)
+type OpenAIProvider struct {
+	baseURL string
+	apiKey  string
+	model   string
+	http    *http.Client
+}
+
+// NewOpenAIProvider falls back to the OpenAI endpoint and default model
+// for empty values
+func NewOpenAIProvider(baseURL, apiKey, model string) *OpenAIProvider {
+	if baseURL == "" {
+		baseURL = DefaultOpenAIBaseURL
+	}
+	if model == "" {
+		model = DefaultOpenAIModel
+	}
+
+	return &OpenAIProvider{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		apiKey:  apiKey,
+		model:   model,
+		http:    &http.Client{Timeout: 2 * time.Minute},
+	}
+}
+
+type chatCompletionRequest struct {
+	Model          string                 `json:"model"`
+	Messages       []Message              `json:"messages"`
+	Temperature    float32                `json:"temperature"`
+	MaxTokens      int                    `json:"max_tokens"`
+	ResponseFormat map[string]interface{} `json:"response_format"`
+	Stream         bool                   `json:"stream,omitempty"`
+}
+
+type chatCompletionResponse struct {
+	Choices []struct {
+		Message struct {
+			Content string `json:"content"`
+			Refusal string `json:"refusal"`
+		} `json:"message"`
+	} `json:"choices"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+type chatCompletionChunk struct {
+	Choices []struct {
+		Delta struct {
+			Content string `json:"content"`
+			Refusal string `json:"refusal"`
+		} `json:"delta"`
+	} `json:"choices"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// Complete sends the conversation and returns the content of the first choice
+func (c *OpenAIProvider) Complete(ctx context.Context, messages []Message, schema map[string]interface{}) (string, error) {
+	resp, err := c.post(ctx, messages, schema, false)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	var completion chatCompletionResponse
+	if err := json.Unmarshal(raw, &completion); err != nil {
+		return "", fmt.Errorf("openai: unexpected response: %w", err)
+	}
+
+	if len(completion.Choices) == 0 {
+		return "", errors.New("openai: no choices returned")
+	}
+
+	message := completion.Choices[0].Message
+	if message.Refusal != "" {
+		return "", fmt.Errorf("openai: refused: %s", message.Refusal)
+	}
+
+	return message.Content, nil
+}
+
+// Stream reads the server-sent chunks of the first choice
+func (c *OpenAIProvider) Stream(ctx context.Context, messages []Message, schema map[string]interface{}, onToken func(string) error) (string, error) {
+	resp, err := c.post(ctx, messages, schema, true)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var content strings.Builder
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			return content.String(), nil
+		}
+
+		var chunk chatCompletionChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return "", fmt.Errorf("openai: unexpected chunk: %w", err)
+		}
+		if chunk.Error != nil {
+			return "", fmt.Errorf("openai: %s", chunk.Error.Message)
+		}
+		if len(chunk.Choices) == 0 {
+			continue
+		}
+
+		delta := chunk.Choices[0].Delta
+		if delta.Refusal != "" {
+			return "", fmt.Errorf("openai: refused: %s", delta.Refusal)
+		}
+		if delta.Content == "" {
+			continue
+		}
+
+		content.WriteString(delta.Content)
+		if err := onToken(delta.Content); err != nil {
+			return "", err
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", errors.New("openai: stream ended unexpectedly")
+}
+
+// post sends the completion request, error responses are turned into errors
+func (c *OpenAIProvider) post(ctx context.Context, messages []Message, schema map[string]interface{}, stream bool) (*http.Response, error) {
+	body, err := json.Marshal(chatCompletionRequest{
+		Model:       c.model,
+		Messages:    messages,
+		Temperature: 0.7,
+		MaxTokens:   10000,
+		ResponseFormat: map[string]interface{}{
+			"type": "json_schema",
+			"json_schema": map[string]interface{}{
+				"name":   "response",
+				"schema": schema,
+			},
+		},
+		Stream: stream,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusOK {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	var completion chatCompletionResponse
+	raw, _ := io.ReadAll(resp.Body)
+	if json.Unmarshal(raw, &completion) == nil && completion.Error != nil {
+		return nil, fmt.Errorf("openai: %s", completion.Error.Message)
+	}
+	return nil, fmt.Errorf("openai: unexpected status %d", resp.StatusCode)
+}
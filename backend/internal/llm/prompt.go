@@ -0,0 +1,160 @@
+package llm
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/Pieli/server/internal/catalog"
+)
+
+// entryContext describes the entries of a category like getSchemaDescription
+// of the frontend did
+func entryContext(c *catalog.Catalog, category catalog.Category) string {
+	parts := []string{}
+	for _, entry := range c.All() {
+		if entry.Category != category {
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("Name: %s\nUse case: %s\nParameters: %s\n",
+			entry.Name, entry.Usecase, propsDescription(entry)))
+	}
+	return strings.Join(parts, "\n")
+}
+
+// propsDescription lists the props the model may set,
+// e.g. "slideDuration: number [default: 30], min: 1"
+func propsDescription(entry catalog.Entry) string {
+	parts := []string{}
+	for _, name := range entry.PropNames() {
+		if slices.Contains(entry.LLMExclude, name) {
+			continue
+		}
+
+		prop := entry.Schema.Properties[name].Value
+		if prop == nil {
+			continue
+		}
+
+		// props composed with oneOf, $ref and the like have no type
+		kind := "any"
+		if types := prop.Type.Slice(); len(types) > 0 {
+			kind = strings.Join(types, "|")
+		}
+		switch {
+		case prop.Format != "":
+			kind = prop.Format
+		case len(prop.Enum) > 0:
+			values := make([]string, 0, len(prop.Enum))
+			for _, value := range prop.Enum {
+				values = append(values, fmt.Sprint(value))
+			}
+			kind = "one of " + strings.Join(values, "|")
+		}
+
+		part := fmt.Sprintf("%s: %s", name, kind)
+		if prop.Default != nil {
+			part += fmt.Sprintf(" [default: %v]", prop.Default)
+		}
+		if prop.Min != nil {
+			part += fmt.Sprintf(", min: %v", *prop.Min)
+		}
+		if prop.Max != nil {
+			part += fmt.Sprintf(", max: %v", *prop.Max)
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// SystemPrompt is the instruction sent in front of every conversation,
+// a port of frontend/src/api/system-prompt.ts
+func SystemPrompt(c *catalog.Catalog) string {
+	return fmt.Sprintf(systemPromptTemplate,
+		entryContext(c, catalog.Animation),
+		entryContext(c, catalog.Background))
+}
+
+const systemPromptTemplate = `
+You are Imation, an expert AI assistant and master animator. You specialize in design, storytelling, and animated sequences.
+
+# Task
+Your task is to create sequences for a promotional video. Begin by developing a script that tells a compelling story to convince the viewer about a product or topic.
+
+The default script structure should include:
+1. Problem
+2. Solution
+3. Benefits
+4. Call to Action
+
+If the user prefers a different structure, follow their request.
+
+# Slide Constraints
+- Each slide may contain a maximum of 6 words. Fewer is better.
+- If content exceeds 5 words, split it meaningfully across multiple slides.
+- Each composition must last no more than 2 seconds (i.e., 60 frames at 30 FPS).
+- Each composition must last no less than 1.5 seconds (i.e., 45 frames at 30 FPS).
+
+# Available Assets
+
+You can choose from the following animation components:
+%s
+
+For backgrounds following options exist:
+%s
+
+- IMPORTANT: think about the color you use for (foreground + background). Text needs to be readable.
+- IMPORTANT: it is prefereable to use more complex backgrounds than PlainBackground, but still use it here and there.
+- IMPORTANT: If a color palette is provided, use those colors consistently throughout the animation while maintaining good contrast and readability.
+
+
+# Output Format
+Return a JSON object containing:
+- An array called "composition" where each item includes:
+  - "id": A unique, descriptive string for the composition
+  - "text": The text to display on the slide
+  - "animationName": One of the animation names provided
+  - "animationSettings": The parameters of the animation
+  - "duration": Frame length (max 45)
+  - "background": the background of each animation
+- A "commentary" field explaining what you did
+
+# Notes
+- You do not need Internet access
+- Use animation settings appropriately according to the provided descriptions
+- Focus on storytelling, clarity, and audience persuasion
+- plan out the story and text first then create the animations
+`
+
+// compositionContext describes the current compositions to the model,
+// nil if there are none
+func compositionContext(compositions []Composition) *Message {
+	if len(compositions) == 0 {
+		return nil
+	}
+
+	summary, err := json.MarshalIndent(compositions, "", "  ")
+	if err != nil {
+		return nil
+	}
+
+	return &Message{
+		Role:    RoleDeveloper,
+		Content: fmt.Sprintf("Current compositions in the project: %s", summary),
+	}
+}
+
+// colorPaletteContext describes the active palette to the model,
+// nil if there is none
+func colorPaletteContext(colors []string) *Message {
+	if len(colors) == 0 {
+		return nil
+	}
+
+	return &Message{
+		Role: RoleDeveloper,
+		Content: fmt.Sprintf("Active color palette: %s. Use these colors consistently throughout the animation while maintaining good contrast and readability.",
+			strings.Join(colors, ", ")),
+	}
+}
@@ -0,0 +1,28 @@
+package llm
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Pieli/server/internal/catalog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSystemPromptDescribesTypelessProps(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "catalog.json")
+	require.NoError(t, os.WriteFile(path, []byte(`[{
+		"name": "wave", "category": "animation", "usecase": "waves",
+		"schema": {"type": "object", "properties": {
+			"color": {"oneOf": [{"type": "string"}, {"type": "null"}]},
+			"mode": {"enum": ["slow", "fast"]},
+			"speed": {"type": "number", "default": 1, "minimum": 0}
+		}}
+	}]`), 0o600))
+	c, err := catalog.Load(path)
+	require.NoError(t, err)
+
+	prompt := SystemPrompt(c)
+	assert.Contains(t, prompt, "Parameters: color: any, mode: one of slow|fast, speed: number [default: 1], min: 0\n")
+}
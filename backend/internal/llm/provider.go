@@ -0,0 +1,13 @@
+package llm
+
+import "context"
+
+// LLMProvider abstracts the language model behind the generator
+type LLMProvider interface {
+	// Complete answers the conversation with JSON matching the schema
+	Complete(ctx context.Context, messages []Message, schema map[string]interface{}) (string, error)
+
+	// Stream works like Complete but hands every chunk of the answer to
+	// onToken as it arrives, an error of onToken aborts the request
+	Stream(ctx context.Context, messages []Message, schema map[string]interface{}, onToken func(string) error) (string, error)
+}
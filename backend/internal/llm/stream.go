@@ -0,0 +1,108 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"regexp"
+)
+
+var compositionsKey = regexp.MustCompile(`"compositions"\s*:\s*\[`)
+
+// compositionScanner picks the completed compositions out of the model
+// output while it is streamed. It tracks the nesting of the objects in
+// the compositions array, so every composition is decoded exactly once.
+type compositionScanner struct {
+	buf      []byte
+	pos      int
+	inArray  bool
+	done     bool
+	depth    int
+	start    int
+	inString bool
+	escaped  bool
+}
+
+// Feed appends a chunk and returns the compositions completed by it
+func (s *compositionScanner) Feed(chunk string) []GeneratedComposition {
+	s.buf = append(s.buf, chunk...)
+
+	if s.done {
+		return nil
+	}
+
+	if !s.inArray {
+		loc := compositionsKey.FindIndex(s.buf)
+		if loc == nil {
+			return nil
+		}
+		s.inArray = true
+		s.pos = loc[1]
+	}
+
+	var completed []GeneratedComposition
+	for ; s.pos < len(s.buf); s.pos++ {
+		c := s.buf[s.pos]
+
+		if s.inString {
+			switch {
+			case s.escaped:
+				s.escaped = false
+			case c == '\\':
+				s.escaped = true
+			case c == '"':
+				s.inString = false
+			}
+			continue
+		}
+
+		switch c {
+		case '"':
+			s.inString = true
+		case '{':
+			if s.depth == 0 {
+				s.start = s.pos
+			}
+			s.depth++
+		case '}':
+			s.depth--
+			if s.depth == 0 {
+				var comp GeneratedComposition
+				if err := json.Unmarshal(s.buf[s.start:s.pos+1], &comp); err == nil {
+					completed = append(completed, comp)
+				}
+			}
+		case ']':
+			if s.depth == 0 {
+				s.done = true
+				return completed
+			}
+		}
+	}
+
+	return completed
+}
+
+// Stream generates like Generate, but reports the raw chunks of the
+// answer and every composition as soon as it is complete. The partial
+// compositions are not validated, the returned Response is.
+func (g *Generator) Stream(ctx context.Context, req GenerateRequest, onToken func(string) error, onComposition func(GeneratedComposition) error) (Response, error) {
+	var scanner compositionScanner
+
+	content, err := g.provider.Stream(ctx, g.Messages(req), g.responseSchema(), func(token string) error {
+		if err := onToken(token); err != nil {
+			return err
+		}
+
+		for _, comp := range scanner.Feed(token) {
+			if err := onComposition(comp); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return Response{}, err
+	}
+
+	return g.ParseResponse(content)
+}
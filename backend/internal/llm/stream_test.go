@@ -0,0 +1,65 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/Pieli/server/internal/catalog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompositionScannerAcrossChunks(t *testing.T) {
+	content := `{"compositions": [{"id":"a","duration":45,"animationName":"fadeInTransition","animationSettings":{"typo_text":"braces } and \" quotes ]"},"text":"x","background":{"name":"plainBackground","settings":{}}},` +
+		`{"id":"b","duration":45,"animationName":"simpleTextTyping","animationSettings":{},"text":"y","background":{"name":"twinTexture","settings":{}}}],"comment":"{not a composition}"}`
+
+	var scanner compositionScanner
+	var ids []string
+	for i := 0; i < len(content); i += 3 {
+		for _, comp := range scanner.Feed(content[i:min(i+3, len(content))]) {
+			ids = append(ids, comp.Id)
+		}
+	}
+
+	assert.Equal(t, []string{"a", "b"}, ids)
+}
+
+func TestStreamWithFakeProvider(t *testing.T) {
+	generator := testGenerator(t, FakeProvider{})
+	prompt := "One two three four five six seven eight nine"
+
+	var tokens strings.Builder
+	var streamed []GeneratedComposition
+	response, err := generator.Stream(context.Background(), GenerateRequest{Prompt: prompt},
+		func(token string) error {
+			tokens.WriteString(token)
+			return nil
+		},
+		func(comp GeneratedComposition) error {
+			streamed = append(streamed, comp)
+			return nil
+		})
+	require.NoError(t, err)
+
+	assert.Equal(t, FakeResponse(prompt, generator.catalog.Names(catalog.Animation), generator.catalog.Names(catalog.Background)), response)
+	assert.Equal(t, response.Compositions, streamed)
+	assert.Contains(t, tokens.String(), `"comment"`)
+}
+
+func TestStreamStopsOnCallbackError(t *testing.T) {
+	generator := testGenerator(t, FakeProvider{})
+	gone := errors.New("client gone")
+
+	calls := 0
+	_, err := generator.Stream(context.Background(), GenerateRequest{Prompt: "hello"},
+		func(string) error {
+			calls++
+			return gone
+		},
+		func(GeneratedComposition) error { return nil })
+
+	assert.ErrorIs(t, err, gone)
+	assert.Equal(t, 1, calls)
+}
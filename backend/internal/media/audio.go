@@ -0,0 +1,381 @@
+package media
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// WaveformSize is the number of peaks of a waveform at most, short
+// files get one peak per sample frame
+const WaveformSize = 1000
+
+// AudioInfo describes a parsed audio file
+type AudioInfo struct {
+	// Duration is the playing time in seconds
+	Duration      float64 `bson:"duration"`
+	SampleRate    int     `bson:"sampleRate"`
+	Channels      int     `bson:"channels"`
+	BitsPerSample int     `bson:"bitsPerSample,omitempty"`
+	// Bitrate is the average number of bits per second of compressed audio
+	Bitrate int `bson:"bitrate,omitempty"`
+	// Waveform holds the highest absolute amplitude of evenly sized parts
+	// of the file, between 0 and 1. Only uncompressed audio has one.
+	Waveform []float64 `bson:"waveform,omitempty"`
+}
+
+var audioParsers = map[string]func(*bufio.Reader, int64) (AudioInfo, error){
+	"audio/wave":   parseWAV,
+	"audio/wav":    parseWAV,
+	"audio/x-wav":  parseWAV,
+	"audio/mpeg":   parseMP3,
+	"audio/mp3":    parseMP3,
+	"audio/x-mpeg": parseMP3,
+}
+
+// CanParseAudio tells whether ProcessAudio understands the MIME type
+func CanParseAudio(mimeType string) bool {
+	_, ok := audioParsers[mimeType]
+	return ok
+}
+
+// ProcessAudio reads the format of a WAV or MP3 file of the given size,
+// WAV files also get a waveform. The file is read once, front to back.
+func ProcessAudio(r io.Reader, mimeType string, size int64) (AudioInfo, error) {
+	parse, ok := audioParsers[mimeType]
+	if !ok {
+		return AudioInfo{}, fmt.Errorf("%w: cannot parse %s", ErrInvalid, mimeType)
+	}
+
+	info, err := parse(bufio.NewReaderSize(r, 64<<10), size)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return AudioInfo{}, fmt.Errorf("%w: the file is truncated", ErrInvalid)
+	}
+	return info, err
+}
+
+// wavFormat is the content of the fmt chunk of a WAV file
+type wavFormat struct {
+	AudioFormat   uint16
+	Channels      uint16
+	SampleRate    uint32
+	ByteRate      uint32
+	BlockAlign    uint16
+	BitsPerSample uint16
+}
+
+// maxWAVFormatSize is the longest fmt chunk, the one of extensible files
+const maxWAVFormatSize = 40
+
+const (
+	wavPCM        = 1
+	wavFloat      = 3
+	wavExtensible = 0xFFFE
+)
+
+// parseWAV walks the chunks of a RIFF WAVE file up to the samples and
+// reads them for the waveform
+func parseWAV(r *bufio.Reader, size int64) (AudioInfo, error) {
+	var header [12]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return AudioInfo{}, err
+	}
+	if string(header[0:4]) != "RIFF" || string(header[8:12]) != "WAVE" {
+		return AudioInfo{}, fmt.Errorf("%w: not a RIFF WAVE file", ErrInvalid)
+	}
+
+	var format *wavFormat
+	offset := int64(len(header))
+	for {
+		var chunk [8]byte
+		if _, err := io.ReadFull(r, chunk[:]); err != nil {
+			return AudioInfo{}, err
+		}
+		offset += int64(len(chunk))
+		id, length := string(chunk[0:4]), int64(binary.LittleEndian.Uint32(chunk[4:8]))
+		// the data length is fixed up below, any other chunk has to fit
+		// into the file before it is read or skipped
+		if id != "data" && size > 0 && length > size-offset {
+			return AudioInfo{}, fmt.Errorf("%w: the %q chunk runs past the end of the file", ErrInvalid, id)
+		}
+
+		switch id {
+		case "fmt ":
+			if length < 16 || length > maxWAVFormatSize {
+				return AudioInfo{}, fmt.Errorf("%w: the fmt chunk has an invalid length", ErrInvalid)
+			}
+			body := make([]byte, length+length%2)
+			if _, err := io.ReadFull(r, body); err != nil {
+				return AudioInfo{}, err
+			}
+			format = &wavFormat{}
+			_ = binary.Read(bytes.NewReader(body[:16]), binary.LittleEndian, format)
+			// the actual format of extensible files is the head of the sub format GUID
+			if format.AudioFormat == wavExtensible && length >= 26 {
+				format.AudioFormat = binary.LittleEndian.Uint16(body[24:26])
+			}
+			offset += int64(len(body))
+
+		case "data":
+			if format == nil {
+				return AudioInfo{}, fmt.Errorf("%w: the data chunk comes before the fmt chunk", ErrInvalid)
+			}
+			// streaming writers leave the length open
+			if size > 0 && (length == 0 || length == math.MaxUint32 || offset+length > size) {
+				length = size - offset
+			}
+			return readWAVSamples(r, *format, length)
+
+		default:
+			if _, err := io.CopyN(io.Discard, r, length+length%2); err != nil {
+				return AudioInfo{}, err
+			}
+			offset += length + length%2
+		}
+	}
+}
+
+// readWAVSamples reads the data chunk and collects the peaks
+func readWAVSamples(r io.Reader, format wavFormat, length int64) (AudioInfo, error) {
+	bytesPerSample := int(format.BitsPerSample+7) / 8
+	if format.Channels == 0 || format.SampleRate == 0 || bytesPerSample == 0 ||
+		int(format.BlockAlign) != bytesPerSample*int(format.Channels) {
+		return AudioInfo{}, fmt.Errorf("%w: the fmt chunk is inconsistent", ErrInvalid)
+	}
+
+	var sample func([]byte) float64
+	switch {
+	case format.AudioFormat == wavPCM && bytesPerSample == 1:
+		sample = func(b []byte) float64 { return (float64(b[0]) - 128) / 128 }
+	case format.AudioFormat == wavPCM && bytesPerSample == 2:
+		sample = func(b []byte) float64 { return float64(int16(binary.LittleEndian.Uint16(b))) / (1 << 15) }
+	case format.AudioFormat == wavPCM && bytesPerSample == 3:
+		sample = func(b []byte) float64 {
+			return float64(int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24)>>8) / (1 << 23)
+		}
+	case format.AudioFormat == wavPCM && bytesPerSample == 4:
+		sample = func(b []byte) float64 { return float64(int32(binary.LittleEndian.Uint32(b))) / (1 << 31) }
+	case format.AudioFormat == wavFloat && bytesPerSample == 4:
+		sample = func(b []byte) float64 { return float64(math.Float32frombits(binary.LittleEndian.Uint32(b))) }
+	case format.AudioFormat == wavFloat && bytesPerSample == 8:
+		sample = func(b []byte) float64 { return math.Float64frombits(binary.LittleEndian.Uint64(b)) }
+	default:
+		return AudioInfo{}, fmt.Errorf("%w: WAV format %d with %d bits is not supported", ErrInvalid, format.AudioFormat, format.BitsPerSample)
+	}
+
+	blockAlign := int64(format.BlockAlign)
+	frames := length / blockAlign
+	info := AudioInfo{
+		Duration:      float64(frames) / float64(format.SampleRate),
+		SampleRate:    int(format.SampleRate),
+		Channels:      int(format.Channels),
+		BitsPerSample: int(format.BitsPerSample),
+		Waveform:      []float64{},
+	}
+	if frames == 0 {
+		return info, nil
+	}
+
+	framesPerPeak := (frames + WaveformSize - 1) / WaveformSize
+	buf := make([]byte, blockAlign*4096)
+	peak, inPeak := 0.0, int64(0)
+	for read := int64(0); read < frames; {
+		n := min(int64(len(buf))/blockAlign, frames-read)
+		if _, err := io.ReadFull(r, buf[:n*blockAlign]); err != nil {
+			return AudioInfo{}, err
+		}
+
+		for frame := int64(0); frame < n; frame++ {
+			block := buf[frame*blockAlign : (frame+1)*blockAlign]
+			for c := 0; c < int(format.Channels); c++ {
+				peak = max(peak, math.Abs(sample(block[c*bytesPerSample:])))
+			}
+			inPeak++
+			if inPeak == framesPerPeak {
+				info.Waveform = append(info.Waveform, roundPeak(peak))
+				peak, inPeak = 0, 0
+			}
+		}
+		read += n
+	}
+	if inPeak > 0 {
+		info.Waveform = append(info.Waveform, roundPeak(peak))
+	}
+
+	return info, nil
+}
+
+// roundPeak keeps three digits, more are not visible in a timeline
+func roundPeak(peak float64) float64 {
+	if math.IsNaN(peak) {
+		return 0
+	}
+	return math.Round(min(peak, 1)*1000) / 1000
+}
+
+// mp3Frame is the decoded header of an MPEG audio frame
+type mp3Frame struct {
+	// Length is the size of the frame in bytes, header included
+	Length     int
+	Samples    int
+	SampleRate int
+	Channels   int
+	// sideInfo is the size of the layer III side information
+	sideInfo int
+}
+
+var (
+	// mp3Bitrates in kbit/s by [MPEG-1][layer - 1][index]
+	mp3Bitrates = [2][3][16]int{
+		{ // MPEG-2 and 2.5
+			{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256, 0},
+			{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},
+			{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},
+		},
+		{ // MPEG-1
+			{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448, 0},
+			{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384, 0},
+			{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0},
+		},
+	}
+	// mp3SampleRates of MPEG-1, MPEG-2 halves and MPEG-2.5 quarters them
+	mp3SampleRates = [3]int{44100, 48000, 32000}
+)
+
+// parseMP3Header decodes a frame header, ok is false for bytes that are
+// not one
+func parseMP3Header(h []byte) (mp3Frame, bool) {
+	if len(h) < 4 || h[0] != 0xFF || h[1]&0xE0 != 0xE0 {
+		return mp3Frame{}, false
+	}
+
+	version := (h[1] >> 3) & 0x3 // 0: MPEG-2.5, 2: MPEG-2, 3: MPEG-1
+	layer := 4 - int((h[1]>>1)&0x3)
+	bitrateIndex := h[2] >> 4
+	rateIndex := (h[2] >> 2) & 0x3
+	padding := int(h[2]>>1) & 0x1
+	if version == 1 || layer == 4 || bitrateIndex == 0 || bitrateIndex == 15 || rateIndex == 3 {
+		return mp3Frame{}, false
+	}
+
+	mpeg1 := 0
+	if version == 3 {
+		mpeg1 = 1
+	}
+	bitrate := mp3Bitrates[mpeg1][layer-1][bitrateIndex] * 1000
+	sampleRate := mp3SampleRates[rateIndex]
+	switch version {
+	case 2:
+		sampleRate /= 2
+	case 0:
+		sampleRate /= 4
+	}
+
+	frame := mp3Frame{SampleRate: sampleRate, Channels: 2}
+	if h[3]>>6 == 3 {
+		frame.Channels = 1
+	}
+
+	switch {
+	case layer == 1:
+		frame.Samples = 384
+		frame.Length = (12*bitrate/sampleRate + padding) * 4
+	case layer == 3 && mpeg1 == 0:
+		frame.Samples = 576
+		frame.Length = 72*bitrate/sampleRate + padding
+	default:
+		frame.Samples = 1152
+		frame.Length = 144*bitrate/sampleRate + padding
+	}
+
+	if layer == 3 {
+		switch {
+		case mpeg1 == 1 && frame.Channels == 2:
+			frame.sideInfo = 32
+		case mpeg1 == 1, frame.Channels == 2:
+			frame.sideInfo = 17
+		default:
+			frame.sideInfo = 9
+		}
+	}
+
+	return frame, true
+}
+
+// parseMP3 counts the frames of an MPEG audio file, that works for
+// constant and variable bitrates alike. MP3s are not decoded, so they
+// have no waveform.
+func parseMP3(br *bufio.Reader, size int64) (AudioInfo, error) {
+	// skip the ID3v2 tag in front of the first frame
+	head, err := br.Peek(10)
+	if err != nil {
+		return AudioInfo{}, err
+	}
+	if string(head[0:3]) == "ID3" {
+		tagSize := int64(head[6]&0x7F)<<21 | int64(head[7]&0x7F)<<14 | int64(head[8]&0x7F)<<7 | int64(head[9]&0x7F)
+		tagSize += 10
+		if head[5]&0x10 != 0 {
+			tagSize += 10 // footer
+		}
+		if _, err := br.Discard(int(tagSize)); err != nil {
+			return AudioInfo{}, err
+		}
+	}
+
+	info := AudioInfo{}
+	var frames, samples, audioBytes int64
+	for {
+		header, err := br.Peek(4)
+		if len(header) < 4 {
+			if err == io.EOF && frames > 0 {
+				break
+			}
+			return AudioInfo{}, fmt.Errorf("%w: no MPEG audio frames", ErrInvalid)
+		}
+
+		frame, ok := parseMP3Header(header)
+		if !ok {
+			// trailing tags and garbage end the stream
+			if frames > 0 {
+				break
+			}
+			return AudioInfo{}, fmt.Errorf("%w: no MPEG audio frames", ErrInvalid)
+		}
+
+		if frames == 0 {
+			info.SampleRate, info.Channels = frame.SampleRate, frame.Channels
+			// the Xing or Info frame of encoders holds no audio
+			if body, _ := br.Peek(4 + frame.sideInfo + 4); frame.sideInfo > 0 && len(body) == 4+frame.sideInfo+4 {
+				if tag := string(body[4+frame.sideInfo:]); tag == "Xing" || tag == "Info" {
+					if _, err := br.Discard(frame.Length); err != nil {
+						return AudioInfo{}, err
+					}
+					continue
+				}
+			}
+		}
+
+		discarded, err := br.Discard(frame.Length)
+		if err != nil && discarded < frame.Length {
+			// a cut off last frame is not played by most decoders
+			if frames > 0 {
+				break
+			}
+			return AudioInfo{}, err
+		}
+		frames++
+		samples += int64(frame.Samples)
+		audioBytes += int64(frame.Length)
+	}
+
+	info.Duration = float64(samples) / float64(info.SampleRate)
+	if info.Duration > 0 {
+		info.Bitrate = int(math.Round(float64(audioBytes*8) / info.Duration))
+	}
+
+	return info, nil
+}
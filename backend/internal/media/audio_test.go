@@ -0,0 +1,185 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// wavFile builds a WAV file around the samples, an extra chunk in front
+// of the data checks that unknown chunks are skipped
+func wavFile(format, channels, sampleRate, bits int, data []byte) []byte {
+	var buf bytes.Buffer
+	le := func(v interface{}) { _ = binary.Write(&buf, binary.LittleEndian, v) }
+
+	buf.WriteString("RIFF")
+	le(uint32(4 + 8 + 16 + 8 + 4 + 8 + len(data)))
+	buf.WriteString("WAVE")
+	buf.WriteString("fmt ")
+	le(uint32(16))
+	le(uint16(format))
+	le(uint16(channels))
+	le(uint32(sampleRate))
+	le(uint32(sampleRate * channels * bits / 8))
+	le(uint16(channels * bits / 8))
+	le(uint16(bits))
+	buf.WriteString("LIST")
+	le(uint32(3))
+	buf.Write([]byte{1, 2, 3, 0})
+	buf.WriteString("data")
+	le(uint32(len(data)))
+	buf.Write(data)
+	return buf.Bytes()
+}
+
+func TestProcessAudioWAV(t *testing.T) {
+	// one second of stereo, the left channel ramps up, the right is silent
+	const rate = 8000
+	data := make([]byte, 0, rate*4)
+	for i := 0; i < rate; i++ {
+		data = binary.LittleEndian.AppendUint16(data, uint16(int16(-i*32767/(rate-1))))
+		data = binary.LittleEndian.AppendUint16(data, 0)
+	}
+	file := wavFile(wavPCM, 2, rate, 16, data)
+
+	info, err := ProcessAudio(bytes.NewReader(file), "audio/wave", int64(len(file)))
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, info.Duration)
+	assert.Equal(t, rate, info.SampleRate)
+	assert.Equal(t, 2, info.Channels)
+	assert.Equal(t, 16, info.BitsPerSample)
+
+	require.Len(t, info.Waveform, WaveformSize)
+	assert.Equal(t, 1.0, info.Waveform[WaveformSize-1])
+	assert.InDelta(t, 0.5, info.Waveform[WaveformSize/2], 0.01)
+	for i := 1; i < len(info.Waveform); i++ {
+		assert.GreaterOrEqual(t, info.Waveform[i], info.Waveform[i-1])
+	}
+}
+
+func TestProcessAudioShortWAV(t *testing.T) {
+	data := []byte{128, 255, 0, 128}
+	file := wavFile(wavPCM, 1, 4, 8, data)
+
+	info, err := ProcessAudio(bytes.NewReader(file), "audio/wave", int64(len(file)))
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, info.Duration)
+	// one peak per frame, the louder half of 8 bit samples tops at 127/128
+	assert.Equal(t, []float64{0, 0.992, 1, 0}, info.Waveform)
+}
+
+func TestProcessAudioFloatWAV(t *testing.T) {
+	var data []byte
+	for _, v := range []float32{0.25, -0.5, 2} {
+		data = binary.LittleEndian.AppendUint32(data, math.Float32bits(v))
+	}
+	file := wavFile(wavFloat, 1, 3, 32, data)
+
+	info, err := ProcessAudio(bytes.NewReader(file), "audio/wave", int64(len(file)))
+	require.NoError(t, err)
+	// clipped samples are capped
+	assert.Equal(t, []float64{0.25, 0.5, 1}, info.Waveform)
+}
+
+func TestProcessAudioStreamedWAV(t *testing.T) {
+	file := wavFile(wavPCM, 1, 2, 16, []byte{0, 0x40, 0, 0xC0})
+	// streaming writers leave the data length open
+	binary.LittleEndian.PutUint32(file[len(file)-8:], math.MaxUint32)
+
+	info, err := ProcessAudio(bytes.NewReader(file), "audio/wave", int64(len(file)))
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, info.Duration)
+	assert.Equal(t, []float64{0.5, 0.5}, info.Waveform)
+}
+
+func TestProcessAudioRejectsInvalidWAV(t *testing.T) {
+	complete := wavFile(wavPCM, 1, 8000, 16, make([]byte, 100))
+	for name, file := range map[string][]byte{
+		"not riff":  []byte("RIFX\x00\x00\x00\x00WAVE"),
+		"truncated": complete[:60],
+		"adpcm":     wavFile(2, 1, 8000, 4, make([]byte, 100)),
+		"huge fmt":  []byte("RIFF\x00\x00\x00\x00WAVEfmt \xf0\xff\xff\xff"),
+		"huge list": append(append([]byte{}, complete[:36]...), "LIST\xf0\xff\xff\xff"...),
+	} {
+		// the stored size is the one of the complete file
+		_, err := ProcessAudio(bytes.NewReader(file), "audio/wave", int64(len(complete)))
+		assert.ErrorIs(t, err, ErrInvalid, name)
+	}
+}
+
+func FuzzProcessAudioWAV(f *testing.F) {
+	f.Add(wavFile(wavPCM, 2, 8000, 16, make([]byte, 64)))
+	f.Add(wavFile(wavFloat, 1, 3, 32, make([]byte, 12)))
+	f.Add([]byte("RIFF\x00\x00\x00\x00WAVEfmt \xf0\xff\xff\xff"))
+	f.Fuzz(func(t *testing.T, file []byte) {
+		// lengths in the file must not make the parser allocate or skip
+		// beyond the file
+		info, err := ProcessAudio(bytes.NewReader(file), "audio/wave", int64(len(file)))
+		if err == nil {
+			assert.LessOrEqual(t, len(info.Waveform), WaveformSize)
+		}
+	})
+}
+
+// mp3Header is an MPEG-1 layer III frame header of 128 kbit/s at 44.1 kHz
+var mp3Header = []byte{0xFF, 0xFB, 0x90, 0x00}
+
+const mp3FrameLength = 417
+
+func mp3FrameWithTag(tag string) []byte {
+	frame := make([]byte, mp3FrameLength)
+	copy(frame, mp3Header)
+	copy(frame[4+32:], tag)
+	return frame
+}
+
+func TestProcessAudioMP3(t *testing.T) {
+	var file bytes.Buffer
+	// an ID3v2 tag with 20 bytes of frames
+	file.Write([]byte{'I', 'D', '3', 4, 0, 0, 0, 0, 0, 20})
+	file.Write(make([]byte, 20))
+	file.Write(mp3FrameWithTag("Xing"))
+	for i := 0; i < 100; i++ {
+		file.Write(mp3FrameWithTag(""))
+	}
+	file.WriteString("TAG")
+	file.Write(make([]byte, 125))
+
+	info, err := ProcessAudio(bytes.NewReader(file.Bytes()), "audio/mpeg", int64(file.Len()))
+	require.NoError(t, err)
+	assert.Equal(t, 44100, info.SampleRate)
+	assert.Equal(t, 2, info.Channels)
+	assert.InDelta(t, 100*1152/44100.0, info.Duration, 1e-9)
+	assert.InDelta(t, 128000, info.Bitrate, 500)
+	assert.Empty(t, info.Waveform)
+}
+
+func TestParseMP3Header(t *testing.T) {
+	frame, ok := parseMP3Header(mp3Header)
+	require.True(t, ok)
+	assert.Equal(t, mp3FrameLength, frame.Length)
+	assert.Equal(t, 1152, frame.Samples)
+
+	// MPEG-2 layer III, 64 kbit/s at 22.05 kHz, mono, padded
+	frame, ok = parseMP3Header([]byte{0xFF, 0xF3, 0x82, 0xC0})
+	require.True(t, ok)
+	assert.Equal(t, 22050, frame.SampleRate)
+	assert.Equal(t, 1, frame.Channels)
+	assert.Equal(t, 576, frame.Samples)
+	assert.Equal(t, 72*64000/22050+1, frame.Length)
+
+	_, ok = parseMP3Header([]byte{0xFF, 0xFB, 0xF0, 0x00})
+	assert.False(t, ok, "bad bitrate")
+	_, ok = parseMP3Header([]byte("ID3\x04"))
+	assert.False(t, ok)
+}
+
+func TestProcessAudioRejectsInvalidMP3(t *testing.T) {
+	_, err := ProcessAudio(bytes.NewReader([]byte("not an mp3 at all")), "audio/mpeg", 17)
+	assert.ErrorIs(t, err, ErrInvalid)
+	assert.False(t, CanParseAudio("audio/ogg"))
+}
@@ -0,0 +1,442 @@
+package media
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/andybalholm/brotli"
+	"golang.org/x/image/font/sfnt"
+)
+
+// maxFontSize caps the size of a font once decompressed
+const maxFontSize = 64 << 20
+
+// FontInfo describes a parsed font file
+type FontInfo struct {
+	// Format is "truetype", "opentype", "collection", "woff" or "woff2"
+	Format string `bson:"format"`
+	// Family is the typographic family, shared by all weights and styles
+	Family    string `bson:"family"`
+	Subfamily string `bson:"subfamily,omitempty"`
+	FullName  string `bson:"fullName,omitempty"`
+	// Weight is the CSS font-weight between 1 and 1000
+	Weight int `bson:"weight"`
+	// Style is the CSS font-style, "normal", "italic" or "oblique"
+	Style string `bson:"style"`
+}
+
+var fontFormats = map[string]string{
+	"font/ttf":        "truetype",
+	"font/otf":        "opentype",
+	"font/collection": "collection",
+	"font/woff":       "woff",
+	"font/woff2":      "woff2",
+}
+
+// CanParseFont tells whether ProcessFont understands the MIME type
+func CanParseFont(mimeType string) bool {
+	_, ok := fontFormats[mimeType]
+	return ok
+}
+
+// ProcessFont reads the names, weight and style of a TrueType or
+// OpenType font, plain, in a collection or wrapped in WOFF or WOFF2.
+// Fonts missing required tables or with broken ones are invalid.
+func ProcessFont(r io.Reader, mimeType string) (FontInfo, error) {
+	format, ok := fontFormats[mimeType]
+	if !ok {
+		return FontInfo{}, fmt.Errorf("%w: cannot parse %s", ErrInvalid, mimeType)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r, maxFontSize+1))
+	if err != nil {
+		return FontInfo{}, err
+	}
+	if len(data) > maxFontSize {
+		return FontInfo{}, fmt.Errorf("%w: the font is too large", ErrInvalid)
+	}
+
+	var tables map[string][]byte
+	// transformed tables of WOFF2 cannot be checked by the sfnt parser
+	transformed := false
+	switch format {
+	case "truetype", "opentype":
+		tables, err = sfntTables(data, 0)
+	case "collection":
+		tables, err = collectionTables(data)
+	case "woff":
+		tables, err = woffTables(data)
+	case "woff2":
+		tables, transformed, err = woff2Tables(data)
+	}
+	if err != nil {
+		return FontInfo{}, err
+	}
+
+	for _, tag := range []string{"cmap", "head", "hhea", "maxp", "name"} {
+		if _, ok := tables[tag]; !ok {
+			return FontInfo{}, fmt.Errorf("%w: the font has no %s table", ErrInvalid, tag)
+		}
+	}
+	if head := tables["head"]; len(head) < 54 || binary.BigEndian.Uint32(head[12:16]) != 0x5F0F3CF5 {
+		return FontInfo{}, fmt.Errorf("%w: the head table is broken", ErrInvalid)
+	}
+	if !transformed {
+		if _, err := sfnt.Parse(buildSFNT(tables)); err != nil {
+			return FontInfo{}, fmt.Errorf("%w: %v", ErrInvalid, err)
+		}
+	}
+
+	info, err := fontNames(tables["name"])
+	if err != nil {
+		return FontInfo{}, err
+	}
+	info.Format = format
+	info.Weight, info.Style = fontStyle(tables["OS/2"], tables["head"])
+
+	return info, nil
+}
+
+// sfntTables reads the table directory of a font starting at offset
+func sfntTables(data []byte, offset int) (map[string][]byte, error) {
+	if len(data) < offset+12 {
+		return nil, fmt.Errorf("%w: the font header is cut off", ErrInvalid)
+	}
+	switch string(data[offset : offset+4]) {
+	case "\x00\x01\x00\x00", "OTTO", "true":
+	default:
+		return nil, fmt.Errorf("%w: unknown font version", ErrInvalid)
+	}
+
+	count := int(binary.BigEndian.Uint16(data[offset+4:]))
+	if len(data) < offset+12+count*16 {
+		return nil, fmt.Errorf("%w: the table directory is cut off", ErrInvalid)
+	}
+
+	tables := make(map[string][]byte, count)
+	for i := 0; i < count; i++ {
+		record := data[offset+12+i*16:]
+		tag := string(record[0:4])
+		start := uint64(binary.BigEndian.Uint32(record[8:12]))
+		length := uint64(binary.BigEndian.Uint32(record[12:16]))
+		if start+length > uint64(len(data)) {
+			return nil, fmt.Errorf("%w: table %q is out of bounds", ErrInvalid, tag)
+		}
+		tables[tag] = data[start : start+length]
+	}
+	return tables, nil
+}
+
+// collectionTables reads the first font of a collection
+func collectionTables(data []byte) (map[string][]byte, error) {
+	if len(data) < 16 || string(data[0:4]) != "ttcf" || binary.BigEndian.Uint32(data[8:12]) == 0 {
+		return nil, fmt.Errorf("%w: not a font collection", ErrInvalid)
+	}
+	return sfntTables(data, int(binary.BigEndian.Uint32(data[12:16])))
+}
+
+// woffTables reads and inflates the tables of a WOFF file
+func woffTables(data []byte) (map[string][]byte, error) {
+	if len(data) < 44 || string(data[0:4]) != "wOFF" {
+		return nil, fmt.Errorf("%w: not a WOFF file", ErrInvalid)
+	}
+
+	count := int(binary.BigEndian.Uint16(data[12:14]))
+	if len(data) < 44+count*20 {
+		return nil, fmt.Errorf("%w: the table directory is cut off", ErrInvalid)
+	}
+
+	tables := make(map[string][]byte, count)
+	total := 0
+	for i := 0; i < count; i++ {
+		record := data[44+i*20:]
+		tag := string(record[0:4])
+		start := uint64(binary.BigEndian.Uint32(record[4:8]))
+		compressed := uint64(binary.BigEndian.Uint32(record[8:12]))
+		length := int(binary.BigEndian.Uint32(record[12:16]))
+		if start+compressed > uint64(len(data)) || compressed > uint64(length) {
+			return nil, fmt.Errorf("%w: table %q is out of bounds", ErrInvalid, tag)
+		}
+		total += length
+		if total > maxFontSize {
+			return nil, fmt.Errorf("%w: the font is too large", ErrInvalid)
+		}
+
+		table := data[start : start+compressed]
+		if compressed < uint64(length) {
+			inflater, err := zlib.NewReader(bytes.NewReader(table))
+			if err != nil {
+				return nil, fmt.Errorf("%w: table %q: %v", ErrInvalid, tag, err)
+			}
+			table = make([]byte, length)
+			_, err = io.ReadFull(inflater, table)
+			inflater.Close()
+			if err != nil {
+				return nil, fmt.Errorf("%w: table %q: %v", ErrInvalid, tag, err)
+			}
+		}
+		tables[tag] = table
+	}
+	return tables, nil
+}
+
+// woff2KnownTags are the tags WOFF2 encodes as an index
+var woff2KnownTags = [63]string{
+	"cmap", "head", "hhea", "hmtx", "maxp", "name", "OS/2", "post", "cvt ", "fpgm",
+	"glyf", "loca", "prep", "CFF ", "VORG", "EBDT", "EBLC", "gasp", "hdmx", "kern",
+	"LTSH", "PCLT", "VDMX", "vhea", "vmtx", "BASE", "GDEF", "GPOS", "GSUB", "EBSC",
+	"JSTF", "MATH", "CBDT", "CBLC", "COLR", "CPAL", "SVG ", "sbix", "acnt", "avar",
+	"bdat", "bloc", "bsln", "cvar", "fdsc", "feat", "fmtx", "fvar", "gvar", "hsty",
+	"just", "lcar", "mort", "morx", "opbd", "prop", "trak", "Zapf", "Silf", "Glat",
+	"Gloc", "Feat", "Sill",
+}
+
+// readBase128 reads a UIntBase128 number of WOFF2
+func readBase128(r *bytes.Reader) (uint32, error) {
+	var value uint32
+	for i := 0; i < 5; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if (i == 0 && b == 0x80) || value&0xFE000000 != 0 {
+			return 0, fmt.Errorf("%w: invalid WOFF2 number", ErrInvalid)
+		}
+		value = value<<7 | uint32(b&0x7F)
+		if b&0x80 == 0 {
+			return value, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: invalid WOFF2 number", ErrInvalid)
+}
+
+// woff2Tables decompresses the tables of a WOFF2 file. The glyph tables
+// are usually transformed, the returned flag tells when one is.
+func woff2Tables(data []byte) (map[string][]byte, bool, error) {
+	if len(data) < 48 || string(data[0:4]) != "wOF2" {
+		return nil, false, fmt.Errorf("%w: not a WOFF2 file", ErrInvalid)
+	}
+	if string(data[4:8]) == "ttcf" {
+		return nil, false, fmt.Errorf("%w: WOFF2 collections are not supported", ErrInvalid)
+	}
+
+	count := int(binary.BigEndian.Uint16(data[12:14]))
+	compressedSize := int(binary.BigEndian.Uint32(data[20:24]))
+
+	type entry struct {
+		tag    string
+		length uint32
+	}
+	entries := make([]entry, 0, count)
+	transformed := false
+	directory := bytes.NewReader(data[48:])
+	total := uint64(0)
+	for i := 0; i < count; i++ {
+		flags, err := directory.ReadByte()
+		if err != nil {
+			return nil, false, fmt.Errorf("%w: the table directory is cut off", ErrInvalid)
+		}
+		tag := ""
+		if index := flags & 0x3F; index == 0x3F {
+			var explicit [4]byte
+			if _, err := io.ReadFull(directory, explicit[:]); err != nil {
+				return nil, false, fmt.Errorf("%w: the table directory is cut off", ErrInvalid)
+			}
+			tag = string(explicit[:])
+		} else {
+			tag = woff2KnownTags[index]
+		}
+
+		length, err := readBase128(directory)
+		if err != nil {
+			return nil, false, fmt.Errorf("%w: the table directory is cut off", ErrInvalid)
+		}
+		// the glyph tables are transformed with version 0, the others without
+		version := flags >> 6
+		if (tag == "glyf" || tag == "loca") == (version == 0) {
+			length, err = readBase128(directory)
+			if err != nil {
+				return nil, false, fmt.Errorf("%w: the table directory is cut off", ErrInvalid)
+			}
+			transformed = true
+		}
+		total += uint64(length)
+		entries = append(entries, entry{tag: tag, length: length})
+	}
+	if total > maxFontSize {
+		return nil, false, fmt.Errorf("%w: the font is too large", ErrInvalid)
+	}
+
+	start := len(data) - directory.Len()
+	if compressedSize <= 0 || start+compressedSize > len(data) {
+		return nil, false, fmt.Errorf("%w: the compressed tables are out of bounds", ErrInvalid)
+	}
+	decompressed, err := io.ReadAll(io.LimitReader(brotli.NewReader(bytes.NewReader(data[start:start+compressedSize])), int64(total)+1))
+	if err != nil {
+		return nil, false, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	if uint64(len(decompressed)) != total {
+		return nil, false, fmt.Errorf("%w: the tables do not match their directory", ErrInvalid)
+	}
+
+	tables := make(map[string][]byte, count)
+	offset := 0
+	for _, e := range entries {
+		tables[e.tag] = decompressed[offset : offset+int(e.length)]
+		offset += int(e.length)
+	}
+	return tables, transformed, nil
+}
+
+// buildSFNT lays the tables out as a plain font file
+func buildSFNT(tables map[string][]byte) []byte {
+	tags := make([]string, 0, len(tables))
+	for tag := range tables {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+
+	version := []byte{0, 1, 0, 0}
+	if _, ok := tables["CFF "]; ok {
+		version = []byte("OTTO")
+	}
+
+	var out bytes.Buffer
+	out.Write(version)
+	_ = binary.Write(&out, binary.BigEndian, [4]uint16{uint16(len(tags)), 0, 0, 0})
+	offset := 12 + 16*len(tags)
+	for _, tag := range tags {
+		out.WriteString(tag)
+		_ = binary.Write(&out, binary.BigEndian, [3]uint32{0, uint32(offset), uint32(len(tables[tag]))})
+		offset += (len(tables[tag]) + 3) &^ 3
+	}
+	for _, tag := range tags {
+		out.Write(tables[tag])
+		out.Write(make([]byte, (4-len(tables[tag])%4)%4))
+	}
+	return out.Bytes()
+}
+
+// name ids of the naming table
+const (
+	nameFamily               = 1
+	nameSubfamily            = 2
+	nameFullName             = 4
+	nameTypographicFamily    = 16
+	nameTypographicSubfamily = 17
+)
+
+// fontNames reads the family names, preferring the typographic ones and
+// English Windows entries
+func fontNames(table []byte) (FontInfo, error) {
+	if len(table) < 6 {
+		return FontInfo{}, fmt.Errorf("%w: the name table is cut off", ErrInvalid)
+	}
+	count := int(binary.BigEndian.Uint16(table[2:4]))
+	storage := int(binary.BigEndian.Uint16(table[4:6]))
+	if len(table) < 6+count*12 {
+		return FontInfo{}, fmt.Errorf("%w: the name table is cut off", ErrInvalid)
+	}
+
+	names := map[uint16]string{}
+	ranks := map[uint16]int{}
+	for i := 0; i < count; i++ {
+		record := table[6+i*12:]
+		platform := binary.BigEndian.Uint16(record[0:2])
+		encoding := binary.BigEndian.Uint16(record[2:4])
+		language := binary.BigEndian.Uint16(record[4:6])
+		id := binary.BigEndian.Uint16(record[6:8])
+		length := int(binary.BigEndian.Uint16(record[8:10]))
+		start := storage + int(binary.BigEndian.Uint16(record[10:12]))
+		if start+length > len(table) {
+			continue
+		}
+		raw := table[start : start+length]
+
+		rank, value := 0, ""
+		switch {
+		case platform == 3 && (encoding == 1 || encoding == 10):
+			rank, value = 2, decodeUTF16(raw)
+			if language == 0x0409 {
+				rank = 3
+			}
+		case platform == 0:
+			rank, value = 1, decodeUTF16(raw)
+		case platform == 1 && encoding == 0:
+			// Mac Roman, only its ASCII part is kept
+			rank, value = 1, strings.Map(func(r rune) rune {
+				if r >= 0x80 {
+					return -1
+				}
+				return r
+			}, string(raw))
+		default:
+			continue
+		}
+		if value = strings.TrimSpace(value); value != "" && rank > ranks[id] {
+			names[id], ranks[id] = value, rank
+		}
+	}
+
+	info := FontInfo{Family: names[nameTypographicFamily], Subfamily: names[nameTypographicSubfamily], FullName: names[nameFullName]}
+	if info.Family == "" {
+		info.Family = names[nameFamily]
+	}
+	if info.Subfamily == "" {
+		info.Subfamily = names[nameSubfamily]
+	}
+	if info.Family == "" {
+		return FontInfo{}, fmt.Errorf("%w: the font has no family name", ErrInvalid)
+	}
+	return info, nil
+}
+
+func decodeUTF16(raw []byte) string {
+	units := make([]uint16, len(raw)/2)
+	for i := range units {
+		units[i] = binary.BigEndian.Uint16(raw[i*2:])
+	}
+	return string(utf16.Decode(units))
+}
+
+// fontStyle reads the weight and style from the OS/2 table, fonts without
+// one only tell bold and italic in the head table
+func fontStyle(os2, head []byte) (int, string) {
+	weight, style := 400, "normal"
+	if len(head) >= 46 {
+		macStyle := binary.BigEndian.Uint16(head[44:46])
+		if macStyle&0x1 != 0 {
+			weight = 700
+		}
+		if macStyle&0x2 != 0 {
+			style = "italic"
+		}
+	}
+
+	if len(os2) >= 64 {
+		weight = int(binary.BigEndian.Uint16(os2[4:6]))
+		// some old fonts count weights from 1 to 9
+		if weight > 0 && weight < 10 {
+			weight *= 100
+		}
+		weight = min(max(weight, 1), 1000)
+
+		selection := binary.BigEndian.Uint16(os2[62:64])
+		switch {
+		case selection&0x1 != 0:
+			style = "italic"
+		case selection&0x200 != 0:
+			style = "oblique"
+		default:
+			style = "normal"
+		}
+	}
+
+	return weight, style
+}
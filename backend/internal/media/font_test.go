@@ -0,0 +1,191 @@
+package media
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"sort"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goitalic"
+	"golang.org/x/image/font/gofont/goregular"
+)
+
+func sortedTags(tables map[string][]byte) []string {
+	tags := make([]string, 0, len(tables))
+	for tag := range tables {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// woff wraps the tables of a font into a WOFF file
+func woff(t *testing.T, font []byte) []byte {
+	tables, err := sfntTables(font, 0)
+	require.NoError(t, err)
+	tags := sortedTags(tables)
+
+	var header, body bytes.Buffer
+	header.WriteString("wOFF")
+	header.Write(font[0:4])
+	_ = binary.Write(&header, binary.BigEndian, []uint32{0})
+	_ = binary.Write(&header, binary.BigEndian, []uint16{uint16(len(tags)), 0})
+	header.Write(make([]byte, 28))
+
+	offset := 44 + 20*len(tags)
+	for _, tag := range tags {
+		var compressed bytes.Buffer
+		deflater := zlib.NewWriter(&compressed)
+		_, _ = deflater.Write(tables[tag])
+		require.NoError(t, deflater.Close())
+		// tables that do not shrink are stored as they are
+		if compressed.Len() >= len(tables[tag]) {
+			compressed.Reset()
+			compressed.Write(tables[tag])
+		}
+
+		header.WriteString(tag)
+		_ = binary.Write(&header, binary.BigEndian, []uint32{uint32(offset), uint32(compressed.Len()), uint32(len(tables[tag])), 0})
+		body.Write(compressed.Bytes())
+		offset += compressed.Len()
+	}
+	return append(header.Bytes(), body.Bytes()...)
+}
+
+// woff2 wraps the tables of a font into a WOFF2 file without transforms
+func woff2(t *testing.T, font []byte) []byte {
+	tables, err := sfntTables(font, 0)
+	require.NoError(t, err)
+	tags := sortedTags(tables)
+
+	var directory, raw, compressed bytes.Buffer
+	for _, tag := range tags {
+		index := byte(0x3F)
+		for i, known := range woff2KnownTags {
+			if known == tag {
+				index = byte(i)
+			}
+		}
+		flags := index
+		// version 3 is the null transform of the glyph tables
+		if tag == "glyf" || tag == "loca" {
+			flags |= 3 << 6
+		}
+		directory.WriteByte(flags)
+		if index == 0x3F {
+			directory.WriteString(tag)
+		}
+		directory.Write(base128(uint32(len(tables[tag]))))
+		raw.Write(tables[tag])
+	}
+	writer := brotli.NewWriter(&compressed)
+	_, _ = writer.Write(raw.Bytes())
+	require.NoError(t, writer.Close())
+
+	var header bytes.Buffer
+	header.WriteString("wOF2")
+	header.Write(font[0:4])
+	_ = binary.Write(&header, binary.BigEndian, []uint32{0})
+	_ = binary.Write(&header, binary.BigEndian, []uint16{uint16(len(tags)), 0})
+	_ = binary.Write(&header, binary.BigEndian, []uint32{0, uint32(compressed.Len())})
+	header.Write(make([]byte, 24))
+
+	return bytes.Join([][]byte{header.Bytes(), directory.Bytes(), compressed.Bytes()}, nil)
+}
+
+func base128(v uint32) []byte {
+	out := []byte{byte(v & 0x7F)}
+	for v >>= 7; v > 0; v >>= 7 {
+		out = append([]byte{byte(v&0x7F) | 0x80}, out...)
+	}
+	return out
+}
+
+func TestProcessFont(t *testing.T) {
+	for name, test := range map[string]struct {
+		font     []byte
+		mimeType string
+		want     FontInfo
+	}{
+		"truetype": {goregular.TTF, "font/ttf", FontInfo{Format: "truetype", Family: "Go", Subfamily: "Regular", FullName: "Go Regular", Weight: 400, Style: "normal"}},
+		"bold":     {gobold.TTF, "font/ttf", FontInfo{Format: "truetype", Family: "Go", Subfamily: "Bold", FullName: "Go Bold", Weight: 600, Style: "normal"}},
+		"italic":   {goitalic.TTF, "font/ttf", FontInfo{Format: "truetype", Family: "Go", Subfamily: "Italic", FullName: "Go Italic", Weight: 400, Style: "italic"}},
+		"woff":     {woff(t, gobold.TTF), "font/woff", FontInfo{Format: "woff", Family: "Go", Subfamily: "Bold", FullName: "Go Bold", Weight: 600, Style: "normal"}},
+		"woff2":    {woff2(t, goitalic.TTF), "font/woff2", FontInfo{Format: "woff2", Family: "Go", Subfamily: "Italic", FullName: "Go Italic", Weight: 400, Style: "italic"}},
+	} {
+		info, err := ProcessFont(bytes.NewReader(test.font), test.mimeType)
+		require.NoError(t, err, name)
+		assert.Equal(t, test.want, info, name)
+	}
+}
+
+func TestProcessFontCollection(t *testing.T) {
+	// a collection of one font, its tables move back by the collection header
+	font := append([]byte(nil), goregular.TTF...)
+	count := int(binary.BigEndian.Uint16(font[4:6]))
+	for i := 0; i < count; i++ {
+		record := font[12+i*16:]
+		binary.BigEndian.PutUint32(record[8:12], binary.BigEndian.Uint32(record[8:12])+16)
+	}
+	collection := append([]byte("ttcf\x00\x01\x00\x00\x00\x00\x00\x01\x00\x00\x00\x10"), font...)
+
+	info, err := ProcessFont(bytes.NewReader(collection), "font/collection")
+	require.NoError(t, err)
+	assert.Equal(t, "collection", info.Format)
+	assert.Equal(t, "Go", info.Family)
+}
+
+func TestProcessFontRejectsMalformedFiles(t *testing.T) {
+	brokenHead := append([]byte(nil), goregular.TTF...)
+	tables, err := sfntTables(brokenHead, 0)
+	require.NoError(t, err)
+	copy(tables["head"][12:16], "junk")
+
+	withoutName := append([]byte(nil), goregular.TTF...)
+	count := int(binary.BigEndian.Uint16(withoutName[4:6]))
+	for i := 0; i < count; i++ {
+		if record := withoutName[12+i*16:]; string(record[0:4]) == "name" {
+			copy(record[0:4], "nope")
+		}
+	}
+
+	brokenWOFF2 := woff2(t, goregular.TTF)
+	brokenWOFF2[len(brokenWOFF2)-5] ^= 0xFF
+
+	for name, test := range map[string]struct {
+		font     []byte
+		mimeType string
+	}{
+		"garbage":       {[]byte("definitely not a font file"), "font/ttf"},
+		"truncated":     {goregular.TTF[:len(goregular.TTF)/2], "font/ttf"},
+		"broken head":   {brokenHead, "font/ttf"},
+		"without name":  {withoutName, "font/ttf"},
+		"woff2 garbage": {brokenWOFF2, "font/woff2"},
+		"woff as woff2": {woff(t, goregular.TTF), "font/woff2"},
+		"eot":           {[]byte("not parsed"), "application/vnd.ms-fontobject"},
+	} {
+		_, err := ProcessFont(bytes.NewReader(test.font), test.mimeType)
+		assert.ErrorIs(t, err, ErrInvalid, name)
+	}
+}
+
+func TestFontStyle(t *testing.T) {
+	os2 := make([]byte, 96)
+	binary.BigEndian.PutUint16(os2[4:6], 3)
+	binary.BigEndian.PutUint16(os2[62:64], 0x200)
+	weight, style := fontStyle(os2, nil)
+	assert.Equal(t, 300, weight)
+	assert.Equal(t, "oblique", style)
+
+	// without OS/2 table the head table tells bold and italic
+	head := make([]byte, 54)
+	binary.BigEndian.PutUint16(head[44:46], 0x3)
+	weight, style = fontStyle(nil, head)
+	assert.Equal(t, 700, weight)
+	assert.Equal(t, "italic", style)
+}
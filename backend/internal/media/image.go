@@ -0,0 +1,291 @@
+package media
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"sort"
+
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/tiff"
+	"golang.org/x/image/webp"
+)
+
+const (
+	// MaxPixels guards against images that decode to huge bitmaps
+	MaxPixels = 50_000_000
+	// PaletteSize is the number of dominant colors suggested at most, the
+	// ColorPalette schema allows 2 to 8
+	PaletteSize = 6
+	// jpegQuality is used for the derivatives of opaque images
+	jpegQuality = 85
+	// paletteSampleSize is the edge the image is shrunk to before counting colors
+	paletteSampleSize = 64
+	// mergeDistance is the squared distance below which colors look alike
+	mergeDistance = 32 * 32
+	// minShare is the part of the image a palette color covers at least
+	minShare = 0.02
+)
+
+// ImageSizes are the derivatives made of every image, by the longest edge
+var ImageSizes = []struct {
+	Name    string
+	MaxEdge int
+}{
+	{"thumbnail", 256},
+	{"preview", 1024},
+}
+
+// ImageInfo describes a decoded image
+type ImageInfo struct {
+	Width  int `bson:"width"`
+	Height int `bson:"height"`
+	// Palette holds the dominant colors as hex codes, most frequent first
+	Palette []string `bson:"palette"`
+}
+
+var decoders = map[string]func(io.Reader) (image.Image, error){
+	"image/jpeg": jpeg.Decode,
+	"image/png":  png.Decode,
+	"image/gif":  gif.Decode,
+	"image/webp": webp.Decode,
+	"image/bmp":  bmp.Decode,
+	"image/tiff": tiff.Decode,
+}
+
+var configDecoders = map[string]func(io.Reader) (image.Config, error){
+	"image/jpeg": jpeg.DecodeConfig,
+	"image/png":  png.DecodeConfig,
+	"image/gif":  gif.DecodeConfig,
+	"image/webp": webp.DecodeConfig,
+	"image/bmp":  bmp.DecodeConfig,
+	"image/tiff": tiff.DecodeConfig,
+}
+
+// CanDecodeImage tells whether ProcessImage understands the MIME type,
+// other images like SVGs are stored without derivatives
+func CanDecodeImage(mimeType string) bool {
+	_, ok := decoders[mimeType]
+	return ok
+}
+
+// ProcessImage decodes the image and returns its size, its dominant colors
+// and the resized derivatives of ImageSizes. The first frame of animated
+// images is used.
+func ProcessImage(r io.ReadSeeker, mimeType string) (ImageInfo, []Rendition, error) {
+	decode, ok := decoders[mimeType]
+	if !ok {
+		return ImageInfo{}, nil, fmt.Errorf("%w: cannot decode %s", ErrInvalid, mimeType)
+	}
+
+	config, err := configDecoders[mimeType](r)
+	if err != nil {
+		return ImageInfo{}, nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > MaxPixels {
+		return ImageInfo{}, nil, fmt.Errorf("%w: %dx%d pixels are not supported", ErrInvalid, config.Width, config.Height)
+	}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return ImageInfo{}, nil, err
+	}
+	img, err := decode(r)
+	if err != nil {
+		return ImageInfo{}, nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+
+	bounds := img.Bounds()
+	info := ImageInfo{
+		Width:   bounds.Dx(),
+		Height:  bounds.Dy(),
+		Palette: Palette(img, PaletteSize),
+	}
+
+	renditions := make([]Rendition, 0, len(ImageSizes))
+	for _, size := range ImageSizes {
+		rendition, err := encodeResized(img, size.MaxEdge)
+		if err != nil {
+			return ImageInfo{}, nil, err
+		}
+		rendition.Name = size.Name
+		renditions = append(renditions, rendition)
+	}
+
+	return info, renditions, nil
+}
+
+// Resize scales the image so its longest edge is at most maxEdge, smaller
+// images keep their size
+func Resize(img image.Image, maxEdge int, scaler draw.Scaler) *image.RGBA {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > maxEdge || height > maxEdge {
+		if width >= height {
+			height = max(1, height*maxEdge/width)
+			width = maxEdge
+		} else {
+			width = max(1, width*maxEdge/height)
+			height = maxEdge
+		}
+	}
+
+	resized := image.NewRGBA(image.Rect(0, 0, width, height))
+	scaler.Scale(resized, resized.Bounds(), img, bounds, draw.Src, nil)
+	return resized
+}
+
+// encodeResized encodes opaque images as JPEG and the others as PNG, to
+// keep their transparency
+func encodeResized(img image.Image, maxEdge int) (Rendition, error) {
+	resized := Resize(img, maxEdge, draw.CatmullRom)
+
+	var buf bytes.Buffer
+	rendition := Rendition{Width: resized.Bounds().Dx(), Height: resized.Bounds().Dy()}
+	if resized.Opaque() {
+		rendition.Type = "image/jpeg"
+		if err := jpeg.Encode(&buf, resized, &jpeg.Options{Quality: jpegQuality}); err != nil {
+			return Rendition{}, err
+		}
+	} else {
+		rendition.Type = "image/png"
+		if err := png.Encode(&buf, resized); err != nil {
+			return Rendition{}, err
+		}
+	}
+	rendition.Data = buf.Bytes()
+
+	return rendition, nil
+}
+
+// colorBox is a set of pixels of the median cut
+type colorBox struct {
+	pixels [][3]uint8
+}
+
+// channelRange returns the channel with the widest spread and the spread
+func (b colorBox) channelRange() (int, int) {
+	channel, widest := 0, -1
+	for c := 0; c < 3; c++ {
+		low, high := 255, 0
+		for _, p := range b.pixels {
+			low = min(low, int(p[c]))
+			high = max(high, int(p[c]))
+		}
+		if high-low > widest {
+			channel, widest = c, high-low
+		}
+	}
+	return channel, widest
+}
+
+func (b colorBox) average() [3]uint8 {
+	var sum [3]int
+	for _, p := range b.pixels {
+		for c := 0; c < 3; c++ {
+			sum[c] += int(p[c])
+		}
+	}
+	n := len(b.pixels)
+	return [3]uint8{uint8((sum[0] + n/2) / n), uint8((sum[1] + n/2) / n), uint8((sum[2] + n/2) / n)}
+}
+
+// Palette returns up to size dominant colors of the image as hex codes,
+// most frequent first. Median cut proposes the colors, look-alikes are
+// merged and every pixel then counts for its closest color, so a plain
+// image yields fewer colors. Transparent pixels and colors covering less
+// than minShare of the image are ignored.
+func Palette(img image.Image, size int) []string {
+	// nearest neighbor keeps the colors, blending would invent new ones
+	sample := Resize(img, paletteSampleSize, draw.NearestNeighbor)
+
+	pixels := make([][3]uint8, 0, len(sample.Pix)/4)
+	for i := 0; i+3 < len(sample.Pix); i += 4 {
+		if sample.Pix[i+3] < 128 {
+			continue
+		}
+		// un-premultiply the alpha of half transparent pixels
+		c := color.NRGBAModel.Convert(color.RGBA{sample.Pix[i], sample.Pix[i+1], sample.Pix[i+2], sample.Pix[i+3]}).(color.NRGBA)
+		pixels = append(pixels, [3]uint8{c.R, c.G, c.B})
+	}
+	if len(pixels) == 0 {
+		return []string{}
+	}
+
+	// split the box with the widest channel until there are enough boxes,
+	// twice as many as needed leaves room for merging look-alikes
+	boxes := []colorBox{{pixels: append([][3]uint8(nil), pixels...)}}
+	for len(boxes) < size*2 {
+		split, widest := -1, 0
+		for i, box := range boxes {
+			if _, spread := box.channelRange(); spread > widest && len(box.pixels) > 1 {
+				split, widest = i, spread
+			}
+		}
+		if split < 0 {
+			break
+		}
+
+		box := boxes[split]
+		channel, _ := box.channelRange()
+		sort.Slice(box.pixels, func(a, b int) bool { return box.pixels[a][channel] < box.pixels[b][channel] })
+		middle := len(box.pixels) / 2
+		boxes[split] = colorBox{pixels: box.pixels[:middle]}
+		boxes = append(boxes, colorBox{pixels: box.pixels[middle:]})
+	}
+
+	candidates := [][3]uint8{}
+	for _, box := range boxes {
+		average := box.average()
+		similar := false
+		for _, c := range candidates {
+			if colorDistance(c, average) < mergeDistance {
+				similar = true
+				break
+			}
+		}
+		if !similar {
+			candidates = append(candidates, average)
+		}
+	}
+
+	// the boxes hold about the same number of pixels, the closest
+	// candidate of every pixel tells how much of the image it covers
+	clusters := make([]colorBox, len(candidates))
+	for _, p := range pixels {
+		closest := 0
+		for i, c := range candidates {
+			if colorDistance(c, p) < colorDistance(candidates[closest], p) {
+				closest = i
+			}
+		}
+		clusters[closest].pixels = append(clusters[closest].pixels, p)
+	}
+	sort.SliceStable(clusters, func(a, b int) bool { return len(clusters[a].pixels) > len(clusters[b].pixels) })
+
+	colors := []string{}
+	for _, cluster := range clusters {
+		if len(colors) == size || float64(len(cluster.pixels)) < minShare*float64(len(pixels)) {
+			break
+		}
+		average := cluster.average()
+		colors = append(colors, fmt.Sprintf("#%02X%02X%02X", average[0], average[1], average[2]))
+	}
+
+	return colors
+}
+
+// colorDistance is the squared euclidean distance of two colors
+func colorDistance(a, b [3]uint8) int {
+	distance := 0
+	for c := 0; c < 3; c++ {
+		d := int(a[c]) - int(b[c])
+		distance += d * d
+	}
+	return distance
+}
@@ -0,0 +1,147 @@
+package media
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/image/draw"
+)
+
+// halves is an image with a left and a right half of different colors
+func halves(width, height int, left, right color.NRGBA) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if x < width/2 {
+				img.Set(x, y, left)
+			} else {
+				img.Set(x, y, right)
+			}
+		}
+	}
+	return img
+}
+
+func encodePNG(t *testing.T, img image.Image) *bytes.Reader {
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+	return bytes.NewReader(buf.Bytes())
+}
+
+func TestPaletteFindsDominantColors(t *testing.T) {
+	red := color.NRGBA{R: 0xFF, A: 0xFF}
+	blue := color.NRGBA{B: 0xFF, A: 0xFF}
+
+	colors := Palette(halves(100, 50, red, blue), PaletteSize)
+	assert.ElementsMatch(t, []string{"#FF0000", "#0000FF"}, colors)
+}
+
+func TestPaletteMergesSimilarColors(t *testing.T) {
+	colors := Palette(halves(100, 50, color.NRGBA{R: 200, A: 0xFF}, color.NRGBA{R: 205, A: 0xFF}), PaletteSize)
+	assert.Len(t, colors, 1)
+}
+
+func TestPaletteSkipsTransparentPixels(t *testing.T) {
+	colors := Palette(halves(100, 50, color.NRGBA{G: 0xFF, A: 0xFF}, color.NRGBA{}), PaletteSize)
+	assert.Equal(t, []string{"#00FF00"}, colors)
+
+	assert.Empty(t, Palette(image.NewNRGBA(image.Rect(0, 0, 10, 10)), PaletteSize))
+}
+
+func TestPaletteOrdersByFrequency(t *testing.T) {
+	img := halves(100, 100, color.NRGBA{R: 0xFF, A: 0xFF}, color.NRGBA{B: 0xFF, A: 0xFF})
+	// a quarter turns green, blue stays the largest part
+	for y := 0; y < 50; y++ {
+		for x := 0; x < 50; x++ {
+			img.Set(x, y, color.NRGBA{G: 0xFF, A: 0xFF})
+		}
+	}
+
+	colors := Palette(img, PaletteSize)
+	require.Len(t, colors, 3)
+	assert.Equal(t, "#0000FF", colors[0])
+}
+
+func TestResizeKeepsAspectRatio(t *testing.T) {
+	resized := Resize(image.NewRGBA(image.Rect(0, 0, 2000, 1000)), 256, draw.ApproxBiLinear)
+	assert.Equal(t, image.Rect(0, 0, 256, 128), resized.Bounds())
+
+	resized = Resize(image.NewRGBA(image.Rect(0, 0, 100, 400)), 256, draw.ApproxBiLinear)
+	assert.Equal(t, image.Rect(0, 0, 64, 256), resized.Bounds())
+
+	// smaller images are never scaled up
+	resized = Resize(image.NewRGBA(image.Rect(0, 0, 100, 50)), 256, draw.ApproxBiLinear)
+	assert.Equal(t, image.Rect(0, 0, 100, 50), resized.Bounds())
+}
+
+func TestProcessImage(t *testing.T) {
+	img := halves(2000, 1000, color.NRGBA{R: 0xFF, A: 0xFF}, color.NRGBA{B: 0xFF, A: 0xFF})
+
+	info, renditions, err := ProcessImage(encodePNG(t, img), "image/png")
+	require.NoError(t, err)
+	assert.Equal(t, 2000, info.Width)
+	assert.Equal(t, 1000, info.Height)
+	assert.ElementsMatch(t, []string{"#FF0000", "#0000FF"}, info.Palette)
+
+	require.Len(t, renditions, len(ImageSizes))
+	for i, rendition := range renditions {
+		assert.Equal(t, ImageSizes[i].Name, rendition.Name)
+		assert.Equal(t, ImageSizes[i].MaxEdge, rendition.Width)
+		assert.Equal(t, ImageSizes[i].MaxEdge/2, rendition.Height)
+		// opaque images become JPEGs
+		assert.Equal(t, "image/jpeg", rendition.Type)
+
+		decoded, format, err := image.Decode(bytes.NewReader(rendition.Data))
+		require.NoError(t, err)
+		assert.Equal(t, "jpeg", format)
+		assert.Equal(t, rendition.Width, decoded.Bounds().Dx())
+	}
+}
+
+func TestProcessImageKeepsTransparency(t *testing.T) {
+	img := halves(40, 20, color.NRGBA{R: 0xFF, A: 0xFF}, color.NRGBA{})
+
+	_, renditions, err := ProcessImage(encodePNG(t, img), "image/png")
+	require.NoError(t, err)
+	for _, rendition := range renditions {
+		assert.Equal(t, "image/png", rendition.Type)
+		assert.Equal(t, 40, rendition.Width)
+	}
+}
+
+func TestProcessImageRejectsInvalidContent(t *testing.T) {
+	_, _, err := ProcessImage(bytes.NewReader([]byte("\x89PNG\r\n\x1a\nbroken")), "image/png")
+	assert.ErrorIs(t, err, ErrInvalid)
+
+	_, _, err = ProcessImage(bytes.NewReader([]byte("<svg/>")), "image/svg+xml")
+	assert.ErrorIs(t, err, ErrInvalid)
+	assert.False(t, CanDecodeImage("image/svg+xml"))
+}
+
+func TestProcessImageRejectsHugeImages(t *testing.T) {
+	// only the header is read, the pixels are never allocated
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, image.NewGray(image.Rect(0, 0, 1, 1))))
+	header := buf.Bytes()
+	// patch the size in the IHDR chunk to 100000x100000
+	copy(header[16:24], []byte{0, 1, 0x86, 0xA0, 0, 1, 0x86, 0xA0})
+
+	_, _, err := ProcessImage(bytes.NewReader(header), "image/png")
+	assert.ErrorIs(t, err, ErrInvalid)
+}
+
+func TestDerivativeKey(t *testing.T) {
+	info := Info{Derivatives: []Derivative{{Name: "thumbnail", Key: DerivativeKey("blobs/sha256/abc/1", "thumbnail")}}}
+
+	derivative, ok := info.Derivative("thumbnail")
+	require.True(t, ok)
+	assert.Equal(t, "blobs/sha256/abc/1.thumbnail", derivative.Key)
+
+	_, ok = info.Derivative("preview")
+	assert.False(t, ok)
+}
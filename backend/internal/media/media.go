@@ -0,0 +1,53 @@
+package media
+
+import (
+	"errors"
+)
+
+// ErrInvalid is returned for files whose content does not match the
+// format they were sniffed as
+var ErrInvalid = errors.New("the file content is not valid")
+
+// Info is what processing learned about a file. It is kept once per
+// content, every asset with the same bytes shares it.
+type Info struct {
+	Image       *ImageInfo   `bson:"image,omitempty"`
+	Audio       *AudioInfo   `bson:"audio,omitempty"`
+	Video       *VideoInfo   `bson:"video,omitempty"`
+	Font        *FontInfo    `bson:"font,omitempty"`
+	Derivatives []Derivative `bson:"derivatives,omitempty"`
+}
+
+// Derivative is a file generated from an asset, e.g. a resized preview
+type Derivative struct {
+	Name   string `bson:"name"`
+	Key    string `bson:"key"`
+	Type   string `bson:"type"`
+	Width  int    `bson:"width,omitempty"`
+	Height int    `bson:"height,omitempty"`
+	Size   int64  `bson:"size"`
+}
+
+// Rendition is a generated file before it is stored
+type Rendition struct {
+	Name   string
+	Type   string
+	Width  int
+	Height int
+	Data   []byte
+}
+
+// DerivativeKey names the blob of a derivative next to its source blob
+func DerivativeKey(sourceKey, name string) string {
+	return sourceKey + "." + name
+}
+
+// Derivative returns the derivative with the given name
+func (i Info) Derivative(name string) (Derivative, bool) {
+	for _, derivative := range i.Derivatives {
+		if derivative.Name == name {
+			return derivative, true
+		}
+	}
+	return Derivative{}, false
+}
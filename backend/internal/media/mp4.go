@@ -0,0 +1,277 @@
+package media
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// box is an ISO base media box with its payload
+type box struct {
+	Type string
+	Data []byte
+}
+
+// readBoxHeader reads the size and type of the next box, the returned size
+// is the one of the payload and negative for a box up to the end of the file
+func readBoxHeader(r io.Reader) (string, int64, error) {
+	var header [8]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return "", 0, err
+	}
+	size := int64(binary.BigEndian.Uint32(header[0:4]))
+	boxType := string(header[4:8])
+
+	switch size {
+	case 0:
+		return boxType, -1, nil
+	case 1:
+		var large [8]byte
+		if _, err := io.ReadFull(r, large[:]); err != nil {
+			return "", 0, err
+		}
+		size = int64(binary.BigEndian.Uint64(large[:])) - 16
+	default:
+		size -= 8
+	}
+	if size < 0 {
+		return "", 0, fmt.Errorf("%w: box %q has an invalid size", ErrInvalid, boxType)
+	}
+	return boxType, size, nil
+}
+
+// children splits the payload of a container box into its boxes
+func children(data []byte) ([]box, error) {
+	boxes := []box{}
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return nil, fmt.Errorf("%w: a box is cut off", ErrInvalid)
+		}
+		size := uint64(binary.BigEndian.Uint32(data[0:4]))
+		boxType := string(data[4:8])
+		header := uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return nil, fmt.Errorf("%w: a box is cut off", ErrInvalid)
+			}
+			size, header = binary.BigEndian.Uint64(data[8:16]), 16
+		}
+		if size < header || size > uint64(len(data)) {
+			return nil, fmt.Errorf("%w: box %q has an invalid size", ErrInvalid, boxType)
+		}
+
+		boxes = append(boxes, box{Type: boxType, Data: data[header:size]})
+		data = data[size:]
+	}
+	return boxes, nil
+}
+
+// child returns the first box of the given type inside a container box
+func child(data []byte, path ...string) ([]byte, bool) {
+	for _, boxType := range path {
+		boxes, err := children(data)
+		if err != nil {
+			return nil, false
+		}
+		found := false
+		for _, b := range boxes {
+			if b.Type == boxType {
+				data, found = b.Data, true
+				break
+			}
+		}
+		if !found {
+			return nil, false
+		}
+	}
+	return data, true
+}
+
+// parseMP4 skips the top level boxes up to the movie box and reads the
+// tracks from it. The movie box may come after the media data.
+func parseMP4(r *bufio.Reader) (VideoInfo, error) {
+	info := VideoInfo{Container: "mp4"}
+	for {
+		boxType, size, err := readBoxHeader(r)
+		if err != nil {
+			return VideoInfo{}, err
+		}
+
+		switch {
+		case boxType == "ftyp" && size >= 4:
+			// only the major brand matters, the compatible ones are skipped
+			var brand [4]byte
+			if _, err := io.ReadFull(r, brand[:]); err != nil {
+				return VideoInfo{}, err
+			}
+			if string(brand[:]) == "qt  " {
+				info.Container = "quicktime"
+			}
+			if _, err := io.CopyN(io.Discard, r, size-4); err != nil {
+				return VideoInfo{}, err
+			}
+		case boxType == "moov":
+			if size < 0 || size > maxHeaderSize {
+				return VideoInfo{}, fmt.Errorf("%w: the movie box is too large", ErrInvalid)
+			}
+			moov := make([]byte, size)
+			if _, err := io.ReadFull(r, moov); err != nil {
+				return VideoInfo{}, err
+			}
+			return parseMoov(moov, info)
+		case size < 0:
+			return VideoInfo{}, fmt.Errorf("%w: the file has no movie box", ErrInvalid)
+		default:
+			if _, err := io.CopyN(io.Discard, r, size); err != nil {
+				return VideoInfo{}, err
+			}
+		}
+	}
+}
+
+// fullBox splits the version off the payload of a full box
+func fullBox(data []byte, minSize int) (byte, []byte, bool) {
+	if len(data) < 4+minSize {
+		return 0, nil, false
+	}
+	return data[0], data[4:], true
+}
+
+// timing reads the timescale and duration of a movie or media header
+func timing(data []byte) (uint32, uint64, bool) {
+	version, body, ok := fullBox(data, 16)
+	if !ok {
+		return 0, 0, false
+	}
+	if version == 1 {
+		if len(body) < 28 {
+			return 0, 0, false
+		}
+		return binary.BigEndian.Uint32(body[16:20]), binary.BigEndian.Uint64(body[20:28]), true
+	}
+	return binary.BigEndian.Uint32(body[8:12]), uint64(binary.BigEndian.Uint32(body[12:16])), true
+}
+
+func parseMoov(moov []byte, info VideoInfo) (VideoInfo, error) {
+	if mvhd, ok := child(moov, "mvhd"); ok {
+		if timescale, duration, ok := timing(mvhd); ok && timescale > 0 && duration != 0xFFFFFFFF {
+			info.Duration = float64(duration) / float64(timescale)
+		}
+	}
+	// fragmented files only know their length from the extends header
+	if mehd, ok := child(moov, "mvex", "mehd"); ok && info.Duration == 0 {
+		if mvhd, ok := child(moov, "mvhd"); ok {
+			timescale, _, _ := timing(mvhd)
+			version, body, ok := fullBox(mehd, 4)
+			if ok && timescale > 0 {
+				duration := uint64(binary.BigEndian.Uint32(body[0:4]))
+				if version == 1 && len(body) >= 8 {
+					duration = binary.BigEndian.Uint64(body[0:8])
+				}
+				info.Duration = float64(duration) / float64(timescale)
+			}
+		}
+	}
+
+	boxes, err := children(moov)
+	if err != nil {
+		return VideoInfo{}, err
+	}
+	for _, trak := range boxes {
+		if trak.Type != "trak" {
+			continue
+		}
+
+		hdlr, ok := child(trak.Data, "mdia", "hdlr")
+		if !ok || len(hdlr) < 12 {
+			continue
+		}
+		handler := string(hdlr[8:12])
+		codec := sampleEntry(trak.Data)
+
+		switch {
+		case handler == "vide" && info.VideoCodec == "":
+			info.VideoCodec = codecName(codec)
+			info.Width, info.Height = trackSize(trak.Data)
+			info.FrameRate = frameRate(trak.Data)
+		case handler == "soun" && info.AudioCodec == "":
+			info.AudioCodec = codecName(codec)
+		}
+	}
+
+	return info, nil
+}
+
+// sampleEntry returns the type of the first sample description of a track
+func sampleEntry(trak []byte) string {
+	stsd, ok := child(trak, "mdia", "minf", "stbl", "stsd")
+	if !ok || len(stsd) < 16 {
+		return ""
+	}
+	return string(stsd[12:16])
+}
+
+// trackSize reads the display size of a track, a quarter turn in the
+// transformation matrix swaps width and height
+func trackSize(trak []byte) (int, int) {
+	tkhd, ok := child(trak, "tkhd")
+	if !ok {
+		return 0, 0
+	}
+	version, body, ok := fullBox(tkhd, 80)
+	if !ok {
+		return 0, 0
+	}
+	// the matrix and size follow the times, which are longer in version 1
+	offset := 36
+	if version == 1 {
+		offset = 48
+	}
+	if len(body) < offset+44 {
+		return 0, 0
+	}
+
+	matrix := body[offset : offset+36]
+	width := int(binary.BigEndian.Uint32(body[offset+36:offset+40]) >> 16)
+	height := int(binary.BigEndian.Uint32(body[offset+40:offset+44]) >> 16)
+	a, d := int32(binary.BigEndian.Uint32(matrix[0:4])), int32(binary.BigEndian.Uint32(matrix[16:20]))
+	if a == 0 && d == 0 {
+		width, height = height, width
+	}
+	return width, height
+}
+
+// frameRate divides the number of samples of a track by its duration
+func frameRate(trak []byte) float64 {
+	mdhd, ok := child(trak, "mdia", "mdhd")
+	if !ok {
+		return 0
+	}
+	timescale, duration, ok := timing(mdhd)
+	if !ok || timescale == 0 || duration == 0 {
+		return 0
+	}
+
+	stts, ok := child(trak, "mdia", "minf", "stbl", "stts")
+	if !ok {
+		return 0
+	}
+	_, body, ok := fullBox(stts, 4)
+	if !ok {
+		return 0
+	}
+	entries := int(binary.BigEndian.Uint32(body[0:4]))
+	if len(body) < 4+entries*8 {
+		return 0
+	}
+	var samples uint64
+	for i := 0; i < entries; i++ {
+		samples += uint64(binary.BigEndian.Uint32(body[4+i*8 : 8+i*8]))
+	}
+
+	return float64(samples) * float64(timescale) / float64(duration)
+}
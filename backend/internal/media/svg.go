@@ -0,0 +1,493 @@
+package media
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// maxSVGSize caps the size of an SVG, before and after sanitizing
+const maxSVGSize = 32 << 20
+
+// maxEntitySize caps the value of an entity declared in the DOCTYPE
+const maxEntitySize = 1024
+
+// maxSVGDepth caps the nesting of elements, browsers stop well before
+const maxSVGDepth = 512
+
+const svgNamespace = "http://www.w3.org/2000/svg"
+
+// Reasons for removing markup from an SVG
+const (
+	SVGScript                = "script"
+	SVGEventHandler          = "eventHandler"
+	SVGForeignContent        = "foreignContent"
+	SVGExternalReference     = "externalReference"
+	SVGDoctype               = "doctype"
+	SVGProcessingInstruction = "processingInstruction"
+)
+
+// SVGChange is one kind of markup SanitizeSVG removed. Identical
+// removals are counted together.
+type SVGChange struct {
+	Reason string
+	// Element is the removed element or the one that lost the attribute,
+	// the target of a processing instruction or empty for the DOCTYPE
+	Element   string
+	Attribute string
+	Count     int
+}
+
+var (
+	// general entities with an inline value, anything else is dropped
+	entityDeclaration = regexp.MustCompile(`<!ENTITY\s+([^\s%"'>]+)\s+("[^"]*"|'[^']*')\s*>`)
+	cssURL            = regexp.MustCompile(`(?i)url\(\s*(?:"([^"]*)"|'([^']*)'|([^)]*))\s*\)`)
+	// css functions that load from a string or run code, they are not
+	// needed for drawing and are never allowed
+	cssLoaders    = regexp.MustCompile(`(?i)(image-set|image|cross-fade|element|src|expression)\s*\(`)
+	cssComment    = regexp.MustCompile(`(?s)/\*.*?\*/`)
+	cssEscape     = regexp.MustCompile(`\\(?:([0-9a-fA-F]{1,6})[ \t\n\r\f]?|(\r\n|[\n\r\f])|(.))`)
+	safeDataImage = regexp.MustCompile(`(?i)^data:image/(png|jpeg|gif|webp)[;,]`)
+	// animations can set any attribute, also handlers and links
+	animationElements = map[string]bool{"set": true, "animate": true}
+	// attributes holding a link, the target has to stay in the document
+	urlAttributes = map[string]bool{"href": true, "src": true, "data": true, "action": true, "formaction": true}
+	// svgElements are the elements kept, by lower case name. Anything
+	// else could be HTML that browsers run when the SVG is inline, like
+	// p or img which break out of the svg element. Scripts, foreignObject
+	// and the old font elements are left out on purpose.
+	svgElements = setOf(
+		"a", "animate", "animatemotion", "animatetransform", "circle", "clippath",
+		"defs", "desc", "ellipse", "feblend", "fecolormatrix", "fecomponenttransfer",
+		"fecomposite", "feconvolvematrix", "fediffuselighting", "fedisplacementmap",
+		"fedistantlight", "fedropshadow", "feflood", "fefunca", "fefuncb", "fefuncg",
+		"fefuncr", "fegaussianblur", "feimage", "femerge", "femergenode",
+		"femorphology", "feoffset", "fepointlight", "fespecularlighting",
+		"fespotlight", "fetile", "feturbulence", "filter", "g", "image", "line",
+		"lineargradient", "marker", "mask", "metadata", "mpath", "path", "pattern",
+		"polygon", "polyline", "radialgradient", "rect", "set", "stop", "style",
+		"svg", "switch", "symbol", "text", "textpath", "title", "tspan", "use", "view",
+	)
+	textEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+)
+
+func setOf(names ...string) map[string]bool {
+	set := make(map[string]bool, len(names))
+	for _, name := range names {
+		set[name] = true
+	}
+	return set
+}
+
+// IsSVG tells whether the MIME type is an SVG, which needs SanitizeSVG
+// before it can be shown in the browser
+func IsSVG(mimeType string) bool {
+	return mimeType == "image/svg+xml"
+}
+
+// SanitizeSVG removes from an SVG what could run or load anything when it
+// is rendered inline: scripts, event handlers, elements that are not SVG,
+// links and style references outside the document, processing
+// instructions and entity declarations. The markup is always written anew, comments are
+// dropped and text escaped, so browsers parsing it as HTML see the same
+// elements as the XML parser did. Markup that does not parse, nests too
+// deep or has no svg root is invalid.
+func SanitizeSVG(r io.Reader) ([]byte, []SVGChange, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxSVGSize+1))
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(data) > maxSVGSize {
+		return nil, nil, fmt.Errorf("%w: the SVG is too large", ErrInvalid)
+	}
+
+	s := newSVGSanitizer(data)
+	if err := s.run(); err != nil {
+		var syntaxError *xml.SyntaxError
+		if errors.As(err, &syntaxError) {
+			return nil, nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+		}
+		return nil, nil, err
+	}
+	return s.out.Bytes(), s.changes, nil
+}
+
+type svgElement struct {
+	name xml.Name
+	// namespaces in scope of the element by prefix, shared with the
+	// parent unless the element declares its own
+	namespaces map[string]string
+}
+
+// changeKey identifies identical removals
+type changeKey struct {
+	reason, element, attribute string
+}
+
+type svgSanitizer struct {
+	decoder *xml.Decoder
+	out     bytes.Buffer
+	changes []SVGChange
+	counted map[changeKey]int
+	stack   []svgElement
+	// depth of the removed element being skipped, zero while writing
+	skipping int
+	// a start tag is written up to its attributes until it is known
+	// whether the element is empty
+	open bool
+	root bool
+	// offset in out of the style element being written, its text is
+	// only checked at the end tag. It is -1 outside of style elements.
+	style     int
+	styleText bytes.Buffer
+}
+
+func newSVGSanitizer(data []byte) *svgSanitizer {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.Entity = map[string]string{}
+	return &svgSanitizer{decoder: decoder, style: -1, counted: map[changeKey]int{}}
+}
+
+// skip drops the tokens inside a removed element
+func (s *svgSanitizer) skip(token xml.Token) error {
+	switch t := token.(type) {
+	case xml.StartElement:
+		if len(s.stack) >= maxSVGDepth {
+			return &xml.SyntaxError{Msg: "elements nest too deep", Line: s.line()}
+		}
+		s.stack = append(s.stack, svgElement{name: t.Name})
+		s.skipping++
+	case xml.EndElement:
+		if s.stack[len(s.stack)-1].name != t.Name {
+			return &xml.SyntaxError{Msg: "unexpected end element </" + qualifiedName(t.Name) + ">", Line: s.line()}
+		}
+		s.stack = s.stack[:len(s.stack)-1]
+		s.skipping--
+	}
+	return nil
+}
+
+func (s *svgSanitizer) run() error {
+	for {
+		token, err := s.decoder.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if s.skipping > 0 {
+			err = s.skip(token)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			err = s.start(t)
+		case xml.EndElement:
+			err = s.end(t)
+		case xml.CharData:
+			s.closeTag()
+			if s.style >= 0 {
+				s.styleText.Write(t)
+			}
+			textEscaper.WriteString(&s.out, string(t))
+		case xml.Comment:
+			// HTML ends comments in places XML does not, they are dropped
+			// rather than risk markup coming out of one
+		case xml.ProcInst:
+			s.procInst(t)
+		case xml.Directive:
+			s.directive(t)
+		}
+		if err != nil {
+			return err
+		}
+		if s.out.Len() > maxSVGSize {
+			return &xml.SyntaxError{Msg: "entities expand beyond the size limit", Line: s.line()}
+		}
+	}
+
+	if !s.root || len(s.stack) > 0 {
+		return &xml.SyntaxError{Msg: "no complete svg element", Line: s.line()}
+	}
+	return nil
+}
+
+func (s *svgSanitizer) line() int {
+	line, _ := s.decoder.InputPos()
+	return line
+}
+
+func (s *svgSanitizer) record(reason, element, attribute string) {
+	key := changeKey{reason, element, attribute}
+	if i, ok := s.counted[key]; ok {
+		s.changes[i].Count++
+		return
+	}
+	s.counted[key] = len(s.changes)
+	s.changes = append(s.changes, SVGChange{Reason: reason, Element: element, Attribute: attribute, Count: 1})
+}
+
+// namespace resolves a prefix in the scope of the current element
+func (s *svgSanitizer) namespace(prefix string) string {
+	if len(s.stack) > 0 {
+		if uri, ok := s.stack[len(s.stack)-1].namespaces[prefix]; ok {
+			return uri
+		}
+	}
+	if prefix == "" {
+		return svgNamespace
+	}
+	return ""
+}
+
+func (s *svgSanitizer) start(t xml.StartElement) error {
+	if len(s.stack) == 0 {
+		if s.root || t.Name.Local != "svg" {
+			return &xml.SyntaxError{Msg: "the root element is not svg", Line: s.line()}
+		}
+		s.root = true
+	}
+
+	if len(s.stack) >= maxSVGDepth {
+		return &xml.SyntaxError{Msg: "elements nest too deep", Line: s.line()}
+	}
+
+	element := svgElement{name: t.Name}
+	if len(s.stack) > 0 {
+		element.namespaces = s.stack[len(s.stack)-1].namespaces
+	}
+	copied := false
+	for _, attr := range t.Attr {
+		prefix := ""
+		switch {
+		case attr.Name.Space == "" && attr.Name.Local == "xmlns":
+		case attr.Name.Space == "xmlns":
+			prefix = attr.Name.Local
+		default:
+			continue
+		}
+		// copy on the first declaration, the parent keeps its scope
+		if !copied {
+			scope := make(map[string]string, len(element.namespaces)+1)
+			for k, v := range element.namespaces {
+				scope[k] = v
+			}
+			element.namespaces, copied = scope, true
+		}
+		element.namespaces[prefix] = attr.Value
+	}
+	s.stack = append(s.stack, element)
+	name := qualifiedName(t.Name)
+	if len(s.stack) == 1 && s.namespace(t.Name.Space) != svgNamespace {
+		return &xml.SyntaxError{Msg: "the root element is not svg", Line: s.line()}
+	}
+
+	if reason := s.removeElement(t); reason != "" {
+		s.record(reason, name, "")
+		s.skipping = 1
+		return nil
+	}
+
+	s.closeTag()
+	if strings.EqualFold(t.Name.Local, "style") {
+		s.style = s.out.Len()
+		s.styleText.Reset()
+	}
+	s.out.WriteString("<" + name)
+	for _, attr := range t.Attr {
+		if reason := removeAttribute(attr); reason != "" {
+			s.record(reason, name, qualifiedName(attr.Name))
+			continue
+		}
+		s.out.WriteString(" " + qualifiedName(attr.Name) + `="`)
+		_ = xml.EscapeText(&s.out, []byte(attr.Value))
+		s.out.WriteString(`"`)
+	}
+	s.open = true
+	return nil
+}
+
+// removeElement returns why the element goes with everything inside it
+func (s *svgSanitizer) removeElement(t xml.StartElement) string {
+	local := strings.ToLower(t.Name.Local)
+	switch {
+	case local == "script" || local == "handler":
+		return SVGScript
+	case s.namespace(t.Name.Space) != svgNamespace || !svgElements[local]:
+		return SVGForeignContent
+	case animationElements[local]:
+		for _, attr := range t.Attr {
+			if attr.Name.Local != "attributeName" {
+				continue
+			}
+			target := strings.ToLower(attr.Value)
+			if i := strings.IndexByte(target, ':'); i >= 0 {
+				target = target[i+1:]
+			}
+			if strings.HasPrefix(target, "on") {
+				return SVGEventHandler
+			}
+			if urlAttributes[target] {
+				return SVGExternalReference
+			}
+		}
+	}
+	return ""
+}
+
+// removeAttribute returns why the attribute is dropped
+func removeAttribute(attr xml.Attr) string {
+	local := strings.ToLower(attr.Name.Local)
+	switch {
+	case attr.Name.Space == "xmlns":
+		// namespace declarations only name things
+	case strings.HasPrefix(local, "on"):
+		return SVGEventHandler
+	case urlAttributes[local] && !localReference(attr.Value):
+		return SVGExternalReference
+	case attr.Name.Space == "xml" && local == "base":
+		return SVGExternalReference
+	case externalCSS(attr.Value):
+		return SVGExternalReference
+	}
+	return ""
+}
+
+// localReference tells whether a link stays within the document, also
+// embedded raster images are fine
+func localReference(value string) bool {
+	value = strings.TrimSpace(value)
+	return strings.HasPrefix(value, "#") || safeDataImage.MatchString(value)
+}
+
+// externalCSS tells whether a style or a presentation attribute loads
+// anything from outside the document. Escapes are decoded first, and the
+// value is checked both with and without comments since either could
+// hide a function name.
+func externalCSS(value string) bool {
+	if !strings.ContainsAny(value, "(@\\") {
+		return false
+	}
+	for _, css := range []string{decodeCSS(value), decodeCSS(cssComment.ReplaceAllString(value, ""))} {
+		if strings.Contains(strings.ToLower(css), "@import") || cssLoaders.MatchString(css) {
+			return true
+		}
+		for _, match := range cssURL.FindAllStringSubmatch(css, -1) {
+			if !localReference(match[1] + match[2] + match[3]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// decodeCSS resolves the escapes of a css value the way browsers do
+func decodeCSS(value string) string {
+	if !strings.Contains(value, "\\") {
+		return value
+	}
+	return cssEscape.ReplaceAllStringFunc(value, func(escape string) string {
+		match := cssEscape.FindStringSubmatch(escape)
+		switch {
+		case match[1] != "":
+			code, _ := strconv.ParseUint(match[1], 16, 32)
+			if code == 0 || code > unicode.MaxRune || (code >= 0xd800 && code <= 0xdfff) {
+				return string(unicode.ReplacementChar)
+			}
+			return string(rune(code))
+		case match[2] != "":
+			return ""
+		default:
+			return match[3]
+		}
+	})
+}
+
+func (s *svgSanitizer) end(t xml.EndElement) error {
+	if len(s.stack) == 0 || s.stack[len(s.stack)-1].name != t.Name {
+		return &xml.SyntaxError{Msg: "unexpected end element </" + qualifiedName(t.Name) + ">", Line: s.line()}
+	}
+	s.stack = s.stack[:len(s.stack)-1]
+
+	// stylesheets that import or point outside the document are removed
+	// entirely, they cannot be cut apart safely
+	if s.style >= 0 && strings.EqualFold(t.Name.Local, "style") {
+		start := s.style
+		s.style = -1
+		if externalCSS(s.styleText.String()) {
+			s.out.Truncate(start)
+			s.open = false
+			s.record(SVGExternalReference, qualifiedName(t.Name), "")
+			return nil
+		}
+	}
+
+	if s.open {
+		s.out.WriteString("/>")
+		s.open = false
+		return nil
+	}
+	s.out.WriteString("</" + qualifiedName(t.Name) + ">")
+	return nil
+}
+
+func (s *svgSanitizer) closeTag() {
+	if s.open {
+		s.out.WriteString(">")
+		s.open = false
+	}
+}
+
+func (s *svgSanitizer) procInst(t xml.ProcInst) {
+	// the declaration only names version and encoding
+	if t.Target == "xml" {
+		s.out.WriteString("<?xml ")
+		s.out.Write(t.Inst)
+		s.out.WriteString("?>")
+		return
+	}
+	s.record(SVGProcessingInstruction, t.Target, "")
+}
+
+// directive keeps a plain DOCTYPE. One with declarations is dropped after
+// its inline entities are taken over, their uses are written out as text.
+func (s *svgSanitizer) directive(t xml.Directive) {
+	if !bytes.HasPrefix(t, []byte("DOCTYPE")) {
+		s.record(SVGDoctype, "", "")
+		return
+	}
+	if !bytes.ContainsRune(t, '[') {
+		s.out.WriteString("<!")
+		s.out.Write(t)
+		s.out.WriteString(">")
+		return
+	}
+
+	for _, match := range entityDeclaration.FindAllSubmatch(t, -1) {
+		value := match[2][1 : len(match[2])-1]
+		if len(value) > maxEntitySize || bytes.ContainsAny(value, "&<") {
+			continue
+		}
+		s.decoder.Entity[string(match[1])] = string(value)
+	}
+	s.record(SVGDoctype, "", "")
+}
+
+func qualifiedName(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	return name.Space + ":" + name.Local
+}
@@ -0,0 +1,221 @@
+package media
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func sanitize(t *testing.T, svg string) (string, []SVGChange) {
+	t.Helper()
+	sanitized, changes, err := SanitizeSVG(strings.NewReader(svg))
+	require.NoError(t, err)
+	return string(sanitized), changes
+}
+
+func TestSanitizeSVGKeepsCleanFiles(t *testing.T) {
+	svg := `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE svg PUBLIC "-//W3C//DTD SVG 1.1//EN" "http://www.w3.org/Graphics/SVG/1.1/DTD/svg11.dtd">
+<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink='http://www.w3.org/1999/xlink' viewBox="0 0 10 10">
+  <!-- a gradient used by reference -->
+  <defs><linearGradient id="g"><stop offset="0" stop-color="#fff"/></linearGradient></defs>
+  <rect width="10" height="10" fill="url(#g)" style="fill: url('#g')"/>
+  <use xlink:href="#g"/>
+  <image href="data:image/png;base64,iVBORw0KGgo="/>
+  <style><![CDATA[ rect > g { fill: red } ]]></style>
+</svg>`
+
+	// written anew, the drawing stays the same
+	sanitized, changes := sanitize(t, svg)
+	assert.Empty(t, changes)
+	assert.Equal(t, `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE svg PUBLIC "-//W3C//DTD SVG 1.1//EN" "http://www.w3.org/Graphics/SVG/1.1/DTD/svg11.dtd">
+<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" viewBox="0 0 10 10">
+  
+  <defs><linearGradient id="g"><stop offset="0" stop-color="#fff"/></linearGradient></defs>
+  <rect width="10" height="10" fill="url(#g)" style="fill: url(&#39;#g&#39;)"/>
+  <use xlink:href="#g"/>
+  <image href="data:image/png;base64,iVBORw0KGgo="/>
+  <style> rect &gt; g { fill: red } </style>
+</svg>`, sanitized)
+}
+
+func TestSanitizeSVGDropsComments(t *testing.T) {
+	// an HTML parser ends the comment at <!--> and would run the image
+	sanitized, changes := sanitize(t, `<svg xmlns="http://www.w3.org/2000/svg"><!--><img src=x onerror=alert(1)>--></svg>`)
+	assert.Equal(t, `<svg xmlns="http://www.w3.org/2000/svg"/>`, sanitized)
+	assert.Empty(t, changes)
+
+	sanitized, _ = sanitize(t, `<svg><text><![CDATA[</text><img src=x onerror=alert(1)>]]></text></svg>`)
+	assert.Equal(t, `<svg><text>&lt;/text&gt;&lt;img src=x onerror=alert(1)&gt;</text></svg>`, sanitized)
+}
+
+func TestSanitizeSVGDecodesCSS(t *testing.T) {
+	for name, style := range map[string]string{
+		"hex escape":     `fill:\75 rl(https://evil/x)`,
+		"char escape":    `fill:u\r\l(https://evil/x)`,
+		"comment":        `fill:url/**/(https://evil/x)`,
+		"image-set":      `fill:image-set('https://evil/x' 1x)`,
+		"webkit":         `fill:-webkit-image-set(url(https://evil/x) 1x)`,
+		"escaped import": `\40 import 'https://evil/x'`,
+	} {
+		sanitized, changes := sanitize(t, `<svg><rect style="`+style+`"/></svg>`)
+		assert.Equal(t, `<svg><rect/></svg>`, sanitized, name)
+		assert.Equal(t, []SVGChange{{Reason: SVGExternalReference, Element: "rect", Attribute: "style", Count: 1}}, changes, name)
+	}
+
+	sanitized, changes := sanitize(t, `<svg><rect style="fill:\75 rl(#g)"/></svg>`)
+	assert.Equal(t, `<svg><rect style="fill:\75 rl(#g)"/></svg>`, sanitized)
+	assert.Empty(t, changes)
+}
+
+func TestSanitizeSVGScopesNamespaces(t *testing.T) {
+	sanitized, changes := sanitize(t, `<svg xmlns="http://www.w3.org/2000/svg" xmlns:s="http://www.w3.org/2000/svg">`+
+		`<g xmlns:s="http://www.w3.org/1999/xhtml"><s:rect/></g><s:rect/></svg>`)
+	assert.Equal(t, `<svg xmlns="http://www.w3.org/2000/svg" xmlns:s="http://www.w3.org/2000/svg">`+
+		`<g xmlns:s="http://www.w3.org/1999/xhtml"/><s:rect/></svg>`, sanitized)
+	assert.Equal(t, []SVGChange{{Reason: SVGForeignContent, Element: "s:rect", Count: 1}}, changes)
+}
+
+func TestSanitizeSVGKeepsOnlySVGElements(t *testing.T) {
+	for name, element := range map[string]string{
+		// HTML start tags like these end the svg element in an HTML parser
+		"iframe in p": `<p><iframe src="javascript:alert(1)"/></p>`,
+		"img":         `<img src="https://evil.example/t.png"/>`,
+		"embed":       `<embed src="https://evil.example/x.swf"/>`,
+		"font":        `<font color="red"><img src="x"/></font>`,
+		"xhtml":       `<div xmlns="http://www.w3.org/1999/xhtml"/>`,
+		"other":       `<x:rect xmlns:x="urn:other"/>`,
+		"undeclared":  `<x:rect/>`,
+	} {
+		sanitized, changes := sanitize(t, `<svg xmlns="http://www.w3.org/2000/svg">`+element+`</svg>`)
+		assert.Equal(t, `<svg xmlns="http://www.w3.org/2000/svg"/>`, sanitized, name)
+		require.Len(t, changes, 1, name)
+		assert.Equal(t, SVGForeignContent, changes[0].Reason, name)
+	}
+
+	// also the case does not matter
+	sanitized, _ := sanitize(t, `<svg><IMG src="x"/><radialGradient/></svg>`)
+	assert.Equal(t, `<svg><radialGradient/></svg>`, sanitized)
+}
+
+func TestSanitizeSVGChecksURLAttributes(t *testing.T) {
+	for _, attribute := range []string{"href", "src", "data", "action", "formaction", "SRC"} {
+		sanitized, changes := sanitize(t, `<svg><image `+attribute+`="https://evil.example/t.png"/></svg>`)
+		assert.Equal(t, `<svg><image/></svg>`, sanitized, attribute)
+		assert.Equal(t, []SVGChange{{Reason: SVGExternalReference, Element: "image", Attribute: attribute, Count: 1}}, changes, attribute)
+	}
+
+	sanitized, changes := sanitize(t, `<svg><image src="#local"/><set attributeName="src" to="https://evil.example/t.png"/></svg>`)
+	assert.Equal(t, `<svg><image src="#local"/></svg>`, sanitized)
+	assert.Equal(t, []SVGChange{{Reason: SVGExternalReference, Element: "set", Count: 1}}, changes)
+}
+
+func TestSanitizeSVGDepth(t *testing.T) {
+	nested := func(depth int) string {
+		return "<svg>" + strings.Repeat("<g>", depth-1) + strings.Repeat("</g>", depth-1) + "</svg>"
+	}
+	_, _, err := SanitizeSVG(strings.NewReader(nested(maxSVGDepth)))
+	assert.NoError(t, err)
+
+	_, _, err = SanitizeSVG(strings.NewReader(nested(maxSVGDepth + 1)))
+	assert.ErrorIs(t, err, ErrInvalid)
+
+	// also inside a removed element
+	svg := "<svg><script>" + strings.Repeat("<g>", maxSVGDepth) + strings.Repeat("</g>", maxSVGDepth) + "</script></svg>"
+	_, _, err = SanitizeSVG(strings.NewReader(svg))
+	assert.ErrorIs(t, err, ErrInvalid)
+}
+
+func TestSanitizeSVG(t *testing.T) {
+	sanitized, changes := sanitize(t, `<?xml version="1.0"?>
+<?xml-stylesheet href="https://evil.example/style.css"?>
+<svg xmlns="http://www.w3.org/2000/svg" xmlns:x="http://www.w3.org/1999/xlink" onload="alert(1)">
+<script>alert(2)</script>
+<g onclick="alert(3)" ONMOUSEOVER="alert(4)"><rect onclick="alert(5)"/></g>
+<foreignObject><div xmlns="http://www.w3.org/1999/xhtml"><script>alert(6)</script></div></foreignObject>
+<a href="javascript:alert(7)"><text>click</text></a>
+<use x:href="https://evil.example/sprite.svg#icon"/>
+<rect fill="url(https://evil.example/tracker)" style="fill: url( 'https://evil.example/tracker' )"/>
+<animate attributeName="href" to="javascript:alert(8)"/>
+<set attributeName="onmouseover" to="alert(9)"/>
+<style>@import url(https://evil.example/more.css);</style>
+<style>rect { fill: blue }</style>
+</svg>`)
+
+	assert.Equal(t, `<?xml version="1.0"?>
+
+<svg xmlns="http://www.w3.org/2000/svg" xmlns:x="http://www.w3.org/1999/xlink">
+
+<g><rect/></g>
+
+<a><text>click</text></a>
+<use/>
+<rect/>
+
+
+
+<style>rect { fill: blue }</style>
+</svg>`, sanitized)
+
+	assert.Equal(t, []SVGChange{
+		{Reason: SVGProcessingInstruction, Element: "xml-stylesheet", Count: 1},
+		{Reason: SVGEventHandler, Element: "svg", Attribute: "onload", Count: 1},
+		{Reason: SVGScript, Element: "script", Count: 1},
+		{Reason: SVGEventHandler, Element: "g", Attribute: "onclick", Count: 1},
+		{Reason: SVGEventHandler, Element: "g", Attribute: "ONMOUSEOVER", Count: 1},
+		{Reason: SVGEventHandler, Element: "rect", Attribute: "onclick", Count: 1},
+		{Reason: SVGForeignContent, Element: "foreignObject", Count: 1},
+		{Reason: SVGExternalReference, Element: "a", Attribute: "href", Count: 1},
+		{Reason: SVGExternalReference, Element: "use", Attribute: "x:href", Count: 1},
+		{Reason: SVGExternalReference, Element: "rect", Attribute: "fill", Count: 1},
+		{Reason: SVGExternalReference, Element: "rect", Attribute: "style", Count: 1},
+		{Reason: SVGExternalReference, Element: "animate", Count: 1},
+		{Reason: SVGEventHandler, Element: "set", Count: 1},
+		{Reason: SVGExternalReference, Element: "style", Count: 1},
+	}, changes)
+}
+
+func TestSanitizeSVGCountsRepeatedChanges(t *testing.T) {
+	_, changes := sanitize(t, `<svg><rect onclick="a()"/><rect onclick="b()"/><rect onclick="c()"/></svg>`)
+	assert.Equal(t, []SVGChange{{Reason: SVGEventHandler, Element: "rect", Attribute: "onclick", Count: 3}}, changes)
+}
+
+func TestSanitizeSVGExpandsEntities(t *testing.T) {
+	// the way Illustrator declares its namespaces, the markup entity is
+	// not taken over and must not turn into elements
+	sanitized, changes := sanitize(t, `<!DOCTYPE svg [
+  <!ENTITY ns_svg "http://www.w3.org/2000/svg">
+  <!ENTITY ext SYSTEM "file:///etc/passwd">
+  <!ENTITY markup "<script>alert(1)</script>">
+]>
+<svg xmlns="&ns_svg;"><text>&amp; done</text></svg>`)
+
+	assert.Equal(t, "\n"+`<svg xmlns="http://www.w3.org/2000/svg"><text>&amp; done</text></svg>`, sanitized)
+	assert.Equal(t, []SVGChange{{Reason: SVGDoctype, Count: 1}}, changes)
+
+	for name, svg := range map[string]string{
+		"external": `<!DOCTYPE svg [<!ENTITY ext SYSTEM "file:///etc/passwd">]><svg>&ext;</svg>`,
+		"markup":   `<!DOCTYPE svg [<!ENTITY markup "<script>alert(1)</script>">]><svg>&markup;</svg>`,
+	} {
+		_, _, err := SanitizeSVG(strings.NewReader(svg))
+		assert.ErrorIs(t, err, ErrInvalid, name)
+	}
+}
+
+func TestSanitizeSVGInvalid(t *testing.T) {
+	for name, svg := range map[string]string{
+		"html":       `<html><svg></svg></html>`,
+		"xhtml root": `<svg xmlns="http://www.w3.org/1999/xhtml"></svg>`,
+		"unclosed":   `<svg><g></svg>`,
+		"truncated":  `<svg><rect/>`,
+		"two roots":  `<svg></svg><svg></svg>`,
+		"mismatched": `<svg><script><a></b></script></svg>`,
+		"empty":      ``,
+	} {
+		_, _, err := SanitizeSVG(strings.NewReader(svg))
+		assert.ErrorIs(t, err, ErrInvalid, name)
+	}
+}
@@ -0,0 +1,122 @@
+package media
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// maxHeaderSize caps the container headers read into memory, the sample
+// tables of very long MP4s are the largest
+const maxHeaderSize = 64 << 20
+
+// VideoInfo describes the container headers of a video
+type VideoInfo struct {
+	// Container is the file format, "mp4", "quicktime", "webm" or "matroska"
+	Container string `bson:"container"`
+	// Duration is the playing time in seconds
+	Duration float64 `bson:"duration"`
+	// Width and Height are the display size, rotation applied
+	Width     int     `bson:"width"`
+	Height    int     `bson:"height"`
+	FrameRate float64 `bson:"frameRate,omitempty"`
+	// VideoCodec and AudioCodec are short names like "h264" or "opus", the
+	// sample entry or codec id for codecs without one
+	VideoCodec string `bson:"videoCodec"`
+	AudioCodec string `bson:"audioCodec,omitempty"`
+}
+
+// renderableCodecs can be decoded by the browser the renderer runs in
+var renderableCodecs = map[string]bool{
+	"h264": true,
+	"vp8":  true,
+	"vp9":  true,
+	"av1":  true,
+}
+
+// CanRender tells whether the renderer can decode the video codec
+func CanRender(videoCodec string) bool {
+	return renderableCodecs[videoCodec]
+}
+
+var videoParsers = map[string]func(*bufio.Reader) (VideoInfo, error){
+	"video/mp4":        parseMP4,
+	"video/quicktime":  parseMP4,
+	"video/webm":       parseWebM,
+	"video/x-matroska": parseWebM,
+}
+
+// CanProbeVideo tells whether ProcessVideo understands the MIME type
+func CanProbeVideo(mimeType string) bool {
+	_, ok := videoParsers[mimeType]
+	return ok
+}
+
+// ProcessVideo reads the container headers of an MP4, QuickTime, WebM or
+// Matroska file. Nothing is decoded, the media data is skipped.
+func ProcessVideo(r io.Reader, mimeType string) (VideoInfo, error) {
+	parse, ok := videoParsers[mimeType]
+	if !ok {
+		return VideoInfo{}, fmt.Errorf("%w: cannot probe %s", ErrInvalid, mimeType)
+	}
+
+	info, err := parse(bufio.NewReaderSize(r, 64<<10))
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return VideoInfo{}, fmt.Errorf("%w: the file is truncated", ErrInvalid)
+	}
+	if err != nil {
+		return VideoInfo{}, err
+	}
+	if info.VideoCodec == "" {
+		return VideoInfo{}, fmt.Errorf("%w: the file has no video track", ErrInvalid)
+	}
+
+	info.Duration = math.Round(info.Duration*1000) / 1000
+	info.FrameRate = math.Round(info.FrameRate*1000) / 1000
+	return info, nil
+}
+
+// codecNames maps MP4 sample entries and Matroska codec ids to short names
+var codecNames = map[string]string{
+	"avc1":             "h264",
+	"avc3":             "h264",
+	"hvc1":             "h265",
+	"hev1":             "h265",
+	"av01":             "av1",
+	"vp08":             "vp8",
+	"vp09":             "vp9",
+	"mp4v":             "mpeg4",
+	"apch":             "prores",
+	"apcn":             "prores",
+	"apcs":             "prores",
+	"apco":             "prores",
+	"ap4h":             "prores",
+	"mp4a":             "aac",
+	"Opus":             "opus",
+	"fLaC":             "flac",
+	"ac-3":             "ac3",
+	"ec-3":             "eac3",
+	".mp3":             "mp3",
+	"V_VP8":            "vp8",
+	"V_VP9":            "vp9",
+	"V_AV1":            "av1",
+	"V_MPEG4/ISO/AVC":  "h264",
+	"V_MPEGH/ISO/HEVC": "h265",
+	"V_THEORA":         "theora",
+	"A_OPUS":           "opus",
+	"A_VORBIS":         "vorbis",
+	"A_AAC":            "aac",
+	"A_FLAC":           "flac",
+	"A_MPEG/L3":        "mp3",
+	"A_AC3":            "ac3",
+}
+
+// codecName returns the short name of a codec, unknown ones keep their id
+func codecName(id string) string {
+	if name, ok := codecNames[id]; ok {
+		return name
+	}
+	return id
+}
@@ -0,0 +1,212 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mp4Box(boxType string, payload ...[]byte) []byte {
+	body := bytes.Join(payload, nil)
+	out := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+	return append(append(out, boxType...), body...)
+}
+
+func u32(values ...uint32) []byte {
+	var out []byte
+	for _, v := range values {
+		out = binary.BigEndian.AppendUint32(out, v)
+	}
+	return out
+}
+
+// mp4Track builds a track with a version 0 track header, matrix holds a, b, c, d
+func mp4Track(handler, entry string, matrix [4]int32, width, height uint32, timescale, duration uint32, samples, delta uint32) []byte {
+	tkhd := u32(0, 0, 0, 1, 0, duration, 0, 0, 0, 0)
+	tkhd = append(tkhd, u32(uint32(matrix[0]), uint32(matrix[1]), 0, uint32(matrix[2]), uint32(matrix[3]), 0, 0, 0, 0x40000000)...)
+	tkhd = append(tkhd, u32(width<<16, height<<16)...)
+
+	return mp4Box("trak",
+		mp4Box("tkhd", tkhd),
+		mp4Box("mdia",
+			mp4Box("mdhd", u32(0, 0, 0, timescale, duration, 0)),
+			mp4Box("hdlr", u32(0, 0), []byte(handler), u32(0, 0, 0), []byte("name\x00")),
+			mp4Box("minf",
+				mp4Box("stbl",
+					mp4Box("stsd", u32(0, 1), mp4Box(entry, make([]byte, 78))),
+					mp4Box("stts", u32(0, 1, samples, delta)),
+				),
+			),
+		),
+	)
+}
+
+var identity = [4]int32{0x10000, 0, 0, 0x10000}
+
+func TestProcessVideoMP4(t *testing.T) {
+	file := bytes.Join([][]byte{
+		mp4Box("ftyp", []byte("isom"), u32(0x200), []byte("isomiso2avc1mp41")),
+		// the media data comes first, the movie box is read after it
+		mp4Box("mdat", make([]byte, 4096)),
+		mp4Box("moov",
+			mp4Box("mvhd", u32(0, 0, 0, 1000, 10010), make([]byte, 80)),
+			mp4Track("soun", "mp4a", identity, 0, 0, 48000, 480480, 470, 1024),
+			mp4Track("vide", "avc1", identity, 1920, 1080, 30000, 300300, 300, 1001),
+		),
+	}, nil)
+
+	info, err := ProcessVideo(bytes.NewReader(file), "video/mp4")
+	require.NoError(t, err)
+	assert.Equal(t, VideoInfo{
+		Container:  "mp4",
+		Duration:   10.01,
+		Width:      1920,
+		Height:     1080,
+		FrameRate:  29.97,
+		VideoCodec: "h264",
+		AudioCodec: "aac",
+	}, info)
+	assert.True(t, CanRender(info.VideoCodec))
+}
+
+func TestProcessVideoRotatedQuickTime(t *testing.T) {
+	file := bytes.Join([][]byte{
+		mp4Box("ftyp", []byte("qt  "), u32(0), []byte("qt  ")),
+		mp4Box("moov",
+			mp4Box("mvhd", u32(0, 0, 0, 600, 1200), make([]byte, 80)),
+			mp4Track("vide", "hvc1", [4]int32{0, 0x10000, -0x10000, 0}, 1920, 1080, 600, 1200, 60, 20),
+		),
+		mp4Box("mdat", make([]byte, 16)),
+	}, nil)
+
+	info, err := ProcessVideo(bytes.NewReader(file), "video/quicktime")
+	require.NoError(t, err)
+	assert.Equal(t, "quicktime", info.Container)
+	assert.Equal(t, 2.0, info.Duration)
+	assert.Equal(t, 1080, info.Width)
+	assert.Equal(t, 1920, info.Height)
+	assert.Equal(t, 30.0, info.FrameRate)
+	assert.Equal(t, "h265", info.VideoCodec)
+	assert.Empty(t, info.AudioCodec)
+	assert.False(t, CanRender(info.VideoCodec))
+}
+
+func TestProcessVideoRejectsInvalidMP4(t *testing.T) {
+	audioOnly := bytes.Join([][]byte{
+		mp4Box("ftyp", []byte("M4A "), u32(0)),
+		mp4Box("moov",
+			mp4Box("mvhd", u32(0, 0, 0, 1000, 1000), make([]byte, 80)),
+			mp4Track("soun", "mp4a", identity, 0, 0, 44100, 44100, 43, 1024),
+		),
+	}, nil)
+
+	for name, file := range map[string][]byte{
+		"no movie box": mp4Box("ftyp", []byte("isom"), u32(0)),
+		"truncated":    mp4Box("moov", make([]byte, 100))[:50],
+		"bad size":     append(u32(4), []byte("moov")...),
+		"huge ftyp":    []byte("\xff\xff\xff\xf0ftypisom"),
+		"audio only":   audioOnly,
+	} {
+		_, err := ProcessVideo(bytes.NewReader(file), "video/mp4")
+		assert.ErrorIs(t, err, ErrInvalid, name)
+	}
+}
+
+// ebml encodes an element, ids are written with their marker
+func ebml(id uint32, payload ...[]byte) []byte {
+	body := bytes.Join(payload, nil)
+	var out []byte
+	for shift := 24; shift >= 0; shift -= 8 {
+		if b := byte(id >> shift); b != 0 || len(out) > 0 {
+			out = append(out, b)
+		}
+	}
+	if len(body) < 127 {
+		out = append(out, 0x80|byte(len(body)))
+	} else {
+		out = append(out, 0x01)
+		out = append(out, binary.BigEndian.AppendUint64(nil, uint64(len(body)))[1:]...)
+	}
+	return append(out, body...)
+}
+
+func ebmlUintBytes(v uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, v)
+}
+
+func TestProcessVideoWebM(t *testing.T) {
+	file := bytes.Join([][]byte{
+		ebml(ebmlHeader, ebml(ebmlDocType, []byte("webm"))),
+		// the segment of live recordings has an unknown size
+		{0x18, 0x53, 0x80, 0x67, 0x01, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF},
+		ebml(0xEC, make([]byte, 200)),
+		ebml(mkvInfo,
+			ebml(mkvTimecodeScale, ebmlUintBytes(1_000_000)),
+			ebml(mkvDuration, binary.BigEndian.AppendUint64(nil, math.Float64bits(5000))),
+		),
+		ebml(mkvTracks,
+			ebml(mkvTrackEntry,
+				ebml(mkvTrackType, []byte{mkvTrackAudio}),
+				ebml(mkvCodecID, []byte("A_OPUS")),
+			),
+			ebml(mkvTrackEntry,
+				ebml(mkvTrackType, []byte{mkvTrackVideo}),
+				ebml(mkvCodecID, []byte("V_VP9")),
+				ebml(mkvDefaultDuration, ebmlUintBytes(33_333_333)),
+				ebml(mkvVideo,
+					ebml(mkvPixelWidth, []byte{0x05, 0x00}),
+					ebml(mkvPixelHeight, []byte{0x02, 0xD0}),
+				),
+			),
+		),
+		ebml(mkvCluster, make([]byte, 64)),
+	}, nil)
+
+	info, err := ProcessVideo(bytes.NewReader(file), "video/webm")
+	require.NoError(t, err)
+	assert.Equal(t, VideoInfo{
+		Container:  "webm",
+		Duration:   5,
+		Width:      1280,
+		Height:     720,
+		FrameRate:  30,
+		VideoCodec: "vp9",
+		AudioCodec: "opus",
+	}, info)
+}
+
+func TestProcessVideoMatroskaWithoutDuration(t *testing.T) {
+	file := bytes.Join([][]byte{
+		ebml(ebmlHeader, ebml(ebmlDocType, []byte("matroska"))),
+		ebml(mkvSegment,
+			ebml(mkvTracks,
+				ebml(mkvTrackEntry,
+					ebml(mkvTrackType, []byte{mkvTrackVideo}),
+					ebml(mkvCodecID, []byte("V_MPEG4/ISO/AVC")),
+					ebml(mkvVideo, ebml(mkvPixelWidth, []byte{64}), ebml(mkvPixelHeight, []byte{48})),
+				),
+			),
+		),
+	}, nil)
+
+	info, err := ProcessVideo(bytes.NewReader(file), "video/webm")
+	require.NoError(t, err)
+	assert.Equal(t, "matroska", info.Container)
+	assert.Equal(t, "h264", info.VideoCodec)
+	assert.Zero(t, info.Duration)
+	assert.Zero(t, info.FrameRate)
+	assert.Equal(t, 64, info.Width)
+}
+
+func TestProcessVideoRejectsInvalidWebM(t *testing.T) {
+	_, err := ProcessVideo(bytes.NewReader([]byte("RIFF....AVI ")), "video/webm")
+	assert.ErrorIs(t, err, ErrInvalid)
+
+	_, err = ProcessVideo(bytes.NewReader(ebml(ebmlHeader, ebml(ebmlDocType, []byte("webm")))), "video/webm")
+	assert.ErrorIs(t, err, ErrInvalid)
+	assert.False(t, CanProbeVideo("video/x-msvideo"))
+}
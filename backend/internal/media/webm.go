@@ -0,0 +1,290 @@
+package media
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+)
+
+// Matroska element ids, WebM is a subset of Matroska
+const (
+	ebmlHeader         = 0x1A45DFA3
+	ebmlDocType        = 0x4282
+	mkvSegment         = 0x18538067
+	mkvInfo            = 0x1549A966
+	mkvTimecodeScale   = 0x2AD7B1
+	mkvDuration        = 0x4489
+	mkvTracks          = 0x1654AE6B
+	mkvTrackEntry      = 0xAE
+	mkvTrackType       = 0x83
+	mkvCodecID         = 0x86
+	mkvDefaultDuration = 0x23E383
+	mkvVideo           = 0xE0
+	mkvPixelWidth      = 0xB0
+	mkvPixelHeight     = 0xBA
+	mkvCluster         = 0x1F43B675
+
+	mkvTrackVideo = 1
+	mkvTrackAudio = 2
+)
+
+// unknownSize marks elements that run until their parent ends
+const unknownSize = -1
+
+// element is a Matroska element with its payload
+type element struct {
+	ID   uint32
+	Data []byte
+}
+
+// readVint reads a variable length integer of EBML, ids keep their length
+// marker and sizes drop it
+func readVint(r io.ByteReader, maxLength int, keepMarker bool) (uint64, int, error) {
+	first, err := r.ReadByte()
+	if err != nil {
+		return 0, 0, err
+	}
+	length := bits.LeadingZeros8(first) + 1
+	if length > maxLength {
+		return 0, 0, fmt.Errorf("%w: invalid EBML number", ErrInvalid)
+	}
+
+	value := uint64(first)
+	if !keepMarker {
+		value &= 0xFF >> length
+	}
+	for i := 1; i < length; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, 0, err
+		}
+		value = value<<8 | uint64(b)
+	}
+	return value, length, nil
+}
+
+// readElementHeader reads the id and payload size of the next element
+func readElementHeader(r io.ByteReader) (uint32, int64, error) {
+	id, _, err := readVint(r, 4, true)
+	if err != nil {
+		return 0, 0, err
+	}
+	size, length, err := readVint(r, 8, false)
+	if err != nil {
+		return 0, 0, err
+	}
+	// a size of all ones is unknown
+	if size == 1<<(7*length)-1 {
+		return uint32(id), unknownSize, nil
+	}
+	if size > math.MaxInt64 {
+		return 0, 0, fmt.Errorf("%w: invalid EBML size", ErrInvalid)
+	}
+	return uint32(id), int64(size), nil
+}
+
+// elements splits the payload of a master element into its children
+func elements(data []byte) ([]element, error) {
+	list := []element{}
+	r := &byteSlice{data: data}
+	for r.pos < len(data) {
+		id, size, err := readElementHeader(r)
+		if err != nil {
+			return nil, fmt.Errorf("%w: an element is cut off", ErrInvalid)
+		}
+		if size == unknownSize || size > int64(len(data)-r.pos) {
+			size = int64(len(data) - r.pos)
+		}
+		list = append(list, element{ID: id, Data: data[r.pos : r.pos+int(size)]})
+		r.pos += int(size)
+	}
+	return list, nil
+}
+
+type byteSlice struct {
+	data []byte
+	pos  int
+}
+
+func (b *byteSlice) ReadByte() (byte, error) {
+	if b.pos >= len(b.data) {
+		return 0, io.ErrUnexpectedEOF
+	}
+	b.pos++
+	return b.data[b.pos-1], nil
+}
+
+func ebmlUint(data []byte) uint64 {
+	var value uint64
+	for _, b := range data {
+		value = value<<8 | uint64(b)
+	}
+	return value
+}
+
+func ebmlFloat(data []byte) float64 {
+	switch len(data) {
+	case 4:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(data)))
+	case 8:
+		return math.Float64frombits(binary.BigEndian.Uint64(data))
+	}
+	return 0
+}
+
+// readElement reads the payload of a master element into memory
+func readElement(r io.Reader, size int64) ([]byte, error) {
+	if size == unknownSize || size > maxHeaderSize {
+		return nil, fmt.Errorf("%w: a header element is too large", ErrInvalid)
+	}
+	data := make([]byte, size)
+	_, err := io.ReadFull(r, data)
+	return data, err
+}
+
+// parseWebM reads the segment information and tracks, which muxers write
+// before the first cluster of frames
+func parseWebM(r *bufio.Reader) (VideoInfo, error) {
+	id, size, err := readElementHeader(r)
+	if err != nil {
+		return VideoInfo{}, err
+	}
+	if id != ebmlHeader {
+		return VideoInfo{}, fmt.Errorf("%w: not an EBML file", ErrInvalid)
+	}
+	header, err := readElement(r, size)
+	if err != nil {
+		return VideoInfo{}, err
+	}
+	info := VideoInfo{Container: "matroska"}
+	children, err := elements(header)
+	if err != nil {
+		return VideoInfo{}, err
+	}
+	for _, e := range children {
+		if e.ID == ebmlDocType && string(e.Data) == "webm" {
+			info.Container = "webm"
+		}
+	}
+
+	id, _, err = readElementHeader(r)
+	if err != nil {
+		return VideoInfo{}, err
+	}
+	if id != mkvSegment {
+		return VideoInfo{}, fmt.Errorf("%w: the file has no segment", ErrInvalid)
+	}
+
+	seenInfo, seenTracks := false, false
+	for !seenInfo || !seenTracks {
+		id, size, err := readElementHeader(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return VideoInfo{}, err
+		}
+
+		switch id {
+		case mkvInfo:
+			data, err := readElement(r, size)
+			if err != nil {
+				return VideoInfo{}, err
+			}
+			parseSegmentInfo(data, &info)
+			seenInfo = true
+		case mkvTracks:
+			data, err := readElement(r, size)
+			if err != nil {
+				return VideoInfo{}, err
+			}
+			parseTracks(data, &info)
+			seenTracks = true
+		case mkvCluster:
+			// the headers are over once the frames start
+			return info, nil
+		default:
+			if size == unknownSize {
+				return info, nil
+			}
+			if _, err := io.CopyN(io.Discard, r, size); err != nil {
+				return VideoInfo{}, err
+			}
+		}
+	}
+
+	return info, nil
+}
+
+func parseSegmentInfo(data []byte, info *VideoInfo) {
+	children, err := elements(data)
+	if err != nil {
+		return
+	}
+	// durations count in units of the timecode scale, a millisecond by default
+	scale, duration := uint64(1_000_000), 0.0
+	for _, e := range children {
+		switch e.ID {
+		case mkvTimecodeScale:
+			scale = ebmlUint(e.Data)
+		case mkvDuration:
+			duration = ebmlFloat(e.Data)
+		}
+	}
+	info.Duration = duration * float64(scale) / 1e9
+}
+
+func parseTracks(data []byte, info *VideoInfo) {
+	entries, err := elements(data)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if entry.ID != mkvTrackEntry {
+			continue
+		}
+		fields, err := elements(entry.Data)
+		if err != nil {
+			continue
+		}
+
+		var trackType, defaultDuration uint64
+		var codec string
+		var video []byte
+		for _, field := range fields {
+			switch field.ID {
+			case mkvTrackType:
+				trackType = ebmlUint(field.Data)
+			case mkvCodecID:
+				codec = string(field.Data)
+			case mkvDefaultDuration:
+				defaultDuration = ebmlUint(field.Data)
+			case mkvVideo:
+				video = field.Data
+			}
+		}
+
+		switch {
+		case trackType == mkvTrackVideo && info.VideoCodec == "":
+			info.VideoCodec = codecName(codec)
+			// the default duration of a frame is in nanoseconds
+			if defaultDuration > 0 {
+				info.FrameRate = 1e9 / float64(defaultDuration)
+			}
+			settings, _ := elements(video)
+			for _, setting := range settings {
+				switch setting.ID {
+				case mkvPixelWidth:
+					info.Width = int(ebmlUint(setting.Data))
+				case mkvPixelHeight:
+					info.Height = int(ebmlUint(setting.Data))
+				}
+			}
+		case trackType == mkvTrackAudio && info.AudioCodec == "":
+			info.AudioCodec = codecName(codec)
+		}
+	}
+}
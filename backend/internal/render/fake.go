@@ -0,0 +1,64 @@
+package render
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+)
+
+// fakeSteps are the progress reports of a fake render
+var fakeSteps = []Progress{
+	{Preparing, 0},
+	{Bundling, 10},
+	{Rendering, 10},
+	{Rendering, 30},
+	{Rendering, 50},
+	{Rendering, 70},
+	{Encoding, 80},
+	{Encoding, 100},
+}
+
+// FakeRenderer is an offline renderer for development and tests. It walks
+// through the stages without a browser and writes a placeholder instead
+// of a video, the duration is the one of the compositions.
+type FakeRenderer struct {
+	// Step is the pause between two progress reports
+	Step time.Duration
+}
+
+func (f FakeRenderer) Render(ctx context.Context, req Request, progress func(Progress)) (float64, error) {
+	frames, err := Frames(req.Compositions)
+	if err != nil {
+		return 0, err
+	}
+	if _, ok := scales[req.Quality]; !ok {
+		return 0, fmt.Errorf("unknown quality %q", req.Quality)
+	}
+	if _, ok := codecs[req.Format]; !ok {
+		return 0, fmt.Errorf("unknown format %q", req.Format)
+	}
+
+	for i, step := range fakeSteps {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return 0, ctx.Err()
+			case <-time.After(f.Step):
+			}
+		}
+
+		// the file is there once the render reports it is done
+		if i == len(fakeSteps)-1 {
+			placeholder := fmt.Sprintf("placeholder %s %s video of %d frames\n", req.Quality, req.Format, frames)
+			if err := os.WriteFile(req.Output, []byte(placeholder), 0o644); err != nil {
+				return 0, err
+			}
+		}
+		if progress != nil {
+			progress(step)
+		}
+	}
+
+	return float64(frames) / FPS, nil
+}
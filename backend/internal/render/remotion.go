@@ -0,0 +1,231 @@
+package render
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Pieli/server/internal/exports"
+	"github.com/Pieli/server/internal/media"
+)
+
+const (
+	// DefaultRemotionEntry is the file of the frontend that registers the
+	// remotion compositions
+	DefaultRemotionEntry = "src/remotion-lib/index.ts"
+	// DefaultRemotionComposition is the id the entry registers the stored
+	// sequence under, it hydrates the compositions passed as comps and
+	// plays them one after another
+	DefaultRemotionComposition = "SequenceBuilder"
+	// remotionLogLines is how much of the cli output an error carries
+	remotionLogLines = 20
+)
+
+// stageRanges places the progress of each stage within the whole render
+var stageRanges = map[Stage][2]int{
+	Bundling:  {0, 10},
+	Rendering: {10, 80},
+	Encoding:  {80, 100},
+}
+
+var (
+	fractionPattern = regexp.MustCompile(`(\d+)\s*/\s*(\d+)`)
+	percentPattern  = regexp.MustCompile(`(\d+(?:\.\d+)?)\s*%`)
+)
+
+// RemotionRenderer renders with the remotion cli of the frontend, the
+// command is the one the render modal lets users copy. The compositions
+// are handed over as the comps input prop.
+type RemotionRenderer struct {
+	// Dir is the frontend directory, its node_modules provide the cli
+	Dir         string
+	Entry       string
+	Composition string
+}
+
+// NewRemotionRenderer falls back to the defaults for empty values
+func NewRemotionRenderer(dir, entry, composition string) *RemotionRenderer {
+	if entry == "" {
+		entry = DefaultRemotionEntry
+	}
+	if composition == "" {
+		composition = DefaultRemotionComposition
+	}
+	return &RemotionRenderer{Dir: dir, Entry: entry, Composition: composition}
+}
+
+// Args returns the arguments of npx rendering the request with the input
+// props in propsFile
+func (r *RemotionRenderer) Args(req Request, propsFile string) ([]string, error) {
+	scale, ok := scales[req.Quality]
+	if !ok {
+		return nil, fmt.Errorf("unknown quality %q", req.Quality)
+	}
+	codec, ok := codecs[req.Format]
+	if !ok {
+		return nil, fmt.Errorf("unknown format %q", req.Format)
+	}
+
+	return []string{
+		"remotion", "render", r.Entry, r.Composition, req.Output,
+		"--props=" + propsFile,
+		"--codec=" + codec,
+		"--scale=" + strconv.FormatFloat(scale, 'f', -1, 64),
+		"--overwrite",
+	}, nil
+}
+
+func (r *RemotionRenderer) Render(ctx context.Context, req Request, progress func(Progress)) (float64, error) {
+	if _, err := Frames(req.Compositions); err != nil {
+		return 0, err
+	}
+	if progress != nil {
+		progress(Progress{Preparing, 0})
+	}
+
+	props, err := json.Marshal(map[string]json.RawMessage{"comps": req.Compositions})
+	if err != nil {
+		return 0, err
+	}
+	propsFile := req.Output + ".props.json"
+	if err := os.WriteFile(propsFile, props, 0o600); err != nil {
+		return 0, err
+	}
+	defer os.Remove(propsFile)
+
+	args, err := r.Args(req, propsFile)
+	if err != nil {
+		return 0, err
+	}
+
+	cmd := exec.CommandContext(ctx, "npx", args...)
+	cmd.Dir = r.Dir
+	cmd.WaitDelay = 10 * time.Second
+	output, err := cmd.StdoutPipe()
+	if err != nil {
+		return 0, err
+	}
+	cmd.Stderr = cmd.Stdout
+	if err := cmd.Start(); err != nil {
+		return 0, err
+	}
+
+	log := readProgress(output, progress)
+	if err := cmd.Wait(); err != nil {
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		return 0, fmt.Errorf("remotion render failed: %w\n%s", err, log)
+	}
+
+	return probeDuration(req)
+}
+
+// readProgress reports the progress the cli prints until the output ends
+// and returns the last lines of it
+func readProgress(output io.Reader, progress func(Progress)) string {
+	var lines []string
+	last := Progress{Preparing, 0}
+
+	scanner := bufio.NewScanner(output)
+	scanner.Split(scanLines)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		lines = append(lines, line)
+		if len(lines) > remotionLogLines {
+			lines = lines[1:]
+		}
+
+		current, ok := parseProgress(line)
+		if !ok || current == last || current.Percent < last.Percent {
+			continue
+		}
+		last = current
+		if progress != nil {
+			progress(current)
+		}
+	}
+	// keep draining, the cli blocks on a full pipe
+	_, _ = io.Copy(io.Discard, output)
+
+	return strings.Join(lines, "\n")
+}
+
+// scanLines splits on carriage returns as well, progress bars redraw the
+// line with them
+func scanLines(data []byte, atEOF bool) (int, []byte, error) {
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// parseProgress reads a progress line of the cli like "Rendered 12/60" or
+// "Bundling 50%"
+func parseProgress(line string) (Progress, bool) {
+	lower := strings.ToLower(line)
+
+	var stage Stage
+	switch {
+	case strings.Contains(lower, "bundl"):
+		stage = Bundling
+	case strings.Contains(lower, "render"):
+		stage = Rendering
+	case strings.Contains(lower, "encod"), strings.Contains(lower, "stitch"):
+		stage = Encoding
+	default:
+		return Progress{}, false
+	}
+
+	var done float64
+	if match := fractionPattern.FindStringSubmatch(line); match != nil {
+		current, _ := strconv.Atoi(match[1])
+		total, _ := strconv.Atoi(match[2])
+		if total == 0 || current > total {
+			return Progress{}, false
+		}
+		done = float64(current) / float64(total)
+	} else if match := percentPattern.FindStringSubmatch(line); match != nil {
+		percent, _ := strconv.ParseFloat(match[1], 64)
+		if percent > 100 {
+			return Progress{}, false
+		}
+		done = percent / 100
+	} else {
+		return Progress{}, false
+	}
+
+	span := stageRanges[stage]
+	return Progress{stage, span[0] + int(done*float64(span[1]-span[0]))}, true
+}
+
+// probeDuration reads the duration from the container of the rendered
+// video
+func probeDuration(req Request) (float64, error) {
+	file, err := os.Open(req.Output)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	info, err := media.ProcessVideo(file, exports.Formats[req.Format])
+	if err != nil {
+		return 0, fmt.Errorf("the rendered video is unreadable: %w", err)
+	}
+	return info.Duration, nil
+}
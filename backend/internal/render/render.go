@@ -0,0 +1,111 @@
+package render
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/Pieli/server/internal/exports"
+)
+
+// FPS is the frame rate the editor plays the compositions at, their
+// durations are counted in frames
+const FPS = 30
+
+// Stage names the step a render is in, stages run in the listed order
+type Stage string
+
+const (
+	Preparing Stage = "preparing"
+	Bundling  Stage = "bundling"
+	Rendering Stage = "rendering"
+	Encoding  Stage = "encoding"
+)
+
+// Progress is how far a render got, Percent runs from 0 to 100 over all
+// stages
+type Progress struct {
+	Stage   Stage
+	Percent int
+}
+
+// Request describes a video to render
+type Request struct {
+	// Compositions is the JSON array of the project compositions
+	Compositions json.RawMessage
+	Quality      string
+	Format       string
+	// Output is the file the video is written to
+	Output string
+}
+
+// Renderer turns compositions into a video file
+type Renderer interface {
+	// Render writes the video to req.Output and returns its duration in
+	// seconds. progress is called from the rendering goroutine whenever
+	// the render advanced, it may be nil.
+	Render(ctx context.Context, req Request, progress func(Progress)) (float64, error)
+}
+
+// scales multiplies the 1080p canvas of the editor to the export quality
+var scales = map[string]float64{
+	"HD": 1,
+	"4K": 2,
+	"8K": 4,
+}
+
+// codecs maps the export formats to the codec they are encoded with
+var codecs = map[string]string{
+	"mp4":  "h264",
+	"webm": "vp8",
+}
+
+// Frames returns the number of frames the compositions play one after
+// another
+func Frames(compositions json.RawMessage) (int, error) {
+	var comps []struct {
+		Duration float64 `json:"duration"`
+	}
+	if err := json.Unmarshal(compositions, &comps); err != nil {
+		return 0, fmt.Errorf("invalid compositions: %w", err)
+	}
+
+	frames := 0
+	for _, comp := range comps {
+		if comp.Duration < 0 {
+			return 0, errors.New("invalid compositions: negative duration")
+		}
+		frames += int(comp.Duration)
+	}
+	if frames == 0 {
+		return 0, errors.New("the compositions have no frames")
+	}
+	return frames, nil
+}
+
+// ForExports renders the jobs of an export pool into temporary files in
+// dir, the pool removes them once the job is completed
+func ForExports(renderer Renderer, dir string) exports.Render {
+	return func(ctx context.Context, job exports.Job, progress func(exports.Progress)) (exports.Output, error) {
+		file, err := os.CreateTemp(dir, "export-*."+job.Format)
+		if err != nil {
+			return exports.Output{}, err
+		}
+		output := exports.Output{Path: file.Name()}
+		if err := file.Close(); err != nil {
+			return output, err
+		}
+
+		output.Duration, err = renderer.Render(ctx, Request{
+			Compositions: job.Compositions,
+			Quality:      job.Quality,
+			Format:       job.Format,
+			Output:       output.Path,
+		}, func(p Progress) {
+			progress(exports.Progress{Stage: string(p.Stage), Percent: p.Percent})
+		})
+		return output, err
+	}
+}
@@ -0,0 +1,124 @@
+package render
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Pieli/server/internal/exports"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var compositions = json.RawMessage(`[
+	{"id": "a", "name": "SimpleFade", "props": {}, "duration": 60},
+	{"id": "b", "name": "SlideInTransition", "props": {}, "duration": 30}
+]`)
+
+func TestFrames(t *testing.T) {
+	frames, err := Frames(compositions)
+	require.NoError(t, err)
+	assert.Equal(t, 90, frames)
+
+	for name, comps := range map[string]string{
+		"empty":    `[]`,
+		"invalid":  `{"duration": 30}`,
+		"negative": `[{"duration": 30}, {"duration": -10}]`,
+	} {
+		_, err := Frames(json.RawMessage(comps))
+		assert.Error(t, err, name)
+	}
+}
+
+func TestFakeRenderer(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "video.mp4")
+
+	var reports []Progress
+	duration, err := FakeRenderer{}.Render(context.Background(),
+		Request{Compositions: compositions, Quality: "4K", Format: "mp4", Output: output},
+		func(p Progress) {
+			if len(reports) > 0 {
+				assert.GreaterOrEqual(t, p.Percent, reports[len(reports)-1].Percent)
+			}
+			reports = append(reports, p)
+		})
+	require.NoError(t, err)
+	assert.Equal(t, 3.0, duration)
+	assert.Equal(t, Progress{Preparing, 0}, reports[0])
+	assert.Equal(t, Progress{Encoding, 100}, reports[len(reports)-1])
+	assert.FileExists(t, output)
+
+	_, err = FakeRenderer{}.Render(context.Background(),
+		Request{Compositions: compositions, Quality: "16K", Format: "mp4", Output: output}, nil)
+	assert.Error(t, err)
+}
+
+func TestFakeRendererCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	output := filepath.Join(t.TempDir(), "video.webm")
+	_, err := FakeRenderer{}.Render(ctx,
+		Request{Compositions: compositions, Quality: "HD", Format: "webm", Output: output}, nil)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.NoFileExists(t, output)
+}
+
+func TestForExports(t *testing.T) {
+	dir := t.TempDir()
+	render := ForExports(FakeRenderer{}, dir)
+
+	var reports []exports.Progress
+	output, err := render(context.Background(), exports.Job{Compositions: compositions, Quality: "HD", Format: "webm"},
+		func(p exports.Progress) {
+			reports = append(reports, p)
+		})
+	require.NoError(t, err)
+	assert.Equal(t, exports.Progress{Stage: "encoding", Percent: 100}, reports[len(reports)-1])
+	assert.Equal(t, dir, filepath.Dir(output.Path))
+	assert.True(t, strings.HasSuffix(output.Path, ".webm"))
+	assert.Equal(t, 3.0, output.Duration)
+
+	content, err := os.ReadFile(output.Path)
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "HD webm")
+}
+
+func TestRemotionArgs(t *testing.T) {
+	renderer := NewRemotionRenderer("../frontend", "", "")
+
+	args, err := renderer.Args(Request{Quality: "4K", Format: "webm", Output: "/tmp/out.webm"}, "/tmp/out.webm.props.json")
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"remotion", "render", DefaultRemotionEntry, DefaultRemotionComposition, "/tmp/out.webm",
+		"--props=/tmp/out.webm.props.json", "--codec=vp8", "--scale=2", "--overwrite",
+	}, args)
+
+	_, err = renderer.Args(Request{Quality: "HD", Format: "gif"}, "props.json")
+	assert.Error(t, err)
+}
+
+func TestReadProgress(t *testing.T) {
+	output := "Bundling 50%\rBundling 100%\n" +
+		"Rendering frames ━━━━━━ 30/60\rRendering frames ━━━━━━ 60/60\n" +
+		"Encoding video ━━━━━━ 60/60\n" +
+		"Rendering frames 10/60\n" +
+		"+ out/video.mp4 1.2 MB\n"
+
+	var reports []Progress
+	log := readProgress(strings.NewReader(output), func(p Progress) {
+		reports = append(reports, p)
+	})
+
+	assert.Equal(t, []Progress{
+		{Bundling, 5},
+		{Bundling, 10},
+		{Rendering, 45},
+		{Rendering, 80},
+		{Encoding, 100},
+	}, reports)
+	assert.True(t, strings.HasSuffix(log, "+ out/video.mp4 1.2 MB"))
+}
@@ -0,0 +1,153 @@
+package renderapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Pieli/server/internal/exports"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// callTimeout bounds the calls besides the upload, a hanging heartbeat
+// would let the lease run out
+const callTimeout = 30 * time.Second
+
+// Client is the queue of a worker running apart from the api server, it
+// hands the calls of an export pool to the render api
+type Client struct {
+	baseURL string
+	token   string
+	http    *http.Client
+}
+
+// NewClient talks to the api server at baseURL with the worker token
+func NewClient(baseURL, token string) *Client {
+	return &Client{
+		baseURL: strings.TrimRight(baseURL, "/") + Prefix,
+		token:   token,
+		http:    &http.Client{},
+	}
+}
+
+// do sends the request and checks the status, 409 is a lost lease
+func (c *Client) do(req *http.Request, out interface{}) (int, error) {
+	req.Header.Set("Authorization", "Bearer "+c.token)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusConflict:
+		return resp.StatusCode, exports.ErrLeaseLost
+	case resp.StatusCode == http.StatusNotFound:
+		return resp.StatusCode, exports.ErrNotFound
+	case resp.StatusCode >= 300:
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return resp.StatusCode, fmt.Errorf("render api answered %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	if out != nil && resp.StatusCode != http.StatusNoContent {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return resp.StatusCode, err
+		}
+	}
+	return resp.StatusCode, nil
+}
+
+// post sends body as JSON
+func (c *Client) post(ctx context.Context, path string, body, out interface{}) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, callTimeout)
+	defer cancel()
+
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+path, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return c.do(req, out)
+}
+
+func (c *Client) Claim(ctx context.Context, worker string, lease time.Duration) (exports.Job, error) {
+	var claimed ClaimedJob
+	status, err := c.post(ctx, "/jobs/claim", LeaseRequest{Worker: worker, LeaseSeconds: int(lease.Seconds())}, &claimed)
+	if err != nil {
+		return exports.Job{}, err
+	}
+	if status == http.StatusNoContent {
+		return exports.Job{}, exports.ErrNoJob
+	}
+
+	id, err := primitive.ObjectIDFromHex(claimed.Id)
+	if err != nil {
+		return exports.Job{}, fmt.Errorf("render api returned an invalid job id %q", claimed.Id)
+	}
+	return exports.Job{
+		Id:             id,
+		ProjectId:      claimed.ProjectId,
+		Quality:        claimed.Quality,
+		Format:         claimed.Format,
+		Compositions:   claimed.Compositions,
+		Status:         exports.Rendering,
+		Attempts:       claimed.Attempts,
+		Worker:         worker,
+		LeaseExpiresAt: claimed.LeaseExpiresAt,
+	}, nil
+}
+
+func (c *Client) Renew(ctx context.Context, job exports.Job, worker string, lease time.Duration) error {
+	_, err := c.post(ctx, "/jobs/"+job.Id.Hex()+"/heartbeat", LeaseRequest{Worker: worker, LeaseSeconds: int(lease.Seconds())}, nil)
+	return err
+}
+
+func (c *Client) Report(ctx context.Context, job exports.Job, worker string, progress exports.Progress) error {
+	_, err := c.post(ctx, "/jobs/"+job.Id.Hex()+"/progress", ProgressRequest{Worker: worker, Stage: progress.Stage, Percent: progress.Percent}, nil)
+	return err
+}
+
+func (c *Client) Fail(ctx context.Context, job exports.Job, worker string, reason error) error {
+	_, err := c.post(ctx, "/jobs/"+job.Id.Hex()+"/fail", FailRequest{Worker: worker, Error: reason.Error()}, nil)
+	return err
+}
+
+// Complete uploads the video, the server stores it and finishes the job
+func (c *Client) Complete(ctx context.Context, job exports.Job, worker string, output exports.Output) error {
+	file, err := os.Open(output.Path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	query := url.Values{}
+	query.Set("worker", worker)
+	query.Set("duration", strconv.FormatFloat(output.Duration, 'f', -1, 64))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, c.baseURL+"/jobs/"+job.Id.Hex()+"/result?"+query.Encode(), file)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = stat.Size()
+	req.Header.Set("Content-Type", exports.Formats[job.Format])
+
+	_, err = c.do(req, nil)
+	return err
+}
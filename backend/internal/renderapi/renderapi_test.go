@@ -0,0 +1,209 @@
+package renderapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Pieli/server/internal/exports"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// memoryJobs keeps a single job the way the exports Service would
+type memoryJobs struct {
+	mu       sync.Mutex
+	job      exports.Job
+	leases   []time.Duration
+	reports  []exports.Progress
+	uploaded []byte
+	duration float64
+	failure  error
+}
+
+func (m *memoryJobs) Get(ctx context.Context, id string) (exports.Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if id != m.job.Id.Hex() {
+		return exports.Job{}, exports.ErrNotFound
+	}
+	return m.job, nil
+}
+
+func (m *memoryJobs) Claim(ctx context.Context, worker string, lease time.Duration) (exports.Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.job.Status != exports.Queued {
+		return exports.Job{}, exports.ErrNoJob
+	}
+	m.job.Status, m.job.Worker = exports.Rendering, worker
+	m.job.LeaseExpiresAt = time.Now().Add(lease)
+	m.job.Attempts++
+	m.leases = append(m.leases, lease)
+	return m.job, nil
+}
+
+func (m *memoryJobs) Renew(ctx context.Context, job exports.Job, worker string, lease time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.job.LeaseExpiresAt = time.Now().Add(lease)
+	m.leases = append(m.leases, lease)
+	return nil
+}
+
+func (m *memoryJobs) Report(ctx context.Context, job exports.Job, worker string, progress exports.Progress) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.reports = append(m.reports, progress)
+	return nil
+}
+
+func (m *memoryJobs) Complete(ctx context.Context, job exports.Job, worker string, output exports.Output) error {
+	content, err := os.ReadFile(output.Path)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.uploaded, m.duration = content, output.Duration
+	m.job.Status, m.job.Worker = exports.Succeeded, ""
+	return nil
+}
+
+func (m *memoryJobs) Fail(ctx context.Context, job exports.Job, worker string, reason error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failure = reason
+	m.job.Status, m.job.Worker = exports.Queued, ""
+	return nil
+}
+
+func newTestServer(t *testing.T, jobs Jobs) string {
+	t.Helper()
+	app := echo.New()
+	Register(app, jobs, "secret", t.TempDir())
+	server := httptest.NewServer(app)
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func TestClientRendersJob(t *testing.T) {
+	jobs := &memoryJobs{job: exports.Job{
+		Id:           primitive.NewObjectID(),
+		ProjectId:    "p1",
+		Quality:      "4K",
+		Format:       "webm",
+		Compositions: json.RawMessage(`[{"duration":30}]`),
+		Status:       exports.Queued,
+	}}
+	client := NewClient(newTestServer(t, jobs)+"/", "secret")
+	ctx := context.Background()
+
+	job, err := client.Claim(ctx, "worker-0", time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, jobs.job.Id, job.Id)
+	assert.Equal(t, "webm", job.Format)
+	assert.Equal(t, 1, job.Attempts)
+	assert.JSONEq(t, `[{"duration":30}]`, string(job.Compositions))
+
+	// nothing else is waiting
+	_, err = client.Claim(ctx, "worker-1", time.Minute)
+	assert.ErrorIs(t, err, exports.ErrNoJob)
+
+	require.NoError(t, client.Renew(ctx, job, "worker-0", 30*time.Second))
+	require.NoError(t, client.Report(ctx, job, "worker-0", exports.Progress{Stage: "rendering", Percent: 40}))
+
+	// only the worker holding the lease gets through
+	assert.ErrorIs(t, client.Renew(ctx, job, "worker-1", time.Minute), exports.ErrLeaseLost)
+	assert.ErrorIs(t, client.Report(ctx, job, "worker-1", exports.Progress{Percent: 50}), exports.ErrLeaseLost)
+
+	video := filepath.Join(t.TempDir(), "video.webm")
+	require.NoError(t, os.WriteFile(video, []byte("webm video"), 0o600))
+	require.NoError(t, client.Complete(ctx, job, "worker-0", exports.Output{Path: video, Duration: 1}))
+
+	// a finished job has no lease left to act on
+	assert.ErrorIs(t, client.Fail(ctx, job, "worker-0", errors.New("too late")), exports.ErrLeaseLost)
+
+	assert.Equal(t, []time.Duration{MaxLease, 30 * time.Second}, jobs.leases)
+	assert.Equal(t, []exports.Progress{{Stage: "rendering", Percent: 40}}, jobs.reports)
+	assert.Equal(t, "webm video", string(jobs.uploaded))
+	assert.Equal(t, 1.0, jobs.duration)
+	assert.Nil(t, jobs.failure)
+}
+
+func TestClientFailsJob(t *testing.T) {
+	jobs := &memoryJobs{job: exports.Job{Id: primitive.NewObjectID(), Format: "mp4", Status: exports.Queued}}
+	client := NewClient(newTestServer(t, jobs), "secret")
+	ctx := context.Background()
+
+	job, err := client.Claim(ctx, "worker-0", 0)
+	require.NoError(t, err)
+	require.NoError(t, client.Fail(ctx, job, "worker-0", errors.New("chrome crashed")))
+	assert.EqualError(t, jobs.failure, "chrome crashed")
+	assert.Equal(t, []time.Duration{exports.DefaultLease}, jobs.leases)
+
+	job.Id = primitive.NewObjectID()
+	assert.ErrorIs(t, client.Renew(ctx, job, "worker-0", time.Minute), exports.ErrNotFound)
+}
+
+func TestClientWithWrongToken(t *testing.T) {
+	jobs := &memoryJobs{job: exports.Job{Id: primitive.NewObjectID(), Status: exports.Queued}}
+	client := NewClient(newTestServer(t, jobs), "guess")
+
+	_, err := client.Claim(context.Background(), "worker-0", time.Minute)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "401")
+	assert.Equal(t, exports.Queued, jobs.job.Status)
+}
+
+func TestClientUploadTooLarge(t *testing.T) {
+	limit := maxResultSize
+	maxResultSize = 4
+	t.Cleanup(func() { maxResultSize = limit })
+
+	jobs := &memoryJobs{job: exports.Job{Id: primitive.NewObjectID(), Format: "mp4", Status: exports.Queued}}
+	client := NewClient(newTestServer(t, jobs), "secret")
+	ctx := context.Background()
+
+	job, err := client.Claim(ctx, "worker-0", time.Minute)
+	require.NoError(t, err)
+
+	video := filepath.Join(t.TempDir(), "video.mp4")
+	require.NoError(t, os.WriteFile(video, []byte("mp4 video"), 0o600))
+	err = client.Complete(ctx, job, "worker-0", exports.Output{Path: video, Duration: 1})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "413")
+	assert.Nil(t, jobs.uploaded)
+	assert.Equal(t, exports.Rendering, jobs.job.Status)
+}
+
+func TestClientWithExpiredLease(t *testing.T) {
+	jobs := &memoryJobs{job: exports.Job{Id: primitive.NewObjectID(), Format: "mp4", Status: exports.Queued}}
+	client := NewClient(newTestServer(t, jobs), "secret")
+	ctx := context.Background()
+
+	job, err := client.Claim(ctx, "worker-0", time.Minute)
+	require.NoError(t, err)
+
+	// the worker went quiet, the sweep has not requeued the job yet
+	jobs.mu.Lock()
+	jobs.job.LeaseExpiresAt = time.Now().Add(-time.Second)
+	jobs.mu.Unlock()
+
+	assert.ErrorIs(t, client.Renew(ctx, job, "worker-0", time.Minute), exports.ErrLeaseLost)
+	assert.ErrorIs(t, client.Report(ctx, job, "worker-0", exports.Progress{Stage: "rendering", Percent: 50}), exports.ErrLeaseLost)
+	assert.ErrorIs(t, client.Fail(ctx, job, "worker-0", errors.New("chrome crashed")), exports.ErrLeaseLost)
+	// nothing reached the queue
+	assert.Equal(t, []time.Duration{time.Minute}, jobs.leases)
+	assert.Empty(t, jobs.reports)
+	assert.Nil(t, jobs.failure)
+}
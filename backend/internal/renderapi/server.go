@@ -0,0 +1,288 @@
+// Package renderapi lets render workers outside the api server take part
+// in the export queue. The server side exposes the queue under
+// /internal/render, the Client speaks it and is a queue for an export pool.
+package renderapi
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Pieli/server/internal/exports"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// Prefix is where the routes are registered, the user authentication
+	// skips it
+	Prefix = "/internal/render"
+	// MaxLease caps the lease a worker may ask for
+	MaxLease = 10 * time.Minute
+)
+
+// maxResultSize caps the size of an uploaded video
+var maxResultSize int64 = 4 << 30
+
+// Jobs is the queue the api hands out, the exports Service
+type Jobs interface {
+	exports.Queue
+	Get(ctx context.Context, id string) (exports.Job, error)
+}
+
+// ClaimedJob is what a worker needs to know to render a job
+type ClaimedJob struct {
+	Id             string          `json:"id"`
+	ProjectId      string          `json:"projectId"`
+	Quality        string          `json:"quality"`
+	Format         string          `json:"format"`
+	Compositions   json.RawMessage `json:"compositions"`
+	Attempts       int             `json:"attempts"`
+	LeaseExpiresAt time.Time       `json:"leaseExpiresAt"`
+}
+
+// LeaseRequest claims a job or renews the lease on it
+type LeaseRequest struct {
+	Worker       string `json:"worker"`
+	LeaseSeconds int    `json:"leaseSeconds"`
+}
+
+// ProgressRequest reports how far the render got
+type ProgressRequest struct {
+	Worker  string `json:"worker"`
+	Stage   string `json:"stage"`
+	Percent int    `json:"percent"`
+}
+
+// FailRequest reports a failed render
+type FailRequest struct {
+	Worker string `json:"worker"`
+	Error  string `json:"error"`
+}
+
+type handler struct {
+	jobs    Jobs
+	tempDir string
+}
+
+// Register adds the routes of the render api, requests must carry the
+// token as bearer token. Uploaded videos are spooled to tempDir.
+func Register(app *echo.Echo, jobs Jobs, token, tempDir string) {
+	h := handler{jobs: jobs, tempDir: tempDir}
+
+	group := app.Group(Prefix, tokenMiddleware(token))
+	group.POST("/jobs/claim", h.claim)
+	group.POST("/jobs/:id/heartbeat", h.heartbeat)
+	group.POST("/jobs/:id/progress", h.progress)
+	group.POST("/jobs/:id/fail", h.fail)
+	group.PUT("/jobs/:id/result", h.result)
+}
+
+func tokenMiddleware(token string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			auth := c.Request().Header.Get("Authorization")
+			given := strings.TrimPrefix(auth, "Bearer ")
+			if !strings.HasPrefix(auth, "Bearer ") || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+				return echo.NewHTTPError(http.StatusUnauthorized,
+					map[string]string{
+						"info":    "Unauthorized",
+						"message": "Invalid worker token",
+					})
+			}
+			return next(c)
+		}
+	}
+}
+
+// lease turns the requested seconds into a lease, it falls back to the
+// default and is capped by MaxLease
+func lease(seconds int) time.Duration {
+	if seconds <= 0 {
+		return exports.DefaultLease
+	}
+	return min(time.Duration(seconds)*time.Second, MaxLease)
+}
+
+func badRequest(message string) error {
+	return echo.NewHTTPError(http.StatusBadRequest, map[string]string{
+		"info":    "Bad Request",
+		"message": message,
+	})
+}
+
+// queueError maps the errors of the queue to responses
+func queueError(err error) error {
+	switch {
+	case errors.Is(err, exports.ErrNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, map[string]string{
+			"info":    "Not Found",
+			"message": "The export job does not exist.",
+		})
+	case errors.Is(err, exports.ErrLeaseLost):
+		return echo.NewHTTPError(http.StatusConflict, map[string]string{
+			"info":    "Conflict",
+			"message": "The lease of the export job is held by another worker.",
+		})
+	default:
+		return echo.NewHTTPError(http.StatusInternalServerError, map[string]string{
+			"info":    "Server Error",
+			"message": "The export queue failed.",
+			"error":   err.Error(),
+		})
+	}
+}
+
+// leased returns the job of the request if the worker holds an unexpired
+// lease on it
+func (h handler) leased(c echo.Context, worker string) (exports.Job, error) {
+	if worker == "" {
+		return exports.Job{}, badRequest("The worker is missing.")
+	}
+
+	job, err := h.jobs.Get(c.Request().Context(), c.Param("id"))
+	if err != nil {
+		return exports.Job{}, queueError(err)
+	}
+	if job.Status != exports.Rendering || job.Worker != worker {
+		return exports.Job{}, queueError(exports.ErrLeaseLost)
+	}
+	// the sweep is about to hand the job to another worker
+	if !job.LeaseExpiresAt.After(time.Now()) {
+		return exports.Job{}, echo.NewHTTPError(http.StatusConflict, map[string]string{
+			"info":    "Conflict",
+			"message": "The lease of the export job has expired.",
+		})
+	}
+	return job, nil
+}
+
+// claim answers with the next job or with 204 when none is waiting
+func (h handler) claim(c echo.Context) error {
+	var req LeaseRequest
+	if err := c.Bind(&req); err != nil || req.Worker == "" {
+		return badRequest("The worker is missing.")
+	}
+
+	job, err := h.jobs.Claim(c.Request().Context(), req.Worker, lease(req.LeaseSeconds))
+	if errors.Is(err, exports.ErrNoJob) {
+		return c.NoContent(http.StatusNoContent)
+	}
+	if err != nil {
+		return queueError(err)
+	}
+
+	return c.JSON(http.StatusOK, ClaimedJob{
+		Id:             job.Id.Hex(),
+		ProjectId:      job.ProjectId,
+		Quality:        job.Quality,
+		Format:         job.Format,
+		Compositions:   job.Compositions,
+		Attempts:       job.Attempts,
+		LeaseExpiresAt: job.LeaseExpiresAt,
+	})
+}
+
+func (h handler) heartbeat(c echo.Context) error {
+	var req LeaseRequest
+	if err := c.Bind(&req); err != nil {
+		return badRequest("The body is not valid JSON.")
+	}
+	job, err := h.leased(c, req.Worker)
+	if err != nil {
+		return err
+	}
+
+	if err := h.jobs.Renew(c.Request().Context(), job, req.Worker, lease(req.LeaseSeconds)); err != nil {
+		return queueError(err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h handler) progress(c echo.Context) error {
+	var req ProgressRequest
+	if err := c.Bind(&req); err != nil {
+		return badRequest("The body is not valid JSON.")
+	}
+	if req.Percent < 0 || req.Percent > 100 {
+		return badRequest("The percent must be between 0 and 100.")
+	}
+	job, err := h.leased(c, req.Worker)
+	if err != nil {
+		return err
+	}
+
+	err = h.jobs.Report(c.Request().Context(), job, req.Worker, exports.Progress{Stage: req.Stage, Percent: req.Percent})
+	if err != nil {
+		return queueError(err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h handler) fail(c echo.Context) error {
+	var req FailRequest
+	if err := c.Bind(&req); err != nil {
+		return badRequest("The body is not valid JSON.")
+	}
+	if req.Error == "" {
+		return badRequest("The error is missing.")
+	}
+	job, err := h.leased(c, req.Worker)
+	if err != nil {
+		return err
+	}
+
+	if err := h.jobs.Fail(c.Request().Context(), job, req.Worker, errors.New(req.Error)); err != nil {
+		return queueError(err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// result takes the rendered video as body, the worker and the duration in
+// seconds come as query parameters
+func (h handler) result(c echo.Context) error {
+	worker := c.QueryParam("worker")
+	duration, err := strconv.ParseFloat(c.QueryParam("duration"), 64)
+	if err != nil || duration < 0 {
+		return badRequest("The duration must be a number of seconds.")
+	}
+	job, err := h.leased(c, worker)
+	if err != nil {
+		return err
+	}
+
+	// the blob store wants the size up front, the body may be chunked
+	file, err := os.CreateTemp(h.tempDir, "upload-*."+job.Format)
+	if err != nil {
+		return queueError(err)
+	}
+	defer os.Remove(file.Name())
+
+	body := http.MaxBytesReader(c.Response(), c.Request().Body, maxResultSize)
+	_, err = io.Copy(file, body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return echo.NewHTTPError(http.StatusRequestEntityTooLarge, map[string]string{
+			"info":    "Request Entity Too Large",
+			"message": "The video is too large.",
+		})
+	}
+	if err != nil {
+		return queueError(err)
+	}
+
+	err = h.jobs.Complete(c.Request().Context(), job, worker, exports.Output{Path: file.Name(), Duration: duration})
+	if err != nil {
+		return queueError(err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,30 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+var ErrBlobNotFound = errors.New("blob not found")
+
+// BlobStore keeps binary objects like uploaded assets under string keys.
+// Keys are slash separated paths, e.g. "assets/<userId>/<assetId>".
+type BlobStore interface {
+	// Put stores size bytes of r under key, an existing blob is replaced
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Get opens the blob, ErrBlobNotFound if there is none
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the blob, deleting a missing blob is not an error
+	Delete(ctx context.Context, key string) error
+	// List calls fn for every blob whose key starts with prefix
+	List(ctx context.Context, prefix string, fn func(BlobInfo) error) error
+}
+
+// BlobInfo describes a stored blob without its content
+type BlobInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"context"
+	"io"
+)
+
+// concatReader reads the blobs of keys one after the other, each blob is
+// only opened once the previous one is used up
+type concatReader struct {
+	ctx     context.Context
+	store   BlobStore
+	keys    []string
+	current io.ReadCloser
+}
+
+// Concat returns the content of the blobs at keys as a single stream
+func Concat(ctx context.Context, store BlobStore, keys []string) io.ReadCloser {
+	return &concatReader{ctx: ctx, store: store, keys: keys}
+}
+
+func (c *concatReader) Read(p []byte) (int, error) {
+	for {
+		if c.current == nil {
+			if len(c.keys) == 0 {
+				return 0, io.EOF
+			}
+			body, err := c.store.Get(c.ctx, c.keys[0])
+			if err != nil {
+				return 0, err
+			}
+			c.current = body
+			c.keys = c.keys[1:]
+		}
+
+		n, err := c.current.Read(p)
+		if err == io.EOF {
+			c.current.Close()
+			c.current = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (c *concatReader) Close() error {
+	if c.current == nil {
+		return nil
+	}
+	err := c.current.Close()
+	c.current = nil
+	return err
+}
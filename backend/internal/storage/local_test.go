@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalBlobStore(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewLocalBlobStore(t.TempDir())
+	require.NoError(t, err)
+
+	require.NoError(t, store.Put(ctx, "assets/u/p/a", strings.NewReader("hello"), 5, "text/plain"))
+
+	body, err := store.Get(ctx, "assets/u/p/a")
+	require.NoError(t, err)
+	content, err := io.ReadAll(body)
+	require.NoError(t, err)
+	require.NoError(t, body.Close())
+	assert.Equal(t, "hello", string(content))
+
+	require.NoError(t, store.Delete(ctx, "assets/u/p/a"))
+	require.NoError(t, store.Delete(ctx, "assets/u/p/a"))
+
+	_, err = store.Get(ctx, "assets/u/p/a")
+	assert.ErrorIs(t, err, ErrBlobNotFound)
+}
+
+func TestLocalBlobStoreRejectsEscapingKeys(t *testing.T) {
+	store, err := NewLocalBlobStore(t.TempDir())
+	require.NoError(t, err)
+
+	err = store.Put(context.Background(), "../outside", strings.NewReader("x"), 1, "")
+	assert.Error(t, err)
+}
+
+func TestLocalBlobStoreChecksSize(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewLocalBlobStore(t.TempDir())
+	require.NoError(t, err)
+
+	assert.Error(t, store.Put(ctx, "short", strings.NewReader("abc"), 5, ""))
+
+	_, err = store.Get(ctx, "short")
+	assert.ErrorIs(t, err, ErrBlobNotFound)
+}
+
+func TestConcat(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewLocalBlobStore(t.TempDir())
+	require.NoError(t, err)
+
+	require.NoError(t, store.Put(ctx, "chunks/0", strings.NewReader("hello "), 6, ""))
+	require.NoError(t, store.Put(ctx, "chunks/1", strings.NewReader(""), 0, ""))
+	require.NoError(t, store.Put(ctx, "chunks/2", strings.NewReader("world"), 5, ""))
+
+	body := Concat(ctx, store, []string{"chunks/0", "chunks/1", "chunks/2"})
+	content, err := io.ReadAll(body)
+	require.NoError(t, err)
+	require.NoError(t, body.Close())
+	assert.Equal(t, "hello world", string(content))
+
+	_, err = io.ReadAll(Concat(ctx, store, []string{"chunks/0", "chunks/missing"}))
+	assert.ErrorIs(t, err, ErrBlobNotFound)
+}
+
+func TestLocalBlobStoreList(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewLocalBlobStore(t.TempDir())
+	require.NoError(t, err)
+
+	require.NoError(t, store.Put(ctx, "assets/u/a", strings.NewReader("hello"), 5, ""))
+	require.NoError(t, store.Put(ctx, "blobs/sha256/x", strings.NewReader("hi"), 2, ""))
+
+	var listed []BlobInfo
+	require.NoError(t, store.List(ctx, "", func(info BlobInfo) error {
+		listed = append(listed, info)
+		return nil
+	}))
+	require.Len(t, listed, 2)
+	assert.Equal(t, "assets/u/a", listed[0].Key)
+	assert.Equal(t, int64(5), listed[0].Size)
+	assert.False(t, listed[0].ModTime.IsZero())
+
+	listed = nil
+	require.NoError(t, store.List(ctx, "blobs/", func(info BlobInfo) error {
+		listed = append(listed, info)
+		return nil
+	}))
+	require.Len(t, listed, 1)
+	assert.Equal(t, "blobs/sha256/x", listed[0].Key)
+}
@@ -0,0 +1,125 @@
+package tiers
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// DefaultTier is assigned to new users and to users without a tier
+const DefaultTier = "free"
+
+//go:embed tiers.json
+var defaultCatalog []byte
+
+// qualities are the export qualities a tier can be limited to
+var qualities = map[string]bool{"HD": true, "4K": true, "8K": true}
+
+// names are the tier names the API knows, see the Tier schema
+var names = map[string]bool{"free": true, "basic": true, "pro": true, "enterprise": true}
+
+type Limits struct {
+	MaxProjects      int    `json:"maxProjects"`
+	MaxStorageBytes  int64  `json:"maxStorageBytes"`
+	MaxExportQuality string `json:"maxExportQuality"`
+}
+
+type Tier struct {
+	Name           string   `json:"name"`
+	MonthlyCredits int      `json:"monthlyCredits"`
+	Price          float32  `json:"price"`
+	Features       []string `json:"features"`
+	Limits         Limits   `json:"limits"`
+}
+
+// Catalog holds the tiers in the order they are offered
+type Catalog struct {
+	tiers  []Tier
+	byName map[string]Tier
+}
+
+// Load reads the catalog from the JSON file at path,
+// an empty path yields the built in catalog
+func Load(path string) (*Catalog, error) {
+	data := defaultCatalog
+	if path != "" {
+		var err error
+		data, err = os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var list []Tier
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("invalid tier catalog: %w", err)
+	}
+
+	return newCatalog(list)
+}
+
+func newCatalog(list []Tier) (*Catalog, error) {
+	catalog := &Catalog{
+		tiers:  list,
+		byName: make(map[string]Tier, len(list)),
+	}
+
+	for _, tier := range list {
+		if tier.Name == "" {
+			return nil, errors.New("invalid tier catalog: tier without name")
+		}
+		if !names[tier.Name] {
+			return nil, fmt.Errorf("invalid tier catalog: unknown tier %q", tier.Name)
+		}
+		if _, ok := catalog.byName[tier.Name]; ok {
+			return nil, fmt.Errorf("invalid tier catalog: duplicate tier %q", tier.Name)
+		}
+		if err := tier.validate(); err != nil {
+			return nil, fmt.Errorf("invalid tier catalog: tier %q: %w", tier.Name, err)
+		}
+		catalog.byName[tier.Name] = tier
+	}
+
+	if _, ok := catalog.byName[DefaultTier]; !ok {
+		return nil, fmt.Errorf("invalid tier catalog: default tier %q is missing", DefaultTier)
+	}
+
+	return catalog, nil
+}
+
+func (t Tier) validate() error {
+	switch {
+	case t.MonthlyCredits < 0:
+		return errors.New("negative monthly credits")
+	case t.Limits.MaxProjects <= 0:
+		return errors.New("maxProjects must be positive")
+	case t.Limits.MaxStorageBytes < 0:
+		return errors.New("negative maxStorageBytes")
+	case !qualities[t.Limits.MaxExportQuality]:
+		return fmt.Errorf("unknown maxExportQuality %q", t.Limits.MaxExportQuality)
+	}
+	return nil
+}
+
+func (c *Catalog) All() []Tier {
+	return c.tiers
+}
+
+// Get returns the tier with the given name, unknown names yield the default tier
+func (c *Catalog) Get(name string) Tier {
+	if tier, ok := c.byName[name]; ok {
+		return tier
+	}
+	return c.byName[DefaultTier]
+}
+
+// Allowances maps each tier name to its monthly credits
+func (c *Catalog) Allowances() map[string]int {
+	allowances := make(map[string]int, len(c.tiers))
+	for _, tier := range c.tiers {
+		allowances[tier.Name] = tier.MonthlyCredits
+	}
+	return allowances
+}
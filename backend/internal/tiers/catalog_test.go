@@ -0,0 +1,73 @@
+package tiers
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadDefaultCatalog(t *testing.T) {
+	catalog, err := Load("")
+	require.NoError(t, err)
+
+	names := []string{}
+	for _, tier := range catalog.All() {
+		names = append(names, tier.Name)
+	}
+	assert.Equal(t, []string{"free", "basic", "pro", "enterprise"}, names)
+	assert.Equal(t, 50, catalog.Get("free").MonthlyCredits)
+}
+
+func TestGetFallsBackToDefaultTier(t *testing.T) {
+	catalog, err := Load("")
+	require.NoError(t, err)
+
+	assert.Equal(t, DefaultTier, catalog.Get("unknown").Name)
+	assert.Equal(t, DefaultTier, catalog.Get("").Name)
+}
+
+func TestLoadFromFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tiers.json")
+	err := os.WriteFile(path, []byte(`[
+		{"name": "free", "monthlyCredits": 10, "price": 0, "features": [], "limits": {"maxProjects": 1, "maxExportQuality": "HD"}},
+		{"name": "pro", "monthlyCredits": 99, "price": 5, "features": ["4K exports"], "limits": {"maxProjects": 9, "maxStorageBytes": 0, "maxExportQuality": "4K"}}
+	]`), 0o600)
+	require.NoError(t, err)
+
+	catalog, err := Load(path)
+	require.NoError(t, err)
+
+	assert.Len(t, catalog.All(), 2)
+	assert.Equal(t, map[string]int{"free": 10, "pro": 99}, catalog.Allowances())
+}
+
+func TestLoadRejectsInvalidCatalogs(t *testing.T) {
+	testCases := []struct {
+		name    string
+		content string
+	}{
+		{name: "malformed", content: `{`},
+		{name: "missing_default", content: `[{"name": "pro"}]`},
+		{name: "duplicate", content: `[{"name": "free"}, {"name": "free"}]`},
+		{name: "unknown_name", content: `[{"name": "free", "limits": {"maxProjects": 1, "maxExportQuality": "HD"}}, {"name": "gold", "limits": {"maxProjects": 1, "maxExportQuality": "HD"}}]`},
+		{name: "unnamed", content: `[{"name": "free"}, {"monthlyCredits": 5}]`},
+		{name: "no_projects", content: `[{"name": "free", "limits": {"maxProjects": 0, "maxExportQuality": "HD"}}]`},
+		{name: "negative_credits", content: `[{"name": "free", "monthlyCredits": -1, "limits": {"maxProjects": 1, "maxExportQuality": "HD"}}]`},
+		{name: "negative_storage", content: `[{"name": "free", "limits": {"maxProjects": 1, "maxStorageBytes": -1, "maxExportQuality": "HD"}}]`},
+		{name: "unknown_quality", content: `[{"name": "free", "limits": {"maxProjects": 1, "maxExportQuality": "16K"}}]`},
+		{name: "missing_quality", content: `[{"name": "free", "limits": {"maxProjects": 1}}]`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "tiers.json")
+			require.NoError(t, os.WriteFile(path, []byte(tc.content), 0o600))
+
+			_, err := Load(path)
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,279 @@
+package uploads
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// TusVersion is the version of the tus protocol the handlers speak
+const TusVersion = "1.0.0"
+
+// DefaultTTL is how long a session survives without a new chunk
+const DefaultTTL = 24 * time.Hour
+
+// ClaimLease is how long a completion holds the session, a request that
+// died halfway leaves it to the next one after that
+const ClaimLease = 15 * time.Minute
+
+// MaxOpen caps the sessions a user has open at once
+const MaxOpen = 20
+
+// Unlimited is the space of a user without a storage quota
+const Unlimited = -1
+
+var (
+	ErrNotFound       = errors.New("upload session not found")
+	ErrOffsetMismatch = errors.New("the chunk does not start at the offset of the upload")
+	ErrBusy           = errors.New("the upload is already being completed")
+	ErrTooMany        = errors.New("too many open uploads")
+	ErrNoSpace        = errors.New("the open uploads exceed the storage quota")
+)
+
+// Chunk is a part of the file stored as its own blob
+type Chunk struct {
+	Key    string `bson:"key"`
+	Offset int64  `bson:"offset"`
+	Size   int64  `bson:"size"`
+}
+
+// Session is a resumable upload, kept in the upload_sessions collection
+type Session struct {
+	Id        primitive.ObjectID `bson:"_id"`
+	UserId    string             `bson:"userId"`
+	ProjectId string             `bson:"projectId"`
+	FileName  string             `bson:"fileName"`
+	Length    int64              `bson:"length"`
+	Offset    int64              `bson:"offset"`
+	Chunks    []Chunk            `bson:"chunks"`
+	// AssetId is claimed when the last chunk arrived until ClaimedUntil,
+	// Completed is set once the asset exists and the chunks are gone
+	AssetId      string    `bson:"assetId,omitempty"`
+	ClaimedUntil time.Time `bson:"claimedUntil,omitempty"`
+	Completed    bool      `bson:"completed"`
+	CreatedAt    time.Time `bson:"createdAt"`
+	ExpiresAt    time.Time `bson:"expiresAt"`
+}
+
+// Received tells whether every byte of the file arrived
+func (s Session) Received() bool {
+	return s.Offset == s.Length
+}
+
+// Busy tells whether a completion holds the session
+func (s Session) Busy(now time.Time) bool {
+	return s.AssetId != "" && !s.Completed && s.ClaimedUntil.After(now)
+}
+
+// ChunkKeys lists the blob keys of the chunks in file order
+func (s Session) ChunkKeys() []string {
+	keys := make([]string, len(s.Chunks))
+	for i, chunk := range s.Chunks {
+		keys[i] = chunk.Key
+	}
+	return keys
+}
+
+// ChunkKey names the blob of a chunk. The random suffix keeps two racing
+// requests for the same offset from overwriting each other.
+func ChunkKey(sessionID primitive.ObjectID, offset int64) string {
+	return fmt.Sprintf("uploads/%s/%d-%s", sessionID.Hex(), offset, primitive.NewObjectID().Hex())
+}
+
+type Store struct {
+	coll *mongo.Collection
+	ttl  time.Duration
+}
+
+func initIndexes(coll *mongo.Collection) {
+	// let mongo drop the sessions nobody resumed, their chunks are left
+	// for the blob sweeper
+	_, err := coll.Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	// create the index for the open sessions of a user
+	_, err = coll.Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys: bson.D{{Key: "userId", Value: 1}},
+	})
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+}
+
+func NewStore(db *mongo.Database) *Store {
+	coll := db.Collection("upload_sessions")
+	initIndexes(coll)
+	return &Store{
+		coll: coll,
+		ttl:  DefaultTTL,
+	}
+}
+
+// Create starts a session for a file of length bytes. The open sessions of
+// the user are capped by MaxOpen and their lengths have to fit into space,
+// the storage left to the user. The session is inserted before the check
+// so concurrent requests see each other, the one over the limit is
+// removed again.
+func (s *Store) Create(ctx context.Context, userID, projectID, fileName string, length, space int64) (Session, error) {
+	now := time.Now()
+	session := Session{
+		Id:        primitive.NewObjectID(),
+		UserId:    userID,
+		ProjectId: projectID,
+		FileName:  fileName,
+		Length:    length,
+		Chunks:    []Chunk{},
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.ttl),
+	}
+
+	_, err := s.coll.InsertOne(ctx, session)
+	if err != nil {
+		return Session{}, err
+	}
+
+	count, length, err := s.open(ctx, userID, now)
+	switch {
+	case err != nil:
+	case count > MaxOpen:
+		err = ErrTooMany
+	case space != Unlimited && length > space:
+		err = ErrNoSpace
+	}
+	if err != nil {
+		_ = s.Delete(context.WithoutCancel(ctx), session.Id)
+		return Session{}, err
+	}
+	return session, nil
+}
+
+// open counts the sessions of the user that are not completed nor expired
+// and sums up their lengths
+func (s *Store) open(ctx context.Context, userID string, now time.Time) (int, int64, error) {
+	pipeline := bson.A{
+		bson.D{{Key: "$match", Value: bson.D{
+			{Key: "userId", Value: userID},
+			{Key: "completed", Value: false},
+			{Key: "expiresAt", Value: bson.D{{Key: "$gt", Value: now}}},
+		}}},
+		bson.D{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: nil},
+			{Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}},
+			{Key: "length", Value: bson.D{{Key: "$sum", Value: "$length"}}},
+		}}},
+	}
+
+	cursor, err := s.coll.Aggregate(ctx, pipeline)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var result []struct {
+		Count  int   `bson:"count"`
+		Length int64 `bson:"length"`
+	}
+	if err = cursor.All(ctx, &result); err != nil {
+		return 0, 0, err
+	}
+	if len(result) == 0 {
+		return 0, 0, nil
+	}
+	return result[0].Count, result[0].Length, nil
+}
+
+// Get returns the session with the given id, expired sessions are not found
+func (s *Store) Get(ctx context.Context, id string) (Session, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return Session{}, ErrNotFound
+	}
+
+	var session Session
+	err = s.coll.FindOne(ctx, bson.M{"_id": objectID, "expiresAt": bson.M{"$gt": time.Now()}}).Decode(&session)
+	if err == mongo.ErrNoDocuments {
+		return Session{}, ErrNotFound
+	}
+	return session, err
+}
+
+// AddChunk appends a stored chunk and moves the offset past it. It fails
+// with ErrOffsetMismatch when another chunk got there first.
+func (s *Store) AddChunk(ctx context.Context, id primitive.ObjectID, chunk Chunk) (Session, error) {
+	filter := bson.M{
+		"_id":     id,
+		"offset":  chunk.Offset,
+		"assetId": bson.M{"$exists": false},
+	}
+	update := bson.M{
+		"$push": bson.M{"chunks": chunk},
+		"$inc":  bson.M{"offset": chunk.Size},
+		"$set":  bson.M{"expiresAt": time.Now().Add(s.ttl)},
+	}
+
+	var session Session
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := s.coll.FindOneAndUpdate(ctx, filter, update, opts).Decode(&session)
+	if err == mongo.ErrNoDocuments {
+		return Session{}, ErrOffsetMismatch
+	}
+	return session, err
+}
+
+// Claim reserves the asset id of a fully received upload for ClaimLease,
+// so only one request turns it into an asset. The claim of a request that
+// died halfway is taken over once it ran out.
+func (s *Store) Claim(ctx context.Context, id primitive.ObjectID, assetID string) (Session, error) {
+	now := time.Now()
+	filter := bson.M{
+		"_id":       id,
+		"completed": false,
+		"$or": bson.A{
+			bson.M{"claimedUntil": bson.M{"$exists": false}},
+			bson.M{"claimedUntil": bson.M{"$lte": now}},
+		},
+		"$expr": bson.M{"$eq": bson.A{"$offset", "$length"}},
+	}
+	update := bson.M{"$set": bson.M{"assetId": assetID, "claimedUntil": now.Add(ClaimLease)}}
+
+	var session Session
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := s.coll.FindOneAndUpdate(ctx, filter, update, opts).Decode(&session)
+	if err == mongo.ErrNoDocuments {
+		return Session{}, ErrBusy
+	}
+	return session, err
+}
+
+// Unclaim gives up the asset id after a failed completion, so it can be
+// retried. A claim taken over in the meantime stays.
+func (s *Store) Unclaim(ctx context.Context, id primitive.ObjectID, assetID string) error {
+	_, err := s.coll.UpdateOne(ctx,
+		bson.M{"_id": id, "assetId": assetID, "completed": false},
+		bson.M{"$unset": bson.M{"assetId": "", "claimedUntil": ""}})
+	return err
+}
+
+// Finish marks the session completed, its chunks are no longer needed
+func (s *Store) Finish(ctx context.Context, id primitive.ObjectID) error {
+	_, err := s.coll.UpdateOne(ctx,
+		bson.M{"_id": id},
+		bson.M{"$set": bson.M{"completed": true, "chunks": []Chunk{}}})
+	return err
+}
+
+func (s *Store) Delete(ctx context.Context, id primitive.ObjectID) error {
+	_, err := s.coll.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
@@ -0,0 +1,114 @@
+package uploads
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// the mock deployment answers the commands in order with the queued
+// responses, the tests check what was sent
+
+func written(n int) bson.D {
+	return mtest.CreateSuccessResponse(bson.E{Key: "n", Value: n})
+}
+
+func openSessions(count int, length int64) bson.D {
+	return mtest.CreateCursorResponse(0, "test.upload_sessions", mtest.FirstBatch,
+		bson.D{{Key: "count", Value: count}, {Key: "length", Value: length}})
+}
+
+// sent lists the commands as "name collection"
+func sent(mt *mtest.T) []string {
+	commands := []string{}
+	for _, e := range mt.GetAllStartedEvents() {
+		commands = append(commands, e.CommandName+" "+e.Command.Lookup(e.CommandName).StringValue())
+	}
+	return commands
+}
+
+func newTestStore(mt *mtest.T) *Store {
+	return &Store{coll: mt.DB.Collection("upload_sessions"), ttl: DefaultTTL}
+}
+
+func TestCreate(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	ctx := context.Background()
+
+	mt.Run("within the limits", func(mt *mtest.T) {
+		mt.AddMockResponses(written(1), openSessions(3, 300))
+		session, err := newTestStore(mt).Create(ctx, "u1", "p1", "clip.mp4", 100, 300)
+		require.NoError(mt, err)
+		assert.Equal(mt, int64(100), session.Length)
+		// the new session is counted along with the others
+		assert.Equal(mt, []string{"insert upload_sessions", "aggregate upload_sessions"}, sent(mt))
+	})
+
+	mt.Run("unlimited space", func(mt *mtest.T) {
+		mt.AddMockResponses(written(1), openSessions(1, 1<<40))
+		_, err := newTestStore(mt).Create(ctx, "u1", "p1", "clip.mp4", 1<<40, Unlimited)
+		require.NoError(mt, err)
+	})
+
+	for name, test := range map[string]struct {
+		count  int
+		length int64
+		err    error
+	}{
+		"over the quota":   {count: 2, length: 301, err: ErrNoSpace},
+		"too many uploads": {count: MaxOpen + 1, length: 100, err: ErrTooMany},
+	} {
+		mt.Run(name, func(mt *mtest.T) {
+			mt.AddMockResponses(written(1), openSessions(test.count, test.length), written(1))
+			_, err := newTestStore(mt).Create(ctx, "u1", "p1", "clip.mp4", 100, 300)
+			assert.ErrorIs(mt, err, test.err)
+			// the session that does not fit is removed again
+			assert.Equal(mt, []string{"insert upload_sessions", "aggregate upload_sessions", "delete upload_sessions"}, sent(mt))
+		})
+	}
+}
+
+func TestClaim(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("leases the claim", func(mt *mtest.T) {
+		id := primitive.NewObjectID()
+		until := time.Now().Add(ClaimLease)
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "value", Value: bson.D{
+			{Key: "_id", Value: id}, {Key: "assetId", Value: "a1"}, {Key: "claimedUntil", Value: until},
+		}}))
+
+		session, err := newTestStore(mt).Claim(context.Background(), id, "a1")
+		require.NoError(mt, err)
+		assert.True(mt, session.Busy(time.Now()))
+
+		command := mt.GetStartedEvent().Command
+		claimed := command.Lookup("update", "$set", "claimedUntil").Time()
+		assert.WithinDuration(mt, until, claimed, time.Minute)
+		// a claim that ran out can be taken over
+		values, err := command.Lookup("query", "$or").Array().Values()
+		require.NoError(mt, err)
+		assert.Len(mt, values, 2)
+	})
+
+	mt.Run("held by another request", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "value", Value: nil}))
+		_, err := newTestStore(mt).Claim(context.Background(), primitive.NewObjectID(), "a2")
+		assert.ErrorIs(mt, err, ErrBusy)
+	})
+}
+
+func TestSessionBusy(t *testing.T) {
+	now := time.Now()
+	assert.False(t, Session{}.Busy(now))
+	assert.True(t, Session{AssetId: "a1", ClaimedUntil: now.Add(time.Minute)}.Busy(now))
+	// the request holding it died
+	assert.False(t, Session{AssetId: "a1", ClaimedUntil: now.Add(-time.Minute)}.Busy(now))
+	assert.False(t, Session{AssetId: "a1", ClaimedUntil: now.Add(time.Minute), Completed: true}.Busy(now))
+}
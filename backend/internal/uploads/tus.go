@@ -0,0 +1,78 @@
+package uploads
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+var ErrTooLarge = errors.New("the chunk exceeds the length of the upload")
+
+// ParseMetadata decodes an Upload-Metadata header, a comma separated list
+// of keys each followed by an optional base64 encoded value
+func ParseMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, errors.New("upload metadata contains an empty key")
+		}
+
+		value, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("upload metadata %q is not base64: %w", key, err)
+		}
+		metadata[key] = string(value)
+	}
+
+	return metadata, nil
+}
+
+// Part is the body of a PATCH request spooled to a temporary file
+type Part struct {
+	File *os.File
+	Size int64
+}
+
+// ReceivePart copies r to a temporary file, at most up to limit bytes.
+// When reading fails midway the part received so far is returned along
+// with the error, so a dropped connection does not lose the bytes that
+// made it. The caller has to Close a returned part.
+func ReceivePart(r io.Reader, limit int64) (*Part, error) {
+	file, err := os.CreateTemp("", "upload-*")
+	if err != nil {
+		return nil, err
+	}
+	part := &Part{File: file}
+
+	// one byte more than allowed tells a chunk at the limit from a larger one
+	size, readErr := io.Copy(file, io.LimitReader(r, limit+1))
+	if size > limit {
+		part.Close()
+		return nil, ErrTooLarge
+	}
+	part.Size = size
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		part.Close()
+		return nil, err
+	}
+
+	return part, readErr
+}
+
+// Close removes the temporary file
+func (p *Part) Close() error {
+	err := p.File.Close()
+	if removeErr := os.Remove(p.File.Name()); err == nil {
+		err = removeErr
+	}
+	return err
+}
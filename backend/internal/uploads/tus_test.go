@@ -0,0 +1,74 @@
+package uploads
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestParseMetadata(t *testing.T) {
+	metadata, err := ParseMetadata("filename bXkgY2xpcC5tcDQ=, filetype dmlkZW8vbXA0,is_confidential")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"filename":        "my clip.mp4",
+		"filetype":        "video/mp4",
+		"is_confidential": "",
+	}, metadata)
+
+	metadata, err = ParseMetadata("")
+	require.NoError(t, err)
+	assert.Empty(t, metadata)
+
+	_, err = ParseMetadata("filename not-base64!")
+	assert.Error(t, err)
+}
+
+// failingReader yields its content and then a broken connection
+type failingReader struct {
+	content io.Reader
+}
+
+func (f failingReader) Read(p []byte) (int, error) {
+	n, err := f.content.Read(p)
+	if err == io.EOF {
+		return n, io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func TestReceivePart(t *testing.T) {
+	part, err := ReceivePart(strings.NewReader("hello"), 5)
+	require.NoError(t, err)
+	content, err := io.ReadAll(part.File)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(content))
+	assert.Equal(t, int64(5), part.Size)
+	require.NoError(t, part.Close())
+
+	_, err = ReceivePart(strings.NewReader("hello!"), 5)
+	assert.ErrorIs(t, err, ErrTooLarge)
+}
+
+func TestReceivePartKeepsBytesOfDroppedConnection(t *testing.T) {
+	part, err := ReceivePart(failingReader{strings.NewReader("hel")}, 5)
+	assert.True(t, errors.Is(err, io.ErrUnexpectedEOF))
+	require.NotNil(t, part)
+	defer part.Close()
+	assert.Equal(t, int64(3), part.Size)
+}
+
+func TestSessionChunks(t *testing.T) {
+	id := primitive.NewObjectID()
+	first, second := ChunkKey(id, 0), ChunkKey(id, 0)
+	assert.NotEqual(t, first, second)
+	assert.True(t, strings.HasPrefix(first, "uploads/"+id.Hex()+"/0-"))
+
+	session := Session{Length: 8, Offset: 8, Chunks: []Chunk{{Key: "a", Size: 3}, {Key: "b", Offset: 3, Size: 5}}}
+	assert.True(t, session.Received())
+	assert.Equal(t, []string{"a", "b"}, session.ChunkKeys())
+}
@@ -0,0 +1,15 @@
+// Read by the Remotion CLI, the export workers render from this directory
+import path from "path";
+import { Config } from "@remotion/cli/config";
+
+// resolve the "@" alias the same way vite does
+Config.overrideWebpackConfig((config) => ({
+  ...config,
+  resolve: {
+    ...config.resolve,
+    alias: {
+      ...(config.resolve?.alias ?? {}),
+      "@": path.join(process.cwd(), "src"),
+    },
+  },
+}));
@@ -1,13 +1,8 @@
-import OpenAI from "openai";
-import { zodResponseFormat } from "openai/helpers/zod";
+import type { User } from "firebase/auth";
 
 import { Response } from "@/api/llm-types";
-import { systemPrompt } from "@/api/system-prompt";
-import {
-  convertChatHistoryToOpenAI,
-  createCompositionContextMessage,
-  createColorPaletteContextMessage,
-} from "@/api/llm-utils";
+import { generateCompositions } from "@/lib/api-client";
+import { dehydrateCompositions } from "@/lib/composition-hydrator";
 
 import type { ResponseType } from "@/api/llm-types";
 import type { CompositionConfig } from "@/components/interfaces/compositions";
@@ -56,57 +51,41 @@ export class NullLLMService implements LLMService {
   }
 }
 
-export class OpenAIService implements LLMService {
-  private client: OpenAI;
+/**
+ * Generates on the server, the model key never reaches the browser. The
+ * server keeps the chat history of the project and stores the prompt
+ * together with the answer.
+ */
+export class ServerLLMService implements LLMService {
+  private user: User;
+  private projectId: string;
   private abortController: AbortController | null = null;
 
-  constructor(apiKey: string) {
-    this.client = new OpenAI({ apiKey: apiKey, dangerouslyAllowBrowser: true });
+  constructor(user: User, projectId: string) {
+    this.user = user;
+    this.projectId = projectId;
   }
 
   async generateCompositions(
     prompt: string,
-    chatHistory: ChatMessage[],
+    _chatHistory: ChatMessage[],
     currentCompositions: CompositionConfig[] | null,
     colorPalette?: ColorPalette | null,
   ): Promise<ResponseType> {
     this.abortController = new AbortController();
 
-    const historyMessages = convertChatHistoryToOpenAI(chatHistory);
-    const compositionContextMessage = createCompositionContextMessage(currentCompositions);
-    const colorPaletteContextMessage = createColorPaletteContextMessage(colorPalette || null);
-    
-    const messages = [
-      { role: "system" as const, content: systemPrompt },
-      ...(compositionContextMessage ? [compositionContextMessage] : []),
-      ...(colorPaletteContextMessage ? [colorPaletteContextMessage] : []),
-      ...historyMessages,
-      { role: "user" as const, content: prompt },
-    ];
-
-    console.log(messages);
-
-    const completion = await this.client.beta.chat.completions.parse(
-      {
-        model: "gpt-4.1-nano-2025-04-14",
-        messages,
-        temperature: 0.7,
-        max_tokens: 10000,
-        response_format: zodResponseFormat(Response, "response"),
-      },
-      {
-        signal: this.abortController.signal,
-      },
+    const result = await generateCompositions(
+      this.user,
+      this.projectId,
+      prompt,
+      currentCompositions ? dehydrateCompositions(currentCompositions) : undefined,
+      colorPalette || undefined,
+      this.abortController.signal,
     );
 
-    // console.dir(completion, { depth: 5 });
-    const message = completion.choices[0]?.message;
-    if (message?.parsed) {
-      // console.log(message.parsed.compositions);
-      // console.log(`answer: ${message.parsed.comment}`);
-    }
-
-    return message.parsed as ResponseType;
+    // the stored answer keeps the full model output
+    const { fullResponse } = JSON.parse(result.message.content);
+    return Response.parse(fullResponse);
   }
 
   responseToGeneratedComposition(resp: ResponseType): CompositionConfig[] {
@@ -35,6 +35,7 @@ import {
 } from "@/lib/api-client";
 import type { Composition, Project } from "@/client/types.gen";
 import type { ChatMessage } from "@/types/chat";
+import { createChatMessage } from "@/types/chat";
 import {
   hydrateCompositions,
   dehydrateCompositions,
@@ -94,12 +95,9 @@ const WorkspaceInner = () => {
           colorPalette: colorPalette,
         };
 
-        const msg = await chatBoxPanelRef.current.registerMessage(
-          "developer",
-          palettePrompt,
-          metadata,
+        await chatBoxPanelRef.current.generate(
+          createChatMessage("developer", palettePrompt, undefined, metadata),
         );
-        msg && (await chatBoxPanelRef.current.generate(msg));
       }
     },
     [currentPalette],
@@ -301,12 +299,9 @@ const WorkspaceInner = () => {
           );
         }
 
-        const msg = await chatBoxPanelRef.current.registerMessage(
-          "user",
-          initialPrompt,
-          metadata,
+        await chatBoxPanelRef.current.generate(
+          createChatMessage("user", initialPrompt.trim(), undefined, metadata),
         );
-        msg && (await chatBoxPanelRef.current.generate(msg));
       }
     };
 
@@ -1,6 +1,6 @@
-import React, { useEffect, useState } from "react";
+import React, { useEffect, useMemo, useState } from "react";
 
-import { NullLLMService, OpenAIService } from "@/api/llm";
+import { NullLLMService, ServerLLMService } from "@/api/llm";
 
 import { ChatInput } from "@/components/chat/chat-input";
 import type { LLMService } from "@/components/interfaces/llm";
@@ -18,13 +18,7 @@ import {
 } from "@/types/chat";
 import { useComposition } from "@/lib/CompositionContext";
 import { useColorPalette } from "@/lib/ColorPaletteContext";
-
-// keep service null if undefined env
-let llm: LLMService = new NullLLMService();
-
-if (import.meta.env.VITE_APP_OPENAI_KEY !== undefined) {
-  llm = new OpenAIService(import.meta.env.VITE_APP_OPENAI_KEY);
-}
+import { useAuth } from "@/lib/AuthContext";
 
 interface ChatBoxPanelProps {
   project: Project | null;
@@ -45,7 +39,7 @@ export interface ChatBoxPanelRef {
 export const ChatBoxPanel = React.forwardRef<
   ChatBoxPanelRef,
   ChatBoxPanelProps
->(({ initialHistory = [], recordMessage }, ref) => {
+>(({ project, projectId, initialHistory = [], recordMessage }, ref) => {
   const {
     compositions,
     setCompositions,
@@ -56,6 +50,17 @@ export const ChatBoxPanel = React.forwardRef<
   const { currentPalette } = useColorPalette();
   const [history, setHistory] = useState<ChatMessage[]>(initialHistory);
   const [prompt, setPrompt] = useState("");
+  const { user } = useAuth();
+
+  // generation runs on the server, it needs a signed in user and a project
+  const effectiveProjectId = project?.id || projectId;
+  const llm: LLMService = useMemo(
+    () =>
+      user && effectiveProjectId
+        ? new ServerLLMService(user, effectiveProjectId)
+        : new NullLLMService(),
+    [user, effectiveProjectId],
+  );
 
   const addMessage = React.useCallback(
     async (msg: ChatMessage) => {
@@ -80,7 +85,7 @@ export const ChatBoxPanel = React.forwardRef<
     llm.abort();
     setIsGenerating(false);
     toast.info("Agent call interrupted");
-  }, [setIsGenerating]);
+  }, [llm, setIsGenerating]);
 
   async function registerMessage(
     role: "user" | "assistant" | "developer",
@@ -98,24 +103,28 @@ export const ChatBoxPanel = React.forwardRef<
   }
 
   async function generateCurrentPrompt() {
-    const msg = await registerMessage("user", prompt);
+    const usedPrompt = prompt.trim();
     //
     // clear prompt as fast as possible
     setPrompt("");
-    if (!msg) {
+    if (usedPrompt.length == 0) {
       return null;
     }
-    await generate(msg);
+    await generate(createChatMessage("user", usedPrompt));
   }
 
   const generate = React.useCallback(
     async (msg: ChatMessage) => {
       setIsGenerating(true);
       if (msg.content === "#dev") {
+        await addMessage(msg);
         devMode();
         return;
       }
 
+      // the server stores the prompt together with the answer, only show it
+      setHistory((prev) => [...prev, msg]);
+
       try {
         const response = await llm.generateCompositions(
           msg.content,
@@ -135,18 +144,18 @@ export const ChatBoxPanel = React.forwardRef<
         toast.success("Animation has been generated.");
         // logCompositionConfig(composition)
 
-        // Store full response with comment for display
+        // Show full response with comment, the server stored it already
         const agentMessage = createAgentMessageFromResponse(
           response,
           response.comment,
         );
-        await addMessage(agentMessage);
+        setHistory((prev) => [...prev, agentMessage]);
       } catch (e) {
         if (typeof e == "string") {
           console.error(e);
           return;
         }
-        if (e instanceof Error && e.message == "Request was aborted.") {
+        if (e instanceof Error && e.name == "AbortError") {
           return;
         }
 
@@ -154,18 +163,19 @@ export const ChatBoxPanel = React.forwardRef<
 
         clearSelectedProperty();
         setCompositions(null);
-        // In case of error, you might also want to add an error message to history
+        // Nothing was stored for a failed generation, only show the error
         const errorMessage = createChatMessage(
           "assistant",
           "An error occurred during generation.",
         );
-        await addMessage(errorMessage);
+        setHistory((prev) => [...prev, errorMessage]);
       }
 
       setIsGenerating(false);
     },
     [
       prompt,
+      llm,
       addMessage,
       setCompositions,
       setIsGenerating,
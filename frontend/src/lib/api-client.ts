@@ -9,10 +9,16 @@ import {
   postApiUsersMeProjectsByProjectIdChat,
   patchApiUsersMeProjectsByProjectIdName,
   patchApiUsersMeProjectsByProjectIdColorScheme,
+  postApiUsersMeProjectsByProjectIdGenerate,
 } from "@/client/sdk.gen";
 import { client } from "@/client/client.gen";
 import type { User } from "firebase/auth";
-import type { Composition, Project, ColorPalette } from "@/client/types.gen";
+import type {
+  Composition,
+  Project,
+  ColorPalette,
+  GenerateResponse,
+} from "@/client/types.gen";
 
 // Configure production URL when not in dev environment
 if (import.meta.env.VITE_ENV !== "dev") {
@@ -341,3 +347,41 @@ export async function deleteProject(
     return false;
   }
 }
+
+/**
+ * Generate compositions from a prompt on the server, which also appends
+ * the prompt and the answer to the project history.
+ * Throws so callers can tell an abort from a failed generation.
+ */
+export async function generateCompositions(
+  firebaseUser: User,
+  projectId: string,
+  prompt: string,
+  compositions?: Composition[],
+  colorScheme?: ColorPalette,
+  signal?: AbortSignal,
+): Promise<GenerateResponse> {
+  const idToken = await firebaseUser.getIdToken();
+
+  const response = await postApiUsersMeProjectsByProjectIdGenerate({
+    path: {
+      projectId: projectId,
+    },
+    headers: {
+      Authorization: `Bearer ${idToken}`,
+    },
+    body: {
+      prompt,
+      compositions,
+      colorScheme,
+    },
+    signal,
+  });
+
+  if (response.data) {
+    return response.data;
+  }
+
+  console.error("Failed to generate compositions:", response.error);
+  throw new Error(response.error?.message || "Failed to generate compositions");
+}
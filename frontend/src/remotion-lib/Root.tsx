@@ -27,6 +27,10 @@ import {
   slideInSchema,
 } from "./TextFades/schemas";
 import { SearchBarAnimation } from "./experimental-jitter/searchbar";
+import {
+  StoredSequence,
+  calculateStoredSequenceMetadata,
+} from "./StoredSequence";
 
 import { GradientMesh, SingleColorGradientMesh, MultiColorGradientMesh } from "./textures/gradientMesh";
 import { GrowingDark } from "./textures/growing-darkess";
@@ -70,6 +74,18 @@ export const RemotionRoot: React.FC = () => {
         }}
       />
 
+      {/* Rendered by the export workers with the project compositions as comps */}
+      <Composition
+        id="SequenceBuilder"
+        component={StoredSequence}
+        durationInFrames={1}
+        fps={30}
+        width={1920}
+        height={1080}
+        defaultProps={{ comps: [] }}
+        calculateMetadata={calculateStoredSequenceMetadata}
+      />
+
       {/* Mount any React component to make it show up in the sidebar and work on it individually! */}
       <Composition
         id="OnlyLogo"
@@ -0,0 +1,30 @@
+import React, { useMemo } from "react";
+import type { CalculateMetadataFunction } from "remotion";
+
+import type { Composition } from "@/client/types.gen";
+import { SequenceBuilder } from "@/components/tree-builder/sequence";
+import { hydrateCompositions } from "@/lib/composition-hydrator";
+
+export type StoredSequenceProps = {
+  comps: Composition[];
+};
+
+/**
+ * Plays the compositions the way the backend stores them. Export workers
+ * pass them to the Remotion CLI as plain JSON props, so components and
+ * schemas are looked up here before the SequenceBuilder gets them.
+ */
+export const StoredSequence: React.FC<StoredSequenceProps> = ({ comps }) => {
+  const hydrated = useMemo(() => hydrateCompositions(comps), [comps]);
+  return <SequenceBuilder comps={hydrated} />;
+};
+
+// the video is as long as the compositions played one after another
+export const calculateStoredSequenceMetadata: CalculateMetadataFunction<
+  StoredSequenceProps
+> = ({ props }) => ({
+  durationInFrames: Math.max(
+    1,
+    props.comps.reduce((total, comp) => total + comp.duration, 0),
+  ),
+});